@@ -85,6 +85,10 @@ func (c *Client) GetLivecomments(ctx context.Context, livestreamID int64, stream
 		req.URL.RawQuery = query.Encode()
 	}
 
+	if o.pagingParam != nil {
+		o.pagingParam.apply(req)
+	}
+
 	resp, err := sendRequest(ctx, c.themeAgent, req)
 	if err != nil {
 		return nil, err
@@ -100,7 +104,12 @@ func (c *Client) GetLivecomments(ctx context.Context, livestreamID int64, stream
 
 	livecomments := []*Livecomment{}
 	if resp.StatusCode == defaultStatusCode {
-		if err := json.NewDecoder(resp.Body).Decode(&livecomments); err != nil {
+		if o.pagingParam != nil {
+			err = decodePage(o.pagingParam, resp.Body, &livecomments)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(&livecomments)
+		}
+		if err != nil {
 			return livecomments, bencherror.NewHttpResponseError(err, req)
 		}
 
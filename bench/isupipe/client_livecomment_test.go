@@ -113,3 +113,69 @@ func TestGetNgWordsBug(t *testing.T) {
 		assert.NotZero(t, ngWord.CreatedAt)
 	}
 }
+
+func TestGetLivecommentsPaging(t *testing.T) {
+	ctx := context.Background()
+
+	testLogger, err := logger.InitTestLogger()
+	assert.NoError(t, err)
+
+	client, err := NewClient(
+		testLogger,
+		agent.WithBaseURL(config.TargetBaseURL),
+		agent.WithTimeout(1*time.Minute),
+	)
+	assert.NoError(t, err)
+
+	user := scheduler.UserScheduler.GetRandomStreamer()
+	client.Register(ctx, &RegisterRequest{
+		Name:        user.Name,
+		DisplayName: user.DisplayName,
+		Description: user.Description,
+		Password:    user.RawPassword,
+		Theme: Theme{
+			DarkMode: user.DarkMode,
+		},
+	})
+
+	err = client.Login(ctx, &LoginRequest{
+		Username: user.Name,
+		Password: user.RawPassword,
+	})
+	assert.NoError(t, err)
+
+	livestream, err := client.ReserveLivestream(ctx, user.Name, &ReserveLivestreamRequest{
+		Title:        "paging-test",
+		Description:  "paging-test",
+		PlaylistUrl:  "https://example.com",
+		ThumbnailUrl: "https://example.com",
+		StartAt:      time.Date(2024, 4, 21, 0, 0, 0, 0, time.UTC).Unix(),
+		EndAt:        time.Date(2024, 4, 21, 1, 0, 0, 0, time.UTC).Unix(),
+		Tags:         []int64{},
+	})
+	assert.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		_, _, err := client.PostLivecomment(ctx, livestream.ID, livestream.Owner.Name, "paging-test", &scheduler.Tip{})
+		assert.NoError(t, err)
+	}
+
+	// 2件ずつ辿り、全件を重複なく取得できる
+	seen := map[int64]struct{}{}
+	paging := &PagingParam{}
+	for page := 0; page < 3; page++ {
+		livecomments, err := client.GetLivecomments(ctx, livestream.ID, livestream.Owner.Name, WithLimitQueryParam(2), WithPagingQueryParam(paging))
+		assert.NoError(t, err)
+		for _, livecomment := range livecomments {
+			_, ok := seen[livecomment.ID]
+			assert.False(t, ok)
+			seen[livecomment.ID] = struct{}{}
+		}
+		if paging.NextCursor == "" {
+			break
+		}
+		paging = &PagingParam{Cursor: paging.NextCursor}
+	}
+	assert.Len(t, seen, 5)
+	assert.Empty(t, paging.NextCursor)
+}
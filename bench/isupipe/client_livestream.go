@@ -114,6 +114,10 @@ func (c *Client) SearchLivestreams(
 		req.URL.RawQuery = query.Encode()
 	}
 
+	if o.pagingParam != nil {
+		o.pagingParam.apply(req)
+	}
+
 	resp, err := sendRequest(ctx, c.agent, req)
 	if err != nil {
 		return nil, err
@@ -129,7 +133,12 @@ func (c *Client) SearchLivestreams(
 
 	var livestreams []*Livestream
 	if resp.StatusCode == defaultStatusCode {
-		if err := json.NewDecoder(resp.Body).Decode(&livestreams); err != nil {
+		if o.pagingParam != nil {
+			err = decodePage(o.pagingParam, resp.Body, &livestreams)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(&livestreams)
+		}
+		if err != nil {
 			return nil, err
 		}
 
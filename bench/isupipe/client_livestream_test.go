@@ -139,3 +139,42 @@ func TestLivestream(t *testing.T) {
 		}
 	}
 }
+
+func TestSearchLivestreamsPaging(t *testing.T) {
+	ctx := context.Background()
+
+	testLogger, err := logger.InitTestLogger()
+	assert.NoError(t, err)
+
+	client, err := NewClient(
+		testLogger,
+		agent.WithBaseURL(config.TargetBaseURL),
+		agent.WithTimeout(1*time.Minute),
+	)
+	assert.NoError(t, err)
+
+	all, err := client.SearchLivestreams(ctx, WithSearchTagQueryParam("ライブ配信"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, all)
+
+	// next_cursorを辿ると、ページングしない検索と同じ配信を同じ順序で取得できる
+	var paged []int64
+	paging := &PagingParam{}
+	for page := 0; page <= len(all); page++ {
+		livestreams, err := client.SearchLivestreams(ctx, WithSearchTagQueryParam("ライブ配信"), WithLimitQueryParam(10), WithPagingQueryParam(paging))
+		assert.NoError(t, err)
+		for _, livestream := range livestreams {
+			paged = append(paged, livestream.ID)
+		}
+		if paging.NextCursor == "" {
+			break
+		}
+		paging = &PagingParam{Cursor: paging.NextCursor}
+	}
+	var want []int64
+	for _, livestream := range all {
+		want = append(want, livestream.ID)
+	}
+	assert.Equal(t, want, paged)
+	assert.Empty(t, paging.NextCursor)
+}
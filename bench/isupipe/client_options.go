@@ -1,5 +1,12 @@
 package isupipe
 
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+)
+
 type ClientOption func(o *ClientOptions)
 
 type LimitParam struct {
@@ -10,10 +17,54 @@ type SearchTagParam struct {
 	Tag string
 }
 
+// PagingParam はカーソルによるページングのパラメータ
+// BeforeID, AfterID, Cursorのうち、指定するのはいずれか1つ
+// いずれも指定しない場合は空のcursorで最初のページを取得する
+type PagingParam struct {
+	BeforeID int64
+	AfterID  int64
+	Cursor   string
+	// NextCursor にはレスポンスで返された次ページのカーソルが格納される
+	// 次ページがない場合は空文字列になる
+	NextCursor string
+}
+
+func (p *PagingParam) apply(req *http.Request) {
+	p.NextCursor = ""
+
+	query := req.URL.Query()
+	switch {
+	case p.BeforeID > 0:
+		query.Add("before_id", strconv.FormatInt(p.BeforeID, 10))
+	case p.AfterID > 0:
+		query.Add("after_id", strconv.FormatInt(p.AfterID, 10))
+	default:
+		query.Add("cursor", p.Cursor)
+	}
+	req.URL.RawQuery = query.Encode()
+}
+
+// decodePage はページングしたレスポンスのitemsをitemsに読み込み、next_cursorをp.NextCursorに格納する
+func decodePage[S ~[]E, E any](p *PagingParam, r io.Reader, items *S) error {
+	var page struct {
+		Items      S      `json:"items"`
+		NextCursor string `json:"next_cursor"`
+	}
+	if err := json.NewDecoder(r).Decode(&page); err != nil {
+		return err
+	}
+	if page.Items != nil {
+		*items = page.Items
+	}
+	p.NextCursor = page.NextCursor
+	return nil
+}
+
 type ClientOptions struct {
 	wantStatusCode int
 	limitParam     *LimitParam
 	searchTag      *SearchTagParam
+	pagingParam    *PagingParam
 	eTag           string
 	// NOTE: スパム報告は、ベンチ走行中は粛清されたライブコメントを期待する場合が有り、エラーになることがある
 	// Pretestでのみスパム報告のバリデーションを行うための対応
@@ -55,6 +106,14 @@ func WithSearchTagQueryParam(tag string) ClientOption {
 	}
 }
 
+// WithPagingQueryParam はページングのクエリパラメータを付与し、
+// レスポンスの次ページカーソルをparam.NextCursorに格納する
+func WithPagingQueryParam(param *PagingParam) ClientOption {
+	return func(o *ClientOptions) {
+		o.pagingParam = param
+	}
+}
+
 func WithETag(eTag string) ClientOption {
 	return func(o *ClientOptions) {
 		o.eTag = eTag
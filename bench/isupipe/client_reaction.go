@@ -45,6 +45,10 @@ func (c *Client) GetReactions(ctx context.Context, livestreamID int64, streamerN
 		req.URL.RawQuery = query.Encode()
 	}
 
+	if o.pagingParam != nil {
+		o.pagingParam.apply(req)
+	}
+
 	resp, err := sendRequest(ctx, c.themeAgent, req)
 	if err != nil {
 		return nil, err
@@ -60,7 +64,12 @@ func (c *Client) GetReactions(ctx context.Context, livestreamID int64, streamerN
 
 	reactions := []Reaction{}
 	if resp.StatusCode == defaultStatusCode {
-		if err := json.NewDecoder(resp.Body).Decode(&reactions); err != nil {
+		if o.pagingParam != nil {
+			err = decodePage(o.pagingParam, resp.Body, &reactions)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(&reactions)
+		}
+		if err != nil {
 			return nil, bencherror.NewHttpResponseError(err, req)
 		}
 
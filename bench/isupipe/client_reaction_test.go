@@ -1 +1,79 @@
 package isupipe
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/isucon/isucandar/agent"
+	"github.com/isucon/isucon13/bench/internal/config"
+	"github.com/isucon/isucon13/bench/internal/logger"
+	"github.com/isucon/isucon13/bench/internal/scheduler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetReactionsPaging(t *testing.T) {
+	ctx := context.Background()
+
+	testLogger, err := logger.InitTestLogger()
+	assert.NoError(t, err)
+
+	client, err := NewClient(
+		testLogger,
+		agent.WithBaseURL(config.TargetBaseURL),
+		agent.WithTimeout(1*time.Minute),
+	)
+	assert.NoError(t, err)
+
+	user := scheduler.UserScheduler.GetRandomStreamer()
+	client.Register(ctx, &RegisterRequest{
+		Name:        user.Name,
+		DisplayName: user.DisplayName,
+		Description: user.Description,
+		Password:    user.RawPassword,
+		Theme: Theme{
+			DarkMode: user.DarkMode,
+		},
+	})
+
+	err = client.Login(ctx, &LoginRequest{
+		Username: user.Name,
+		Password: user.RawPassword,
+	})
+	assert.NoError(t, err)
+
+	livestream, err := client.ReserveLivestream(ctx, user.Name, &ReserveLivestreamRequest{
+		Title:        "reaction-paging-test",
+		Description:  "reaction-paging-test",
+		PlaylistUrl:  "https://example.com",
+		ThumbnailUrl: "https://example.com",
+		StartAt:      time.Date(2024, 4, 22, 0, 0, 0, 0, time.UTC).Unix(),
+		EndAt:        time.Date(2024, 4, 22, 1, 0, 0, 0, time.UTC).Unix(),
+		Tags:         []int64{},
+	})
+	assert.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		_, err := client.PostReaction(ctx, livestream.ID, livestream.Owner.Name, &PostReactionRequest{EmojiName: "innocent"})
+		assert.NoError(t, err)
+	}
+
+	// next_cursorを辿り、全件を重複なく取得できる
+	seen := map[int64]struct{}{}
+	paging := &PagingParam{}
+	for page := 0; page < 3; page++ {
+		reactions, err := client.GetReactions(ctx, livestream.ID, livestream.Owner.Name, WithLimitQueryParam(2), WithPagingQueryParam(paging))
+		assert.NoError(t, err)
+		for _, reaction := range reactions {
+			_, ok := seen[reaction.ID]
+			assert.False(t, ok)
+			seen[reaction.ID] = struct{}{}
+		}
+		if paging.NextCursor == "" {
+			break
+		}
+		paging = &PagingParam{Cursor: paging.NextCursor}
+	}
+	assert.Len(t, seen, 5)
+	assert.Empty(t, paging.NextCursor)
+}
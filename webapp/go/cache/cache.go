@@ -0,0 +1,105 @@
+// Package cache はリクエストをまたいで共有する、並行アクセスに安全な型付きのキャッシュ
+package cache
+
+import "sync"
+
+// Cache はキーと値の型を指定したインメモリのキャッシュ
+// 値はコピーせずに返すため、スライスなどの参照型を格納した場合は呼び出し側で変更しないこと
+type Cache[K comparable, V any] struct {
+	mu    sync.RWMutex
+	items map[K]V
+	// DeleteやResetのたびに増やす. 読み込み中に無効化された値を格納しないために使う
+	generation uint64
+}
+
+func New[K comparable, V any]() *Cache[K, V] {
+	return &Cache[K, V]{
+		items: make(map[K]V),
+	}
+}
+
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	v, ok := c.items[key]
+	return v, ok
+}
+
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[key] = value
+}
+
+// Delete はキーを無効化する. 同時に実行中の読み込みの結果も格納しない
+func (c *Cache[K, V]) Delete(keys ...K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		delete(c.items, key)
+	}
+	c.generation++
+}
+
+// Reset は全てのキーを無効化する
+func (c *Cache[K, V]) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = make(map[K]V)
+	c.generation++
+}
+
+func (c *Cache[K, V]) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.items)
+}
+
+// GetMultiOrLoad はキャッシュにないキーだけをloadで読み込んで格納し、全てのキーの値を返す
+// loadが値を返さなかったキーは結果に含めず、キャッシュにも格納しない
+// loadの実行中にDeleteやResetがあった場合は、古い値の可能性があるため結果を返すだけで格納しない
+// loadはコミット済みの値を返すこと. ロールバックされうる値を格納すると、無効化されずに残ってしまう
+func (c *Cache[K, V]) GetMultiOrLoad(keys []K, load func(missing []K) (map[K]V, error)) (map[K]V, error) {
+	values := make(map[K]V, len(keys))
+	var missing []K
+	seen := make(map[K]struct{}, len(keys))
+
+	c.mu.RLock()
+	generation := c.generation
+	for _, key := range keys {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		if v, ok := c.items[key]; ok {
+			values[key] = v
+		} else {
+			missing = append(missing, key)
+		}
+	}
+	c.mu.RUnlock()
+
+	if len(missing) == 0 {
+		return values, nil
+	}
+
+	loaded, err := load(missing)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	store := c.generation == generation
+	for _, key := range missing {
+		v, ok := loaded[key]
+		if !ok {
+			continue
+		}
+		values[key] = v
+		if store {
+			c.items[key] = v
+		}
+	}
+	return values, nil
+}
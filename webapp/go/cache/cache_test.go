@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetMultiOrLoad(t *testing.T) {
+	tests := []struct {
+		name       string
+		cached     map[int64]string
+		keys       []int64
+		loadResult map[int64]string
+		want       map[int64]string
+		wantLoaded []int64
+	}{
+		{
+			name:       "all cached",
+			cached:     map[int64]string{1: "a", 2: "b"},
+			keys:       []int64{1, 2},
+			want:       map[int64]string{1: "a", 2: "b"},
+			wantLoaded: nil,
+		},
+		{
+			name:       "load only missing keys once",
+			cached:     map[int64]string{1: "a"},
+			keys:       []int64{1, 2, 2, 3},
+			loadResult: map[int64]string{2: "b", 3: "c"},
+			want:       map[int64]string{1: "a", 2: "b", 3: "c"},
+			wantLoaded: []int64{2, 3},
+		},
+		{
+			name:       "not found keys are omitted",
+			keys:       []int64{1, 2},
+			loadResult: map[int64]string{1: "a"},
+			want:       map[int64]string{1: "a"},
+			wantLoaded: []int64{1, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New[int64, string]()
+			for k, v := range tt.cached {
+				c.Set(k, v)
+			}
+
+			var loaded []int64
+			got, err := c.GetMultiOrLoad(tt.keys, func(missing []int64) (map[int64]string, error) {
+				loaded = missing
+				return tt.loadResult, nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantLoaded, loaded)
+
+			// 読み込んだ値は格納され、見つからなかったキーは格納されない
+			assert.Equal(t, len(tt.want), c.Len())
+		})
+	}
+}
+
+func TestGetMultiOrLoadError(t *testing.T) {
+	c := New[int64, string]()
+	_, err := c.GetMultiOrLoad([]int64{1}, func(missing []int64) (map[int64]string, error) {
+		return nil, errors.New("boom")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestGetMultiOrLoadInvalidatedWhileLoading(t *testing.T) {
+	c := New[int64, string]()
+	got, err := c.GetMultiOrLoad([]int64{1}, func(missing []int64) (map[int64]string, error) {
+		// 読み込み中に書き込みがあって無効化された
+		c.Delete(1)
+		return map[int64]string{1: "stale"}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[int64]string{1: "stale"}, got)
+
+	_, ok := c.Get(1)
+	assert.False(t, ok)
+}
+
+func TestDeleteAndReset(t *testing.T) {
+	c := New[string, int]()
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Set("c", 3)
+
+	c.Delete("a", "b")
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+	v, ok := c.Get("c")
+	assert.True(t, ok)
+	assert.Equal(t, 3, v)
+
+	c.Reset()
+	assert.Equal(t, 0, c.Len())
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	c := New[int, int]()
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := j % 10
+				_, err := c.GetMultiOrLoad([]int{key}, func(missing []int) (map[int]int, error) {
+					return map[int]int{key: key * 2}, nil
+				})
+				assert.NoError(t, err)
+				if j%100 == i {
+					c.Delete(key)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for k := 0; k < 10; k++ {
+		if v, ok := c.Get(k); ok {
+			assert.Equal(t, k*2, v)
+		}
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// アプリケーションの設定
+// デフォルト値 < 設定ファイル(JSON) < 環境変数 の順に上書きし、起動時にまとめて検証する
+// 環境変数名は以前から使っているものをそのまま使う
+
+const (
+	// configFileEnvKey は設定ファイルのパス. --configで指定した場合はそちらを優先する
+	configFileEnvKey = "ISUCON13_CONFIG_FILE"
+
+	listenAddressEnvKey = "ISUCON13_LISTEN_ADDRESS"
+
+	mysqlNetEnvKey             = "ISUCON13_MYSQL_DIALCONFIG_NET"
+	mysqlAddressEnvKey         = "ISUCON13_MYSQL_DIALCONFIG_ADDRESS"
+	mysqlPortEnvKey            = "ISUCON13_MYSQL_DIALCONFIG_PORT"
+	mysqlUserEnvKey            = "ISUCON13_MYSQL_DIALCONFIG_USER"
+	mysqlPasswordEnvKey        = "ISUCON13_MYSQL_DIALCONFIG_PASSWORD"
+	mysqlDatabaseEnvKey        = "ISUCON13_MYSQL_DIALCONFIG_DATABASE"
+	mysqlParseTimeEnvKey       = "ISUCON13_MYSQL_DIALCONFIG_PARSETIME"
+	mysqlMaxOpenConnsEnvKey    = "ISUCON13_MYSQL_MAX_OPEN_CONNS"
+	mysqlMaxIdleConnsEnvKey    = "ISUCON13_MYSQL_MAX_IDLE_CONNS"
+	mysqlConnMaxLifetimeEnvKey = "ISUCON13_MYSQL_CONN_MAX_LIFETIME"
+
+	baseDomainEnvKey    = "ISUCON13_BASE_DOMAIN"
+	iconPathEnvKey      = "ISUCON13_ICON_PATH"
+	fallbackIconEnvKey  = "ISUCON13_FALLBACK_ICON_PATH"
+	sessionSecretEnvKey = "ISUCON13_SESSION_SECRETKEY"
+	sessionTTLEnvKey    = "ISUCON13_SESSION_TTL"
+	sessionMaxAgeEnvKey = "ISUCON13_SESSION_COOKIE_MAX_AGE"
+
+	reservationTermStartEnvKey = "ISUCON13_RESERVATION_TERM_START"
+	reservationTermEndEnvKey   = "ISUCON13_RESERVATION_TERM_END"
+)
+
+// maskedConfigValue は--print-configでパスワード等の代わりに表示する
+const maskedConfigValue = "********"
+
+type Config struct {
+	// ListenAddress はHTTPサーバがlistenするアドレス. 例: ":8080"
+	ListenAddress string `json:"listen_address"`
+	// BaseDomain はユーザごとのサブドメインを払い出すゾーン. セッションのCookieもこのドメインに発行する
+	BaseDomain string `json:"base_domain"`
+
+	DB          DBConfig          `json:"db"`
+	Icon        IconConfig        `json:"icon"`
+	Session     SessionConfig     `json:"session"`
+	Reservation ReservationConfig `json:"reservation"`
+	DNS         DNSConfig         `json:"dns"`
+	Moderation  ModerationConfig  `json:"moderation"`
+	Livestream  LivestreamConfig  `json:"livestream"`
+	RateLimit   RateLimitConfig   `json:"rate_limit"`
+}
+
+type DBConfig struct {
+	Net       string `json:"net"`
+	Address   string `json:"address"`
+	Port      string `json:"port"`
+	User      string `json:"user"`
+	Password  string `json:"password"`
+	Database  string `json:"database"`
+	ParseTime bool   `json:"parse_time"`
+
+	MaxOpenConns int `json:"max_open_conns"`
+	MaxIdleConns int `json:"max_idle_conns"`
+	// ConnMaxLifetime が0の場合はコネクションを使い回し続ける
+	ConnMaxLifetime configDuration `json:"conn_max_lifetime"`
+}
+
+type IconConfig struct {
+	// Path はアップロードされたアイコンを保存するディレクトリ
+	Path string `json:"path"`
+	// FallbackImage はアイコンを登録していないユーザに返す画像
+	FallbackImage string `json:"fallback_image"`
+}
+
+type SessionConfig struct {
+	SecretKey string `json:"secret_key"`
+	// Store はセッションの保存先. mysql または memory
+	Store string `json:"store"`
+	// TTL はログインしてからセッションが失効するまでの時間
+	TTL configDuration `json:"ttl"`
+	// CookieMaxAge はセッションCookieのMax-Age
+	CookieMaxAge configDuration `json:"cookie_max_age"`
+}
+
+type ReservationConfig struct {
+	// 予約可能期間. TermEndAtちょうどに始まる予約はできない
+	TermStartAt time.Time `json:"term_start_at"`
+	TermEndAt   time.Time `json:"term_end_at"`
+}
+
+type DNSConfig struct {
+	// Backend はpdnsutil, mysql, memoryのいずれか
+	Backend          string `json:"backend"`
+	PowerDNSMySQLDSN string `json:"powerdns_mysql_dsn"`
+	// SubdomainAddress はユーザのサブドメインに設定するAレコードのアドレス. 必須
+	SubdomainAddress string `json:"subdomain_address"`
+	// ZoneFile は/api/initializeでゾーンを戻す際に読むゾーンファイル. base_domainのゾーンでなければならない
+	ZoneFile string `json:"zone_file"`
+}
+
+type ModerationConfig struct {
+	// ReportAutoHideThreshold 件の報告を受けたライブコメントを自動で非表示にする. 0の場合は無効
+	ReportAutoHideThreshold int64 `json:"report_auto_hide_threshold"`
+}
+
+type LivestreamConfig struct {
+	EnforceLiveWindow      bool           `json:"enforce_live_window"`
+	ViewerHeartbeatTimeout configDuration `json:"viewer_heartbeat_timeout"`
+}
+
+type RateLimitConfig struct {
+	// Store はトークンバケットの保存先. memory または mysql
+	Store string `json:"store"`
+	// Rules はルートグループごとの制限. 形式はparseRateLimitRuleを参照
+	Rules map[string]string `json:"rules"`
+}
+
+// configDuration はJSONでは "1h30m" のような文字列で表す
+type configDuration time.Duration
+
+func (d configDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *configDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be string such as \"1h\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = configDuration(v)
+	return nil
+}
+
+// defaultConfig は環境変数も設定ファイルもない場合の設定. 以前のハードコードしていた値と同じ
+func defaultConfig() *Config {
+	return &Config{
+		ListenAddress: ":8080",
+		BaseDomain:    "u.isucon.dev",
+		DB: DBConfig{
+			Net:          "tcp",
+			Address:      "127.0.0.1",
+			Port:         "3306",
+			User:         "isucon",
+			Password:     "isucon",
+			Database:     "isupipe",
+			ParseTime:    true,
+			MaxOpenConns: 10,
+			MaxIdleConns: 2,
+		},
+		Icon: IconConfig{
+			Path:          "/home/isucon/webapp/public/icons",
+			FallbackImage: "../img/NoImage.jpg",
+		},
+		Session: SessionConfig{
+			SecretKey:    "isucon13_session_cookiestore_defaultsecret",
+			Store:        "mysql",
+			TTL:          configDuration(time.Hour),
+			CookieMaxAge: configDuration(60000 * time.Second),
+		},
+		Reservation: ReservationConfig{
+			// 予約可能期間は2023/11/25 10:00(JST)からの１年間
+			TermStartAt: time.Date(2023, 11, 25, 1, 0, 0, 0, time.UTC),
+			TermEndAt:   time.Date(2024, 11, 25, 1, 0, 0, 0, time.UTC),
+		},
+		DNS: DNSConfig{
+			Backend:  "pdnsutil",
+			ZoneFile: defaultPowerDNSZoneFile,
+		},
+		Livestream: LivestreamConfig{
+			ViewerHeartbeatTimeout: configDuration(60 * time.Second),
+		},
+		RateLimit: RateLimitConfig{
+			Store: "memory",
+			Rules: map[string]string{},
+		},
+	}
+}
+
+// loadConfig はデフォルト値に設定ファイルと環境変数を重ねた設定を返す. 検証はValidateで行う
+// pathが空の場合は設定ファイルを読まない
+func loadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyEnv は環境変数で設定を上書きする. 値を解釈できない環境変数はまとめてエラーにする
+func (c *Config) applyEnv() error {
+	var errs []error
+	str := func(key string, dst *string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+	parse := func(key string, fn func(v string) error) {
+		if v, ok := os.LookupEnv(key); ok {
+			if err := fn(v); err != nil {
+				errs = append(errs, fmt.Errorf("environ %s: %w", key, err))
+			}
+		}
+	}
+	boolean := func(key string, dst *bool) {
+		parse(key, func(v string) (err error) {
+			*dst, err = strconv.ParseBool(v)
+			return err
+		})
+	}
+	integer := func(key string, dst *int) {
+		parse(key, func(v string) (err error) {
+			*dst, err = strconv.Atoi(v)
+			return err
+		})
+	}
+	integer64 := func(key string, dst *int64) {
+		parse(key, func(v string) (err error) {
+			*dst, err = strconv.ParseInt(v, 10, 64)
+			return err
+		})
+	}
+	duration := func(key string, dst *configDuration) {
+		parse(key, func(v string) error {
+			d, err := time.ParseDuration(v)
+			*dst = configDuration(d)
+			return err
+		})
+	}
+	timestamp := func(key string, dst *time.Time) {
+		parse(key, func(v string) (err error) {
+			*dst, err = time.Parse(time.RFC3339, v)
+			return err
+		})
+	}
+
+	str(listenAddressEnvKey, &c.ListenAddress)
+	str(baseDomainEnvKey, &c.BaseDomain)
+
+	str(mysqlNetEnvKey, &c.DB.Net)
+	str(mysqlAddressEnvKey, &c.DB.Address)
+	str(mysqlPortEnvKey, &c.DB.Port)
+	str(mysqlUserEnvKey, &c.DB.User)
+	str(mysqlPasswordEnvKey, &c.DB.Password)
+	str(mysqlDatabaseEnvKey, &c.DB.Database)
+	boolean(mysqlParseTimeEnvKey, &c.DB.ParseTime)
+	integer(mysqlMaxOpenConnsEnvKey, &c.DB.MaxOpenConns)
+	integer(mysqlMaxIdleConnsEnvKey, &c.DB.MaxIdleConns)
+	duration(mysqlConnMaxLifetimeEnvKey, &c.DB.ConnMaxLifetime)
+
+	str(iconPathEnvKey, &c.Icon.Path)
+	str(fallbackIconEnvKey, &c.Icon.FallbackImage)
+
+	str(sessionSecretEnvKey, &c.Session.SecretKey)
+	str(sessionStoreEnvKey, &c.Session.Store)
+	duration(sessionTTLEnvKey, &c.Session.TTL)
+	duration(sessionMaxAgeEnvKey, &c.Session.CookieMaxAge)
+
+	timestamp(reservationTermStartEnvKey, &c.Reservation.TermStartAt)
+	timestamp(reservationTermEndEnvKey, &c.Reservation.TermEndAt)
+
+	str(dnsBackendEnvKey, &c.DNS.Backend)
+	str(powerDNSMySQLDSNEnvKey, &c.DNS.PowerDNSMySQLDSN)
+	str(powerDNSSubdomainAddressEnvKey, &c.DNS.SubdomainAddress)
+	str(powerDNSZoneFileEnvKey, &c.DNS.ZoneFile)
+
+	integer64(reportAutoHideThresholdEnvKey, &c.Moderation.ReportAutoHideThreshold)
+	boolean(enforceLiveWindowEnvKey, &c.Livestream.EnforceLiveWindow)
+	duration(viewerHeartbeatTimeoutEnvKey, &c.Livestream.ViewerHeartbeatTimeout)
+
+	str(rateLimitStoreEnvKey, &c.RateLimit.Store)
+	for _, group := range rateLimitGroups {
+		if v, ok := os.LookupEnv(rateLimitRuleEnvKeyPrefix + strings.ToUpper(group)); ok {
+			if c.RateLimit.Rules == nil {
+				c.RateLimit.Rules = map[string]string{}
+			}
+			c.RateLimit.Rules[group] = v
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Validate は設定の誤りをすべて集めて返す
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	if _, port, err := net.SplitHostPort(c.ListenAddress); err != nil {
+		errs = append(errs, fmt.Errorf("listen_address %q must be <host>:<port>: %w", c.ListenAddress, err))
+	} else {
+		check(isPort(port), "listen_address %q has invalid port", c.ListenAddress)
+	}
+	check(c.BaseDomain != "" && !strings.HasPrefix(c.BaseDomain, ".") && !strings.HasSuffix(c.BaseDomain, "."), "base_domain %q must be domain name without leading or trailing dot", c.BaseDomain)
+
+	if err := c.DB.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	check(c.Icon.Path != "", "icon.path must not be empty")
+	check(c.Icon.FallbackImage != "", "icon.fallback_image must not be empty")
+
+	check(c.Session.SecretKey != "", "session.secret_key must not be empty")
+	check(c.Session.TTL > 0, "session.ttl must be positive")
+	check(c.Session.CookieMaxAge >= c.Session.TTL, "session.cookie_max_age must not be shorter than session.ttl")
+
+	check(c.Reservation.TermEndAt.After(c.Reservation.TermStartAt), "reservation.term_end_at must be after reservation.term_start_at")
+
+	check(net.ParseIP(c.DNS.SubdomainAddress) != nil, "dns.subdomain_address %q must be IP address (environ %s)", c.DNS.SubdomainAddress, powerDNSSubdomainAddressEnvKey)
+	check(c.DNS.ZoneFile != "", "dns.zone_file must not be empty")
+	// 同梱のゾーンファイルはu.isucon.dev用なので、ベースドメインを変える場合はゾーンファイルも差し替える必要がある
+	check(c.DNS.ZoneFile != defaultPowerDNSZoneFile || c.BaseDomain == defaultConfig().BaseDomain, "dns.zone_file must be set for base_domain %q (the default zone file is for %s)", c.BaseDomain, defaultConfig().BaseDomain)
+
+	check(c.Moderation.ReportAutoHideThreshold >= 0, "moderation.report_auto_hide_threshold must not be negative")
+	check(c.Livestream.ViewerHeartbeatTimeout > 0, "livestream.viewer_heartbeat_timeout must be positive")
+
+	for _, group := range sortedKeys(c.RateLimit.Rules) {
+		if !slices.Contains(rateLimitGroups, group) {
+			errs = append(errs, fmt.Errorf("rate_limit.rules has unknown group %q (%s)", group, strings.Join(rateLimitGroups, ", ")))
+			continue
+		}
+		if _, err := parseRateLimitRule(c.RateLimit.Rules[group]); err != nil {
+			errs = append(errs, fmt.Errorf("rate_limit.rules.%s: %w", group, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Validate はデータベースの設定の誤りをすべて集めて返す
+// migrateサブコマンドはデータベースの設定だけを使うので、これだけを検証する
+func (c *DBConfig) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Net == "tcp" || c.Net == "unix", "db.net %q must be tcp or unix", c.Net)
+	check(c.Address != "", "db.address must not be empty")
+	check(c.Net == "unix" || isPort(c.Port), "db.port %q must be port number", c.Port)
+	check(c.User != "", "db.user must not be empty")
+	check(c.Database != "", "db.database must not be empty")
+	check(c.MaxOpenConns > 0, "db.max_open_conns must be positive, got %d", c.MaxOpenConns)
+	check(c.MaxIdleConns >= 0, "db.max_idle_conns must not be negative, got %d", c.MaxIdleConns)
+	check(c.ConnMaxLifetime >= 0, "db.conn_max_lifetime must not be negative")
+
+	return errors.Join(errs...)
+}
+
+func isPort(s string) bool {
+	port, err := strconv.Atoi(s)
+	return err == nil && port > 0 && port <= 65535
+}
+
+// sortedKeys はエラーの順序を安定させるためにキーを並べる
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Masked はパスワードなどを伏せた設定を返す
+func (c *Config) Masked() *Config {
+	masked := *c
+	if masked.DB.Password != "" {
+		masked.DB.Password = maskedConfigValue
+	}
+	if masked.Session.SecretKey != "" {
+		masked.Session.SecretKey = maskedConfigValue
+	}
+	if masked.DNS.PowerDNSMySQLDSN != "" {
+		masked.DNS.PowerDNSMySQLDSN = maskedConfigValue
+	}
+	return &masked
+}
+
+// apply は検証済みの設定を各機能のパッケージ変数に反映する
+func (c *Config) apply() error {
+	rules := map[string]rateLimitRule{}
+	for group, v := range c.RateLimit.Rules {
+		rule, err := parseRateLimitRule(v)
+		if err != nil {
+			return fmt.Errorf("rate_limit.rules.%s: %w", group, err)
+		}
+		rules[group] = rule
+	}
+
+	baseDomain = c.BaseDomain
+	iconPath = c.Icon.Path
+	fallbackImage = c.Icon.FallbackImage
+	sessionTTL = time.Duration(c.Session.TTL)
+	sessionCookieMaxAge = time.Duration(c.Session.CookieMaxAge)
+	reservationTermStartAt = c.Reservation.TermStartAt
+	reservationTermEndAt = c.Reservation.TermEndAt
+	reportAutoHideThreshold = c.Moderation.ReportAutoHideThreshold
+	enforceLiveWindow = c.Livestream.EnforceLiveWindow
+	viewerHeartbeatTimeout = time.Duration(c.Livestream.ViewerHeartbeatTimeout)
+	rateLimitRules = rules
+	return nil
+}
+
+// printConfigMain は --print-config の処理. パスワードなどを伏せた設定を出力し、検証結果を終了コードで返す
+func printConfigMain(cfg *Config) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(cfg.Masked()); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to print config: %v\n", err)
+		return 1
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config:\n%v\n", err)
+		return 1
+	}
+	return 0
+}
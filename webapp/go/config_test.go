@@ -0,0 +1,176 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	t.Setenv(powerDNSSubdomainAddressEnvKey, "127.0.0.1")
+
+	cfg, err := loadConfig("")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, cfg.Validate())
+	assert.Equal(t, ":8080", cfg.ListenAddress)
+	assert.Equal(t, "u.isucon.dev", cfg.BaseDomain)
+	assert.Equal(t, 10, cfg.DB.MaxOpenConns)
+	assert.Equal(t, configDuration(time.Hour), cfg.Session.TTL)
+	assert.Equal(t, reservationTermStartAt, cfg.Reservation.TermStartAt)
+	assert.Equal(t, reservationTermEndAt, cfg.Reservation.TermEndAt)
+}
+
+// 環境変数は設定ファイルより優先する
+func TestLoadConfigFileAndEnv(t *testing.T) {
+	path := writeConfigFile(t, `{
+  "listen_address": "127.0.0.1:9000",
+  "db": {"address": "db.internal", "max_open_conns": 32, "conn_max_lifetime": "5m"},
+  "session": {"ttl": "2h"},
+  "reservation": {"term_start_at": "2024-01-01T00:00:00Z"},
+  "dns": {"subdomain_address": "192.0.2.1"},
+  "rate_limit": {"rules": {"reaction": "5/s"}}
+}`)
+	t.Setenv(mysqlMaxOpenConnsEnvKey, "64")
+	t.Setenv(rateLimitRuleEnvKeyPrefix+"LIVECOMMENT", "30/m,10")
+	t.Setenv(baseDomainEnvKey, "t.isucon.dev")
+	t.Setenv(powerDNSZoneFileEnvKey, "/etc/pdns/t.isucon.dev.zone")
+
+	cfg, err := loadConfig(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, cfg.Validate())
+	assert.Equal(t, "127.0.0.1:9000", cfg.ListenAddress)
+	assert.Equal(t, "db.internal", cfg.DB.Address)
+	assert.Equal(t, "3306", cfg.DB.Port, "fields missing in the file keep defaults")
+	assert.Equal(t, 64, cfg.DB.MaxOpenConns)
+	assert.Equal(t, configDuration(5*time.Minute), cfg.DB.ConnMaxLifetime)
+	assert.Equal(t, configDuration(2*time.Hour), cfg.Session.TTL)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), cfg.Reservation.TermStartAt)
+	assert.Equal(t, "192.0.2.1", cfg.DNS.SubdomainAddress)
+	assert.Equal(t, "t.isucon.dev", cfg.BaseDomain)
+	assert.Equal(t, "/etc/pdns/t.isucon.dev.zone", cfg.DNS.ZoneFile)
+	assert.Equal(t, map[string]string{"reaction": "5/s", "livecomment": "30/m,10"}, cfg.RateLimit.Rules)
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	t.Run("unknown field in file", func(t *testing.T) {
+		_, err := loadConfig(writeConfigFile(t, `{"listen_port": 8080}`))
+		assert.ErrorContains(t, err, `unknown field "listen_port"`)
+	})
+	t.Run("invalid duration in file", func(t *testing.T) {
+		_, err := loadConfig(writeConfigFile(t, `{"session": {"ttl": 3600}}`))
+		assert.ErrorContains(t, err, "duration must be string")
+	})
+	t.Run("invalid env values are reported together", func(t *testing.T) {
+		t.Setenv(mysqlParseTimeEnvKey, "yes please")
+		t.Setenv(sessionTTLEnvKey, "1 hour")
+		_, err := loadConfig("")
+		assert.ErrorContains(t, err, "environ "+mysqlParseTimeEnvKey)
+		assert.ErrorContains(t, err, "environ "+sessionTTLEnvKey)
+	})
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.ListenAddress = "8080"
+	cfg.BaseDomain = ".u.isucon.dev"
+	cfg.DB.MaxOpenConns = 0
+	cfg.Session.TTL = 0
+	cfg.Reservation.TermEndAt = cfg.Reservation.TermStartAt
+	cfg.RateLimit.Rules = map[string]string{"follow": "1/s", "reaction": "fast"}
+
+	err := cfg.Validate()
+	for _, want := range []string{
+		"listen_address",
+		"base_domain",
+		"db.max_open_conns",
+		"session.ttl",
+		"reservation.term_end_at",
+		"dns.subdomain_address",
+		`unknown group "follow"`,
+		"rate_limit.rules.reaction",
+	} {
+		assert.ErrorContains(t, err, want)
+	}
+}
+
+// migrateサブコマンドはデータベースの設定だけを検証するので、DNSなどの設定が不足していても実行できる
+func TestDBConfigValidate(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.DNS.SubdomainAddress = ""
+	cfg.DNS.ZoneFile = ""
+	cfg.Session.SecretKey = ""
+	assert.Error(t, cfg.Validate())
+	assert.NoError(t, cfg.DB.Validate())
+
+	cfg.DB.Net = "udp"
+	cfg.DB.Database = ""
+	cfg.DB.MaxOpenConns = 0
+	err := cfg.DB.Validate()
+	for _, want := range []string{
+		"db.net",
+		"db.database",
+		"db.max_open_conns",
+	} {
+		assert.ErrorContains(t, err, want)
+	}
+	assert.NotContains(t, err.Error(), "dns.")
+}
+
+// 同梱のゾーンファイルはu.isucon.dev用なので、ベースドメインだけを変えることはできない
+func TestConfigValidateZoneFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		baseDomain string
+		zoneFile   string
+		wantErr    string
+	}{
+		{name: "default", baseDomain: "u.isucon.dev", zoneFile: defaultPowerDNSZoneFile},
+		{name: "custom base domain and zone file", baseDomain: "t.isucon.dev", zoneFile: "/etc/pdns/t.isucon.dev.zone"},
+		{name: "custom base domain with default zone file", baseDomain: "t.isucon.dev", zoneFile: defaultPowerDNSZoneFile, wantErr: `dns.zone_file must be set for base_domain "t.isucon.dev"`},
+		{name: "empty zone file", baseDomain: "u.isucon.dev", zoneFile: "", wantErr: "dns.zone_file must not be empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.DNS.SubdomainAddress = "127.0.0.1"
+			cfg.BaseDomain = tt.baseDomain
+			cfg.DNS.ZoneFile = tt.zoneFile
+
+			err := cfg.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigMasked(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.DNS.PowerDNSMySQLDSN = "isudns:isudns@tcp(127.0.0.1:3306)/isudns"
+
+	masked := cfg.Masked()
+	assert.Equal(t, maskedConfigValue, masked.DB.Password)
+	assert.Equal(t, maskedConfigValue, masked.Session.SecretKey)
+	assert.Equal(t, maskedConfigValue, masked.DNS.PowerDNSMySQLDSN)
+	// 元の設定は変更しない
+	assert.Equal(t, "isucon", cfg.DB.Password)
+}
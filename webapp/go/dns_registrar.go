@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	dnsBackendEnvKey               = "ISUCON13_DNS_BACKEND"
+	powerDNSMySQLDSNEnvKey         = "ISUCON13_POWERDNS_MYSQL_DSN"
+	powerDNSSubdomainAddressEnvKey = "ISUCON13_POWERDNS_SUBDOMAIN_ADDRESS"
+	powerDNSZoneFileEnvKey         = "ISUCON13_POWERDNS_ZONE_FILE"
+
+	// u.isucon.dev用のゾーンファイル. ベースドメインを変える場合はdns.zone_fileで差し替えること
+	defaultPowerDNSZoneFile = "../pdns/u.isucon.dev.zone"
+	powerDNSRecordTTL       = 3600
+	// PowerDNSのMySQLバックエンドに接続する際のデフォルト
+	defaultPowerDNSMySQLDSN = "isudns:isudns@tcp(127.0.0.1:3306)/isudns"
+)
+
+// DNSRegistrar はユーザごとのサブドメインのAレコードを管理する
+type DNSRegistrar interface {
+	// AddRecord は <name>.<ベースドメイン> のAレコードを登録する
+	AddRecord(ctx context.Context, name string) error
+	// RemoveRecord は <name>.<ベースドメイン> のAレコードを削除する. 存在しない場合もエラーにしない
+	RemoveRecord(ctx context.Context, name string) error
+	// ResetZone はゾーンをゾーンファイルの内容に戻す. 後から登録したレコードは消える
+	ResetZone(ctx context.Context) error
+}
+
+var dnsRegistrar DNSRegistrar
+
+func newDNSRegistrar(kind string, dsn string, zone string, zoneFile string, address string) (DNSRegistrar, error) {
+	switch kind {
+	case "", "pdnsutil":
+		return &pdnsutilDNSRegistrar{zone: zone, zoneFile: zoneFile, address: address}, nil
+	case "mysql":
+		if dsn == "" {
+			dsn = defaultPowerDNSMySQLDSN
+		}
+		db, err := sqlx.Open("mysql", dsn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open PowerDNS database: %w", err)
+		}
+		if err := db.Ping(); err != nil {
+			return nil, fmt.Errorf("failed to connect PowerDNS database: %w", err)
+		}
+		return &mysqlDNSRegistrar{db: db, zone: zone, zoneFile: zoneFile, address: address}, nil
+	case "memory":
+		return newMemoryDNSRegistrar(zone, zoneFile, address), nil
+	default:
+		return nil, fmt.Errorf("unknown DNS backend %q", kind)
+	}
+}
+
+// pdnsutilDNSRegistrar はpdnsutilコマンドでレコードを操作する
+type pdnsutilDNSRegistrar struct {
+	zone     string
+	zoneFile string
+	address  string
+}
+
+func (r *pdnsutilDNSRegistrar) AddRecord(ctx context.Context, name string) error {
+	out, err := exec.CommandContext(ctx, "pdnsutil", "add-record", r.zone, name, "A", fmt.Sprint(powerDNSRecordTTL), r.address).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w", string(out), err)
+	}
+	return nil
+}
+
+func (r *pdnsutilDNSRegistrar) ResetZone(ctx context.Context) error {
+	content, err := loadZoneTemplate(r.zoneFile, r.address)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp("", r.zone+".zone")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	out, err := exec.CommandContext(ctx, "pdnsutil", "load-zone", r.zone, f.Name()).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w", string(out), err)
+	}
+	return nil
+}
+
+func (r *pdnsutilDNSRegistrar) RemoveRecord(ctx context.Context, name string) error {
+	out, err := exec.CommandContext(ctx, "pdnsutil", "delete-rrset", r.zone, name, "A").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w", string(out), err)
+	}
+	return nil
+}
+
+// mysqlDNSRegistrar はPowerDNSのMySQLバックエンド(isudns)に直接書き込む
+type mysqlDNSRegistrar struct {
+	db       *sqlx.DB
+	zone     string
+	zoneFile string
+	address  string
+}
+
+func (r *mysqlDNSRegistrar) recordName(name string) string {
+	return strings.ToLower(name) + "." + r.zone
+}
+
+func (r *mysqlDNSRegistrar) AddRecord(ctx context.Context, name string) error {
+	rs, err := r.db.ExecContext(ctx, "INSERT INTO records (domain_id, name, type, content, ttl, prio, disabled, auth) SELECT id, ?, 'A', ?, ?, 0, 0, 1 FROM domains WHERE name = ?", r.recordName(name), r.address, powerDNSRecordTTL, r.zone)
+	if err != nil {
+		return err
+	}
+	if n, err := rs.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("zone %s is not found", r.zone)
+	}
+	return nil
+}
+
+func (r *mysqlDNSRegistrar) RemoveRecord(ctx context.Context, name string) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM records WHERE name = ? AND type = 'A'", r.recordName(name))
+	return err
+}
+
+// ResetZone はゾーンのレコードを入れ直す. ゾーンがまだなければ作成する
+func (r *mysqlDNSRegistrar) ResetZone(ctx context.Context) error {
+	content, err := loadZoneTemplate(r.zoneFile, r.address)
+	if err != nil {
+		return err
+	}
+	records, err := parseZone(content, r.zone)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var domainID int64
+	if err := tx.GetContext(ctx, &domainID, "SELECT id FROM domains WHERE name = ?", r.zone); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to get zone: %w", err)
+		}
+		rs, err := tx.ExecContext(ctx, "INSERT INTO domains (name, type) VALUES (?, 'NATIVE')", r.zone)
+		if err != nil {
+			return fmt.Errorf("failed to create zone: %w", err)
+		}
+		if domainID, err = rs.LastInsertId(); err != nil {
+			return fmt.Errorf("failed to get last inserted zone id: %w", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM records WHERE domain_id = ?", domainID); err != nil {
+		return fmt.Errorf("failed to delete records: %w", err)
+	}
+	for _, rec := range records {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO records (domain_id, name, type, content, ttl, prio, disabled, auth) VALUES (?, ?, ?, ?, ?, 0, 0, 1)", domainID, rec.Name, rec.Type, rec.Content, rec.TTL); err != nil {
+			return fmt.Errorf("failed to insert record: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	return nil
+}
+
+// memoryDNSRegistrar はプロセス内でレコードを保持する. テストや開発用途向け
+type memoryDNSRegistrar struct {
+	mu       sync.Mutex
+	zone     string
+	zoneFile string
+	address  string
+	records  map[string]string
+}
+
+func newMemoryDNSRegistrar(zone string, zoneFile string, address string) *memoryDNSRegistrar {
+	return &memoryDNSRegistrar{
+		zone:     zone,
+		zoneFile: zoneFile,
+		address:  address,
+		records:  make(map[string]string),
+	}
+}
+
+func (r *memoryDNSRegistrar) AddRecord(_ context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.records[strings.ToLower(name)] = r.address
+	return nil
+}
+
+func (r *memoryDNSRegistrar) RemoveRecord(_ context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.records, strings.ToLower(name))
+	return nil
+}
+
+func (r *memoryDNSRegistrar) ResetZone(_ context.Context) error {
+	content, err := loadZoneTemplate(r.zoneFile, r.address)
+	if err != nil {
+		return err
+	}
+	records, err := parseZone(content, r.zone)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.records = make(map[string]string)
+	for _, rec := range records {
+		if rec.Type != "A" || rec.Name == r.zone {
+			continue
+		}
+		r.records[strings.TrimSuffix(rec.Name, "."+r.zone)] = rec.Content
+	}
+	return nil
+}
+
+// Lookup は登録されたレコードのアドレスを返す
+func (r *memoryDNSRegistrar) Lookup(name string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	address, ok := r.records[strings.ToLower(name)]
+	return address, ok
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDNSRegistrar(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		dsn     string
+		want    DNSRegistrar
+		wantErr string
+	}{
+		{name: "default", kind: "", want: &pdnsutilDNSRegistrar{}},
+		{name: "pdnsutil", kind: "pdnsutil", want: &pdnsutilDNSRegistrar{}},
+		{name: "memory", kind: "memory", want: &memoryDNSRegistrar{}},
+		{name: "unknown", kind: "bind", wantErr: `unknown DNS backend "bind"`},
+		// 起動時に接続を確認する
+		{name: "mysql unreachable", kind: "mysql", dsn: "isudns:isudns@tcp(127.0.0.1:1)/isudns", wantErr: "failed to connect PowerDNS database"},
+		{name: "mysql invalid dsn", kind: "mysql", dsn: "isudns@127.0.0.1", wantErr: "failed to open PowerDNS database"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newDNSRegistrar(tt.kind, tt.dsn, "u.isucon.dev", defaultPowerDNSZoneFile, "127.0.0.1")
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.IsType(t, tt.want, r)
+		})
+	}
+}
+
+func TestMemoryDNSRegistrar(t *testing.T) {
+	ctx := context.Background()
+	r := newMemoryDNSRegistrar("u.isucon.dev", defaultPowerDNSZoneFile, "192.0.2.1")
+
+	// ユーザ名の大文字小文字は区別しない
+	assert.NoError(t, r.AddRecord(ctx, "NewUser"))
+	address, ok := r.Lookup("newuser")
+	assert.True(t, ok)
+	assert.Equal(t, "192.0.2.1", address)
+
+	assert.NoError(t, r.RemoveRecord(ctx, "newuser"))
+	_, ok = r.Lookup("NewUser")
+	assert.False(t, ok)
+	// 存在しないレコードの削除はエラーにしない
+	assert.NoError(t, r.RemoveRecord(ctx, "newuser"))
+}
+
+// installFakePdnsutil は引数と読み込んだゾーンファイルをログに書き出すpdnsutilをPATHの先頭に置き、ログのパスを返す
+// failがtrueの場合はエラー出力をして失敗する
+func installFakePdnsutil(t *testing.T, fail bool) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "pdnsutil.log")
+	script := fmt.Sprintf(`#!/bin/sh
+echo "$@" >> %[1]q
+if [ "$1" = load-zone ]; then cat "$3" >> %[1]q; fi
+`, logFile)
+	if fail {
+		script += "echo 'fake pdnsutil failed' >&2\nexit 1\n"
+	}
+	if err := os.WriteFile(filepath.Join(dir, "pdnsutil"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return logFile
+}
+
+func TestPdnsutilDNSRegistrar(t *testing.T) {
+	zoneFile := filepath.Join(t.TempDir(), "u.isucon.dev.zone")
+	if err := os.WriteFile(zoneFile, []byte("pipe IN A <ISUCON_SUBDOMAIN_ADDRESS>\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		call    func(ctx context.Context, r DNSRegistrar) error
+		wantLog string
+	}{
+		{
+			name:    "add record",
+			call:    func(ctx context.Context, r DNSRegistrar) error { return r.AddRecord(ctx, "newuser") },
+			wantLog: "add-record u.isucon.dev newuser A 3600 192.0.2.1\n",
+		},
+		{
+			name:    "remove record",
+			call:    func(ctx context.Context, r DNSRegistrar) error { return r.RemoveRecord(ctx, "newuser") },
+			wantLog: "delete-rrset u.isucon.dev newuser A\n",
+		},
+		{
+			// アドレスを埋め込んだゾーンファイルを読み込ませる
+			name:    "reset zone",
+			call:    func(ctx context.Context, r DNSRegistrar) error { return r.ResetZone(ctx) },
+			wantLog: "pipe IN A 192.0.2.1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logFile := installFakePdnsutil(t, false)
+			r := &pdnsutilDNSRegistrar{zone: "u.isucon.dev", zoneFile: zoneFile, address: "192.0.2.1"}
+
+			assert.NoError(t, tt.call(context.Background(), r))
+			b, err := os.ReadFile(logFile)
+			assert.NoError(t, err)
+			assert.Contains(t, string(b), tt.wantLog)
+		})
+		t.Run(tt.name+" failure", func(t *testing.T) {
+			installFakePdnsutil(t, true)
+			r := &pdnsutilDNSRegistrar{zone: "u.isucon.dev", zoneFile: zoneFile, address: "192.0.2.1"}
+
+			// pdnsutilの出力をエラーに含める
+			assert.ErrorContains(t, tt.call(context.Background(), r), "fake pdnsutil failed")
+		})
+	}
+
+	t.Run("reset zone without zone file", func(t *testing.T) {
+		installFakePdnsutil(t, false)
+		r := &pdnsutilDNSRegistrar{zone: "u.isucon.dev", zoneFile: filepath.Join(t.TempDir(), "missing.zone"), address: "192.0.2.1"}
+		assert.ErrorContains(t, r.ResetZone(context.Background()), "failed to read zone file")
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// zoneRecord はPowerDNSのrecordsテーブルの1行に対応する
+type zoneRecord struct {
+	// Name は末尾のドットを除いた完全修飾名
+	Name    string
+	Type    string
+	Content string
+	TTL     int
+}
+
+// loadZoneTemplate はゾーンファイルを読み、サブドメインのアドレスを埋め込む
+func loadZoneTemplate(path string, address string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read zone file: %w", err)
+	}
+	return strings.ReplaceAll(string(b), "<ISUCON_SUBDOMAIN_ADDRESS>", address), nil
+}
+
+// parseZone はゾーンファイルをレコードに分解する
+// u.isucon.dev.zoneで使っている書式($TTL, $ORIGIN, 括弧による複数行, 所有者名の省略)だけに対応する
+func parseZone(content string, origin string) ([]zoneRecord, error) {
+	var (
+		records []zoneRecord
+		ttl     = powerDNSRecordTTL
+		owner   string
+		pending []string
+		depth   int
+	)
+
+	for n, line := range strings.Split(content, "\n") {
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		// 括弧で囲まれた部分は1行につなげてから解釈する
+		if depth == 0 {
+			// 行頭が空白なら直前のレコードと同じ所有者名
+			if line[0] == ' ' || line[0] == '\t' {
+				pending = []string{"", strings.TrimSpace(line)}
+			} else {
+				pending = []string{line}
+			}
+		} else {
+			pending = append(pending, line)
+		}
+		depth += strings.Count(line, "(") - strings.Count(line, ")")
+		if depth > 0 {
+			continue
+		}
+		if depth < 0 {
+			return nil, fmt.Errorf("line %d: unbalanced parenthesis", n+1)
+		}
+
+		joined := strings.NewReplacer("(", " ", ")", " ").Replace(strings.Join(pending, " "))
+		fields := strings.Fields(joined)
+		inheritOwner := pending[0] == ""
+
+		switch {
+		case !inheritOwner && fields[0] == "$TTL":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: invalid $TTL", n+1)
+			}
+			v, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid $TTL: %w", n+1, err)
+			}
+			ttl = v
+			continue
+		case !inheritOwner && fields[0] == "$ORIGIN":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: invalid $ORIGIN", n+1)
+			}
+			origin = strings.TrimSuffix(fields[1], ".")
+			continue
+		}
+
+		if !inheritOwner {
+			owner = qualifyZoneName(fields[0], origin)
+			fields = fields[1:]
+		}
+		if owner == "" {
+			return nil, fmt.Errorf("line %d: owner name is missing", n+1)
+		}
+
+		recordTTL := ttl
+		for len(fields) > 0 {
+			if v, err := strconv.Atoi(fields[0]); err == nil {
+				recordTTL = v
+			} else if !strings.EqualFold(fields[0], "IN") {
+				break
+			}
+			fields = fields[1:]
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: record type or data is missing", n+1)
+		}
+
+		rtype, rdata := strings.ToUpper(fields[0]), fields[1:]
+		switch rtype {
+		case "SOA":
+			if len(rdata) != 7 {
+				return nil, fmt.Errorf("line %d: invalid SOA record", n+1)
+			}
+			rdata[0] = qualifyZoneName(rdata[0], origin)
+			rdata[1] = qualifyZoneName(rdata[1], origin)
+		case "NS", "CNAME":
+			rdata[0] = qualifyZoneName(rdata[0], origin)
+		}
+		records = append(records, zoneRecord{
+			Name:    owner,
+			Type:    rtype,
+			Content: strings.Join(rdata, " "),
+			TTL:     recordTTL,
+		})
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parenthesis at end of zone")
+	}
+	return records, nil
+}
+
+// qualifyZoneName はゾーン内の相対名を、末尾のドットを除いた完全修飾名にする
+func qualifyZoneName(name string, origin string) string {
+	switch {
+	case name == "@":
+		return origin
+	case strings.HasSuffix(name, "."):
+		return strings.ToLower(strings.TrimSuffix(name, "."))
+	default:
+		return strings.ToLower(name) + "." + origin
+	}
+}
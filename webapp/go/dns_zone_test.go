@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseZone(t *testing.T) {
+	content := `$TTL 3600
+@   SOA  ns1 hostmaster.u.isucon.dev. (
+    0      ; serial
+    10800  ; refresh
+    3600   ; retry
+    604800 ; xxpire
+    3600   ; ncache
+)
+
+@        IN NS ns1.u.isucon.dev.
+@        IN A  192.0.2.1
+ns1      IN A  192.0.2.1
+         60 IN A 192.0.2.2
+WWW      IN A  192.0.2.1
+`
+
+	records, err := parseZone(content, "u.isucon.dev")
+	assert.NoError(t, err)
+	assert.Equal(t, []zoneRecord{
+		{Name: "u.isucon.dev", Type: "SOA", Content: "ns1.u.isucon.dev hostmaster.u.isucon.dev 0 10800 3600 604800 3600", TTL: 3600},
+		{Name: "u.isucon.dev", Type: "NS", Content: "ns1.u.isucon.dev", TTL: 3600},
+		{Name: "u.isucon.dev", Type: "A", Content: "192.0.2.1", TTL: 3600},
+		{Name: "ns1.u.isucon.dev", Type: "A", Content: "192.0.2.1", TTL: 3600},
+		{Name: "ns1.u.isucon.dev", Type: "A", Content: "192.0.2.2", TTL: 60},
+		{Name: "www.u.isucon.dev", Type: "A", Content: "192.0.2.1", TTL: 3600},
+	}, records)
+
+	_, err = parseZone("@ SOA ns1 hostmaster (\n0 1 2 3 4\n", "u.isucon.dev")
+	assert.Error(t, err)
+}
+
+func TestMemoryDNSRegistrarResetZone(t *testing.T) {
+	ctx := context.Background()
+	r := newMemoryDNSRegistrar("u.isucon.dev", defaultPowerDNSZoneFile, "192.0.2.1")
+	assert.NoError(t, r.AddRecord(ctx, "newuser"))
+
+	assert.NoError(t, r.ResetZone(ctx))
+
+	// 後から登録したレコードは消え、ゾーンファイルのレコードだけが残る
+	_, ok := r.Lookup("newuser")
+	assert.False(t, ok)
+	address, ok := r.Lookup("test001")
+	assert.True(t, ok)
+	assert.Equal(t, "192.0.2.1", address)
+}
+
+// ベースドメインを変えた場合は、設定したゾーンファイルからゾーンを戻す
+func TestMemoryDNSRegistrarResetZoneFile(t *testing.T) {
+	ctx := context.Background()
+	zoneFile := filepath.Join(t.TempDir(), "t.isucon.dev.zone")
+	content := `$TTL 3600
+@   SOA  ns1 hostmaster.t.isucon.dev. (
+    0 10800 3600 604800 3600
+)
+@        IN NS ns1.t.isucon.dev.
+ns1      IN A  <ISUCON_SUBDOMAIN_ADDRESS>
+pipe     IN A  <ISUCON_SUBDOMAIN_ADDRESS>
+`
+	if err := os.WriteFile(zoneFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newMemoryDNSRegistrar("t.isucon.dev", zoneFile, "192.0.2.1")
+	assert.NoError(t, r.ResetZone(ctx))
+
+	address, ok := r.Lookup("pipe")
+	assert.True(t, ok)
+	assert.Equal(t, "192.0.2.1", address)
+	// 同梱のu.isucon.dev用のゾーンファイルのレコードは含まれない
+	_, ok = r.Lookup("test001")
+	assert.False(t, ok)
+
+	r = newMemoryDNSRegistrar("t.isucon.dev", filepath.Join(t.TempDir(), "missing.zone"), "192.0.2.1")
+	assert.ErrorContains(t, r.ResetZone(ctx), "failed to read zone file")
+}
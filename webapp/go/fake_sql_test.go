@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// fakeSQLResult はfakeSQLHandlerが返すクエリの結果
+// Columnsが空の場合はExecの結果として扱う
+type fakeSQLResult struct {
+	Columns      []string
+	Rows         [][]driver.Value
+	RowsAffected int64
+}
+
+// fakeSQLHandler は発行されたクエリに対する結果を返す
+// トランザクションの開始と終了は"BEGIN", "COMMIT", "ROLLBACK"として渡す
+type fakeSQLHandler func(query string, args []driver.Value) (fakeSQLResult, error)
+
+// fakeSQL はfakeSQLHandlerで応答するdatabase/sqlのドライバ
+// MySQLを使うストアを、データベースなしでテストするために使う
+type fakeSQL struct {
+	mu      sync.Mutex
+	handler fakeSQLHandler
+	queries []string
+}
+
+// newFakeSQLDB はhandlerで応答するデータベースを返す
+func newFakeSQLDB(t *testing.T, handler fakeSQLHandler) (*sqlx.DB, *fakeSQL) {
+	t.Helper()
+
+	f := &fakeSQL{handler: handler}
+	db := sqlx.NewDb(sql.OpenDB(f), "mysql")
+	t.Cleanup(func() { db.Close() })
+	return db, f
+}
+
+// Queries は発行されたクエリを順に返す
+func (f *fakeSQL) Queries() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.queries...)
+}
+
+func (f *fakeSQL) handle(query string, args []driver.NamedValue) (fakeSQLResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.queries = append(f.queries, query)
+	values := make([]driver.Value, len(args))
+	for i, a := range args {
+		values[i] = a.Value
+	}
+	return f.handler(query, values)
+}
+
+func (f *fakeSQL) Connect(context.Context) (driver.Conn, error) {
+	return &fakeSQLConn{f: f}, nil
+}
+
+func (f *fakeSQL) Driver() driver.Driver {
+	return fakeSQLDriver{}
+}
+
+type fakeSQLDriver struct{}
+
+func (fakeSQLDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fake sql driver must be opened with sql.OpenDB")
+}
+
+type fakeSQLConn struct {
+	f *fakeSQL
+}
+
+func (c *fakeSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeSQLStmt{c: c, query: query}, nil
+}
+
+func (c *fakeSQLConn) Close() error {
+	return nil
+}
+
+func (c *fakeSQLConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeSQLConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	if _, err := c.f.handle("BEGIN", nil); err != nil {
+		return nil, err
+	}
+	return &fakeSQLTx{c: c}, nil
+}
+
+func (c *fakeSQLConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	r, err := c.f.handle(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(r.RowsAffected), nil
+}
+
+func (c *fakeSQLConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	r, err := c.f.handle(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeSQLRows{columns: r.Columns, rows: r.Rows}, nil
+}
+
+type fakeSQLTx struct {
+	c *fakeSQLConn
+}
+
+func (tx *fakeSQLTx) Commit() error {
+	_, err := tx.c.f.handle("COMMIT", nil)
+	return err
+}
+
+func (tx *fakeSQLTx) Rollback() error {
+	_, err := tx.c.f.handle("ROLLBACK", nil)
+	return err
+}
+
+// fakeSQLStmt はExecContext, QueryContextを使わない呼び出しのためのプリペアドステートメント
+type fakeSQLStmt struct {
+	c     *fakeSQLConn
+	query string
+}
+
+func (s *fakeSQLStmt) Close() error {
+	return nil
+}
+
+func (s *fakeSQLStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeSQLStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.c.ExecContext(context.Background(), s.query, namedValues(args))
+}
+
+func (s *fakeSQLStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.c.QueryContext(context.Background(), s.query, namedValues(args))
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
+
+type fakeSQLRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeSQLRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeSQLRows) Close() error {
+	return nil
+}
+
+func (r *fakeSQLRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+// フィードで一度に返す配信数の上限
+const maxFeedLimit = 100
+
+type FollowModel struct {
+	ID         int64 `db:"id"`
+	FollowerID int64 `db:"follower_id"`
+	FolloweeID int64 `db:"followee_id"`
+	CreatedAt  int64 `db:"created_at"`
+}
+
+// 配信者のフォローAPI
+// POST /api/user/:username/follow
+func followHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	username := c.Param("username")
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	var followee User
+	err := repositoryStore.Tx(ctx, func(r Repository) error {
+		followeeModel, err := r.Users().FindByName(ctx, username)
+		if errors.Is(err, ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found user that has the given username")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+		}
+		if followeeModel.ID == userID {
+			return echo.NewHTTPError(http.StatusBadRequest, "can't follow yourself")
+		}
+
+		// 既にフォローしている場合は何もしない
+		if err := r.Follows().Follow(ctx, FollowModel{
+			FollowerID: userID,
+			FolloweeID: followeeModel.ID,
+			CreatedAt:  time.Now().Unix(),
+		}); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert follow: "+err.Error())
+		}
+
+		followee, err = fillUserResponse(ctx, r, followeeModel)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill user: "+err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, followee)
+}
+
+// 配信者のフォロー解除API
+// DELETE /api/user/:username/follow
+func unfollowHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	username := c.Param("username")
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	err := repositoryStore.Tx(ctx, func(r Repository) error {
+		followeeModel, err := r.Users().FindByName(ctx, username)
+		if errors.Is(err, ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found user that has the given username")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+		}
+
+		if err := r.Follows().Unfollow(ctx, userID, followeeModel.ID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete follow: "+err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// フォロー中の配信者一覧取得API
+// GET /api/user/me/following
+func getFollowingHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	var followees []User
+	err := repositoryStore.Tx(ctx, func(r Repository) error {
+		followeeModels, err := r.Follows().ListFollowees(ctx, userID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get followees: "+err.Error())
+		}
+
+		followees = make([]User, 0, len(followeeModels))
+		if len(followeeModels) > 0 {
+			users, err := fillUsersResponse(ctx, r, followeeModels)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill users: "+err.Error())
+			}
+			for _, u := range followeeModels {
+				followees = append(followees, *users[u.ID])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, followees)
+}
+
+// フォロー中の配信者の配信予定・配信中の配信一覧取得API
+// GET /api/feed?limit=
+func getFeedHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	limit := maxFeedLimit
+	if v := c.QueryParam("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, "limit query parameter must be positive integer")
+		}
+		if l < limit {
+			limit = l
+		}
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreams := []Livestream{}
+	err := repositoryStore.Tx(ctx, func(r Repository) error {
+		// 終了していない配信を、開始が早い順に返す
+		livestreamModels, err := r.Follows().Feed(ctx, userID, time.Now().Unix(), limit)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestreams: "+err.Error())
+		}
+
+		if len(livestreamModels) > 0 {
+			livestreams, err = fillLivestreamsResponse(ctx, r, livestreamModels)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestreams: "+err.Error())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, livestreams)
+}
+
+// countFollowers はユーザごとのフォロワー数を返す. フォロワーがいないユーザはマップに含まれない
+func countFollowers(ctx context.Context, tx *sqlx.Tx, userIDs []int64) (map[int64]int64, error) {
+	counts := make(map[int64]int64, len(userIDs))
+	if len(userIDs) == 0 {
+		return counts, nil
+	}
+
+	var rows []struct {
+		FolloweeID int64 `db:"followee_id"`
+		Count      int64 `db:"count"`
+	}
+	query, params, err := sqlx.In("SELECT followee_id, COUNT(*) AS count FROM follows WHERE followee_id IN (?) GROUP BY followee_id", userIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create follows query: %w", err)
+	}
+	if err := tx.SelectContext(ctx, &rows, query, params...); err != nil {
+		return nil, fmt.Errorf("failed to count followers: %w", err)
+	}
+	for _, r := range rows {
+		counts[r.FolloweeID] = r.Count
+	}
+	return counts, nil
+}
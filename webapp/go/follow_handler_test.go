@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// フィクスチャではviewerがstreamerをフォローしている
+func TestFollowHandler(t *testing.T) {
+	tests := []struct {
+		name          string
+		username      string
+		userID        int64
+		wantStatus    int
+		wantFollowers int64
+		wantFollows   int
+	}{
+		{name: "follow", username: "streamer", userID: testCollaboratorID, wantStatus: http.StatusOK, wantFollowers: 2, wantFollows: 2},
+		// 既にフォローしている場合は何もしない
+		{name: "follow again", username: "streamer", userID: testViewerID, wantStatus: http.StatusOK, wantFollowers: 1, wantFollows: 1},
+		{name: "follow yourself", username: "streamer", userID: testStreamerID, wantStatus: http.StatusBadRequest, wantFollows: 1},
+		{name: "user not found", username: "nobody", userID: testViewerID, wantStatus: http.StatusNotFound, wantFollows: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			rec := serveTestRequest(t, http.MethodPost, "/api/user/:username/follow", "/api/user/"+tt.username+"/follow", tt.userID, followHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Len(t, s.data.follows, tt.wantFollows)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			followee := decodeResponse[User](t, rec)
+			assert.Equal(t, tt.username, followee.Name)
+			assert.Equal(t, tt.wantFollowers, followee.FollowersCount)
+		})
+	}
+}
+
+func TestUnfollowHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		username    string
+		userID      int64
+		wantStatus  int
+		wantFollows int
+	}{
+		{name: "unfollow", username: "streamer", userID: testViewerID, wantStatus: http.StatusNoContent, wantFollows: 0},
+		// フォローしていない場合は何もしない
+		{name: "not following", username: "streamer", userID: testCollaboratorID, wantStatus: http.StatusNoContent, wantFollows: 1},
+		{name: "user not found", username: "nobody", userID: testViewerID, wantStatus: http.StatusNotFound, wantFollows: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			rec := serveTestRequest(t, http.MethodDelete, "/api/user/:username/follow", "/api/user/"+tt.username+"/follow", tt.userID, unfollowHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Len(t, s.data.follows, tt.wantFollows)
+		})
+	}
+}
+
+func TestGetFollowingHandler(t *testing.T) {
+	tests := []struct {
+		name      string
+		userID    int64
+		wantNames []string
+	}{
+		// フォローした新しい順に返す
+		{name: "following", userID: testViewerID, wantNames: []string{"collaborator", "streamer"}},
+		{name: "not following", userID: testStreamerID, wantNames: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			s.data.follows = append(s.data.follows, FollowModel{ID: 2, FollowerID: testViewerID, FolloweeID: testCollaboratorID})
+			rec := serveTestRequest(t, http.MethodGet, "/api/user/me/following", "/api/user/me/following", tt.userID, getFollowingHandler)
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			names := []string{}
+			for _, u := range decodeResponse[[]User](t, rec) {
+				names = append(names, u.Name)
+			}
+			assert.Equal(t, tt.wantNames, names)
+		})
+	}
+}
+
+func TestGetFeedHandler(t *testing.T) {
+	const otherStreamerID, otherLivestreamID int64 = 4, 3
+
+	tests := []struct {
+		name       string
+		statuses   [2]string
+		query      string
+		userID     int64
+		wantStatus int
+		wantIDs    []int64
+	}{
+		{
+			name:       "live and upcoming",
+			statuses:   [2]string{livestreamStatusLive, livestreamStatusUpcoming},
+			userID:     testViewerID,
+			wantStatus: http.StatusOK,
+			wantIDs:    []int64{testLivestreamID, testOtherLivestreamID},
+		},
+		{
+			// IDではなく開始日時の順に並べる
+			name:       "ordered by start_at",
+			statuses:   [2]string{livestreamStatusUpcoming, livestreamStatusLive},
+			userID:     testViewerID,
+			wantStatus: http.StatusOK,
+			wantIDs:    []int64{testOtherLivestreamID, testLivestreamID},
+		},
+		{
+			name:       "ended livestreams are excluded",
+			statuses:   [2]string{livestreamStatusEnded, livestreamStatusUpcoming},
+			userID:     testViewerID,
+			wantStatus: http.StatusOK,
+			wantIDs:    []int64{testOtherLivestreamID},
+		},
+		{
+			name:       "limit",
+			statuses:   [2]string{livestreamStatusLive, livestreamStatusUpcoming},
+			query:      "?limit=1",
+			userID:     testViewerID,
+			wantStatus: http.StatusOK,
+			wantIDs:    []int64{testLivestreamID},
+		},
+		{
+			name:       "not following",
+			statuses:   [2]string{livestreamStatusLive, livestreamStatusUpcoming},
+			userID:     testCollaboratorID,
+			wantStatus: http.StatusOK,
+			wantIDs:    []int64{},
+		},
+		{
+			name:       "zero limit",
+			statuses:   [2]string{livestreamStatusLive, livestreamStatusUpcoming},
+			query:      "?limit=0",
+			userID:     testViewerID,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid limit",
+			statuses:   [2]string{livestreamStatusLive, livestreamStatusUpcoming},
+			query:      "?limit=all",
+			userID:     testViewerID,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			moveTestLivestream(s, testLivestreamID, tt.statuses[0])
+			moveTestLivestream(s, testOtherLivestreamID, tt.statuses[1])
+			// フォローしていない配信者の配信は含めない
+			s.data.users[otherStreamerID] = UserModel{ID: otherStreamerID, Name: "other"}
+			l := s.data.livestreams[testLivestreamID]
+			l.ID, l.UserID = otherLivestreamID, otherStreamerID
+			s.data.livestreams[otherLivestreamID] = l
+
+			rec := serveTestRequest(t, http.MethodGet, "/api/feed", "/api/feed"+tt.query, tt.userID, getFeedHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			ids := []int64{}
+			for _, l := range decodeResponse[[]Livestream](t, rec) {
+				ids = append(ids, l.ID)
+			}
+			assert.Equal(t, tt.wantIDs, ids)
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 初期化処理
+// 以前はinit.shでmysqlクライアントとpdnsutilを呼び出していたが、
+// CLIツールへの依存をなくし、既存のDB接続で初期データを投入する
+
+var initialDataDir = "../sql"
+
+// 投入する順に並べる
+var initialDataFiles = []string{
+	"init.sql",
+	"initial_users.sql",
+	"initial_livestreams.sql",
+	"initial_tags.sql",
+	"initial_livestream_tags.sql",
+	"initial_reservation_slots.sql",
+	"initial_reactions.sql",
+	"initial_ngwords.sql",
+	"initial_livecomments.sql",
+	"init_stats.sql",
+}
+
+// 複数行INSERTにまとめる際の1文あたりの上限
+// max_allowed_packet(デフォルト64MB)より十分小さくしておく
+const maxInsertBatchBytes = 1 << 20
+
+type InitializeStep struct {
+	Name string `json:"name"`
+	// ElapsedMs は処理にかかった時間(ミリ秒)
+	ElapsedMs int64 `json:"elapsed_ms"`
+}
+
+// initializer は各ステップの所要時間を記録しながら初期化を進める
+type initializer struct {
+	steps []InitializeStep
+}
+
+func (i *initializer) step(name string, fn func() error) error {
+	startedAt := time.Now()
+	if err := fn(); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	i.steps = append(i.steps, InitializeStep{Name: name, ElapsedMs: time.Since(startedAt).Milliseconds()})
+	return nil
+}
+
+func (i *initializer) Run(ctx context.Context, db *sqlx.DB, registrar DNSRegistrar) error {
+	// 新しいデータベースでは初期スキーマとマイグレーションを適用してから初期データを入れる
+	if err := i.step("schema", func() error {
+		return withMigrator(ctx, db, io.Discard, func(m *migrator) error {
+			return m.Up(ctx)
+		})
+	}); err != nil {
+		return err
+	}
+	for _, name := range initialDataFiles {
+		if err := i.step(name, func() error {
+			return execSQLFile(ctx, db, filepath.Join(initialDataDir, name))
+		}); err != nil {
+			return err
+		}
+	}
+	if err := i.step("dns", func() error {
+		return registrar.ResetZone(ctx)
+	}); err != nil {
+		return err
+	}
+	return i.step("icons", resetIconDir)
+}
+
+// execSQLFile はSQLファイルの文を1つのトランザクションで実行する
+// 同じテーブル・カラムへの1行ずつのINSERTは複数行INSERTにまとめてから送る
+func execSQLFile(ctx context.Context, db *sqlx.DB, path string) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	stmts := batchInserts(splitSQLStatements(string(src)), maxInsertBatchBytes)
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to execute %.80q: %w", stmt, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	return nil
+}
+
+// splitSQLStatements はSQLを文ごとに分割する
+// 文字列リテラルと識別子の中のセミコロン、コメントを考慮する. コメントと空の文は取り除く
+// データベースはDSNで指定しているので、USE文も取り除く
+func splitSQLStatements(src string) []string {
+	var (
+		stmts []string
+		buf   strings.Builder
+		quote byte
+	)
+	flush := func() {
+		stmt := strings.TrimSpace(buf.String())
+		buf.Reset()
+		if stmt == "" || strings.HasPrefix(strings.ToUpper(stmt), "USE ") {
+			return
+		}
+		stmts = append(stmts, stmt)
+	}
+
+	for i := 0; i < len(src); i++ {
+		ch := src[i]
+		if quote != 0 {
+			buf.WriteByte(ch)
+			switch {
+			case ch == '\\' && quote != '`' && i+1 < len(src):
+				i++
+				buf.WriteByte(src[i])
+			case ch == quote:
+				quote = 0
+			}
+			continue
+		}
+
+		switch {
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+			buf.WriteByte(ch)
+		case ch == '#' || isLineCommentStart(src[i:]):
+			// 行末までコメント
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			buf.WriteByte('\n')
+		case ch == '/' && strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				i = len(src)
+			} else {
+				i += end + 3
+			}
+			buf.WriteByte(' ')
+		case ch == ';':
+			flush()
+		default:
+			buf.WriteByte(ch)
+		}
+	}
+	flush()
+	return stmts
+}
+
+// isLineCommentStart はsが"-- "形式のコメントで始まるか判定する. MySQLでは--の後に空白か改行が必要
+func isLineCommentStart(s string) bool {
+	if !strings.HasPrefix(s, "--") {
+		return false
+	}
+	return len(s) == 2 || s[2] == ' ' || s[2] == '\t' || s[2] == '\n' || s[2] == '\r'
+}
+
+var insertValuesPattern = regexp.MustCompile(`(?is)^(INSERT\s+INTO\s+[\w` + "`" + `]+\s*\([^()'"]*\)\s*VALUES)\s*(\(.*\))$`)
+
+// batchInserts は同じテーブル・カラムへのINSERTの行をまとめて、文の数を減らす
+// 初期データはテーブルをまたいだ順序に依存しないので、テーブルごとにまとめる
+// INSERT以外の文の前では、それまでにまとめた行をすべて書き出して順序を保つ
+func batchInserts(stmts []string, maxBytes int) []string {
+	var (
+		batched []string
+		order   []string
+		pending = map[string]*strings.Builder{}
+	)
+	flushPrefix := func(prefix string) {
+		if b := pending[prefix]; b != nil {
+			batched = append(batched, b.String())
+			delete(pending, prefix)
+		}
+	}
+	flushAll := func() {
+		for _, prefix := range order {
+			flushPrefix(prefix)
+		}
+		order = order[:0]
+	}
+
+	for _, stmt := range stmts {
+		m := insertValuesPattern.FindStringSubmatch(stmt)
+		if m == nil || strings.Contains(strings.ToUpper(m[2]), "ON DUPLICATE KEY") {
+			flushAll()
+			batched = append(batched, stmt)
+			continue
+		}
+		prefix, rows := m[1], m[2]
+		if len(stmt) >= maxBytes {
+			// 1文で上限を超えるものは、同じテーブルのそれまでの行を書き出してからそのまま送る
+			flushPrefix(prefix)
+			batched = append(batched, stmt)
+			continue
+		}
+		if b := pending[prefix]; b != nil && b.Len()+len(rows)+1 > maxBytes {
+			flushPrefix(prefix)
+		}
+		b := pending[prefix]
+		if b == nil {
+			b = &strings.Builder{}
+			b.WriteString(prefix)
+			b.WriteByte(' ')
+			pending[prefix] = b
+			order = append(order, prefix)
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(rows)
+	}
+	flushAll()
+	return batched
+}
+
+// resetIconDir はアップロードされたアイコンを削除し、フォールバック画像だけを置く
+func resetIconDir() error {
+	if err := os.MkdirAll(iconPath, 0755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(iconPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(iconPath, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	src, err := os.Open(fallbackImage)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(filepath.Join(iconPath, "NoImage.jpg"))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
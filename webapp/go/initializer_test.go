@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitSQLStatements(t *testing.T) {
+	src := "-- コメント\n" +
+		"USE `isupipe`;\n" +
+		"INSERT INTO users (name, description) VALUES ('a;b', 'it\\'s; ok');\n" +
+		"# ハッシュのコメント\n" +
+		"INSERT INTO tags(name) VALUES (\"x -- y\"); /* ブロック; コメント */\n" +
+		"UPDATE `a;b` SET c = 1--\n" +
+		";\n" +
+		";"
+
+	assert.Equal(t, []string{
+		"INSERT INTO users (name, description) VALUES ('a;b', 'it\\'s; ok')",
+		"INSERT INTO tags(name) VALUES (\"x -- y\")",
+		"UPDATE `a;b` SET c = 1",
+	}, splitSQLStatements(src))
+}
+
+func TestBatchInserts(t *testing.T) {
+	stmts := []string{
+		"INSERT INTO users (id, name) VALUES (1, 'a')",
+		"INSERT INTO themes (user_id, dark_mode) VALUES (1, true)",
+		"INSERT INTO users (id, name) VALUES (2, 'b'), (3, 'c')",
+		"INSERT INTO themes (user_id, dark_mode) VALUES (2, false)",
+		"UPDATE users SET name = 'z' WHERE id = 1",
+		"INSERT INTO users (id, name) VALUES (4, 'd') ON DUPLICATE KEY UPDATE name = VALUES(name)",
+		"INSERT INTO users (id, name) VALUES (5, 'e')",
+	}
+
+	assert.Equal(t, []string{
+		"INSERT INTO users (id, name) VALUES (1, 'a'),(2, 'b'), (3, 'c')",
+		"INSERT INTO themes (user_id, dark_mode) VALUES (1, true),(2, false)",
+		"UPDATE users SET name = 'z' WHERE id = 1",
+		"INSERT INTO users (id, name) VALUES (4, 'd') ON DUPLICATE KEY UPDATE name = VALUES(name)",
+		"INSERT INTO users (id, name) VALUES (5, 'e')",
+	}, batchInserts(stmts, maxInsertBatchBytes))
+
+	// 上限を超える場合は分割する
+	assert.Equal(t, []string{
+		"INSERT INTO users (id, name) VALUES (1, 'a')",
+		"INSERT INTO users (id, name) VALUES (2, 'b'), (3, 'c')",
+	}, batchInserts([]string{stmts[0], stmts[2]}, 50))
+}
+
+// 初期データのファイルはすべて分割・結合でき、行数が変わらない
+func TestBatchInitialDataFiles(t *testing.T) {
+	for _, name := range initialDataFiles {
+		t.Run(name, func(t *testing.T) {
+			src, err := os.ReadFile(filepath.Join(initialDataDir, name))
+			if !assert.NoError(t, err) {
+				return
+			}
+			stmts := splitSQLStatements(string(src))
+			batched := batchInserts(stmts, maxInsertBatchBytes)
+			assert.LessOrEqual(t, len(batched), len(stmts))
+			for _, stmt := range batched {
+				// 元から上限より大きい文はそのまま送る
+				if len(stmt) > maxInsertBatchBytes {
+					assert.Contains(t, stmts, stmt)
+				}
+				assert.False(t, strings.HasSuffix(stmt, ";"))
+			}
+			assert.Equal(t, countInsertRows(t, stmts), countInsertRows(t, batched))
+		})
+	}
+}
+
+func countInsertRows(t *testing.T, stmts []string) int {
+	t.Helper()
+
+	var rows int
+	for _, stmt := range stmts {
+		if m := insertValuesPattern.FindStringSubmatch(stmt); m != nil {
+			// 行は "(" で始まり、値の中の括弧は関数呼び出しだけなので、"), (" か "),(" で区切る
+			rows += strings.Count(strings.ReplaceAll(m[2], "), (", "),("), "),(") + 1
+		}
+	}
+	return rows
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -28,6 +27,8 @@ type LivecommentModel struct {
 	Comment      string `db:"comment"`
 	Tip          int64  `db:"tip"`
 	CreatedAt    int64  `db:"created_at"`
+	// モデレーションにより論理削除した日時. 0の場合は削除されていない
+	DeletedAt int64 `db:"deleted_at"`
 }
 
 type Livecomment struct {
@@ -47,22 +48,29 @@ type LivecommentReport struct {
 }
 
 type LivecommentReportModel struct {
-	ID            int64 `db:"id"`
-	UserID        int64 `db:"user_id"`
-	LivestreamID  int64 `db:"livestream_id"`
-	LivecommentID int64 `db:"livecomment_id"`
-	CreatedAt     int64 `db:"created_at"`
+	ID            int64  `db:"id"`
+	UserID        int64  `db:"user_id"`
+	LivestreamID  int64  `db:"livestream_id"`
+	LivecommentID int64  `db:"livecomment_id"`
+	Status        string `db:"status"`
+	CreatedAt     int64  `db:"created_at"`
 }
 
 type ModerateRequest struct {
 	NGWord string `json:"ng_word"`
+	// 省略時はsubstring
+	MatchMode string `json:"match_mode"`
+	// 省略時はlivestream
+	Scope string `json:"scope"`
 }
 
 type NGWord struct {
-	ID           int64  `json:"id" db:"id"`
-	UserID       int64  `json:"user_id" db:"user_id"`
+	ID     int64 `json:"id" db:"id"`
+	UserID int64 `json:"user_id" db:"user_id"`
+	// 配信者の全ての配信に適用するNGワードの場合は0
 	LivestreamID int64  `json:"livestream_id" db:"livestream_id"`
 	Word         string `json:"word" db:"word"`
+	MatchMode    string `json:"match_mode" db:"match_mode"`
 	CreatedAt    int64  `json:"created_at" db:"created_at"`
 }
 
@@ -79,44 +87,52 @@ func getLivecommentsHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
 	}
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
+	page, err := parsePageRequest(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+		return err
 	}
-	defer tx.Rollback()
 
-	query := "SELECT * FROM livecomments WHERE livestream_id = ? ORDER BY created_at DESC"
-	if c.QueryParam("limit") != "" {
-		limit, err := strconv.Atoi(c.QueryParam("limit"))
-		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, "limit query parameter must be integer")
+	var (
+		livecomments []Livecomment
+		nextCursor   string
+	)
+	err = repositoryStore.Tx(ctx, func(r Repository) error {
+		if page.NeedsSortKey {
+			cursor, err := r.Livecomments().FindInLivestream(ctx, int64(livestreamID), page.Cursor.ID)
+			if errors.Is(err, ErrRecordNotFound) {
+				return echo.NewHTTPError(http.StatusBadRequest, "not found livecomment that has the given cursor id")
+			}
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to get cursor livecomment: "+err.Error())
+			}
+			page.Cursor.SortKey = cursor.CreatedAt
 		}
-		query += fmt.Sprintf(" LIMIT %d", limit)
-	}
-
-	livecommentModels := []LivecommentModel{}
-	err = tx.SelectContext(ctx, &livecommentModels, query, livestreamID)
-	if errors.Is(err, sql.ErrNoRows) {
-		return c.JSON(http.StatusOK, []*Livecomment{})
-	}
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomments: "+err.Error())
-	}
 
-	livecomments := make([]Livecomment, 0, len(livecommentModels))
-	if len(livecommentModels) > 0 {
-		l, err := fillLivecommentsResponse(ctx, tx, livecommentModels)
+		// モデレーションされたライブコメントは視聴者には見せない
+		livecommentModels, err := r.Livecomments().ListVisible(ctx, int64(livestreamID), page)
 		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomments: "+err.Error())
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomments: "+err.Error())
 		}
-		livecomments = l
-	}
 
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+		livecommentModels, nextCursor = paginate(page, livecommentModels, func(l LivecommentModel) (int64, int64) {
+			return l.CreatedAt, l.ID
+		})
+
+		livecomments = make([]Livecomment, 0, len(livecommentModels))
+		if len(livecommentModels) > 0 {
+			l, err := fillLivecommentsResponse(ctx, r, livecommentModels)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomments: "+err.Error())
+			}
+			livecomments = l
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	return c.JSON(http.StatusOK, livecomments)
+	return writePage(c, page, livecomments, nextCursor)
 }
 
 func getNgwords(c echo.Context) error {
@@ -136,23 +152,37 @@ func getNgwords(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
 	}
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
-	}
-	defer tx.Rollback()
-
 	var ngWords []*NGWord
-	if err := tx.SelectContext(ctx, &ngWords, "SELECT * FROM ng_words WHERE user_id = ? AND livestream_id = ? ORDER BY created_at DESC", userID, livestreamID); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return c.JSON(http.StatusOK, []*NGWord{})
-		} else {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get NG words: "+err.Error())
+	err = repositoryStore.Tx(ctx, func(r Repository) error {
+		// コラボレーターには配信者が登録したNGワードを返す
+		ngWordOwnerID := userID
+		livestreamModel, err := r.Livestreams().FindByID(ctx, int64(livestreamID))
+		if err != nil && !errors.Is(err, ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+		}
+		if livestreamModel.ID != 0 {
+			isModerator, err := r.Livestreams().IsModerator(ctx, livestreamModel, userID)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to check livestream collaborators: "+err.Error())
+			}
+			if isModerator {
+				ngWordOwnerID = livestreamModel.UserID
+			}
 		}
-	}
 
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+		// 配信者の全ての配信に適用するNGワードも合わせて返す
+		livestreamIDs := []int64{int64(livestreamID)}
+		if livestreamModel.ID != 0 && livestreamModel.UserID == ngWordOwnerID {
+			livestreamIDs = append(livestreamIDs, channelNGWordLivestreamID)
+		}
+		ngWords, err = r.NGWords().List(ctx, ngWordOwnerID, livestreamIDs)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get NG words: "+err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return c.JSON(http.StatusOK, ngWords)
@@ -181,61 +211,70 @@ func postLivecommentHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
 	}
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
-	}
-	defer tx.Rollback()
-
-	var livestreamModel LivestreamModel
-	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+	var (
+		livecommentModel LivecommentModel
+		livecomment      Livecomment
+	)
+	err = repositoryStore.Tx(ctx, func(r Repository) error {
+		livestreamModel, err := r.Livestreams().FindByID(ctx, int64(livestreamID))
+		if errors.Is(err, ErrRecordNotFound) {
 			return echo.NewHTTPError(http.StatusNotFound, "livestream not found")
-		} else {
+		}
+		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
 		}
-	}
-
-	// スパム判定
-	var ngwords []*NGWord
-	if err := tx.SelectContext(ctx, &ngwords, "SELECT id, user_id, livestream_id, word FROM ng_words WHERE user_id = ? AND livestream_id = ?", livestreamModel.UserID, livestreamModel.ID); err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get NG words: "+err.Error())
-	}
 
-	for _, ngword := range ngwords {
-		if strings.Contains(strings.ToLower(req.Comment), ngword.Word) {
-			c.Logger().Infof("comment %q hists ngword %q", req.Comment, ngword.Word)
-			return echo.NewHTTPError(http.StatusBadRequest, "このコメントがスパム判定されました")
+		if err := verifyLivestreamIsLive(ctx, r, livestreamModel.ID); err != nil {
+			return err
 		}
-	}
 
-	now := time.Now().Unix()
-	livecommentModel := LivecommentModel{
-		UserID:       userID,
-		LivestreamID: int64(livestreamID),
-		Comment:      req.Comment,
-		Tip:          req.Tip,
-		CreatedAt:    now,
-	}
+		// スパム判定
+		ngwords, err := r.NGWords().List(ctx, livestreamModel.UserID, []int64{livestreamModel.ID, channelNGWordLivestreamID})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get NG words: "+err.Error())
+		}
+		for _, ngword := range ngwords {
+			matcher, err := newNGWordMatcher(ngword.Word, ngword.MatchMode)
+			if err != nil {
+				c.Logger().Warnf("skip broken ngword %d: %+v", ngword.ID, err)
+				continue
+			}
+			if matcher.Match(req.Comment) {
+				c.Logger().Infof("comment %q hists ngword %q", req.Comment, ngword.Word)
+				return echo.NewHTTPError(http.StatusBadRequest, "このコメントがスパム判定されました")
+			}
+		}
 
-	rs, err := tx.NamedExecContext(ctx, "INSERT INTO livecomments (user_id, livestream_id, comment, tip, created_at) VALUES (:user_id, :livestream_id, :comment, :tip, :created_at)", livecommentModel)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert livecomment: "+err.Error())
-	}
+		livecommentModel = LivecommentModel{
+			UserID:       userID,
+			LivestreamID: int64(livestreamID),
+			Comment:      req.Comment,
+			Tip:          req.Tip,
+			CreatedAt:    time.Now().Unix(),
+		}
+		if err := r.Livecomments().Create(ctx, &livecommentModel); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert livecomment: "+err.Error())
+		}
 
-	livecommentID, err := rs.LastInsertId()
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get last inserted livecomment id: "+err.Error())
-	}
-	livecommentModel.ID = livecommentID
+		if err := r.Tips().Record(ctx, livecommentModel, livestreamModel.UserID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		if err := r.Stats().CountLivecomment(ctx, livecommentModel.LivestreamID, livecommentModel.Tip); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
 
-	livecomment, err := fillLivecommentResponse(ctx, tx, livecommentModel)
+		livecomment, err = fillLivecommentResponse(ctx, r, livecommentModel)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment: "+err.Error())
+		}
+		return nil
+	})
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment: "+err.Error())
+		return err
 	}
 
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	if err := eventBroker.Publish(livecommentModel.LivestreamID, livestreamEventLivecomment, livecomment); err != nil {
+		c.Logger().Warnf("failed to publish livecomment event: %+v", err)
 	}
 
 	return c.JSON(http.StatusCreated, livecomment)
@@ -263,53 +302,62 @@ func reportLivecommentHandler(c echo.Context) error {
 	// existence already checked
 	userID := sess.Values[defaultUserIDKey].(int64)
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
-	}
-	defer tx.Rollback()
-
-	var livestreamModel LivestreamModel
-	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+	var (
+		livecommentModel LivecommentModel
+		report           LivecommentReport
+		hidden           bool
+	)
+	err = repositoryStore.Tx(ctx, func(r Repository) error {
+		livestreamModel, err := r.Livestreams().FindByID(ctx, int64(livestreamID))
+		if errors.Is(err, ErrRecordNotFound) {
 			return echo.NewHTTPError(http.StatusNotFound, "livestream not found")
-		} else {
+		}
+		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
 		}
-	}
 
-	var livecommentModel LivecommentModel
-	if err := tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ?", livecommentID); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+		livecommentModel, err = r.Livecomments().FindByID(ctx, int64(livecommentID))
+		if errors.Is(err, ErrRecordNotFound) || livecommentModel.DeletedAt != 0 {
 			return echo.NewHTTPError(http.StatusNotFound, "livecomment not found")
-		} else {
+		}
+		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment: "+err.Error())
 		}
-	}
 
-	now := time.Now().Unix()
-	reportModel := LivecommentReportModel{
-		UserID:        int64(userID),
-		LivestreamID:  int64(livestreamID),
-		LivecommentID: int64(livecommentID),
-		CreatedAt:     now,
-	}
-	rs, err := tx.NamedExecContext(ctx, "INSERT INTO livecomment_reports(user_id, livestream_id, livecomment_id, created_at) VALUES (:user_id, :livestream_id, :livecomment_id, :created_at)", &reportModel)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert livecomment report: "+err.Error())
-	}
-	reportID, err := rs.LastInsertId()
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get last inserted livecomment report id: "+err.Error())
-	}
-	reportModel.ID = reportID
+		reportModel := LivecommentReportModel{
+			UserID:        userID,
+			LivestreamID:  int64(livestreamID),
+			LivecommentID: int64(livecommentID),
+			Status:        reportStatusOpen,
+			CreatedAt:     time.Now().Unix(),
+		}
+		if err := r.Reports().Create(ctx, &reportModel); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert livecomment report: "+err.Error())
+		}
+
+		if err := r.Stats().CountReport(ctx, reportModel.LivestreamID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
 
-	report, err := fillLivecommentReportResponse(ctx, tx, reportModel)
+		hidden, err = autoHideReportedLivecomment(ctx, r, livestreamModel, livecommentModel)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to auto-hide reported livecomment: "+err.Error())
+		}
+
+		report, err = fillLivecommentReportResponse(ctx, r, reportModel)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment report: "+err.Error())
+		}
+		return nil
+	})
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment report: "+err.Error())
+		return err
 	}
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+
+	if hidden {
+		if err := eventBroker.Publish(livecommentModel.LivestreamID, livestreamEventLivecommentDeleted, LivecommentDeletedEvent{LivecommentID: livecommentModel.ID}); err != nil {
+			c.Logger().Warnf("failed to publish livecomment_deleted event: %+v", err)
+		}
 	}
 
 	return c.JSON(http.StatusCreated, report)
@@ -339,73 +387,192 @@ func moderateHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
 	}
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
+	matcher, err := newNGWordMatcher(req.NGWord, req.MatchMode)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
-	}
-	defer tx.Rollback()
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid ng_word: "+err.Error())
+	}
+	ngWordLivestreamID := int64(livestreamID)
+	switch req.Scope {
+	case "", ngWordScopeLivestream:
+	case ngWordScopeChannel:
+		ngWordLivestreamID = channelNGWordLivestreamID
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "scope must be livestream or channel")
+	}
+	// 部分文字列での照合は従来通り小文字で保存する
+	word := req.NGWord
+	if matcher.mode == ngWordMatchSubstring {
+		word = strings.ToLower(req.NGWord)
+	}
+
+	var (
+		wordID              int64
+		deletedLivecomments []LivecommentModel
+	)
+	err = repositoryStore.Tx(ctx, func(r Repository) error {
+		// 配信者自身かコラボレーターによるmoderateなのかを検証
+		livestreamModel, err := r.Livestreams().FindByID(ctx, int64(livestreamID))
+		if err != nil && !errors.Is(err, ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestreams: "+err.Error())
+		}
+		isModerator, err := r.Livestreams().IsModerator(ctx, livestreamModel, userID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to check livestream collaborators: "+err.Error())
+		}
+		if livestreamModel.ID == 0 || !isModerator {
+			return echo.NewHTTPError(http.StatusBadRequest, "A streamer can't moderate livestreams that other streamers own")
+		}
 
-	// 配信者自身の配信に対するmoderateなのかを検証
-	var ownedLivestreams []LivestreamModel
-	if err := tx.SelectContext(ctx, &ownedLivestreams, "SELECT * FROM livestreams WHERE id = ? AND user_id = ?", livestreamID, userID); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestreams: "+err.Error())
+		// NGワードは配信者のものとして登録し、コラボレーターが登録したものも同様に適用する
+		ngWord := NGWord{
+			UserID:       livestreamModel.UserID,
+			LivestreamID: ngWordLivestreamID,
+			Word:         word,
+			MatchMode:    matcher.mode,
+			CreatedAt:    time.Now().Unix(),
+		}
+		if err := r.NGWords().Create(ctx, &ngWord); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert new NG word: "+err.Error())
+		}
+		wordID = ngWord.ID
+
+		// NGワードにヒットする過去の投稿も全て論理削除する
+		// 削除したライブコメントは視聴者に通知するため、先に控えておく
+		deletedLivecomments, err = r.Livecomments().ListHitNGWord(ctx, livestreamModel, ngWordLivestreamID, req.NGWord, matcher)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get old livecomments that hit spams: "+err.Error())
+		}
+		if err := r.Moderations().SoftDelete(ctx, deletedLivecomments, moderationReasonNGWord, wordID, userID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete old livecomments that hit spams: "+err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	if len(ownedLivestreams) == 0 {
-		return echo.NewHTTPError(http.StatusBadRequest, "A streamer can't moderate livestreams that other streamers own")
+
+	for _, l := range deletedLivecomments {
+		if err := eventBroker.Publish(l.LivestreamID, livestreamEventLivecommentDeleted, LivecommentDeletedEvent{LivecommentID: l.ID}); err != nil {
+			c.Logger().Warnf("failed to publish livecomment_deleted event: %+v", err)
+		}
 	}
 
-	rs, err := tx.NamedExecContext(ctx, "INSERT INTO ng_words(user_id, livestream_id, word, created_at) VALUES (:user_id, :livestream_id, :word, :created_at)", &NGWord{
-		UserID:       int64(userID),
-		LivestreamID: int64(livestreamID),
-		Word:         strings.ToLower(req.NGWord),
-		CreatedAt:    time.Now().Unix(),
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"word_id": wordID,
 	})
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert new NG word: "+err.Error())
+}
+
+// findLivecommentsHitNGWord は新しく登録したNGワードにヒットする過去のライブコメントを行ロックして返す
+// ngWordLivestreamIDが0の場合は配信者の全ての配信が対象になる
+func findLivecommentsHitNGWord(ctx context.Context, tx *sqlx.Tx, livestreamModel LivestreamModel, ngWordLivestreamID int64, ngWord string, matcher *ngWordMatcher) ([]LivecommentModel, error) {
+	cond := "livestream_id = ? AND deleted_at = 0"
+	args := []interface{}{livestreamModel.ID}
+	if ngWordLivestreamID == channelNGWordLivestreamID {
+		cond = "livestream_id IN (SELECT id FROM livestreams WHERE user_id = ?) AND deleted_at = 0"
+		args = []interface{}{livestreamModel.UserID}
+	}
+
+	var livecommentModels []LivecommentModel
+	if matcher.mode == ngWordMatchSubstring {
+		query := "SELECT id, livestream_id FROM livecomments WHERE " + cond + " AND comment LIKE ? FOR UPDATE"
+		if err := tx.SelectContext(ctx, &livecommentModels, query, append(args, "%"+escapeLike(ngWord)+"%")...); err != nil {
+			return nil, err
+		}
+		return livecommentModels, nil
 	}
 
-	wordID, err := rs.LastInsertId()
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get last inserted NG word id: "+err.Error())
+	// 正規化や正規表現での照合はSQLで表現できないので、対象の配信のコメントを全て見る
+	query := "SELECT id, livestream_id, comment FROM livecomments WHERE " + cond + " FOR UPDATE"
+	if err := tx.SelectContext(ctx, &livecommentModels, query, args...); err != nil {
+		return nil, err
+	}
+	hits := make([]LivecommentModel, 0, len(livecommentModels))
+	for _, l := range livecommentModels {
+		if matcher.Match(l.Comment) {
+			hits = append(hits, l)
+		}
 	}
+	return hits, nil
+}
+
+// NGワードの削除API
+// DELETE /api/livestream/:livestream_id/ngwords/:ngword_id
+func deleteNgwordHandler(c echo.Context) error {
+	ctx := c.Request().Context()
 
-	// NGワードにヒットする過去の投稿も全削除する
-	query := "DELETE FROM livecomments WHERE livestream_id = ? AND comment LIKE ?"
-	if _, err := tx.ExecContext(ctx, query, livestreamID, "%"+req.NGWord+"%"); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete old livecomments that hit spams: "+err.Error())
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
 	}
 
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+	ngwordID, err := strconv.Atoi(c.Param("ngword_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "ngword_id in path must be integer")
 	}
 
-	return c.JSON(http.StatusCreated, map[string]interface{}{
-		"word_id": wordID,
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	err = repositoryStore.Tx(ctx, func(r Repository) error {
+		livestreamModel, err := r.Livestreams().FindByID(ctx, int64(livestreamID))
+		if errors.Is(err, ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "livestream not found")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+		}
+		isModerator, err := r.Livestreams().IsModerator(ctx, livestreamModel, userID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to check livestream collaborators: "+err.Error())
+		}
+		if !isModerator {
+			return echo.NewHTTPError(http.StatusForbidden, "A streamer can't moderate livestreams that other streamers own")
+		}
+
+		err = r.NGWords().Delete(ctx, int64(ngwordID), livestreamModel.UserID, []int64{livestreamModel.ID, channelNGWordLivestreamID})
+		if errors.Is(err, ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "NG word not found")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete NG word: "+err.Error())
+		}
+		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
 }
 
-func fillLivecommentResponse(ctx context.Context, tx *sqlx.Tx, livecommentModel LivecommentModel) (Livecomment, error) {
-	commentOwnerModel := UserModel{}
-	if err := tx.GetContext(ctx, &commentOwnerModel, "SELECT * FROM users WHERE id = ?", livecommentModel.UserID); err != nil {
-		return Livecomment{}, err
-	}
-	commentOwner, err := fillUserResponse(ctx, tx, commentOwnerModel)
+func fillLivecommentResponse(ctx context.Context, r Repository, livecommentModel LivecommentModel) (Livecomment, error) {
+	commentOwners, err := fillUsersResponseByID(ctx, r, []int64{livecommentModel.UserID})
 	if err != nil {
 		return Livecomment{}, err
 	}
+	commentOwner, ok := commentOwners[livecommentModel.UserID]
+	if !ok {
+		return Livecomment{}, ErrRecordNotFound
+	}
 
-	livestreamModel := LivestreamModel{}
-	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livecommentModel.LivestreamID); err != nil {
+	livestreamModel, err := r.Livestreams().FindByID(ctx, livecommentModel.LivestreamID)
+	if err != nil {
 		return Livecomment{}, err
 	}
-	livestream, err := fillLivestreamResponse(ctx, tx, livestreamModel)
+	livestream, err := fillLivestreamResponse(ctx, r, livestreamModel)
 	if err != nil {
 		return Livecomment{}, err
 	}
 
 	livecomment := Livecomment{
 		ID:         livecommentModel.ID,
-		User:       commentOwner,
+		User:       *commentOwner,
 		Livestream: livestream,
 		Comment:    livecommentModel.Comment,
 		Tip:        livecommentModel.Tip,
@@ -415,7 +582,7 @@ func fillLivecommentResponse(ctx context.Context, tx *sqlx.Tx, livecommentModel
 	return livecomment, nil
 }
 
-func fillLivecommentsResponse(ctx context.Context, tx *sqlx.Tx, livecommentModels []LivecommentModel) ([]Livecomment, error) {
+func fillLivecommentsResponse(ctx context.Context, r Repository, livecommentModels []LivecommentModel) ([]Livecomment, error) {
 	livestreamIDs := make([]int64, 0, len(livecommentModels))
 	userIDs := make([]int64, 0, len(livecommentModels))
 	for _, l := range livecommentModels {
@@ -423,35 +590,23 @@ func fillLivecommentsResponse(ctx context.Context, tx *sqlx.Tx, livecommentModel
 		userIDs = append(userIDs, l.UserID)
 	}
 
-	commentOwnerModels := make([]UserModel, 0, len(userIDs))
-	query, params, err := sqlx.In("SELECT * FROM users WHERE id IN (?)", userIDs)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create comment-owner query: %w", err)
-	}
-	if err := tx.SelectContext(ctx, &commentOwnerModels, query, params...); err != nil {
-		return nil, fmt.Errorf("failed to query owners: %w", err)
-	}
-	commentOwners, err := fillUsersResponse(ctx, tx, commentOwnerModels)
+	commentOwners, err := fillUsersResponseByID(ctx, r, userIDs)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fill users response: %w", err)
 	}
 
-	livestreamModels := make([]*LivestreamModel, 0, len(livestreamIDs))
-	query, params, err = sqlx.In("SELECT * FROM livestreams WHERE id IN (?)", livestreamIDs)
+	livestreamModels, err := r.Livestreams().FindByIDs(ctx, livestreamIDs)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create livestreams query: %w", err)
+		return nil, err
 	}
-	if err := tx.SelectContext(ctx, &livestreamModels, query, params...); err != nil {
-		return nil, fmt.Errorf("failed to query livestreams: %w", err)
-	}
-	livestreams, err := fillLivestreamsResponse(ctx, tx, livestreamModels)
+	livestreams, err := fillLivestreamsResponse(ctx, r, livestreamModelsOf(livestreamModels))
 	if err != nil {
 		return nil, fmt.Errorf("failed to fill livestreams response: %w", err)
 	}
 
 	livestreamMap := make(map[int64]*Livestream, len(livestreams))
-	for _, l := range livestreams {
-		livestreamMap[l.ID] = &l
+	for i := range livestreams {
+		livestreamMap[livestreams[i].ID] = &livestreams[i]
 	}
 
 	livecomments := make([]Livecomment, 0, len(livecommentModels))
@@ -470,21 +625,92 @@ func fillLivecommentsResponse(ctx context.Context, tx *sqlx.Tx, livecommentModel
 	return livecomments, nil
 }
 
-func fillLivecommentReportResponse(ctx context.Context, tx *sqlx.Tx, reportModel LivecommentReportModel) (LivecommentReport, error) {
-	reporterModel := UserModel{}
-	if err := tx.GetContext(ctx, &reporterModel, "SELECT * FROM users WHERE id = ?", reportModel.UserID); err != nil {
+func fillLivecommentReportsResponse(ctx context.Context, r Repository, reportModels []LivecommentReportModel) ([]LivecommentReport, error) {
+	reports := make([]LivecommentReport, 0, len(reportModels))
+	if len(reportModels) == 0 {
+		return reports, nil
+	}
+
+	reporterIDs := make([]int64, 0, len(reportModels))
+	livecommentIDs := make([]int64, 0, len(reportModels))
+	for _, reportModel := range reportModels {
+		reporterIDs = append(reporterIDs, reportModel.UserID)
+		livecommentIDs = append(livecommentIDs, reportModel.LivecommentID)
+	}
+
+	reporterModels, err := r.Users().FindByIDs(ctx, reporterIDs)
+	if err != nil {
+		return nil, err
+	}
+	reporters, err := fillUsersResponse(ctx, r, userModelsOf(reporterModels))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fill users response: %w", err)
+	}
+
+	livecommentMap, err := fillLivecommentsResponseByID(ctx, r, livecommentIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, reportModel := range reportModels {
+		reporter, ok := reporters[reportModel.UserID]
+		if !ok {
+			return nil, fmt.Errorf("reporter %d is not found", reportModel.UserID)
+		}
+		livecomment, ok := livecommentMap[reportModel.LivecommentID]
+		if !ok {
+			return nil, fmt.Errorf("livecomment %d is not found", reportModel.LivecommentID)
+		}
+		reports = append(reports, LivecommentReport{
+			ID:          reportModel.ID,
+			Reporter:    *reporter,
+			Livecomment: livecomment,
+			CreatedAt:   reportModel.CreatedAt,
+		})
+	}
+	return reports, nil
+}
+
+// fillLivecommentsResponseByID はIDを指定してライブコメントを取得し、IDをキーにしたマップで返す
+// 論理削除したライブコメントも含む
+func fillLivecommentsResponseByID(ctx context.Context, r Repository, livecommentIDs []int64) (map[int64]Livecomment, error) {
+	livecommentMap := make(map[int64]Livecomment, len(livecommentIDs))
+	if len(livecommentIDs) == 0 {
+		return livecommentMap, nil
+	}
+
+	livecommentModels, err := r.Livecomments().FindByIDs(ctx, livecommentIDs)
+	if err != nil {
+		return nil, err
+	}
+	if len(livecommentModels) == 0 {
+		return livecommentMap, nil
+	}
+	livecomments, err := fillLivecommentsResponse(ctx, r, livecommentModels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fill livecomments response: %w", err)
+	}
+	for _, l := range livecomments {
+		livecommentMap[l.ID] = l
+	}
+	return livecommentMap, nil
+}
+
+func fillLivecommentReportResponse(ctx context.Context, r Repository, reportModel LivecommentReportModel) (LivecommentReport, error) {
+	reporterModel, err := r.Users().FindByID(ctx, reportModel.UserID)
+	if err != nil {
 		return LivecommentReport{}, err
 	}
-	reporter, err := fillUserResponse(ctx, tx, reporterModel)
+	reporter, err := fillUserResponse(ctx, r, reporterModel)
 	if err != nil {
 		return LivecommentReport{}, err
 	}
 
-	livecommentModel := LivecommentModel{}
-	if err := tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ?", reportModel.LivecommentID); err != nil {
+	livecommentModel, err := r.Livecomments().FindByID(ctx, reportModel.LivecommentID)
+	if err != nil {
 		return LivecommentReport{}, err
 	}
-	livecomment, err := fillLivecommentResponse(ctx, tx, livecommentModel)
+	livecomment, err := fillLivecommentResponse(ctx, r, livecommentModel)
 	if err != nil {
 		return LivecommentReport{}, err
 	}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetLivecommentsHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		wantStatus     int
+		wantIDs        []int64
+		wantNextCursor bool
+		paged          bool
+	}{
+		{
+			name:       "newest first without moderated livecomments",
+			wantStatus: http.StatusOK,
+			wantIDs:    []int64{4, 2, 1},
+		},
+		{
+			name:           "limit",
+			query:          "?limit=2",
+			wantStatus:     http.StatusOK,
+			wantIDs:        []int64{4, 2},
+			wantNextCursor: true,
+		},
+		{
+			name:       "before_id",
+			query:      "?before_id=4",
+			wantStatus: http.StatusOK,
+			wantIDs:    []int64{2, 1},
+			paged:      true,
+		},
+		{
+			name:       "after_id",
+			query:      "?after_id=1&limit=5",
+			wantStatus: http.StatusOK,
+			wantIDs:    []int64{4, 2},
+			paged:      true,
+		},
+		{
+			name:       "cursor id in other livestream",
+			query:      "?before_id=5",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestRepositoryStore(t)
+			rec := serveTestRequest(t, http.MethodGet, "/api/livestream/:livestream_id/livecomment", "/api/livestream/1/livecomment"+tt.query, testViewerID, getLivecommentsHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			livecomments, nextCursor := decodeListResponse[Livecomment](t, rec, tt.paged)
+			ids := []int64{}
+			for _, l := range livecomments {
+				ids = append(ids, l.ID)
+			}
+			assert.Equal(t, tt.wantIDs, ids)
+			assert.Equal(t, tt.wantNextCursor, nextCursor != "")
+		})
+	}
+}
+
+func TestGetLivecommentsHandlerFollowsCursor(t *testing.T) {
+	newTestRepositoryStore(t)
+
+	// 最初のページは空のcursorで取得し、レスポンスのnext_cursorを辿る
+	var ids []int64
+	cursor := ""
+	for i := 0; i < 5; i++ {
+		rec := serveTestRequest(t, http.MethodGet, "/api/livestream/:livestream_id/livecomment", "/api/livestream/1/livecomment?limit=1&cursor="+cursor, testViewerID, getLivecommentsHandler)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		livecomments, next := decodeListResponse[Livecomment](t, rec, true)
+		for _, l := range livecomments {
+			ids = append(ids, l.ID)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	assert.Equal(t, []int64{4, 2, 1}, ids)
+}
+
+func TestGetNgwords(t *testing.T) {
+	tests := []struct {
+		name         string
+		livestreamID string
+		userID       int64
+		wantWords    []string
+	}{
+		{
+			name:         "streamer gets livestream and channel NG words",
+			livestreamID: "1",
+			userID:       testStreamerID,
+			wantWords:    []string{"bar", "foo"},
+		},
+		{
+			name:         "collaborator gets streamer's NG words",
+			livestreamID: "1",
+			userID:       testCollaboratorID,
+			wantWords:    []string{"bar", "foo"},
+		},
+		{
+			name:         "viewer gets own NG words",
+			livestreamID: "1",
+			userID:       testViewerID,
+			wantWords:    []string{},
+		},
+		{
+			name:         "livestream not found",
+			livestreamID: "100",
+			userID:       testStreamerID,
+			wantWords:    []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestRepositoryStore(t)
+			rec := serveTestRequest(t, http.MethodGet, "/api/livestream/:livestream_id/ngwords", "/api/livestream/"+tt.livestreamID+"/ngwords", tt.userID, getNgwords)
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			words := []string{}
+			for _, w := range decodeResponse[[]*NGWord](t, rec) {
+				words = append(words, w.Word)
+			}
+			assert.Equal(t, tt.wantWords, words)
+		})
+	}
+}
+
+func TestPostLivecommentHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		body       string
+		wantStatus int
+	}{
+		{
+			name:       "livecomment with tip",
+			status:     livestreamStatusLive,
+			body:       `{"comment": "がんばって", "tip": 1000}`,
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "livestream NG word",
+			status:     livestreamStatusLive,
+			body:       `{"comment": "FOO!", "tip": 1000}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "channel NG word",
+			status:     livestreamStatusLive,
+			body:       `{"comment": "bar", "tip": 1000}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "livestream not live",
+			status:     livestreamStatusEnded,
+			body:       `{"comment": "がんばって", "tip": 1000}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			moveTestLivestream(s, testLivestreamID, tt.status)
+			enforceLiveWindow = true
+			t.Cleanup(func() { enforceLiveWindow = false })
+
+			rec := serveTestJSONRequest(t, http.MethodPost, "/api/livestream/:livestream_id/livecomment", "/api/livestream/1/livecomment", tt.body, testViewerID, postLivecommentHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusCreated {
+				assert.Len(t, s.data.livecomments, 5)
+				assert.Empty(t, s.data.tips)
+				assert.Equal(t, int64(500), s.data.userStats[testStreamerID].TotalTip)
+				return
+			}
+			livecomment := decodeResponse[Livecomment](t, rec)
+			assert.Equal(t, int64(6), livecomment.ID)
+			assert.Equal(t, "viewer", livecomment.User.Name)
+			assert.Equal(t, testLivestreamID, livecomment.Livestream.ID)
+
+			// 投げ銭は台帳と統計情報の両方に記録する
+			if assert.Len(t, s.data.tips, 1) {
+				for _, tip := range s.data.tips {
+					assert.Equal(t, int64(1000), tip.Amount)
+					assert.Equal(t, testStreamerID, tip.StreamerID)
+				}
+			}
+			stats := s.data.livestreamStats[testLivestreamID]
+			assert.Equal(t, int64(5), stats.Livecomments)
+			assert.Equal(t, int64(1000), stats.MaxTip)
+			assert.Equal(t, int64(1503), stats.Score)
+			assert.Equal(t, int64(1500), s.data.userStats[testStreamerID].TotalTip)
+		})
+	}
+}
+
+func TestReportLivecommentHandler(t *testing.T) {
+	tests := []struct {
+		name          string
+		livecommentID string
+		wantStatus    int
+	}{
+		{
+			name:          "report livecomment",
+			livecommentID: "1",
+			wantStatus:    http.StatusCreated,
+		},
+		{
+			name:          "moderated livecomment",
+			livecommentID: "3",
+			wantStatus:    http.StatusNotFound,
+		},
+		{
+			name:          "livecomment not found",
+			livecommentID: "100",
+			wantStatus:    http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			rec := serveTestRequest(t, http.MethodPost, "/api/livestream/:livestream_id/livecomment/:livecomment_id/report", "/api/livestream/1/livecomment/"+tt.livecommentID+"/report", testCollaboratorID, reportLivecommentHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusCreated {
+				assert.Len(t, s.data.reports, 1)
+				assert.Equal(t, int64(1), s.data.livestreamStats[testLivestreamID].Reports)
+				return
+			}
+			report := decodeResponse[LivecommentReport](t, rec)
+			assert.Equal(t, "collaborator", report.Reporter.Name)
+			assert.Equal(t, int64(1), report.Livecomment.ID)
+			assert.Equal(t, reportStatusOpen, s.data.reports[report.ID].Status)
+			assert.Equal(t, int64(2), s.data.livestreamStats[testLivestreamID].Reports)
+		})
+	}
+}
+
+func TestModerateHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		userID      int64
+		wantStatus  int
+		wantDeleted []int64
+	}{
+		{
+			name:        "livestream scope",
+			body:        `{"ng_word": "こんにちは"}`,
+			userID:      testStreamerID,
+			wantStatus:  http.StatusCreated,
+			wantDeleted: []int64{1},
+		},
+		{
+			name:        "channel scope by collaborator",
+			body:        `{"ng_word": "の", "scope": "channel"}`,
+			userID:      testCollaboratorID,
+			wantStatus:  http.StatusCreated,
+			wantDeleted: []int64{5},
+		},
+		{
+			// LIKEのワイルドカードにせず、文字として照合する
+			name:       "wildcard characters",
+			body:       `{"ng_word": "_"}`,
+			userID:     testStreamerID,
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "viewer can't moderate",
+			body:       `{"ng_word": "こんにちは"}`,
+			userID:     testViewerID,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid scope",
+			body:       `{"ng_word": "こんにちは", "scope": "site"}`,
+			userID:     testStreamerID,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			rec := serveTestJSONRequest(t, http.MethodPost, "/api/livestream/:livestream_id/moderate", "/api/livestream/1/moderate", tt.body, tt.userID, moderateHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusCreated {
+				assert.Len(t, s.data.ngWords, 3)
+				assert.Empty(t, s.data.moderations)
+				return
+			}
+			wordID := decodeResponse[map[string]int64](t, rec)["word_id"]
+			// コラボレーターが登録したNGワードも配信者のものになる
+			assert.Equal(t, testStreamerID, s.data.ngWords[wordID].UserID)
+
+			var deleted []int64
+			for _, m := range s.data.moderations {
+				assert.Equal(t, moderationReasonNGWord, m.Reason)
+				assert.Equal(t, wordID, m.NGWordID)
+				assert.Equal(t, tt.userID, m.UserID)
+				assert.NotZero(t, s.data.livecomments[m.LivecommentID].DeletedAt)
+				deleted = append(deleted, m.LivecommentID)
+			}
+			assert.Equal(t, tt.wantDeleted, deleted)
+		})
+	}
+}
+
+func TestDeleteNgwordHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		ngwordID   string
+		userID     int64
+		wantStatus int
+	}{
+		{
+			name:       "livestream NG word",
+			ngwordID:   "1",
+			userID:     testStreamerID,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "channel NG word by collaborator",
+			ngwordID:   "2",
+			userID:     testCollaboratorID,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "NG word of other livestream",
+			ngwordID:   "3",
+			userID:     testStreamerID,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "viewer can't delete",
+			ngwordID:   "1",
+			userID:     testViewerID,
+			wantStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			rec := serveTestRequest(t, http.MethodDelete, "/api/livestream/:livestream_id/ngwords/:ngword_id", "/api/livestream/1/ngwords/"+tt.ngwordID, tt.userID, deleteNgwordHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus == http.StatusNoContent {
+				assert.Len(t, s.data.ngWords, 2)
+			} else {
+				assert.Len(t, s.data.ngWords, 3)
+			}
+		})
+	}
+}
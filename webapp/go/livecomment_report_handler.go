@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	reportStatusOpen      = "open"
+	reportStatusDismissed = "dismissed"
+	reportStatusActioned  = "actioned"
+
+	// 報告したユーザ数がこの値以上になったライブコメントを自動で非表示にする. 0の場合は無効
+	reportAutoHideThresholdEnvKey = "ISUCON13_REPORT_AUTO_HIDE_THRESHOLD"
+)
+
+var reportAutoHideThreshold int64
+
+// ReportedLivecomment はスパム報告をライブコメントごとにまとめたもの
+type ReportedLivecomment struct {
+	Livecomment Livecomment `json:"livecomment"`
+	ReportCount int64       `json:"report_count"`
+	// 未対応の報告が1件でもあればopen, 削除済みであればactioned, それ以外はdismissed
+	Status          string `json:"status"`
+	FirstReportedAt int64  `json:"first_reported_at"`
+	LastReportedAt  int64  `json:"last_reported_at"`
+}
+
+type reportedLivecommentModel struct {
+	LivecommentID   int64 `db:"livecomment_id"`
+	ReportCount     int64 `db:"report_count"`
+	OpenCount       int64 `db:"open_count"`
+	ActionedCount   int64 `db:"actioned_count"`
+	FirstReportedAt int64 `db:"first_reported_at"`
+	LastReportedAt  int64 `db:"last_reported_at"`
+}
+
+func (m reportedLivecommentModel) status() string {
+	switch {
+	case m.OpenCount > 0:
+		return reportStatusOpen
+	case m.ActionedCount > 0:
+		return reportStatusActioned
+	default:
+		return reportStatusDismissed
+	}
+}
+
+// status別に絞り込むためのHAVING句. reportedLivecommentModel.statusと対応させること
+// MySQLのリポジトリが使うほか、statusの検証にも使う
+var reportStatusHavingClauses = map[string]string{
+	reportStatusOpen:      " HAVING open_count > 0",
+	reportStatusActioned:  " HAVING open_count = 0 AND actioned_count > 0",
+	reportStatusDismissed: " HAVING open_count = 0 AND actioned_count = 0",
+}
+
+// スパム報告されたライブコメントの一覧取得API
+// GET /api/livestream/:livestream_id/report/livecomments?status=
+func getReportedLivecommentsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	status := c.QueryParam("status")
+	if _, ok := reportStatusHavingClauses[status]; status != "" && !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "status must be one of open, dismissed and actioned")
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	var reported []ReportedLivecomment
+	err = repositoryStore.Tx(ctx, func(r Repository) error {
+		if _, err := getModeratedLivestream(ctx, r, int64(livestreamID), userID); err != nil {
+			return err
+		}
+
+		var err error
+		reported, err = getReportedLivecomments(ctx, r, int64(livestreamID), 0, status)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reported livecomments: "+err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, reported)
+}
+
+// スパム報告の却下API
+// POST /api/livestream/:livestream_id/livecomment/:livecomment_id/report/dismiss
+func dismissLivecommentReportsHandler(c echo.Context) error {
+	return resolveLivecommentReports(c, reportStatusDismissed)
+}
+
+// スパム報告されたライブコメントの削除API
+// POST /api/livestream/:livestream_id/livecomment/:livecomment_id/report/delete
+func deleteReportedLivecommentHandler(c echo.Context) error {
+	return resolveLivecommentReports(c, reportStatusActioned)
+}
+
+// resolveLivecommentReports は未対応のスパム報告をstatusに更新する
+// actionedの場合は報告されたライブコメントを論理削除する
+func resolveLivecommentReports(c echo.Context, status string) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+	livecommentID, err := strconv.Atoi(c.Param("livecomment_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livecomment_id in path must be integer")
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	var (
+		livecommentModel LivecommentModel
+		reported         ReportedLivecomment
+		deleted          bool
+	)
+	err = repositoryStore.Tx(ctx, func(r Repository) error {
+		if _, err := getModeratedLivestream(ctx, r, int64(livestreamID), userID); err != nil {
+			return err
+		}
+
+		var err error
+		livecommentModel, err = r.Livecomments().FindInLivestreamForUpdate(ctx, int64(livestreamID), int64(livecommentID))
+		if errors.Is(err, ErrRecordNotFound) {
+			// 論理削除済みのライブコメントへの報告も対応済みにできる
+			livecommentModel, err = r.Livecomments().FindInLivestream(ctx, int64(livestreamID), int64(livecommentID))
+		}
+		if errors.Is(err, ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "livecomment not found")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment: "+err.Error())
+		}
+
+		reportModels, err := r.Reports().ListReported(ctx, int64(livestreamID), int64(livecommentID), "")
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to count livecomment reports: "+err.Error())
+		}
+		if len(reportModels) == 0 {
+			return echo.NewHTTPError(http.StatusNotFound, "livecomment is not reported")
+		}
+
+		if status == reportStatusActioned && livecommentModel.DeletedAt == 0 {
+			if err := r.Moderations().SoftDelete(ctx, []LivecommentModel{livecommentModel}, moderationReasonReport, 0, userID); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete livecomment: "+err.Error())
+			}
+			deleted = true
+		}
+		if err := r.Reports().Resolve(ctx, int64(livestreamID), int64(livecommentID), status); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update livecomment reports: "+err.Error())
+		}
+
+		reportedLivecomments, err := getReportedLivecomments(ctx, r, int64(livestreamID), int64(livecommentID), "")
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reported livecomment: "+err.Error())
+		}
+		if len(reportedLivecomments) != 1 {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reported livecomment")
+		}
+		reported = reportedLivecomments[0]
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if deleted {
+		if err := eventBroker.Publish(livecommentModel.LivestreamID, livestreamEventLivecommentDeleted, LivecommentDeletedEvent{LivecommentID: livecommentModel.ID}); err != nil {
+			c.Logger().Warnf("failed to publish livecomment_deleted event: %+v", err)
+		}
+	}
+
+	return c.JSON(http.StatusOK, reported)
+}
+
+// autoHideReportedLivecomment は報告したユーザ数が閾値に達したライブコメントを論理削除する
+// 削除した場合はtrueを返す. 配信者によるモデレーションとして記録する
+func autoHideReportedLivecomment(ctx context.Context, r Repository, livestreamModel LivestreamModel, livecommentModel LivecommentModel) (bool, error) {
+	if reportAutoHideThreshold <= 0 || livecommentModel.DeletedAt != 0 {
+		return false, nil
+	}
+
+	reporters, err := r.Reports().CountOpenReporters(ctx, livestreamModel.ID, livecommentModel.ID)
+	if err != nil {
+		return false, fmt.Errorf("failed to count reporters: %w", err)
+	}
+	if reporters < reportAutoHideThreshold {
+		return false, nil
+	}
+
+	if err := r.Moderations().SoftDelete(ctx, []LivecommentModel{livecommentModel}, moderationReasonReport, 0, livestreamModel.UserID); err != nil {
+		return false, err
+	}
+	if err := r.Reports().Resolve(ctx, livestreamModel.ID, livecommentModel.ID, reportStatusActioned); err != nil {
+		return false, fmt.Errorf("failed to update livecomment reports: %w", err)
+	}
+	return true, nil
+}
+
+// getReportedLivecomments はスパム報告をライブコメントごとに集計し、最後に報告された順に返す
+// livecommentIDが0でない場合はそのライブコメントのみを対象にする
+func getReportedLivecomments(ctx context.Context, r Repository, livestreamID int64, livecommentID int64, status string) ([]ReportedLivecomment, error) {
+	models, err := r.Reports().ListReported(ctx, livestreamID, livecommentID, status)
+	if err != nil {
+		return nil, err
+	}
+
+	livecommentIDs := make([]int64, len(models))
+	for i := range models {
+		livecommentIDs[i] = models[i].LivecommentID
+	}
+	livecommentMap, err := fillLivecommentsResponseByID(ctx, r, livecommentIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	reported := make([]ReportedLivecomment, 0, len(models))
+	for _, m := range models {
+		livecomment, ok := livecommentMap[m.LivecommentID]
+		if !ok {
+			return nil, fmt.Errorf("livecomment %d is not found", m.LivecommentID)
+		}
+		reported = append(reported, ReportedLivecomment{
+			Livecomment:     livecomment,
+			ReportCount:     m.ReportCount,
+			Status:          m.status(),
+			FirstReportedAt: m.FirstReportedAt,
+			LastReportedAt:  m.LastReportedAt,
+		})
+	}
+	return reported, nil
+}
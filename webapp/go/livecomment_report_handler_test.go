@@ -0,0 +1,286 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// addTestReports はフィクスチャの報告(ライブコメント3への未対応の報告)に加えて、以下の報告を登録する
+// ライブコメント1: 却下済み2件, ライブコメント2: 対応済み1件, ライブコメント4: 未対応2件
+func addTestReports(s *memoryRepositoryStore) {
+	for _, r := range []LivecommentReportModel{
+		{ID: 2, UserID: testViewerID, LivestreamID: testLivestreamID, LivecommentID: 1, Status: reportStatusDismissed, CreatedAt: 150},
+		{ID: 3, UserID: testCollaboratorID, LivestreamID: testLivestreamID, LivecommentID: 1, Status: reportStatusDismissed, CreatedAt: 160},
+		{ID: 4, UserID: testViewerID, LivestreamID: testLivestreamID, LivecommentID: 2, Status: reportStatusActioned, CreatedAt: 250},
+		{ID: 5, UserID: testViewerID, LivestreamID: testLivestreamID, LivecommentID: 4, Status: reportStatusOpen, CreatedAt: 410},
+		{ID: 6, UserID: testStreamerID, LivestreamID: testLivestreamID, LivecommentID: 4, Status: reportStatusOpen, CreatedAt: 420},
+	} {
+		s.data.reports[r.ID] = r
+	}
+}
+
+func TestGetReportedLivecommentsHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		userID     int64
+		wantStatus int
+		want       []ReportedLivecomment
+	}{
+		{
+			name:       "all statuses in last reported order",
+			target:     "/api/livestream/1/report/livecomments",
+			userID:     testStreamerID,
+			wantStatus: http.StatusOK,
+			want: []ReportedLivecomment{
+				{Livecomment: Livecomment{ID: 4}, ReportCount: 2, Status: reportStatusOpen, FirstReportedAt: 410, LastReportedAt: 420},
+				{Livecomment: Livecomment{ID: 3}, ReportCount: 1, Status: reportStatusOpen, FirstReportedAt: 320, LastReportedAt: 320},
+				{Livecomment: Livecomment{ID: 2}, ReportCount: 1, Status: reportStatusActioned, FirstReportedAt: 250, LastReportedAt: 250},
+				{Livecomment: Livecomment{ID: 1}, ReportCount: 2, Status: reportStatusDismissed, FirstReportedAt: 150, LastReportedAt: 160},
+			},
+		},
+		{
+			name:       "open",
+			target:     "/api/livestream/1/report/livecomments?status=open",
+			userID:     testCollaboratorID,
+			wantStatus: http.StatusOK,
+			want: []ReportedLivecomment{
+				{Livecomment: Livecomment{ID: 4}, ReportCount: 2, Status: reportStatusOpen, FirstReportedAt: 410, LastReportedAt: 420},
+				{Livecomment: Livecomment{ID: 3}, ReportCount: 1, Status: reportStatusOpen, FirstReportedAt: 320, LastReportedAt: 320},
+			},
+		},
+		{
+			name:       "dismissed",
+			target:     "/api/livestream/1/report/livecomments?status=dismissed",
+			userID:     testStreamerID,
+			wantStatus: http.StatusOK,
+			want: []ReportedLivecomment{
+				{Livecomment: Livecomment{ID: 1}, ReportCount: 2, Status: reportStatusDismissed, FirstReportedAt: 150, LastReportedAt: 160},
+			},
+		},
+		{
+			name:       "actioned",
+			target:     "/api/livestream/1/report/livecomments?status=actioned",
+			userID:     testStreamerID,
+			wantStatus: http.StatusOK,
+			want: []ReportedLivecomment{
+				{Livecomment: Livecomment{ID: 2}, ReportCount: 1, Status: reportStatusActioned, FirstReportedAt: 250, LastReportedAt: 250},
+			},
+		},
+		{
+			name:       "no reports",
+			target:     "/api/livestream/2/report/livecomments",
+			userID:     testStreamerID,
+			wantStatus: http.StatusOK,
+			want:       []ReportedLivecomment{},
+		},
+		{
+			name:       "invalid status",
+			target:     "/api/livestream/1/report/livecomments?status=closed",
+			userID:     testStreamerID,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "viewer can't get reports",
+			target:     "/api/livestream/1/report/livecomments",
+			userID:     testViewerID,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "livestream not found",
+			target:     "/api/livestream/100/report/livecomments",
+			userID:     testStreamerID,
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			addTestReports(s)
+			rec := serveTestRequest(t, http.MethodGet, "/api/livestream/:livestream_id/report/livecomments", tt.target, tt.userID, getReportedLivecommentsHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			reported := decodeResponse[[]ReportedLivecomment](t, rec)
+			// ライブコメントはIDだけを比べる
+			for i := range reported {
+				reported[i].Livecomment = Livecomment{ID: reported[i].Livecomment.ID}
+			}
+			assert.Equal(t, tt.want, reported)
+		})
+	}
+}
+
+func TestResolveLivecommentReportsHandler(t *testing.T) {
+	tests := []struct {
+		name          string
+		action        string
+		livecommentID string
+		userID        int64
+		wantStatus    int
+		wantReport    string
+		// wantModerated はこのリクエストでライブコメントを論理削除したかどうか
+		wantModerated bool
+	}{
+		{
+			name:          "dismiss",
+			action:        "dismiss",
+			livecommentID: "4",
+			userID:        testStreamerID,
+			wantStatus:    http.StatusOK,
+			wantReport:    reportStatusDismissed,
+		},
+		{
+			name:          "delete by collaborator",
+			action:        "delete",
+			livecommentID: "4",
+			userID:        testCollaboratorID,
+			wantStatus:    http.StatusOK,
+			wantReport:    reportStatusActioned,
+			wantModerated: true,
+		},
+		{
+			// 論理削除済みのライブコメントは削除し直さない
+			name:          "delete moderated livecomment",
+			action:        "delete",
+			livecommentID: "3",
+			userID:        testStreamerID,
+			wantStatus:    http.StatusOK,
+			wantReport:    reportStatusActioned,
+		},
+		{
+			// 対応済みの報告は変わらない
+			name:          "dismiss resolved reports",
+			action:        "dismiss",
+			livecommentID: "2",
+			userID:        testStreamerID,
+			wantStatus:    http.StatusOK,
+			wantReport:    reportStatusActioned,
+		},
+		{
+			name:          "not reported",
+			action:        "delete",
+			livecommentID: "5",
+			userID:        testStreamerID,
+			wantStatus:    http.StatusNotFound,
+		},
+		{
+			name:          "livecomment not found",
+			action:        "dismiss",
+			livecommentID: "100",
+			userID:        testStreamerID,
+			wantStatus:    http.StatusNotFound,
+		},
+		{
+			name:          "viewer can't resolve",
+			action:        "delete",
+			livecommentID: "4",
+			userID:        testViewerID,
+			wantStatus:    http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			addTestReports(s)
+			handler := map[string]echo.HandlerFunc{"dismiss": dismissLivecommentReportsHandler, "delete": deleteReportedLivecommentHandler}[tt.action]
+			rec := serveTestRequest(t, http.MethodPost, "/api/livestream/:livestream_id/livecomment/:livecomment_id/report/"+tt.action, "/api/livestream/1/livecomment/"+tt.livecommentID+"/report/"+tt.action, tt.userID, handler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusOK {
+				assert.Equal(t, reportStatusOpen, s.data.reports[5].Status)
+				assert.Equal(t, int64(0), s.data.livecomments[4].DeletedAt)
+				assert.Empty(t, s.data.moderations)
+				return
+			}
+			reported := decodeResponse[ReportedLivecomment](t, rec)
+			assert.Equal(t, tt.livecommentID, strconv.FormatInt(reported.Livecomment.ID, 10))
+			assert.Equal(t, tt.wantReport, reported.Status)
+			for _, r := range s.data.reports {
+				if strconv.FormatInt(r.LivecommentID, 10) == tt.livecommentID {
+					assert.Equal(t, tt.wantReport, r.Status)
+				}
+			}
+
+			if tt.wantModerated {
+				if assert.Len(t, s.data.moderations, 1) {
+					for _, m := range s.data.moderations {
+						assert.Equal(t, moderationReasonReport, m.Reason)
+						assert.Equal(t, tt.userID, m.UserID)
+					}
+				}
+				assert.NotZero(t, s.data.livecomments[4].DeletedAt)
+			} else {
+				assert.Empty(t, s.data.moderations)
+			}
+		})
+	}
+}
+
+func TestReportAutoHideThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int64
+		reporters []int64
+		// wantHidden はi番目の報告の後に非表示になっているかどうか
+		wantHidden []bool
+	}{
+		{
+			name:       "disabled",
+			threshold:  0,
+			reporters:  []int64{testCollaboratorID, testStreamerID},
+			wantHidden: []bool{false, false},
+		},
+		{
+			name:       "hidden when reporters reach threshold",
+			threshold:  2,
+			reporters:  []int64{testCollaboratorID, testStreamerID},
+			wantHidden: []bool{false, true},
+		},
+		{
+			// 同じユーザが何度報告しても1人として数える
+			name:       "same reporter counts once",
+			threshold:  2,
+			reporters:  []int64{testCollaboratorID, testCollaboratorID},
+			wantHidden: []bool{false, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			defer func(v int64) { reportAutoHideThreshold = v }(reportAutoHideThreshold)
+			reportAutoHideThreshold = tt.threshold
+
+			for i, reporterID := range tt.reporters {
+				rec := serveTestRequest(t, http.MethodPost, "/api/livestream/:livestream_id/livecomment/:livecomment_id/report", "/api/livestream/1/livecomment/1/report", reporterID, reportLivecommentHandler)
+				if !assert.Equal(t, http.StatusCreated, rec.Code) {
+					return
+				}
+				assert.Equal(t, tt.wantHidden[i], s.data.livecomments[1].DeletedAt != 0, "after report %d", i+1)
+			}
+
+			if !tt.wantHidden[len(tt.wantHidden)-1] {
+				assert.Empty(t, s.data.moderations)
+				return
+			}
+			// 配信者によるモデレーションとして記録し、報告を対応済みにする
+			if assert.Len(t, s.data.moderations, 1) {
+				for _, m := range s.data.moderations {
+					assert.Equal(t, moderationReasonReport, m.Reason)
+					assert.Equal(t, testStreamerID, m.UserID)
+				}
+			}
+			for _, r := range s.data.reports {
+				if r.LivecommentID == 1 {
+					assert.Equal(t, reportStatusActioned, r.Status)
+				}
+			}
+		})
+	}
+}
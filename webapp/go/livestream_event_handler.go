@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	livestreamEventLivecomment        = "livecomment"
+	livestreamEventReaction           = "reaction"
+	livestreamEventLivecommentDeleted = "livecomment_deleted"
+	// Last-Event-IDが古すぎて再送できない場合に送るイベント
+	// クライアントはREST APIで取得し直す必要がある
+	livestreamEventReset = "reset"
+
+	// ライブ配信ごとに再送用に保持するイベント数
+	livestreamEventHistorySize = 1000
+	// 再送用に保持する期間. 再接続はこれより十分短い間隔で行われる
+	livestreamEventHistoryTTL = 10 * time.Minute
+	// この回数のPublishごとに古いイベントと購読者のいないライブ配信を捨てる
+	livestreamEventSweepInterval = 1000
+	// 購読者ごとの送信待ちイベント数. これを超えると購読を打ち切り、再接続させる
+	livestreamEventSubscriberBuffer  = 256
+	livestreamEventHeartbeatInterval = 15 * time.Second
+)
+
+type LivestreamEvent struct {
+	ID   int64
+	Type string
+	Data []byte
+
+	publishedAt time.Time
+}
+
+type LivecommentDeletedEvent struct {
+	LivecommentID int64 `json:"livecomment_id"`
+}
+
+type livestreamEventSubscriber struct {
+	ch chan LivestreamEvent
+}
+
+type livestreamEventTopic struct {
+	history []LivestreamEvent
+	// 履歴から溢れた最新のイベントID
+	evictedID   int64
+	subscribers map[*livestreamEventSubscriber]struct{}
+}
+
+// livestreamEventBroker はコミット済みの書き込みをSSEの購読者に配信する
+// イベントIDはプロセス内で単調増加し、Last-Event-IDによる再送に使う
+//
+// ブローカーはプロセス内で完結し、サーバ間でイベントを共有しない
+// 複数台構成では、書き込みを受けたサーバに接続している購読者にしか配信されず、
+// 別のサーバに再接続すると、Last-Event-IDが別のサーバで振られたIDなので正しく再送できない
+// 複数台で動かす場合は、同じライブ配信への書き込みと購読を1台に振り分ける必要がある
+type livestreamEventBroker struct {
+	mu        sync.Mutex
+	lastID    int64
+	topics    map[int64]*livestreamEventTopic
+	publishes int
+}
+
+var eventBroker = newLivestreamEventBroker()
+
+func newLivestreamEventBroker() *livestreamEventBroker {
+	return &livestreamEventBroker{
+		topics: make(map[int64]*livestreamEventTopic),
+	}
+}
+
+func (b *livestreamEventBroker) topic(livestreamID int64) *livestreamEventTopic {
+	t, ok := b.topics[livestreamID]
+	if !ok {
+		// 捨てたライブ配信の履歴に何があったかは分からないので、これまでのイベントは全て溢れたものとして扱う
+		t = &livestreamEventTopic{
+			evictedID:   b.lastID,
+			subscribers: make(map[*livestreamEventSubscriber]struct{}),
+		}
+		b.topics[livestreamID] = t
+	}
+	return t
+}
+
+// Publish はイベントをJSONにして購読者へ配信する. トランザクションのコミット後に呼ぶこと
+func (b *livestreamEventBroker) Publish(livestreamID int64, eventType string, v interface{}) error {
+	return b.publish(livestreamID, eventType, v, time.Now())
+}
+
+func (b *livestreamEventBroker) publish(livestreamID int64, eventType string, v interface{}, now time.Time) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s event: %w", eventType, err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.publishes++
+	if b.publishes%livestreamEventSweepInterval == 0 {
+		b.sweep(now)
+	}
+
+	t := b.topic(livestreamID)
+	b.lastID++
+	event := LivestreamEvent{
+		ID:          b.lastID,
+		Type:        eventType,
+		Data:        data,
+		publishedAt: now,
+	}
+	t.history = append(t.history, event)
+	if len(t.history) > livestreamEventHistorySize {
+		evicted := len(t.history) - livestreamEventHistorySize
+		t.evictedID = t.history[evicted-1].ID
+		t.history = t.history[evicted:]
+	}
+
+	for sub := range t.subscribers {
+		select {
+		case sub.ch <- event:
+		default:
+			// 詰まっている購読者は切断し、Last-Event-IDで再接続させる
+			delete(t.subscribers, sub)
+			close(sub.ch)
+		}
+	}
+
+	return nil
+}
+
+// Subscribe は購読を開始し、lastEventIDより後の保持済みイベントを返す
+// 保持済みイベントで欠落を埋められない場合はresync=trueを返す
+func (b *livestreamEventBroker) Subscribe(livestreamID int64, lastEventID int64) (sub *livestreamEventSubscriber, backlog []LivestreamEvent, resync bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t := b.topic(livestreamID)
+	sub = &livestreamEventSubscriber{
+		ch: make(chan LivestreamEvent, livestreamEventSubscriberBuffer),
+	}
+	t.subscribers[sub] = struct{}{}
+
+	if lastEventID <= 0 {
+		return sub, nil, false
+	}
+
+	// 履歴から溢れたイベントを取りこぼしているか、プロセス再起動や別のサーバへの再接続でIDが合わない
+	if lastEventID < t.evictedID || lastEventID > b.lastID {
+		resync = true
+	}
+	for _, event := range t.history {
+		if event.ID > lastEventID {
+			backlog = append(backlog, event)
+		}
+	}
+
+	return sub, backlog, resync
+}
+
+func (b *livestreamEventBroker) Unsubscribe(livestreamID int64, sub *livestreamEventSubscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t, ok := b.topics[livestreamID]
+	if !ok {
+		return
+	}
+	if _, ok := t.subscribers[sub]; ok {
+		delete(t.subscribers, sub)
+		close(sub.ch)
+	}
+	if len(t.subscribers) == 0 && len(t.history) == 0 {
+		delete(b.topics, livestreamID)
+	}
+}
+
+// sweep は保持期間を過ぎたイベントを捨て、購読者も履歴もなくなったライブ配信を捨てる
+// 終了した配信にはイベントが増えないので、最後のイベントから保持期間が経てば消える
+func (b *livestreamEventBroker) sweep(now time.Time) {
+	expiredBefore := now.Add(-livestreamEventHistoryTTL)
+	for livestreamID, t := range b.topics {
+		expired := 0
+		for expired < len(t.history) && t.history[expired].publishedAt.Before(expiredBefore) {
+			expired++
+		}
+		if expired > 0 {
+			t.evictedID = t.history[expired-1].ID
+			// 捨てたイベントを参照し続けないようにコピーする
+			t.history = append([]LivestreamEvent(nil), t.history[expired:]...)
+		}
+		if len(t.subscribers) == 0 && len(t.history) == 0 {
+			delete(b.topics, livestreamID)
+		}
+	}
+}
+
+// Reset は全てのイベント履歴と購読を破棄する
+func (b *livestreamEventBroker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, t := range b.topics {
+		for sub := range t.subscribers {
+			close(sub.ch)
+		}
+	}
+	b.topics = make(map[int64]*livestreamEventTopic)
+}
+
+// ライブコメント・リアクション・モデレーションのイベントストリーム
+// GET /api/livestream/:livestream_id/events
+func getLivestreamEventsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	var lastEventID int64
+	lastEventIDParam := c.Request().Header.Get("Last-Event-ID")
+	if lastEventIDParam == "" {
+		// EventSourceを使わないクライアント向けにクエリパラメータも受け付ける
+		lastEventIDParam = c.QueryParam("last_event_id")
+	}
+	if lastEventIDParam != "" {
+		lastEventID, err = strconv.ParseInt(lastEventIDParam, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Last-Event-ID must be integer")
+		}
+	}
+
+	err = repositoryStore.Tx(ctx, func(r Repository) error {
+		_, err := r.Livestreams().FindByID(ctx, int64(livestreamID))
+		if errors.Is(err, ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "livestream not found")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sub, backlog, resync := eventBroker.Subscribe(int64(livestreamID), lastEventID)
+	defer eventBroker.Unsubscribe(int64(livestreamID), sub)
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	// nginxのバッファリングを無効化
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+
+	if resync {
+		if err := writeLivestreamEvent(res, LivestreamEvent{Type: livestreamEventReset, Data: []byte("{}")}); err != nil {
+			return nil
+		}
+	}
+	for _, event := range backlog {
+		if err := writeLivestreamEvent(res, event); err != nil {
+			return nil
+		}
+	}
+	res.Flush()
+
+	heartbeat := time.NewTicker(livestreamEventHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-sub.ch:
+			if !ok {
+				// 購読が打ち切られたので、クライアントに再接続させる
+				return nil
+			}
+			if err := writeLivestreamEvent(res, event); err != nil {
+				return nil
+			}
+			res.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(res, ": ping\n\n"); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
+
+func writeLivestreamEvent(res *echo.Response, event LivestreamEvent) error {
+	if event.ID > 0 {
+		if _, err := fmt.Fprintf(res, "id: %d\n", event.ID); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event.Type, event.Data)
+	return err
+}
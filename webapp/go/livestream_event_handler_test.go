@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func eventIDs(events []LivestreamEvent) []int64 {
+	ids := []int64{}
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestLivestreamEventBrokerSubscribe(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	// 配信1にイベント1, 2, 4、配信2にイベント3
+	newBroker := func() *livestreamEventBroker {
+		b := newLivestreamEventBroker()
+		for _, livestreamID := range []int64{1, 1, 2, 1} {
+			assert.NoError(t, b.publish(livestreamID, livestreamEventReaction, struct{}{}, now))
+		}
+		return b
+	}
+
+	tests := []struct {
+		name        string
+		lastEventID int64
+		wantBacklog []int64
+		wantResync  bool
+	}{
+		{
+			name:        "without Last-Event-ID",
+			lastEventID: 0,
+			wantBacklog: []int64{},
+		},
+		{
+			name:        "replay after Last-Event-ID",
+			lastEventID: 1,
+			wantBacklog: []int64{2, 4},
+		},
+		{
+			name:        "Last-Event-ID of other livestream",
+			lastEventID: 3,
+			wantBacklog: []int64{4},
+		},
+		{
+			name:        "Last-Event-ID before restart",
+			lastEventID: 100,
+			wantBacklog: []int64{},
+			wantResync:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBroker()
+			sub, backlog, resync := b.Subscribe(1, tt.lastEventID)
+			defer b.Unsubscribe(1, sub)
+
+			assert.Equal(t, tt.wantBacklog, eventIDs(backlog))
+			assert.Equal(t, tt.wantResync, resync)
+
+			// 購読後のイベントは購読者に届き、他の配信のイベントは届かない
+			assert.NoError(t, b.publish(2, livestreamEventReaction, struct{}{}, now))
+			assert.NoError(t, b.publish(1, livestreamEventLivecommentDeleted, LivecommentDeletedEvent{LivecommentID: 10}, now))
+			event := <-sub.ch
+			assert.Equal(t, int64(6), event.ID)
+			assert.Equal(t, livestreamEventLivecommentDeleted, event.Type)
+			assert.JSONEq(t, `{"livecomment_id": 10}`, string(event.Data))
+		})
+	}
+}
+
+func TestLivestreamEventBrokerHistoryOverflow(t *testing.T) {
+	b := newLivestreamEventBroker()
+	now := time.Unix(1700000000, 0)
+	// イベント1, 2は履歴から溢れる
+	for i := 0; i < livestreamEventHistorySize+2; i++ {
+		assert.NoError(t, b.publish(1, livestreamEventReaction, struct{}{}, now))
+	}
+
+	_, backlog, resync := b.Subscribe(1, 2)
+	assert.False(t, resync)
+	assert.Len(t, backlog, livestreamEventHistorySize)
+
+	// イベント2を受け取っていないクライアントは取り直す
+	_, backlog, resync = b.Subscribe(1, 1)
+	assert.True(t, resync)
+	assert.Len(t, backlog, livestreamEventHistorySize)
+}
+
+func TestLivestreamEventBrokerSweep(t *testing.T) {
+	b := newLivestreamEventBroker()
+	now := time.Unix(1700000000, 0)
+	assert.NoError(t, b.publish(1, livestreamEventReaction, struct{}{}, now))
+	assert.NoError(t, b.publish(2, livestreamEventReaction, struct{}{}, now))
+	sub, _, _ := b.Subscribe(2, 0)
+	assert.NoError(t, b.publish(3, livestreamEventReaction, struct{}{}, now.Add(livestreamEventHistoryTTL)))
+
+	b.sweep(now.Add(livestreamEventHistoryTTL + time.Second))
+
+	// 購読者のいない配信1は捨て、購読者のいる配信2は履歴だけを捨てる. 配信3の履歴は保持期間内
+	assert.NotContains(t, b.topics, int64(1))
+	if assert.Contains(t, b.topics, int64(2)) {
+		assert.Empty(t, b.topics[2].history)
+	}
+	assert.Len(t, b.topics[3].history, 1)
+
+	// 購読者がいなくなった配信は捨てる
+	b.Unsubscribe(2, sub)
+	assert.NotContains(t, b.topics, int64(2))
+
+	// 捨てた配信のイベントを受け取っていたクライアントは取り直す
+	sub, backlog, resync := b.Subscribe(1, 1)
+	assert.Empty(t, backlog)
+	assert.True(t, resync)
+	b.Unsubscribe(1, sub)
+
+	// 配信3の履歴はまだ再送できる
+	_, backlog, resync = b.Subscribe(3, 2)
+	assert.Equal(t, []int64{3}, eventIDs(backlog))
+	assert.False(t, resync)
+}
+
+func TestGetLivestreamEventsHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		livestreamID string
+		lastEventID  string
+		wantStatus   int
+		wantBody     string
+	}{
+		{
+			name:         "replay after Last-Event-ID",
+			livestreamID: "1",
+			lastEventID:  "1",
+			wantStatus:   http.StatusOK,
+			wantBody:     "id: 3\nevent: livecomment_deleted\ndata: {\"livecomment_id\":3}\n\n",
+		},
+		{
+			name:         "without Last-Event-ID",
+			livestreamID: "1",
+			wantStatus:   http.StatusOK,
+			wantBody:     "",
+		},
+		{
+			name:         "resync after restart",
+			livestreamID: "1",
+			lastEventID:  "100",
+			wantStatus:   http.StatusOK,
+			wantBody:     "event: reset\ndata: {}\n\n",
+		},
+		{
+			name:         "not found",
+			livestreamID: "100",
+			wantStatus:   http.StatusNotFound,
+		},
+		{
+			name:         "invalid Last-Event-ID",
+			livestreamID: "1",
+			lastEventID:  "abc",
+			wantStatus:   http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestRepositoryStore(t)
+			prevBroker := eventBroker
+			t.Cleanup(func() { eventBroker = prevBroker })
+			eventBroker = newLivestreamEventBroker()
+			// 配信1にイベント1, 3、配信2にイベント2
+			assert.NoError(t, eventBroker.Publish(testLivestreamID, livestreamEventReaction, struct{}{}))
+			assert.NoError(t, eventBroker.Publish(testOtherLivestreamID, livestreamEventReaction, struct{}{}))
+			assert.NoError(t, eventBroker.Publish(testLivestreamID, livestreamEventLivecommentDeleted, LivecommentDeletedEvent{LivecommentID: 3}))
+
+			// 再送を書き出したらすぐに戻るよう、切断済みのリクエストにする
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			req := httptest.NewRequest(http.MethodGet, "/api/livestream/"+tt.livestreamID+"/events", nil).WithContext(ctx)
+			if tt.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+			rec := serveTestHTTPRequest(t, "/api/livestream/:livestream_id/events", req, testViewerID, getLivestreamEventsHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+			assert.Equal(t, tt.wantBody, rec.Body.String())
+		})
+	}
+}
@@ -2,12 +2,12 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -23,12 +23,16 @@ type ReserveLivestreamRequest struct {
 	ThumbnailUrl string  `json:"thumbnail_url"`
 	StartAt      int64   `json:"start_at"`
 	EndAt        int64   `json:"end_at"`
+	// Collaborators はコラボレーターのユーザ名
+	Collaborators []string `json:"collaborators"`
 }
 
 type LivestreamViewerModel struct {
 	UserID       int64 `db:"user_id" json:"user_id"`
 	LivestreamID int64 `db:"livestream_id" json:"livestream_id"`
 	CreatedAt    int64 `db:"created_at" json:"created_at"`
+	LastSeenAt   int64 `db:"last_seen_at" json:"last_seen_at"`
+	LeftAt       int64 `db:"left_at" json:"left_at"`
 }
 
 type LivestreamModel struct {
@@ -52,6 +56,16 @@ type Livestream struct {
 	Tags         []Tag  `json:"tags"`
 	StartAt      int64  `json:"start_at"`
 	EndAt        int64  `json:"end_at"`
+	// Status はレスポンス時点での配信の状態. upcoming, live, ended のいずれか
+	Status string `json:"status"`
+	// Collaborators は配信者と同様にモデレーションや報告の閲覧ができるユーザ
+	Collaborators []User `json:"collaborators"`
+}
+
+type LivestreamCollaboratorModel struct {
+	ID           int64 `db:"id" json:"id"`
+	LivestreamID int64 `db:"livestream_id" json:"livestream_id"`
+	UserID       int64 `db:"user_id" json:"user_id"`
 }
 
 type LivestreamTagModel struct {
@@ -86,184 +100,658 @@ func reserveLivestreamHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
 	}
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
+	if !isInReservationTerm(req.StartAt, req.EndAt) {
+		return echo.NewHTTPError(http.StatusBadRequest, "bad reservation time range")
+	}
+
+	livestreamModel := LivestreamModel{
+		UserID:       userID,
+		Title:        req.Title,
+		Description:  req.Description,
+		PlaylistUrl:  req.PlaylistUrl,
+		ThumbnailUrl: req.ThumbnailUrl,
+		StartAt:      req.StartAt,
+		EndAt:        req.EndAt,
+	}
+	// 配信のIDは登録するまで決まらないので、コミットした後に登録できていれば無効化する
+	defer func() {
+		if livestreamModel.ID != 0 {
+			invalidateLivestreamCaches(livestreamModel.ID)
+		}
+	}()
+	var livestream Livestream
+	err := repositoryStore.Tx(ctx, func(r Repository) error {
+		// 予約枠をみて、予約が可能か調べる
+		// NOTE: 並列な予約のoverbooking防止にFOR UPDATEが必要
+		reservation := reservationRange{StartAt: req.StartAt, EndAt: req.EndAt}
+		slots, err := r.ReservationSlots().Lock(ctx, reservation)
+		if err != nil {
+			c.Logger().Warnf("予約枠一覧取得でエラー発生: %+v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation_slots: "+err.Error())
+		}
+
+		for _, slot := range slots {
+			c.Logger().Infof("%d ~ %d予約枠の残数 = %d\n", slot.StartAt, slot.EndAt, slot.Slot)
+			if slot.Slot < 1 {
+				return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("予約期間 %d ~ %dに対して、予約区間 %d ~ %dが予約できません", reservationTermStartAt.Unix(), reservationTermEndAt.Unix(), req.StartAt, req.EndAt))
+			}
+		}
+
+		if err := r.ReservationSlots().Consume(ctx, reservation); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update reservation_slot: "+err.Error())
+		}
+
+		if err := r.Livestreams().Create(ctx, &livestreamModel); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert livestream: "+err.Error())
+		}
+
+		if err := r.Stats().CreateLivestream(ctx, livestreamModel.ID, livestreamModel.UserID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+
+		// タグ追加
+		if err := r.Livestreams().SetTags(ctx, livestreamModel.ID, req.Tags); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert livestream tags: "+err.Error())
+		}
+
+		// コラボレーター追加
+		if err := setLivestreamCollaborators(ctx, r, livestreamModel, req.Collaborators); err != nil {
+			return err
+		}
+
+		livestream, err = fillLivestreamResponse(ctx, r, livestreamModel)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
+		}
+		return nil
+	})
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+		return err
 	}
-	defer tx.Rollback()
 
-	// 2023/11/25 10:00からの１年間の期間内であるかチェック
-	var (
-		termStartAt    = time.Date(2023, 11, 25, 1, 0, 0, 0, time.UTC)
-		termEndAt      = time.Date(2024, 11, 25, 1, 0, 0, 0, time.UTC)
-		reserveStartAt = time.Unix(req.StartAt, 0)
-		reserveEndAt   = time.Unix(req.EndAt, 0)
-	)
-	if (reserveStartAt.Equal(termEndAt) || reserveStartAt.After(termEndAt)) || (reserveEndAt.Equal(termStartAt) || reserveEndAt.Before(termStartAt)) {
-		return echo.NewHTTPError(http.StatusBadRequest, "bad reservation time range")
+	return c.JSON(http.StatusCreated, livestream)
+}
+
+type UpdateLivestreamRequest struct {
+	Title        *string  `json:"title"`
+	Description  *string  `json:"description"`
+	PlaylistUrl  *string  `json:"playlist_url"`
+	ThumbnailUrl *string  `json:"thumbnail_url"`
+	Tags         *[]int64 `json:"tags"`
+	StartAt      *int64   `json:"start_at"`
+	EndAt        *int64   `json:"end_at"`
+	// Collaborators を指定した場合、コラボレーターを置き換える
+	Collaborators *[]string `json:"collaborators"`
+}
+
+// 配信予約の編集API
+// PATCH /api/livestream/:livestream_id
+func updateLivestreamHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
 	}
 
-	// 予約枠をみて、予約が可能か調べる
-	// NOTE: 並列な予約のoverbooking防止にFOR UPDATEが必要
-	var slots []*ReservationSlotModel
-	if err := tx.SelectContext(ctx, &slots, "SELECT slot, start_at, end_at FROM reservation_slots WHERE start_at >= ? AND start_at <= ? AND end_at <= ? FOR UPDATE", req.StartAt, req.StartAt, req.EndAt); err != nil {
-		c.Logger().Warnf("予約枠一覧取得でエラー発生: %+v", err)
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation_slots: "+err.Error())
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
 	}
 
-	for _, slot := range slots {
-		c.Logger().Infof("%d ~ %d予約枠の残数 = %d\n", slot.StartAt, slot.EndAt, slot.Slot)
-		if slot.Slot < 1 {
-			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("予約期間 %d ~ %dに対して、予約区間 %d ~ %dが予約できません", termStartAt.Unix(), termEndAt.Unix(), req.StartAt, req.EndAt))
-		}
+	var req *UpdateLivestreamRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
 	}
 
-	var (
-		livestreamModel = &LivestreamModel{
-			UserID:       int64(userID),
-			Title:        req.Title,
-			Description:  req.Description,
-			PlaylistUrl:  req.PlaylistUrl,
-			ThumbnailUrl: req.ThumbnailUrl,
-			StartAt:      req.StartAt,
-			EndAt:        req.EndAt,
+	// コミットした後に無効化する
+	defer invalidateLivestreamCaches(int64(livestreamID))
+	var livestream Livestream
+	err = repositoryStore.Tx(ctx, func(r Repository) error {
+		livestreamModel, err := getOwnedLivestreamForUpdate(ctx, r, int64(livestreamID), userID)
+		if err != nil {
+			return err
+		}
+		if err := verifyLivestreamIsUpcoming(*livestreamModel, time.Now()); err != nil {
+			return err
 		}
-	)
 
-	if _, err := tx.ExecContext(ctx, "UPDATE reservation_slots SET slot = slot - 1 WHERE start_at >= ? AND start_at <= ? AND end_at <= ?", req.StartAt, req.StartAt, req.EndAt); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update reservation_slot: "+err.Error())
-	}
+		if req.Title != nil {
+			livestreamModel.Title = *req.Title
+		}
+		if req.Description != nil {
+			livestreamModel.Description = *req.Description
+		}
+		if req.PlaylistUrl != nil {
+			livestreamModel.PlaylistUrl = *req.PlaylistUrl
+		}
+		if req.ThumbnailUrl != nil {
+			livestreamModel.ThumbnailUrl = *req.ThumbnailUrl
+		}
 
-	rs, err := tx.NamedExecContext(ctx, "INSERT INTO livestreams (user_id, title, description, playlist_url, thumbnail_url, start_at, end_at) VALUES(:user_id, :title, :description, :playlist_url, :thumbnail_url, :start_at, :end_at)", livestreamModel)
+		// 配信時間を変更する場合は、元の予約枠を返却してから新しい予約枠を確保する
+		current := reservationRange{StartAt: livestreamModel.StartAt, EndAt: livestreamModel.EndAt}
+		updated := current
+		if req.StartAt != nil {
+			updated.StartAt = *req.StartAt
+		}
+		if req.EndAt != nil {
+			updated.EndAt = *req.EndAt
+		}
+		if updated != current {
+			if updated.StartAt >= updated.EndAt {
+				return echo.NewHTTPError(http.StatusBadRequest, "start_at must be before end_at")
+			}
+			if !isInReservationTerm(updated.StartAt, updated.EndAt) {
+				return echo.NewHTTPError(http.StatusBadRequest, "bad reservation time range")
+			}
+
+			// NOTE: 並列な予約のoverbooking防止に、新旧両方の予約枠をまとめてFOR UPDATEでロックする
+			if _, err := r.ReservationSlots().Lock(ctx, current, updated); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation_slots: "+err.Error())
+			}
+			if err := r.ReservationSlots().Release(ctx, current); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to release reservation_slots: "+err.Error())
+			}
+
+			// 返却した後の残数で判定する
+			slots, err := r.ReservationSlots().Lock(ctx, updated)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation_slots: "+err.Error())
+			}
+			for _, slot := range slots {
+				if slot.Slot < 1 {
+					return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("予約期間 %d ~ %dに対して、予約区間 %d ~ %dが予約できません", reservationTermStartAt.Unix(), reservationTermEndAt.Unix(), updated.StartAt, updated.EndAt))
+				}
+			}
+			if err := r.ReservationSlots().Consume(ctx, updated); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to update reservation_slot: "+err.Error())
+			}
+
+			livestreamModel.StartAt = updated.StartAt
+			livestreamModel.EndAt = updated.EndAt
+		}
+
+		if err := r.Livestreams().Update(ctx, *livestreamModel); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update livestream: "+err.Error())
+		}
+
+		if req.Tags != nil {
+			if err := r.Livestreams().SetTags(ctx, livestreamModel.ID, *req.Tags); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to set livestream tags: "+err.Error())
+			}
+		}
+
+		if req.Collaborators != nil {
+			if err := setLivestreamCollaborators(ctx, r, *livestreamModel, *req.Collaborators); err != nil {
+				return err
+			}
+		}
+
+		livestream, err = fillLivestreamResponse(ctx, r, *livestreamModel)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
+		}
+		return nil
+	})
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert livestream: "+err.Error())
+		return err
+	}
+
+	return c.JSON(http.StatusOK, livestream)
+}
+
+// 配信予約のキャンセルAPI
+// DELETE /api/livestream/:livestream_id
+func cancelLivestreamHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
 	}
 
-	livestreamID, err := rs.LastInsertId()
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get last inserted livestream id: "+err.Error())
-	}
-	livestreamModel.ID = livestreamID
-
-	// タグ追加
-	if len(req.Tags) > 0 {
-		tags := make([]struct {
-			TagID        int64 `db:"tag_id"`
-			LivestreamID int64 `db:"livestream_id"`
-		}, 0, len(req.Tags))
-		for _, t := range req.Tags {
-			tags = append(tags, struct {
-				TagID        int64 `db:"tag_id"`
-				LivestreamID int64 `db:"livestream_id"`
-			}{TagID: t, LivestreamID: livestreamID})
-		}
-		if _, err := tx.NamedExecContext(ctx, "INSERT INTO livestream_tags (livestream_id, tag_id) VALUES (:livestream_id, :tag_id)", tags); err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert livestream tags: "+err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	// コミットした後に無効化する
+	defer invalidateLivestreamCaches(int64(livestreamID))
+	err = repositoryStore.Tx(ctx, func(r Repository) error {
+		livestreamModel, err := getOwnedLivestreamForUpdate(ctx, r, int64(livestreamID), userID)
+		if err != nil {
+			return err
+		}
+		if err := verifyLivestreamIsUpcoming(*livestreamModel, time.Now()); err != nil {
+			return err
 		}
+
+		reservation := reservationRange{StartAt: livestreamModel.StartAt, EndAt: livestreamModel.EndAt}
+		if _, err := r.ReservationSlots().Lock(ctx, reservation); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation_slots: "+err.Error())
+		}
+		if err := r.ReservationSlots().Release(ctx, reservation); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to release reservation_slots: "+err.Error())
+		}
+
+		if err := r.Livestreams().Delete(ctx, livestreamModel.ID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete livestream: "+err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	livestream, err := fillLivestreamResponse(ctx, tx, *livestreamModel)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// getOwnedLivestreamForUpdate はユーザが所有する配信を行ロックを取って取得する
+// 返すエラーはecho.HTTPErrorなので、そのままハンドラから返してよい
+func getOwnedLivestreamForUpdate(ctx context.Context, r Repository, livestreamID int64, userID int64) (*LivestreamModel, error) {
+	livestreamModel, err := r.Livestreams().FindByIDForUpdate(ctx, livestreamID)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
+		if errors.Is(err, ErrRecordNotFound) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "not found livestream that has the given id")
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+	}
+	if livestreamModel.UserID != userID {
+		return nil, echo.NewHTTPError(http.StatusForbidden, "can't modify other streamer's livestream")
 	}
+	return &livestreamModel, nil
+}
 
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+// deleteLivestream は配信と、それに紐づくタグ・ライブコメント・リアクション等を削除する
+// 投げ銭の台帳は売上として残し、削除するライブコメントへの参照だけを外す
+func deleteLivestream(ctx context.Context, tx *sqlx.Tx, livestreamID int64) error {
+	if err := removeLivestreamStats(ctx, tx, livestreamID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE tips SET livecomment_id = NULL WHERE livestream_id = ?", livestreamID); err != nil {
+		return fmt.Errorf("failed to detach tips: %w", err)
+	}
+	for _, table := range []string{
+		"livestream_tags",
+		"livestream_collaborators",
+		"livestream_viewers_history",
+		"livecomment_reports",
+		"livecomment_moderations",
+		"ng_words",
+		"reactions",
+		"livecomments",
+	} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE livestream_id = ?", livestreamID); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", table, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM livestreams WHERE id = ?", livestreamID); err != nil {
+		return fmt.Errorf("failed to delete livestreams: %w", err)
 	}
+	return nil
+}
 
-	return c.JSON(http.StatusCreated, livestream)
+func insertLivestreamTags(ctx context.Context, tx *sqlx.Tx, livestreamID int64, tagIDs []int64) error {
+	if len(tagIDs) == 0 {
+		return nil
+	}
+	tags := make([]*LivestreamTagModel, 0, len(tagIDs))
+	for _, tagID := range tagIDs {
+		tags = append(tags, &LivestreamTagModel{
+			LivestreamID: livestreamID,
+			TagID:        tagID,
+		})
+	}
+	_, err := tx.NamedExecContext(ctx, "INSERT INTO livestream_tags (livestream_id, tag_id) VALUES (:livestream_id, :tag_id)", tags)
+	return err
 }
 
-func searchLivestreamsHandler(c echo.Context) error {
-	ctx := c.Request().Context()
-	keyTagName := c.QueryParam("tag")
+// setLivestreamCollaborators はユーザ名で指定されたユーザで配信のコラボレーターを置き換える
+// 配信者自身と重複は無視する. 返すエラーはecho.HTTPErrorなので、そのままハンドラから返してよい
+func setLivestreamCollaborators(ctx context.Context, r Repository, livestreamModel LivestreamModel, usernames []string) error {
+	usernames = uniqueStrings(usernames)
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
+	userModels, err := r.Users().FindByNames(ctx, usernames)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get collaborators: "+err.Error())
+	}
+	if len(userModels) != len(usernames) {
+		return echo.NewHTTPError(http.StatusBadRequest, "collaborators contain unknown username")
 	}
-	defer tx.Rollback()
 
-	var livestreamModels []*LivestreamModel
-	if c.QueryParam("tag") != "" {
-		// タグによる取得
-		var tagIDList []int
-		if err := tx.SelectContext(ctx, &tagIDList, "SELECT id FROM tags WHERE name = ?", keyTagName); err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get tags: "+err.Error())
+	userIDs := make([]int64, 0, len(userModels))
+	for _, u := range userModels {
+		if u.ID == livestreamModel.UserID {
+			continue
 		}
+		userIDs = append(userIDs, u.ID)
+	}
+	if err := r.Livestreams().SetCollaborators(ctx, livestreamModel.ID, userIDs); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to set livestream collaborators: "+err.Error())
+	}
+	return nil
+}
 
-		query, params, err := sqlx.In("SELECT livestream_id FROM livestream_tags WHERE tag_id IN (?)", tagIDList)
-		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to construct IN query: "+err.Error())
+// isLivestreamModerator は配信者かコラボレーターであればtrueを返す
+func isLivestreamModerator(ctx context.Context, tx *sqlx.Tx, livestreamModel LivestreamModel, userID int64) (bool, error) {
+	if livestreamModel.UserID == userID {
+		return true, nil
+	}
+	var count int64
+	if err := tx.GetContext(ctx, &count, "SELECT COUNT(*) FROM livestream_collaborators WHERE livestream_id = ? AND user_id = ?", livestreamModel.ID, userID); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// 予約可能期間. config.goの設定で上書きする
+var (
+	// デフォルトは2023/11/25 10:00からの１年間
+	reservationTermStartAt = time.Date(2023, 11, 25, 1, 0, 0, 0, time.UTC)
+	reservationTermEndAt   = time.Date(2024, 11, 25, 1, 0, 0, 0, time.UTC)
+)
+
+func isInReservationTerm(startAt int64, endAt int64) bool {
+	var (
+		reserveStartAt = time.Unix(startAt, 0)
+		reserveEndAt   = time.Unix(endAt, 0)
+	)
+	if (reserveStartAt.Equal(reservationTermEndAt) || reserveStartAt.After(reservationTermEndAt)) || (reserveEndAt.Equal(reservationTermStartAt) || reserveEndAt.Before(reservationTermStartAt)) {
+		return false
+	}
+	return true
+}
+
+// reservationSlotsCondition は予約区間が消費する予約枠を選択する条件
+// 予約と返却で必ず同じ予約枠を対象にするため、この条件を共有する
+const reservationSlotsCondition = "start_at >= ? AND start_at <= ? AND end_at <= ?"
+
+type reservationRange struct {
+	StartAt int64
+	EndAt   int64
+}
+
+func (r reservationRange) args() []interface{} {
+	return []interface{}{r.StartAt, r.StartAt, r.EndAt}
+}
+
+// contains はreservationSlotsConditionと同じ条件で、予約枠が予約区間に消費されるかを返す
+func (r reservationRange) contains(slot ReservationSlotModel) bool {
+	return slot.StartAt >= r.StartAt && slot.StartAt <= r.StartAt && slot.EndAt <= r.EndAt
+}
+
+// lockReservationSlots は予約区間が消費する予約枠をstart_at順にFOR UPDATEで取得する
+// 複数の区間をまとめてロックすることで、ロック順序を揃えてデッドロックを避ける
+func lockReservationSlots(ctx context.Context, tx *sqlx.Tx, ranges ...reservationRange) ([]*ReservationSlotModel, error) {
+	conds := make([]string, 0, len(ranges))
+	args := make([]interface{}, 0, len(ranges)*3)
+	for _, r := range ranges {
+		conds = append(conds, "("+reservationSlotsCondition+")")
+		args = append(args, r.args()...)
+	}
+
+	var slots []*ReservationSlotModel
+	query := "SELECT id, slot, start_at, end_at FROM reservation_slots WHERE " + strings.Join(conds, " OR ") + " ORDER BY start_at FOR UPDATE"
+	if err := tx.SelectContext(ctx, &slots, query, args...); err != nil {
+		return nil, err
+	}
+	return slots, nil
+}
+
+func consumeReservationSlots(ctx context.Context, tx *sqlx.Tx, r reservationRange) error {
+	_, err := tx.ExecContext(ctx, "UPDATE reservation_slots SET slot = slot - 1 WHERE "+reservationSlotsCondition, r.args()...)
+	return err
+}
+
+func releaseReservationSlots(ctx context.Context, tx *sqlx.Tx, r reservationRange) error {
+	_, err := tx.ExecContext(ctx, "UPDATE reservation_slots SET slot = slot + 1 WHERE "+reservationSlotsCondition, r.args()...)
+	return err
+}
+
+// LivestreamSearchQuery は配信検索の条件. 指定された条件は全てANDで結合される
+type LivestreamSearchQuery struct {
+	// タグ名. TagModeがANDなら全てのタグ、ORならいずれかのタグが付与された配信にマッチする
+	Tags    []string
+	TagMode string
+	// タイトルか説明文に含まれるキーワード
+	Keyword string
+	// 配信者のユーザ名
+	Owner string
+	// 配信期間がこの時間帯と重なる配信にマッチする. 0は無指定
+	StartAt int64
+	EndAt   int64
+	// 配信の状態. 複数指定した場合はいずれかの状態の配信にマッチする
+	Statuses []string
+	// 配信の状態を判定する基準時刻
+	Now time.Time
+}
+
+const (
+	searchTagModeAnd = "and"
+	searchTagModeOr  = "or"
+)
+
+func parseLivestreamSearchQuery(c echo.Context) (*LivestreamSearchQuery, error) {
+	q := &LivestreamSearchQuery{
+		TagMode: searchTagModeOr,
+		Keyword: strings.TrimSpace(c.QueryParam("q")),
+		Owner:   c.QueryParam("owner"),
+		Now:     time.Now(),
+	}
+
+	// tag=a&tag=b と tags=a,b のどちらの形式でも受け付ける
+	params := c.QueryParams()
+	for _, name := range params["tag"] {
+		if name != "" {
+			q.Tags = append(q.Tags, name)
 		}
-		var keyTaggedLivestreamIDs []int64
-		if err := tx.SelectContext(ctx, &keyTaggedLivestreamIDs, query, params...); err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get keyTaggedLivestreams: "+err.Error())
+	}
+	for _, v := range params["tags"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				q.Tags = append(q.Tags, name)
+			}
 		}
+	}
 
-		query, params, err = sqlx.In("SELECT * FROM livestreams WHERE id IN (?) ORDER BY id DESC", keyTaggedLivestreamIDs)
+	if v := c.QueryParam("tag_mode"); v != "" {
+		mode := strings.ToLower(v)
+		if mode != searchTagModeAnd && mode != searchTagModeOr {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "tag_mode query parameter must be 'and' or 'or'")
+		}
+		q.TagMode = mode
+	}
+
+	if v := c.QueryParam("start_at"); v != "" {
+		startAt, err := strconv.ParseInt(v, 10, 64)
 		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to create livestreams query: "+err.Error())
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "start_at query parameter must be integer")
 		}
-		if err := tx.SelectContext(ctx, &livestreamModels, query, params...); err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestreams: "+err.Error())
+		q.StartAt = startAt
+	}
+	if v := c.QueryParam("end_at"); v != "" {
+		endAt, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "end_at query parameter must be integer")
 		}
-	} else {
-		// 検索条件なし
-		query := `SELECT * FROM livestreams ORDER BY id DESC`
-		if c.QueryParam("limit") != "" {
-			limit, err := strconv.Atoi(c.QueryParam("limit"))
-			if err != nil {
-				return echo.NewHTTPError(http.StatusBadRequest, "limit query parameter must be integer")
+		q.EndAt = endAt
+	}
+	if q.StartAt != 0 && q.EndAt != 0 && q.StartAt >= q.EndAt {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "start_at must be before end_at")
+	}
+
+	// status=live&status=upcoming と status=live,upcoming のどちらの形式でも受け付ける
+	for _, v := range params["status"] {
+		for _, status := range strings.Split(v, ",") {
+			status = strings.ToLower(strings.TrimSpace(status))
+			if status == "" {
+				continue
+			}
+			if _, _, ok := livestreamStatusCondition(status, q.Now); !ok {
+				return nil, echo.NewHTTPError(http.StatusBadRequest, "status query parameter must be upcoming, live or ended")
 			}
-			query += fmt.Sprintf(" LIMIT %d", limit)
+			q.Statuses = append(q.Statuses, status)
 		}
+	}
 
-		if err := tx.SelectContext(ctx, &livestreamModels, query); err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestreams: "+err.Error())
+	return q, nil
+}
+
+// whereClause は検索条件をWHERE句の条件に変換する
+func (q *LivestreamSearchQuery) whereClause() (string, []interface{}, error) {
+	var (
+		conds []string
+		args  []interface{}
+	)
+
+	if len(q.Tags) > 0 {
+		tags := uniqueStrings(q.Tags)
+		sub := "SELECT lt.livestream_id FROM livestream_tags lt INNER JOIN tags t ON t.id = lt.tag_id WHERE t.name IN (?)"
+		subArgs := []interface{}{tags}
+		if q.TagMode == searchTagModeAnd {
+			sub += " GROUP BY lt.livestream_id HAVING COUNT(DISTINCT t.id) = ?"
+			subArgs = append(subArgs, len(tags))
+		}
+		sub, subArgs, err := sqlx.In(sub, subArgs...)
+		if err != nil {
+			return "", nil, err
 		}
+		conds = append(conds, "id IN ("+sub+")")
+		args = append(args, subArgs...)
 	}
 
-	livestreams, err := fillLivestreamsResponse(ctx, tx, livestreamModels)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
+	if q.Keyword != "" {
+		pattern := "%" + escapeLike(q.Keyword) + "%"
+		conds = append(conds, "(title LIKE ? OR description LIKE ?)")
+		args = append(args, pattern, pattern)
 	}
 
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	if q.Owner != "" {
+		conds = append(conds, "user_id = (SELECT id FROM users WHERE name = ?)")
+		args = append(args, q.Owner)
 	}
 
-	return c.JSON(http.StatusOK, livestreams)
+	if q.StartAt != 0 {
+		conds = append(conds, "end_at > ?")
+		args = append(args, q.StartAt)
+	}
+	if q.EndAt != 0 {
+		conds = append(conds, "start_at < ?")
+		args = append(args, q.EndAt)
+	}
+
+	if len(q.Statuses) > 0 {
+		var statusConds []string
+		for _, status := range uniqueStrings(q.Statuses) {
+			cond, condArgs, _ := livestreamStatusCondition(status, q.Now)
+			statusConds = append(statusConds, cond)
+			args = append(args, condArgs...)
+		}
+		conds = append(conds, "("+strings.Join(statusConds, " OR ")+")")
+	}
+
+	if len(conds) == 0 {
+		return "1 = 1", nil, nil
+	}
+	return strings.Join(conds, " AND "), args, nil
 }
 
-func getMyLivestreamsHandler(c echo.Context) error {
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]struct{}, len(values))
+	unique := make([]string, 0, len(values))
+	for _, v := range values {
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		unique = append(unique, v)
+	}
+	return unique
+}
+
+// escapeLike はLIKE句のワイルドカードをエスケープする
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func searchLivestreamsHandler(c echo.Context) error {
 	ctx := c.Request().Context()
-	if err := verifyUserSession(c); err != nil {
+
+	searchQuery, err := parseLivestreamSearchQuery(c)
+	if err != nil {
 		return err
 	}
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
+	// 配信はIDの降順で並べるため、カーソルの並び替えキーは不要
+	page, err := parsePageRequest(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+		return err
+	}
+
+	var (
+		livestreams []Livestream
+		nextCursor  string
+	)
+	err = repositoryStore.Tx(ctx, func(r Repository) error {
+		livestreamModels, err := r.Livestreams().Search(ctx, searchQuery, page)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestreams: "+err.Error())
+		}
+
+		livestreamModels, nextCursor = paginate(page, livestreamModels, func(l *LivestreamModel) (int64, int64) {
+			return 0, l.ID
+		})
+
+		livestreams, err = fillLivestreamsResponse(ctx, r, livestreamModels)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return writePage(c, page, livestreams, nextCursor)
+}
+
+func getMyLivestreamsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	if err := verifyUserSession(c); err != nil {
+		return err
 	}
-	defer tx.Rollback()
 
 	// error already checked
 	sess, _ := session.Get(defaultSessionIDKey, c)
 	// existence already checked
 	userID := sess.Values[defaultUserIDKey].(int64)
 
-	var livestreamModels []*LivestreamModel
-	if err := tx.SelectContext(ctx, &livestreamModels, "SELECT * FROM livestreams WHERE user_id = ?", userID); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestreams: "+err.Error())
-	}
-	livestreams, err := fillLivestreamsResponse(ctx, tx, livestreamModels)
+	var livestreams []Livestream
+	err := repositoryStore.Tx(ctx, func(r Repository) error {
+		livestreamModels, err := r.Livestreams().ListByUserID(ctx, userID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestreams: "+err.Error())
+		}
+		livestreams, err = fillLivestreamsResponse(ctx, r, livestreamModels)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
+		}
+		return nil
+	})
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
-	}
-
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+		return err
 	}
 
 	return c.JSON(http.StatusOK, livestreams)
@@ -277,32 +765,28 @@ func getUserLivestreamsHandler(c echo.Context) error {
 
 	username := c.Param("username")
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
-	}
-	defer tx.Rollback()
-
-	var user UserModel
-	if err := tx.GetContext(ctx, &user, "SELECT * FROM users WHERE name = ?", username); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+	var livestreams []Livestream
+	err := repositoryStore.Tx(ctx, func(r Repository) error {
+		user, err := r.Users().FindByName(ctx, username)
+		if errors.Is(err, ErrRecordNotFound) {
 			return echo.NewHTTPError(http.StatusNotFound, "user not found")
-		} else {
+		}
+		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
 		}
-	}
 
-	var livestreamModels []*LivestreamModel
-	if err := tx.SelectContext(ctx, &livestreamModels, "SELECT * FROM livestreams WHERE user_id = ?", user.ID); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestreams: "+err.Error())
-	}
-	livestreams, err := fillLivestreamsResponse(ctx, tx, livestreamModels)
+		livestreamModels, err := r.Livestreams().ListByUserID(ctx, user.ID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestreams: "+err.Error())
+		}
+		livestreams, err = fillLivestreamsResponse(ctx, r, livestreamModels)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestreams: "+err.Error())
+		}
+		return nil
+	})
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestreams: "+err.Error())
-	}
-
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+		return err
 	}
 
 	return c.JSON(http.StatusOK, livestreams)
@@ -326,24 +810,25 @@ func enterLivestreamHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id must be integer")
 	}
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
-	}
-	defer tx.Rollback()
-
-	viewer := LivestreamViewerModel{
-		UserID:       int64(userID),
-		LivestreamID: int64(livestreamID),
-		CreatedAt:    time.Now().Unix(),
-	}
-
-	if _, err := tx.NamedExecContext(ctx, "INSERT INTO livestream_viewers_history (user_id, livestream_id, created_at) VALUES(:user_id, :livestream_id, :created_at)", viewer); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert livestream_view_history: "+err.Error())
-	}
+	err = repositoryStore.Tx(ctx, func(r Repository) error {
+		if err := verifyLivestreamIsLive(ctx, r, int64(livestreamID)); err != nil {
+			return err
+		}
 
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+		now := time.Now().Unix()
+		viewer := LivestreamViewerModel{
+			UserID:       int64(userID),
+			LivestreamID: int64(livestreamID),
+			CreatedAt:    now,
+			LastSeenAt:   now,
+		}
+		if err := r.Viewers().Enter(ctx, viewer); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert livestream_view_history: "+err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return c.NoContent(http.StatusOK)
@@ -366,57 +851,87 @@ func exitLivestreamHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
 	}
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
+	err = repositoryStore.Tx(ctx, func(r Repository) error {
+		// 視聴履歴はユニーク視聴者数の集計に使うため、削除せずに退出日時を記録する
+		if err := r.Viewers().Exit(ctx, userID, int64(livestreamID), time.Now().Unix()); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update livestream_view_history: "+err.Error())
+		}
+		return nil
+	})
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
-	}
-	defer tx.Rollback()
-
-	if _, err := tx.ExecContext(ctx, "DELETE FROM livestream_viewers_history WHERE user_id = ? AND livestream_id = ?", userID, livestreamID); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete livestream_view_history: "+err.Error())
-	}
-
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+		return err
 	}
 
 	return c.NoContent(http.StatusOK)
 }
 
-func getLivestreamHandler(c echo.Context) error {
+// 視聴継続のハートビートAPI
+// POST /api/livestream/:livestream_id/heartbeat
+func heartbeatLivestreamHandler(c echo.Context) error {
 	ctx := c.Request().Context()
-
 	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
 		return err
 	}
 
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
 	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
 	}
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
+	err = repositoryStore.Tx(ctx, func(r Repository) error {
+		err := r.Viewers().Heartbeat(ctx, userID, int64(livestreamID), time.Now().Unix())
+		if errors.Is(err, ErrRecordNotFound) {
+			// 退出済みか入室していないので、入室からやり直してもらう
+			return echo.NewHTTPError(http.StatusNotFound, "not entered the livestream")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update livestream_view_history: "+err.Error())
+		}
+		return nil
+	})
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+		return err
 	}
-	defer tx.Rollback()
 
-	livestreamModel := LivestreamModel{}
-	err = tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID)
-	if errors.Is(err, sql.ErrNoRows) {
-		return echo.NewHTTPError(http.StatusNotFound, "not found livestream that has the given id")
-	}
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+	return c.NoContent(http.StatusNoContent)
+}
+
+func getLivestreamHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		return err
 	}
 
-	livestream, err := fillLivestreamResponse(ctx, tx, livestreamModel)
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
 	}
 
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	var livestream Livestream
+	err = repositoryStore.Tx(ctx, func(r Repository) error {
+		livestreamModel, err := r.Livestreams().FindByID(ctx, int64(livestreamID))
+		if errors.Is(err, ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found livestream that has the given id")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+		}
+
+		livestream, err = fillLivestreamResponse(ctx, r, livestreamModel)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livestream: "+err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return c.JSON(http.StatusOK, livestream)
@@ -434,90 +949,55 @@ func getLivecommentReportsHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
 	}
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
-	}
-	defer tx.Rollback()
-
-	var livestreamModel LivestreamModel
-	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", livestreamID); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
-	}
-
 	// error already check
 	sess, _ := session.Get(defaultSessionIDKey, c)
 	// existence already check
 	userID := sess.Values[defaultUserIDKey].(int64)
 
-	if livestreamModel.UserID != userID {
-		return echo.NewHTTPError(http.StatusForbidden, "can't get other streamer's livecomment reports")
-	}
+	var reports []LivecommentReport
+	err = repositoryStore.Tx(ctx, func(r Repository) error {
+		livestreamModel, err := r.Livestreams().FindByID(ctx, int64(livestreamID))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+		}
 
-	var reportModels []*LivecommentReportModel
-	if err := tx.SelectContext(ctx, &reportModels, "SELECT * FROM livecomment_reports WHERE livestream_id = ?", livestreamID); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment reports: "+err.Error())
-	}
+		isModerator, err := r.Livestreams().IsModerator(ctx, livestreamModel, userID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to check livestream collaborators: "+err.Error())
+		}
+		if !isModerator {
+			return echo.NewHTTPError(http.StatusForbidden, "can't get other streamer's livecomment reports")
+		}
 
-	reports := make([]LivecommentReport, len(reportModels))
-	for i := range reportModels {
-		report, err := fillLivecommentReportResponse(ctx, tx, *reportModels[i])
+		reportModels, err := r.Reports().ListByLivestreamID(ctx, int64(livestreamID))
 		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment report: "+err.Error())
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment reports: "+err.Error())
 		}
-		reports[i] = report
-	}
 
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+		reports, err = fillLivecommentReportsResponse(ctx, r, reportModels)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment report: "+err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return c.JSON(http.StatusOK, reports)
 }
 
-func fillLivestreamResponse(ctx context.Context, tx *sqlx.Tx, livestreamModel LivestreamModel) (Livestream, error) {
-	ownerModel := UserModel{}
-	if err := tx.GetContext(ctx, &ownerModel, "SELECT * FROM users WHERE id = ?", livestreamModel.UserID); err != nil {
-		return Livestream{}, err
-	}
-	owner, err := fillUserResponse(ctx, tx, ownerModel)
+func fillLivestreamResponse(ctx context.Context, r Repository, livestreamModel LivestreamModel) (Livestream, error) {
+	livestreams, err := fillLivestreamsResponse(ctx, r, []*LivestreamModel{&livestreamModel})
 	if err != nil {
 		return Livestream{}, err
 	}
-
-	var livestreamTagModels []*LivestreamTagModel
-	if err := tx.SelectContext(ctx, &livestreamTagModels, "SELECT * FROM livestream_tags WHERE livestream_id = ?", livestreamModel.ID); err != nil {
-		return Livestream{}, err
-	}
-
-	tags := make([]Tag, len(livestreamTagModels))
-	for i := range livestreamTagModels {
-		tagModel := TagModel{}
-		if err := tx.GetContext(ctx, &tagModel, "SELECT * FROM tags WHERE id = ?", livestreamTagModels[i].TagID); err != nil {
-			return Livestream{}, err
-		}
-
-		tags[i] = Tag{
-			ID:   tagModel.ID,
-			Name: tagModel.Name,
-		}
-	}
-
-	livestream := Livestream{
-		ID:           livestreamModel.ID,
-		Owner:        owner,
-		Title:        livestreamModel.Title,
-		Tags:         tags,
-		Description:  livestreamModel.Description,
-		PlaylistUrl:  livestreamModel.PlaylistUrl,
-		ThumbnailUrl: livestreamModel.ThumbnailUrl,
-		StartAt:      livestreamModel.StartAt,
-		EndAt:        livestreamModel.EndAt,
-	}
-	return livestream, nil
+	return livestreams[0], nil
 }
 
-func fillLivestreamsResponse(ctx context.Context, tx *sqlx.Tx, livestreamModels []*LivestreamModel) ([]Livestream, error) {
+func fillLivestreamsResponse(ctx context.Context, r Repository, livestreamModels []*LivestreamModel) ([]Livestream, error) {
+	// 全ての配信の状態を同じ時刻で判定する
+	now := time.Now()
 	livestreamIDs := make([]int64, 0, len(livestreamModels))
 	userIDs := make([]int64, 0, len(livestreamModels))
 	for _, l := range livestreamModels {
@@ -525,60 +1005,60 @@ func fillLivestreamsResponse(ctx context.Context, tx *sqlx.Tx, livestreamModels
 		userIDs = append(userIDs, l.UserID)
 	}
 
+	// コラボレーターも配信者と一緒にユーザ情報を取得する
+	collaboratorModels, err := r.Livestreams().Collaborators(ctx, livestreamIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, lc := range collaboratorModels {
+		userIDs = append(userIDs, lc.UserID)
+	}
+
 	ownerMap := make(map[int64]*User, len(userIDs))
 	if len(userIDs) > 0 {
-		ownerModels := make([]UserModel, 0, len(userIDs))
-		query, params, err := sqlx.In("SELECT * FROM users WHERE id IN (?)", userIDs)
+		owners, err := r.Users().FindByIDs(ctx, userIDs)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create users query: %w", err)
-		}
-		if err := tx.SelectContext(ctx, &ownerModels, query, params...); err != nil {
-			return nil, fmt.Errorf("failed to query users: %w", err)
+			return nil, err
 		}
 
-		o, err := fillUsersResponse(ctx, tx, ownerModels)
+		o, err := fillUsersResponse(ctx, r, userModelsOf(owners))
 		if err != nil {
 			return nil, fmt.Errorf("failed to fill users response: %w", err)
 		}
 		ownerMap = o
 	}
 
-	livestreamTagsMap := make(map[int64][]Tag, len(livestreamIDs))
-	if len(livestreamIDs) > 0 {
-		var livestreamTagModels []*struct {
-			LivestreamID int64  `db:"livestream_id"`
-			TagID        int64  `db:"tag_id"`
-			TagName      string `db:"tag_name"`
-		}
-		query, params, err := sqlx.In("SELECT lt.livestream_id, lt.tag_id, t.name as tag_name FROM livestream_tags lt INNER JOIN tags t ON lt.tag_id = t.id WHERE lt.livestream_id IN (?)", livestreamIDs)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create livestream_tags query: %w", err)
-		}
-		if err := tx.SelectContext(ctx, &livestreamTagModels, query, params...); err != nil {
-			return nil, fmt.Errorf("failed to query livestream_tags: %w", err)
-		}
+	livestreamTagsMap, err := r.Livestreams().Tags(ctx, livestreamIDs)
+	if err != nil {
+		return nil, err
+	}
 
-		for _, lt := range livestreamTagModels {
-			if _, ok := livestreamTagsMap[lt.LivestreamID]; !ok {
-				livestreamTagsMap[lt.LivestreamID] = make([]Tag, 0)
-			}
-			tag := Tag{
-				ID:   lt.TagID,
-				Name: lt.TagName,
-			}
-			livestreamTagsMap[lt.LivestreamID] = append(livestreamTagsMap[lt.LivestreamID], tag)
+	livestreamCollaboratorsMap := make(map[int64][]User, len(livestreamIDs))
+	for _, lc := range collaboratorModels {
+		collaborator, ok := ownerMap[lc.UserID]
+		if !ok {
+			return nil, fmt.Errorf("collaborator %d is not found", lc.UserID)
 		}
+		livestreamCollaboratorsMap[lc.LivestreamID] = append(livestreamCollaboratorsMap[lc.LivestreamID], *collaborator)
 	}
 
 	livestreams := make([]Livestream, 0, len(livestreamModels))
 	for _, l := range livestreamModels {
+		owner, ok := ownerMap[l.UserID]
+		if !ok {
+			return nil, fmt.Errorf("owner %d is not found", l.UserID)
+		}
 		tags := make([]Tag, 0)
 		if v, ok := livestreamTagsMap[l.ID]; ok {
 			tags = v
 		}
+		collaborators := make([]User, 0)
+		if v, ok := livestreamCollaboratorsMap[l.ID]; ok {
+			collaborators = v
+		}
 		livestream := Livestream{
 			ID:           l.ID,
-			Owner:        *ownerMap[l.UserID],
+			Owner:        *owner,
 			Title:        l.Title,
 			Tags:         tags,
 			Description:  l.Description,
@@ -586,6 +1066,9 @@ func fillLivestreamsResponse(ctx context.Context, tx *sqlx.Tx, livestreamModels
 			ThumbnailUrl: l.ThumbnailUrl,
 			StartAt:      l.StartAt,
 			EndAt:        l.EndAt,
+			Status:       livestreamStatus(l.StartAt, l.EndAt, now),
+
+			Collaborators: collaborators,
 		}
 		livestreams = append(livestreams, livestream)
 	}
@@ -0,0 +1,608 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetLivestreamHandler(t *testing.T) {
+	tests := []struct {
+		name              string
+		livestreamID      string
+		wantStatus        int
+		wantTags          []Tag
+		wantCollaborators []string
+	}{
+		{
+			name:              "tags and collaborators in registration order",
+			livestreamID:      "1",
+			wantStatus:        http.StatusOK,
+			wantTags:          []Tag{{ID: 2, Name: "ゲーム実況"}, {ID: 1, Name: "ライブ配信"}},
+			wantCollaborators: []string{"collaborator"},
+		},
+		{
+			name:              "no tags and no collaborators",
+			livestreamID:      "2",
+			wantStatus:        http.StatusOK,
+			wantTags:          []Tag{},
+			wantCollaborators: []string{},
+		},
+		{
+			name:         "not found",
+			livestreamID: "100",
+			wantStatus:   http.StatusNotFound,
+		},
+		{
+			name:         "invalid id",
+			livestreamID: "abc",
+			wantStatus:   http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestRepositoryStore(t)
+			rec := serveTestRequest(t, http.MethodGet, "/api/livestream/:livestream_id", "/api/livestream/"+tt.livestreamID, testViewerID, getLivestreamHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			livestream := decodeResponse[Livestream](t, rec)
+			assert.Equal(t, "streamer", livestream.Owner.Name)
+			assert.Equal(t, tt.wantTags, livestream.Tags)
+			collaborators := []string{}
+			for _, u := range livestream.Collaborators {
+				collaborators = append(collaborators, u.Name)
+			}
+			assert.Equal(t, tt.wantCollaborators, collaborators)
+			assert.Equal(t, livestreamStatusEnded, livestream.Status)
+		})
+	}
+}
+
+func TestListLivestreamsHandlers(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		target     string
+		userID     int64
+		handler    echo.HandlerFunc
+		wantStatus int
+		wantIDs    []int64
+	}{
+		{
+			name:       "my livestreams",
+			path:       "/api/livestream",
+			target:     "/api/livestream",
+			userID:     testStreamerID,
+			handler:    getMyLivestreamsHandler,
+			wantStatus: http.StatusOK,
+			wantIDs:    []int64{testLivestreamID, testOtherLivestreamID},
+		},
+		{
+			name:       "my livestreams when none",
+			path:       "/api/livestream",
+			target:     "/api/livestream",
+			userID:     testViewerID,
+			handler:    getMyLivestreamsHandler,
+			wantStatus: http.StatusOK,
+			wantIDs:    []int64{},
+		},
+		{
+			name:       "user livestreams",
+			path:       "/api/user/:username/livestream",
+			target:     "/api/user/streamer/livestream",
+			userID:     testViewerID,
+			handler:    getUserLivestreamsHandler,
+			wantStatus: http.StatusOK,
+			wantIDs:    []int64{testLivestreamID, testOtherLivestreamID},
+		},
+		{
+			name:       "user not found",
+			path:       "/api/user/:username/livestream",
+			target:     "/api/user/nobody/livestream",
+			userID:     testViewerID,
+			handler:    getUserLivestreamsHandler,
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestRepositoryStore(t)
+			rec := serveTestRequest(t, http.MethodGet, tt.path, tt.target, tt.userID, tt.handler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			ids := []int64{}
+			for _, l := range decodeResponse[[]Livestream](t, rec) {
+				ids = append(ids, l.ID)
+			}
+			assert.Equal(t, tt.wantIDs, ids)
+		})
+	}
+}
+
+func TestGetLivecommentReportsHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		userID     int64
+		wantStatus int
+	}{
+		{name: "streamer", userID: testStreamerID, wantStatus: http.StatusOK},
+		{name: "collaborator", userID: testCollaboratorID, wantStatus: http.StatusOK},
+		{name: "viewer", userID: testViewerID, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestRepositoryStore(t)
+			rec := serveTestRequest(t, http.MethodGet, "/api/livestream/:livestream_id/report", "/api/livestream/1/report", tt.userID, getLivecommentReportsHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			reports := decodeResponse[[]LivecommentReport](t, rec)
+			if assert.Len(t, reports, 1) {
+				assert.Equal(t, "viewer", reports[0].Reporter.Name)
+				// 論理削除したライブコメントの報告も返す
+				assert.Equal(t, int64(3), reports[0].Livecomment.ID)
+			}
+		})
+	}
+}
+
+func TestCancelLivestreamHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		livestreamID int64
+		status       string
+		userID       int64
+		wantStatus   int
+		wantDeleted  bool
+	}{
+		{
+			name:         "owner cancels upcoming livestream",
+			livestreamID: testLivestreamID,
+			status:       livestreamStatusUpcoming,
+			userID:       testStreamerID,
+			wantStatus:   http.StatusNoContent,
+			wantDeleted:  true,
+		},
+		{
+			name:         "live livestream can't be canceled",
+			livestreamID: testLivestreamID,
+			status:       livestreamStatusLive,
+			userID:       testStreamerID,
+			wantStatus:   http.StatusBadRequest,
+		},
+		{
+			name:         "ended livestream can't be canceled",
+			livestreamID: testLivestreamID,
+			status:       livestreamStatusEnded,
+			userID:       testStreamerID,
+			wantStatus:   http.StatusBadRequest,
+		},
+		{
+			name:         "collaborator can't cancel",
+			livestreamID: testLivestreamID,
+			status:       livestreamStatusUpcoming,
+			userID:       testCollaboratorID,
+			wantStatus:   http.StatusForbidden,
+		},
+		{
+			name:         "not found",
+			livestreamID: 100,
+			status:       livestreamStatusUpcoming,
+			userID:       testStreamerID,
+			wantStatus:   http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			moveTestLivestream(s, testLivestreamID, tt.status)
+			rec := serveTestRequest(t, http.MethodDelete, "/api/livestream/:livestream_id", "/api/livestream/"+strconv.FormatInt(tt.livestreamID, 10), tt.userID, cancelLivestreamHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+
+			ctx := context.Background()
+			assert.NoError(t, s.Tx(ctx, func(r Repository) error {
+				_, err := r.Livestreams().FindByID(ctx, testLivestreamID)
+				if tt.wantDeleted {
+					assert.ErrorIs(t, err, ErrRecordNotFound)
+				} else {
+					assert.NoError(t, err)
+				}
+
+				// キャンセルした配信の予約枠だけが返却される
+				slots, err := r.ReservationSlots().Lock(ctx,
+					reservationRange{StartAt: s.data.reservationSlots[0].StartAt, EndAt: s.data.reservationSlots[0].EndAt},
+					reservationRange{StartAt: s.data.reservationSlots[1].StartAt, EndAt: s.data.reservationSlots[1].EndAt},
+				)
+				assert.NoError(t, err)
+				wantSlot := map[int64]int64{1: 4, 2: 4}
+				if tt.wantDeleted {
+					wantSlot[1] = 5
+				}
+				gotSlot := map[int64]int64{}
+				for _, slot := range slots {
+					gotSlot[slot.ID] = slot.Slot
+				}
+				assert.Equal(t, wantSlot, gotSlot)
+
+				livecomments, err := r.Livecomments().FindByIDs(ctx, []int64{1, 2, 3, 4, 5})
+				assert.NoError(t, err)
+				if tt.wantDeleted {
+					assert.Len(t, livecomments, 1)
+				} else {
+					assert.Len(t, livecomments, 5)
+				}
+				return nil
+			}))
+		})
+	}
+}
+
+func TestReserveLivestreamHandler(t *testing.T) {
+	// 配信2と同じ区間の予約枠(残り4)を予約する
+	startAt := testLivestreamStartAt.Add(time.Hour).Unix()
+	endAt := startAt + 3600
+	tests := []struct {
+		name          string
+		userID        int64
+		slot          int64
+		startAt       int64
+		collaborators string
+		wantStatus    int
+		wantSlot      int64
+	}{
+		{name: "success", userID: testStreamerID, slot: 4, startAt: startAt, collaborators: `["viewer"]`, wantStatus: http.StatusCreated, wantSlot: 3},
+		{name: "full slot", userID: testStreamerID, slot: 0, startAt: startAt, collaborators: `[]`, wantStatus: http.StatusBadRequest, wantSlot: 0},
+		{name: "out of reservation term", userID: testStreamerID, slot: 4, startAt: reservationTermEndAt.Unix(), collaborators: `[]`, wantStatus: http.StatusBadRequest, wantSlot: 4},
+		{name: "unknown collaborator rolls back", userID: testStreamerID, slot: 4, startAt: startAt, collaborators: `["unknown"]`, wantStatus: http.StatusBadRequest, wantSlot: 4},
+		{name: "not logged in", userID: 0, slot: 4, startAt: startAt, collaborators: `[]`, wantStatus: http.StatusForbidden, wantSlot: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			s.data.reservationSlots[1].Slot = tt.slot
+
+			body := fmt.Sprintf(`{"title": "新しい配信", "description": "説明", "playlist_url": "https://example.com/playlist.m3u8", "thumbnail_url": "https://example.com/thumbnail.jpg", "start_at": %d, "end_at": %d, "tags": [1], "collaborators": %s}`, tt.startAt, tt.startAt+3600, tt.collaborators)
+			rec := serveTestJSONRequest(t, http.MethodPost, "/api/livestream/reservation", "/api/livestream/reservation", body, tt.userID, reserveLivestreamHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantSlot, s.data.reservationSlots[1].Slot)
+			assert.Equal(t, int64(4), s.data.reservationSlots[0].Slot)
+			if tt.wantStatus != http.StatusCreated {
+				assert.Len(t, s.data.livestreams, 2)
+				return
+			}
+
+			livestream := decodeResponse[Livestream](t, rec)
+			assert.Equal(t, int64(3), livestream.ID)
+			assert.Equal(t, "新しい配信", livestream.Title)
+			assert.Equal(t, "streamer", livestream.Owner.Name)
+			assert.Equal(t, startAt, livestream.StartAt)
+			assert.Equal(t, endAt, livestream.EndAt)
+			assert.Equal(t, []Tag{{ID: 1, Name: "ライブ配信"}}, livestream.Tags)
+			if assert.Len(t, livestream.Collaborators, 1) {
+				assert.Equal(t, "viewer", livestream.Collaborators[0].Name)
+			}
+
+			livestreamModel := s.data.livestreams[livestream.ID]
+			assert.Equal(t, testStreamerID, livestreamModel.UserID)
+			assert.Equal(t, LivestreamStatsModel{LivestreamID: livestream.ID, UserID: testStreamerID}, s.data.livestreamStats[livestream.ID])
+		})
+	}
+}
+
+func TestUpdateLivestreamHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		userID     int64
+		body       string
+		wantStatus int
+	}{
+		{
+			name:       "owner updates upcoming livestream",
+			status:     livestreamStatusUpcoming,
+			userID:     testStreamerID,
+			body:       `{"title": "新しいタイトル", "tags": [1], "collaborators": ["viewer", "streamer", "viewer"]}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "live livestream can't be updated",
+			status:     livestreamStatusLive,
+			userID:     testStreamerID,
+			body:       `{"title": "新しいタイトル"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "ended livestream can't be updated",
+			status:     livestreamStatusEnded,
+			userID:     testStreamerID,
+			body:       `{"title": "新しいタイトル"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "collaborator can't update",
+			status:     livestreamStatusUpcoming,
+			userID:     testCollaboratorID,
+			body:       `{"title": "新しいタイトル"}`,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "unknown collaborator",
+			status:     livestreamStatusUpcoming,
+			userID:     testStreamerID,
+			body:       `{"title": "新しいタイトル", "collaborators": ["nobody"]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			moveTestLivestream(s, testLivestreamID, tt.status)
+			before := s.data.livestreams[testLivestreamID]
+			rec := serveTestJSONRequest(t, http.MethodPatch, "/api/livestream/:livestream_id", "/api/livestream/1", tt.body, tt.userID, updateLivestreamHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusOK {
+				// 失敗した場合は何も変更しない
+				assert.Equal(t, before, s.data.livestreams[testLivestreamID])
+				assert.Len(t, s.data.collaborators, 1)
+				return
+			}
+			livestream := decodeResponse[Livestream](t, rec)
+			assert.Equal(t, "新しいタイトル", livestream.Title)
+			assert.Equal(t, []Tag{{ID: 1, Name: "ライブ配信"}}, livestream.Tags)
+			// 配信者自身と重複は無視し、既存のコラボレーターは置き換える
+			if assert.Len(t, livestream.Collaborators, 1) {
+				assert.Equal(t, "viewer", livestream.Collaborators[0].Name)
+			}
+			assert.Equal(t, "新しいタイトル", s.data.livestreams[testLivestreamID].Title)
+		})
+	}
+}
+
+func TestUpdateLivestreamHandlerReservation(t *testing.T) {
+	tests := []struct {
+		name       string
+		freeSlot   int64
+		wantStatus int
+		// 元の区間と新しい区間の予約枠の残数
+		wantSlots [2]int64
+	}{
+		{name: "move to free slot", freeSlot: 1, wantStatus: http.StatusOK, wantSlots: [2]int64{5, 0}},
+		{name: "no free slot", freeSlot: 0, wantStatus: http.StatusBadRequest, wantSlots: [2]int64{4, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			moveTestLivestream(s, testLivestreamID, livestreamStatusUpcoming)
+			current := s.data.livestreams[testLivestreamID]
+			// 1時間後ろにずらした区間の予約枠
+			s.data.reservationSlots = append(s.data.reservationSlots, ReservationSlotModel{ID: 3, Slot: tt.freeSlot, StartAt: current.EndAt, EndAt: current.EndAt + 3600})
+
+			termStartAt, termEndAt := reservationTermStartAt, reservationTermEndAt
+			t.Cleanup(func() { reservationTermStartAt, reservationTermEndAt = termStartAt, termEndAt })
+			reservationTermStartAt = time.Unix(current.StartAt, 0)
+			reservationTermEndAt = time.Unix(current.EndAt+24*3600, 0)
+
+			body := fmt.Sprintf(`{"start_at": %d, "end_at": %d}`, current.EndAt, current.EndAt+3600)
+			rec := serveTestJSONRequest(t, http.MethodPatch, "/api/livestream/:livestream_id", "/api/livestream/1", body, testStreamerID, updateLivestreamHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantSlots, [2]int64{s.data.reservationSlots[0].Slot, s.data.reservationSlots[2].Slot})
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, current.EndAt, s.data.livestreams[testLivestreamID].StartAt)
+			} else {
+				assert.Equal(t, current, s.data.livestreams[testLivestreamID])
+			}
+		})
+	}
+}
+
+func TestSearchLivestreamsHandler(t *testing.T) {
+	// 配信1: [startAt, startAt+1h) タグ ライブ配信, ゲーム実況
+	// 配信2: [startAt+1h, startAt+2h) タグ ライブ配信
+	startAt := testLivestreamStartAt.Unix()
+	tests := []struct {
+		name           string
+		query          string
+		wantStatus     int
+		wantIDs        []int64
+		wantNextCursor bool
+		paged          bool
+	}{
+		{name: "no condition", query: "", wantStatus: http.StatusOK, wantIDs: []int64{testOtherLivestreamID, testLivestreamID}},
+		{name: "single tag", query: "tag=ゲーム実況", wantStatus: http.StatusOK, wantIDs: []int64{testLivestreamID}},
+		{name: "tags or", query: "tags=ライブ配信,ゲーム実況", wantStatus: http.StatusOK, wantIDs: []int64{testOtherLivestreamID, testLivestreamID}},
+		{name: "tags and", query: "tag=ライブ配信&tag=ゲーム実況&tag_mode=and", wantStatus: http.StatusOK, wantIDs: []int64{testLivestreamID}},
+		{name: "tags and with duplicates", query: "tags=ライブ配信,ライブ配信&tag_mode=AND", wantStatus: http.StatusOK, wantIDs: []int64{testOtherLivestreamID, testLivestreamID}},
+		{name: "tags and with unknown tag", query: "tags=ライブ配信,unknown&tag_mode=and", wantStatus: http.StatusOK, wantIDs: []int64{}},
+		{name: "keyword", query: "q=配信2", wantStatus: http.StatusOK, wantIDs: []int64{testOtherLivestreamID}},
+		{name: "keyword with wildcard", query: "q=%25", wantStatus: http.StatusOK, wantIDs: []int64{}},
+		{name: "owner", query: "owner=streamer&tag=ゲーム実況", wantStatus: http.StatusOK, wantIDs: []int64{testLivestreamID}},
+		{name: "unknown owner", query: "owner=nobody", wantStatus: http.StatusOK, wantIDs: []int64{}},
+		{name: "overlaps first livestream", query: fmt.Sprintf("start_at=%d&end_at=%d", startAt+600, startAt+1200), wantStatus: http.StatusOK, wantIDs: []int64{testLivestreamID}},
+		{name: "overlaps both livestreams", query: fmt.Sprintf("start_at=%d&end_at=%d", startAt+1800, startAt+5400), wantStatus: http.StatusOK, wantIDs: []int64{testOtherLivestreamID, testLivestreamID}},
+		{name: "touching end is not overlap", query: fmt.Sprintf("start_at=%d", startAt+3600), wantStatus: http.StatusOK, wantIDs: []int64{testOtherLivestreamID}},
+		{name: "touching start is not overlap", query: fmt.Sprintf("end_at=%d", startAt+3600), wantStatus: http.StatusOK, wantIDs: []int64{testLivestreamID}},
+		{name: "status", query: "status=live,ended", wantStatus: http.StatusOK, wantIDs: []int64{testOtherLivestreamID, testLivestreamID}},
+		{name: "status upcoming", query: "status=upcoming", wantStatus: http.StatusOK, wantIDs: []int64{}},
+		{name: "limit", query: "limit=1", wantStatus: http.StatusOK, wantIDs: []int64{testOtherLivestreamID}, wantNextCursor: true},
+		{name: "before id", query: fmt.Sprintf("before_id=%d", testOtherLivestreamID), wantStatus: http.StatusOK, wantIDs: []int64{testLivestreamID}, paged: true},
+		{name: "first page", query: "limit=1&cursor=", wantStatus: http.StatusOK, wantIDs: []int64{testOtherLivestreamID}, wantNextCursor: true, paged: true},
+		{name: "invalid tag mode", query: "tag=ライブ配信&tag_mode=xor", wantStatus: http.StatusBadRequest},
+		{name: "invalid start at", query: "start_at=yesterday", wantStatus: http.StatusBadRequest},
+		{name: "invalid end at", query: "end_at=tomorrow", wantStatus: http.StatusBadRequest},
+		{name: "empty period", query: fmt.Sprintf("start_at=%d&end_at=%d", startAt, startAt), wantStatus: http.StatusBadRequest},
+		{name: "invalid status", query: "status=archived", wantStatus: http.StatusBadRequest},
+		{name: "invalid limit", query: "limit=-1", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			s.data.livestreamTags = append(s.data.livestreamTags, LivestreamTagModel{ID: 3, LivestreamID: testOtherLivestreamID, TagID: 1})
+
+			target := "/api/livestream/search?" + (&url.URL{RawQuery: tt.query}).Query().Encode()
+			rec := serveTestRequest(t, http.MethodGet, "/api/livestream/search", target, 0, searchLivestreamsHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			livestreams, nextCursor := decodeListResponse[Livestream](t, rec, tt.paged)
+			ids := []int64{}
+			for _, l := range livestreams {
+				ids = append(ids, l.ID)
+			}
+			assert.Equal(t, tt.wantIDs, ids)
+			assert.Equal(t, tt.wantNextCursor, nextCursor != "")
+		})
+	}
+}
+
+func TestSetLivestreamCollaborators(t *testing.T) {
+	tests := []struct {
+		name       string
+		usernames  []string
+		wantStatus int
+		wantIDs    []int64
+	}{
+		{name: "replace", usernames: []string{"viewer"}, wantIDs: []int64{testViewerID}},
+		{name: "owner and duplicates are ignored", usernames: []string{"viewer", "streamer", "collaborator", "viewer"}, wantIDs: []int64{testViewerID, testCollaboratorID}},
+		{name: "clear", usernames: []string{}, wantIDs: []int64{}},
+		{name: "unknown username", usernames: []string{"viewer", "nobody"}, wantStatus: http.StatusBadRequest, wantIDs: []int64{testCollaboratorID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			ctx := context.Background()
+
+			err := s.Tx(ctx, func(r Repository) error {
+				return setLivestreamCollaborators(ctx, r, s.data.livestreams[testLivestreamID], tt.usernames)
+			})
+			if tt.wantStatus != 0 {
+				var herr *echo.HTTPError
+				if assert.ErrorAs(t, err, &herr) {
+					assert.Equal(t, tt.wantStatus, herr.Code)
+				}
+			} else {
+				assert.NoError(t, err)
+			}
+
+			ids := []int64{}
+			for _, c := range s.data.collaborators {
+				if c.LivestreamID == testLivestreamID {
+					ids = append(ids, c.UserID)
+				}
+			}
+			assert.Equal(t, tt.wantIDs, ids)
+		})
+	}
+}
+
+// コラボレーターは参加している配信に限り、配信者と同じモデレーション操作と報告の閲覧ができる
+func TestLivestreamModeratorRights(t *testing.T) {
+	handlers := []struct {
+		name          string
+		method        string
+		path          string
+		target        map[int64]string
+		body          string
+		handler       echo.HandlerFunc
+		wantStatus    int
+		wantForbidden int
+	}{
+		{
+			name:          "get reports",
+			method:        http.MethodGet,
+			path:          "/api/livestream/:livestream_id/report",
+			target:        map[int64]string{testLivestreamID: "/api/livestream/1/report", testOtherLivestreamID: "/api/livestream/2/report"},
+			handler:       getLivecommentReportsHandler,
+			wantStatus:    http.StatusOK,
+			wantForbidden: http.StatusForbidden,
+		},
+		{
+			name:          "get moderations",
+			method:        http.MethodGet,
+			path:          "/api/livestream/:livestream_id/moderations",
+			target:        map[int64]string{testLivestreamID: "/api/livestream/1/moderations", testOtherLivestreamID: "/api/livestream/2/moderations"},
+			handler:       getLivecommentModerationsHandler,
+			wantStatus:    http.StatusOK,
+			wantForbidden: http.StatusForbidden,
+		},
+		{
+			name:          "moderate",
+			method:        http.MethodPost,
+			path:          "/api/livestream/:livestream_id/moderate",
+			target:        map[int64]string{testLivestreamID: "/api/livestream/1/moderate", testOtherLivestreamID: "/api/livestream/2/moderate"},
+			body:          `{"ng_word": "hoge"}`,
+			handler:       moderateHandler,
+			wantStatus:    http.StatusCreated,
+			wantForbidden: http.StatusBadRequest,
+		},
+		{
+			name:          "delete NG word",
+			method:        http.MethodDelete,
+			path:          "/api/livestream/:livestream_id/ngwords/:ngword_id",
+			target:        map[int64]string{testLivestreamID: "/api/livestream/1/ngwords/1", testOtherLivestreamID: "/api/livestream/2/ngwords/3"},
+			handler:       deleteNgwordHandler,
+			wantStatus:    http.StatusNoContent,
+			wantForbidden: http.StatusForbidden,
+		},
+		{
+			name:          "delete livecomment",
+			method:        http.MethodDelete,
+			path:          "/api/livestream/:livestream_id/livecomment/:livecomment_id",
+			target:        map[int64]string{testLivestreamID: "/api/livestream/1/livecomment/1", testOtherLivestreamID: "/api/livestream/2/livecomment/5"},
+			handler:       deleteLivecommentHandler,
+			wantStatus:    http.StatusNoContent,
+			wantForbidden: http.StatusForbidden,
+		},
+	}
+	users := []struct {
+		name         string
+		userID       int64
+		livestreamID int64
+		allowed      bool
+	}{
+		{name: "streamer", userID: testStreamerID, livestreamID: testLivestreamID, allowed: true},
+		{name: "collaborator", userID: testCollaboratorID, livestreamID: testLivestreamID, allowed: true},
+		{name: "viewer", userID: testViewerID, livestreamID: testLivestreamID},
+		{name: "collaborator of other livestream", userID: testCollaboratorID, livestreamID: testOtherLivestreamID},
+	}
+	for _, h := range handlers {
+		for _, u := range users {
+			t.Run(h.name+"/"+u.name, func(t *testing.T) {
+				newTestRepositoryStore(t)
+				var rec *httptest.ResponseRecorder
+				if h.body != "" {
+					rec = serveTestJSONRequest(t, h.method, h.path, h.target[u.livestreamID], h.body, u.userID, h.handler)
+				} else {
+					rec = serveTestRequest(t, h.method, h.path, h.target[u.livestreamID], u.userID, h.handler)
+				}
+
+				if u.allowed {
+					assert.Equal(t, h.wantStatus, rec.Code)
+				} else {
+					assert.Equal(t, h.wantForbidden, rec.Code)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	livestreamStatusUpcoming = "upcoming"
+	livestreamStatusLive     = "live"
+	livestreamStatusEnded    = "ended"
+
+	// trueの場合、配信時間外のライブコメント・リアクション・入室を拒否する
+	enforceLiveWindowEnvKey = "ISUCON13_ENFORCE_LIVE_WINDOW"
+)
+
+// 既定では従来通り配信時間外の操作も受け付ける
+var enforceLiveWindow = false
+
+// livestreamStatus は配信時間と現在時刻から配信の状態を求める
+// start_atちょうどは配信中、end_atちょうどは終了とする
+func livestreamStatus(startAt, endAt int64, now time.Time) string {
+	switch t := now.Unix(); {
+	case t < startAt:
+		return livestreamStatusUpcoming
+	case t < endAt:
+		return livestreamStatusLive
+	default:
+		return livestreamStatusEnded
+	}
+}
+
+// livestreamStatusCondition はlivestreamStatusと同じ判定をするWHERE句の条件を返す
+func livestreamStatusCondition(status string, now time.Time) (string, []interface{}, bool) {
+	t := now.Unix()
+	switch status {
+	case livestreamStatusUpcoming:
+		return "start_at > ?", []interface{}{t}, true
+	case livestreamStatusLive:
+		return "(start_at <= ? AND end_at > ?)", []interface{}{t, t}, true
+	case livestreamStatusEnded:
+		return "end_at <= ?", []interface{}{t}, true
+	default:
+		return "", nil, false
+	}
+}
+
+// verifyLivestreamIsLive は配信時間の制限が有効な場合に、配信中でなければエラーを返す
+// 返すエラーはecho.NewHTTPErrorなのでそのまま出力できる
+func verifyLivestreamIsLive(ctx context.Context, r Repository, livestreamID int64) error {
+	if !enforceLiveWindow {
+		return nil
+	}
+
+	livestreamModel, err := r.Livestreams().FindByID(ctx, livestreamID)
+	if errors.Is(err, ErrRecordNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "livestream not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+	}
+	if status := livestreamStatus(livestreamModel.StartAt, livestreamModel.EndAt, time.Now()); status != livestreamStatusLive {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream is "+status)
+	}
+	return nil
+}
+
+// verifyLivestreamIsUpcoming は配信が始まっていればエラーを返す. 配信中・終了済みの配信は編集もキャンセルもできない
+// 返すエラーはecho.NewHTTPErrorなのでそのまま出力できる
+func verifyLivestreamIsUpcoming(livestreamModel LivestreamModel, now time.Time) error {
+	if status := livestreamStatus(livestreamModel.StartAt, livestreamModel.EndAt, now); status != livestreamStatusUpcoming {
+		return echo.NewHTTPError(http.StatusBadRequest, "can't modify livestream that is "+status)
+	}
+	return nil
+}
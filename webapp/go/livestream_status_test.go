@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLivestreamStatus(t *testing.T) {
+	const (
+		startAt int64 = 1700000000
+		endAt   int64 = 1700003600
+	)
+	tests := []struct {
+		name string
+		now  int64
+		want string
+	}{
+		{name: "before start", now: startAt - 1, want: livestreamStatusUpcoming},
+		{name: "at start", now: startAt, want: livestreamStatusLive},
+		{name: "during", now: startAt + 1800, want: livestreamStatusLive},
+		{name: "at end", now: endAt, want: livestreamStatusEnded},
+		{name: "after end", now: endAt + 1, want: livestreamStatusEnded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, livestreamStatus(startAt, endAt, time.Unix(tt.now, 0)))
+		})
+	}
+}
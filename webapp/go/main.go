@@ -4,13 +4,13 @@ package main
 // sqlx的な参考: https://jmoiron.github.io/sqlx/
 
 import (
-	"fmt"
+	"context"
+	"flag"
 	"log"
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
-	"strconv"
+	"time"
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
@@ -22,84 +22,37 @@ import (
 	echolog "github.com/labstack/gommon/log"
 )
 
-const (
-	listenPort                     = 8080
-	powerDNSSubdomainAddressEnvKey = "ISUCON13_POWERDNS_SUBDOMAIN_ADDRESS"
-
-	iconPath = "/home/isucon/webapp/public/icons"
-)
-
-var (
-	powerDNSSubdomainAddress string
-	dbConn                   *sqlx.DB
-	secret                   = []byte("isucon13_session_cookiestore_defaultsecret")
-)
+var dbConn *sqlx.DB
 
 func init() {
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-	if secretKey, ok := os.LookupEnv("ISUCON13_SESSION_SECRETKEY"); ok {
-		secret = []byte(secretKey)
-	}
 }
 
 type InitializeResponse struct {
 	Language string `json:"language"`
+	// Steps は初期化の各ステップの所要時間
+	Steps []InitializeStep `json:"steps"`
 }
 
-func connectDB(logger echo.Logger) (*sqlx.DB, error) {
-	const (
-		networkTypeEnvKey = "ISUCON13_MYSQL_DIALCONFIG_NET"
-		addrEnvKey        = "ISUCON13_MYSQL_DIALCONFIG_ADDRESS"
-		portEnvKey        = "ISUCON13_MYSQL_DIALCONFIG_PORT"
-		userEnvKey        = "ISUCON13_MYSQL_DIALCONFIG_USER"
-		passwordEnvKey    = "ISUCON13_MYSQL_DIALCONFIG_PASSWORD"
-		dbNameEnvKey      = "ISUCON13_MYSQL_DIALCONFIG_DATABASE"
-		parseTimeEnvKey   = "ISUCON13_MYSQL_DIALCONFIG_PARSETIME"
-	)
-
+func connectDB(cfg DBConfig) (*sqlx.DB, error) {
 	conf := mysql.NewConfig()
-
-	// 環境変数がセットされていなかった場合でも一旦動かせるように、デフォルト値を入れておく
-	// この挙動を変更して、エラーを出すようにしてもいいかもしれない
-	conf.Net = "tcp"
-	conf.Addr = net.JoinHostPort("127.0.0.1", "3306")
-	conf.User = "isucon"
-	conf.Passwd = "isucon"
-	conf.DBName = "isupipe"
-	conf.ParseTime = true
-
-	if v, ok := os.LookupEnv(networkTypeEnvKey); ok {
-		conf.Net = v
-	}
-	if addr, ok := os.LookupEnv(addrEnvKey); ok {
-		if port, ok2 := os.LookupEnv(portEnvKey); ok2 {
-			conf.Addr = net.JoinHostPort(addr, port)
-		} else {
-			conf.Addr = net.JoinHostPort(addr, "3306")
-		}
-	}
-	if v, ok := os.LookupEnv(userEnvKey); ok {
-		conf.User = v
-	}
-	if v, ok := os.LookupEnv(passwordEnvKey); ok {
-		conf.Passwd = v
-	}
-	if v, ok := os.LookupEnv(dbNameEnvKey); ok {
-		conf.DBName = v
-	}
-	if v, ok := os.LookupEnv(parseTimeEnvKey); ok {
-		parseTime, err := strconv.ParseBool(v)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse environment variable '%s' as bool: %+v", parseTimeEnvKey, err)
-		}
-		conf.ParseTime = parseTime
+	conf.Net = cfg.Net
+	conf.Addr = cfg.Address
+	if cfg.Net != "unix" {
+		conf.Addr = net.JoinHostPort(cfg.Address, cfg.Port)
 	}
+	conf.User = cfg.User
+	conf.Passwd = cfg.Password
+	conf.DBName = cfg.Database
+	conf.ParseTime = cfg.ParseTime
 
 	db, err := sqlx.Open("mysql", conf.FormatDSN())
 	if err != nil {
 		return nil, err
 	}
-	db.SetMaxOpenConns(10)
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime))
 
 	if err := db.Ping(); err != nil {
 		return nil, err
@@ -109,24 +62,69 @@ func connectDB(logger echo.Logger) (*sqlx.DB, error) {
 }
 
 func initializeHandler(c echo.Context) error {
-	if out, err := exec.Command("../sql/init.sh").CombinedOutput(); err != nil {
-		c.Logger().Warnf("init.sh failed with err=%s", string(out))
+	ini := &initializer{}
+	if err := ini.Run(c.Request().Context(), dbConn, dnsRegistrar); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to initialize: "+err.Error())
 	}
+	for _, step := range ini.steps {
+		c.Logger().Infof("initialize %s took %dms", step.Name, step.ElapsedMs)
+	}
+	eventBroker.Reset()
+	resetCaches()
+	if err := sessionStore.Reset(c.Request().Context()); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to reset sessions: "+err.Error())
+	}
+	if err := rateLimitStore.Reset(c.Request().Context()); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to reset rate limits: "+err.Error())
+	}
 
 	c.Request().Header.Add("Content-Type", "application/json;charset=utf-8")
 	return c.JSON(http.StatusOK, InitializeResponse{
 		Language: "golang",
+		Steps:    ini.steps,
 	})
 }
 
 func main() {
+	configPath := flag.String("config", os.Getenv(configFileEnvKey), "path to JSON config file")
+	printConfig := flag.Bool("print-config", false, "print the effective config and exit")
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+	if *printConfig {
+		os.Exit(printConfigMain(cfg))
+	}
+	if args := flag.Args(); len(args) > 0 {
+		if args[0] != "migrate" {
+			log.Fatalf("unknown command %q", args[0])
+		}
+		// マイグレーションにはDNSなどの設定は不要なので、データベースの設定だけを検証する
+		if err := cfg.DB.Validate(); err != nil {
+			log.Fatalf("invalid config:\n%v", err)
+		}
+		os.Exit(migrateMain(cfg.DB, args[1:]))
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("invalid config:\n%v", err)
+	}
+	if err := cfg.apply(); err != nil {
+		log.Fatalf("failed to apply config: %v", err)
+	}
+
+	if err := loadFallbackImageHash(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
 	e := echo.New()
 	e.Debug = true
 	e.Logger.SetLevel(echolog.DEBUG)
 	e.Use(middleware.Logger())
-	cookieStore := sessions.NewCookieStore(secret)
-	cookieStore.Options.Domain = "*.u.isucon.dev"
+	cookieStore := sessions.NewCookieStore([]byte(cfg.Session.SecretKey))
+	cookieStore.Options.Domain = "*." + cfg.BaseDomain
 	e.Use(session.Middleware(cookieStore))
 	// e.Use(middleware.Recover())
 
@@ -140,24 +138,38 @@ func main() {
 	// livestream
 	// reserve livestream
 	e.POST("/api/livestream/reservation", reserveLivestreamHandler)
+	// reservation slots
+	e.GET("/api/reservation_slots", getReservationSlotsHandler)
+	e.GET("/api/reservation_slots/next", getNextReservationWindowHandler)
 	// list livestream
 	e.GET("/api/livestream/search", searchLivestreamsHandler)
 	e.GET("/api/livestream", getMyLivestreamsHandler)
 	e.GET("/api/user/:username/livestream", getUserLivestreamsHandler)
 	// get livestream
 	e.GET("/api/livestream/:livestream_id", getLivestreamHandler)
+	// edit / cancel livestream
+	e.PATCH("/api/livestream/:livestream_id", updateLivestreamHandler)
+	e.DELETE("/api/livestream/:livestream_id", cancelLivestreamHandler)
 	// get polling livecomment timeline
 	e.GET("/api/livestream/:livestream_id/livecomment", getLivecommentsHandler)
 	// ライブコメント投稿
-	e.POST("/api/livestream/:livestream_id/livecomment", postLivecommentHandler)
-	e.POST("/api/livestream/:livestream_id/reaction", postReactionHandler)
+	e.POST("/api/livestream/:livestream_id/livecomment", postLivecommentHandler, rateLimit(rateLimitGroupLivecomment))
+	e.POST("/api/livestream/:livestream_id/reaction", postReactionHandler, rateLimit(rateLimitGroupReaction))
 	e.GET("/api/livestream/:livestream_id/reaction", getReactionsHandler)
+	// ライブコメント・リアクション・モデレーションのServer-Sent Events
+	e.GET("/api/livestream/:livestream_id/events", getLivestreamEventsHandler)
 
 	// (配信者向け)ライブコメントの報告一覧取得API
 	e.GET("/api/livestream/:livestream_id/report", getLivecommentReportsHandler)
+	e.GET("/api/livestream/:livestream_id/report/livecomments", getReportedLivecommentsHandler)
 	e.GET("/api/livestream/:livestream_id/ngwords", getNgwords)
+	e.DELETE("/api/livestream/:livestream_id/ngwords/:ngword_id", deleteNgwordHandler)
+	e.DELETE("/api/livestream/:livestream_id/livecomment/:livecomment_id", deleteLivecommentHandler)
+	e.GET("/api/livestream/:livestream_id/moderations", getLivecommentModerationsHandler)
 	// ライブコメント報告
-	e.POST("/api/livestream/:livestream_id/livecomment/:livecomment_id/report", reportLivecommentHandler)
+	e.POST("/api/livestream/:livestream_id/livecomment/:livecomment_id/report", reportLivecommentHandler, rateLimit(rateLimitGroupReport))
+	e.POST("/api/livestream/:livestream_id/livecomment/:livecomment_id/report/dismiss", dismissLivecommentReportsHandler)
+	e.POST("/api/livestream/:livestream_id/livecomment/:livecomment_id/report/delete", deleteReportedLivecommentHandler)
 	// 配信者によるモデレーション (NGワード登録)
 	e.POST("/api/livestream/:livestream_id/moderate", moderateHandler)
 
@@ -166,14 +178,28 @@ func main() {
 	e.POST("/api/livestream/:livestream_id/enter", enterLivestreamHandler)
 	// ユーザ視聴終了 (viewer)
 	e.DELETE("/api/livestream/:livestream_id/exit", exitLivestreamHandler)
+	// 視聴継続 (viewer)
+	e.POST("/api/livestream/:livestream_id/heartbeat", heartbeatLivestreamHandler)
 
 	// user
 	e.POST("/api/register", registerHandler)
 	e.POST("/api/login", loginHandler)
+	e.POST("/api/logout", logoutHandler)
+	e.POST("/api/logout/all", logoutAllHandler)
 	e.GET("/api/user/me", getMeHandler)
+	e.PATCH("/api/user/me", updateMeHandler)
+	e.PUT("/api/user/me/password", updatePasswordHandler)
+	e.DELETE("/api/user/me", deleteMeHandler)
+	e.GET("/api/user/me/following", getFollowingHandler)
+	e.GET("/api/user/me/earnings", getMyEarningsHandler)
+	e.POST("/api/user/:username/follow", followHandler)
+	e.DELETE("/api/user/:username/follow", unfollowHandler)
+	// フォロー中の配信者の配信一覧
+	e.GET("/api/feed", getFeedHandler)
 	// フロントエンドで、配信予約のコラボレーターを指定する際に必要
 	e.GET("/api/user/:username", getUserHandler)
 	e.GET("/api/user/:username/statistics", getUserStatisticsHandler)
+	e.GET("/api/user/:username/icon", getIconHandler)
 	e.POST("/api/icon", postIconHandler)
 
 	// stats
@@ -186,24 +212,42 @@ func main() {
 	e.HTTPErrorHandler = errorResponseHandler
 
 	// DB接続
-	conn, err := connectDB(e.Logger)
+	conn, err := connectDB(cfg.DB)
 	if err != nil {
 		e.Logger.Errorf("failed to connect db: %v", err)
 		os.Exit(1)
 	}
 	defer conn.Close()
 	dbConn = conn
+	if err := checkSchemaVersion(context.Background(), dbConn); err != nil {
+		e.Logger.Errorf("%v", err)
+		os.Exit(1)
+	}
+	repositoryStore = newMySQLRepositoryStore(dbConn)
+
+	store, err := newSessionStore(cfg.Session.Store, dbConn)
+	if err != nil {
+		e.Logger.Errorf("failed to create session store: %v", err)
+		os.Exit(1)
+	}
+	sessionStore = store
 
-	subdomainAddr, ok := os.LookupEnv(powerDNSSubdomainAddressEnvKey)
-	if !ok {
-		e.Logger.Errorf("environ %s must be provided", powerDNSSubdomainAddressEnvKey)
+	registrar, err := newDNSRegistrar(cfg.DNS.Backend, cfg.DNS.PowerDNSMySQLDSN, cfg.BaseDomain, cfg.DNS.ZoneFile, cfg.DNS.SubdomainAddress)
+	if err != nil {
+		e.Logger.Errorf("failed to create DNS registrar: %v", err)
+		os.Exit(1)
+	}
+	dnsRegistrar = registrar
+
+	limitStore, err := newRateLimitStore(cfg.RateLimit.Store, dbConn)
+	if err != nil {
+		e.Logger.Errorf("failed to create rate limit store: %v", err)
 		os.Exit(1)
 	}
-	powerDNSSubdomainAddress = subdomainAddr
+	rateLimitStore = limitStore
 
 	// HTTPサーバ起動
-	listenAddr := net.JoinHostPort("", strconv.Itoa(listenPort))
-	if err := e.Start(listenAddr); err != nil {
+	if err := e.Start(cfg.ListenAddress); err != nil {
 		e.Logger.Errorf("failed to start HTTP server: %v", err)
 		os.Exit(1)
 	}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	echolog "github.com/labstack/gommon/log"
+)
+
+// スキーママイグレーション
+// 初期スキーマ(sql/initdb.d/10_schema.sql)をバージョン0とし、
+// migrations/に置いた <version>_<name>.up.sql / .down.sql を順に適用する
+// 10_schema.sqlはマイグレーション導入前からあるデータベースと同じ元のスキーマのまま変更しない
+// テーブルやカラムの追加は、既存のデータベースにも適用されるよう必ずマイグレーションとして書く
+// 適用済みのバージョンはschema_migrationsテーブルに記録する
+// MySQLのDDLはロールバックできないので、途中で失敗したマイグレーションは手で戻してから再実行すること
+
+var schemaFile = "../sql/initdb.d/10_schema.sql"
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// 複数のプロセスが同時にマイグレーションしないように取るロック
+const migrationLockName = "isupipe_schema_migrations"
+
+type migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// loadMigrations はマイグレーションをバージョン順に読み込む
+// バージョンは1からの連番で、upとdownが揃っていなければならない
+func loadMigrations(fsys fs.FS, dir string) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*migration{}
+	for _, entry := range entries {
+		m := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version %q: %w", entry.Name(), err)
+		}
+		b, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig := byVersion[version]
+		if mig == nil {
+			mig = &migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration version %d has different names %q and %q", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(b)
+		} else {
+			mig.Down = string(b)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, mig := range migrations {
+		if mig.Version != int64(i+1) {
+			return nil, fmt.Errorf("migration version %d is missing", i+1)
+		}
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down scripts", mig.Version, mig.Name)
+		}
+	}
+	return migrations, nil
+}
+
+// embeddedMigrations はバイナリに埋め込んだマイグレーションを返す
+func embeddedMigrations() []migration {
+	migrations, err := loadMigrations(migrationFS, "migrations")
+	if err != nil {
+		// 埋め込んだファイルはビルド時に決まるので、ここで失敗するのはバグ
+		panic(err)
+	}
+	return migrations
+}
+
+// expectedSchemaVersion はこのバイナリが前提とするスキーマのバージョン
+func expectedSchemaVersion() int64 {
+	return int64(len(embeddedMigrations()))
+}
+
+type migrator struct {
+	conn       *sqlx.Conn
+	migrations []migration
+	out        io.Writer
+}
+
+// withMigrator はマイグレーション用のロックを取ったコネクションでfnを実行する
+func withMigrator(ctx context.Context, db *sqlx.DB, out io.Writer, fn func(m *migrator) error) error {
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked int
+	if err := conn.GetContext(ctx, &locked, "SELECT GET_LOCK(?, 60)", migrationLockName); err != nil {
+		return fmt.Errorf("failed to get migration lock: %w", err)
+	}
+	if locked != 1 {
+		return fmt.Errorf("timed out waiting for migration lock")
+	}
+	defer conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", migrationLockName)
+
+	m := &migrator{conn: conn, migrations: embeddedMigrations(), out: out}
+	if err := m.ensureBaseline(ctx); err != nil {
+		return err
+	}
+	return fn(m)
+}
+
+// ensureBaseline はテーブルがまだ作られていないデータベースに初期スキーマを適用し、schema_migrationsを作成する
+func (m *migrator) ensureBaseline(ctx context.Context) error {
+	var count int
+	if err := m.conn.GetContext(ctx, &count, "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'users'"); err != nil {
+		return fmt.Errorf("failed to check schema: %w", err)
+	}
+	if count == 0 {
+		fmt.Fprintf(m.out, "applying initial schema %s\n", schemaFile)
+		src, err := os.ReadFile(schemaFile)
+		if err != nil {
+			return err
+		}
+		if err := m.exec(ctx, string(src)); err != nil {
+			return fmt.Errorf("failed to apply initial schema: %w", err)
+		}
+	}
+
+	_, err := m.conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+  version BIGINT NOT NULL PRIMARY KEY,
+  name VARCHAR(255) NOT NULL,
+  applied_at BIGINT NOT NULL
+) ENGINE = InnoDB CHARACTER SET utf8mb4 COLLATE utf8mb4_bin`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return nil
+}
+
+func (m *migrator) exec(ctx context.Context, src string) error {
+	for _, stmt := range splitSQLStatements(src) {
+		if _, err := m.conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to execute %.80q: %w", stmt, err)
+		}
+	}
+	return nil
+}
+
+// Version は適用済みの最新のバージョンを返す. 初期スキーマのみの場合は0
+func (m *migrator) Version(ctx context.Context) (int64, error) {
+	var version int64
+	if err := m.conn.GetContext(ctx, &version, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations"); err != nil {
+		return 0, fmt.Errorf("failed to get schema version: %w", err)
+	}
+	return version, nil
+}
+
+// Up は未適用のマイグレーションをすべて適用する
+func (m *migrator) Up(ctx context.Context) error {
+	current, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if current > int64(len(m.migrations)) {
+		return fmt.Errorf("database schema version %d is newer than this binary (%d)", current, len(m.migrations))
+	}
+
+	for _, mig := range m.migrations[current:] {
+		fmt.Fprintf(m.out, "applying %d_%s\n", mig.Version, mig.Name)
+		if err := m.exec(ctx, mig.Up); err != nil {
+			return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+		if _, err := m.conn.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", mig.Version, mig.Name, time.Now().Unix()); err != nil {
+			return fmt.Errorf("failed to record migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+	}
+	return nil
+}
+
+// Down は適用済みのマイグレーションを新しい順にn個戻す
+func (m *migrator) Down(ctx context.Context, n int) error {
+	current, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if current > int64(len(m.migrations)) {
+		return fmt.Errorf("database schema version %d is newer than this binary (%d)", current, len(m.migrations))
+	}
+
+	for ; n > 0 && current > 0; n, current = n-1, current-1 {
+		mig := m.migrations[current-1]
+		fmt.Fprintf(m.out, "reverting %d_%s\n", mig.Version, mig.Name)
+		if err := m.exec(ctx, mig.Down); err != nil {
+			return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+		if _, err := m.conn.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", mig.Version); err != nil {
+			return fmt.Errorf("failed to record migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+	}
+	return nil
+}
+
+// Status は各マイグレーションの適用状況を出力する
+func (m *migrator) Status(ctx context.Context) error {
+	current, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	for _, mig := range m.migrations {
+		state := "pending"
+		if mig.Version <= current {
+			state = "applied"
+		}
+		fmt.Fprintf(m.out, "%-8s %d_%s\n", state, mig.Version, mig.Name)
+	}
+	fmt.Fprintf(m.out, "database version %d, binary version %d\n", current, len(m.migrations))
+	return nil
+}
+
+// checkSchemaVersion はデータベースのスキーマがこのバイナリの前提と一致しているか確認する
+func checkSchemaVersion(ctx context.Context, db *sqlx.DB) error {
+	var version int64
+	if err := db.GetContext(ctx, &version, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations"); err != nil {
+		return fmt.Errorf("failed to get schema version (run `isupipe migrate up`): %w", err)
+	}
+	if expected := expectedSchemaVersion(); version != expected {
+		return fmt.Errorf("database schema version is %d but this binary expects %d (run `isupipe migrate up`)", version, expected)
+	}
+	return nil
+}
+
+// runMigrateCommand は `isupipe migrate [up|down [n]|status]` を実行する
+func runMigrateCommand(ctx context.Context, db *sqlx.DB, args []string, out io.Writer) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	return withMigrator(ctx, db, out, func(m *migrator) error {
+		switch command {
+		case "up":
+			if len(args) > 1 {
+				return errors.New("usage: isupipe migrate up")
+			}
+			if err := m.Up(ctx); err != nil {
+				return err
+			}
+		case "down":
+			n := 1
+			if len(args) > 2 {
+				return errors.New("usage: isupipe migrate down [n]")
+			}
+			if len(args) == 2 {
+				v, err := strconv.Atoi(args[1])
+				if err != nil || v <= 0 {
+					return errors.New("usage: isupipe migrate down [n]")
+				}
+				n = v
+			}
+			if err := m.Down(ctx, n); err != nil {
+				return err
+			}
+		case "status":
+		default:
+			return fmt.Errorf("unknown migrate command %q (up, down or status)", command)
+		}
+		return m.Status(ctx)
+	})
+}
+
+// migrateMain は `isupipe migrate` サブコマンドのエントリポイント. 終了コードを返す
+func migrateMain(cfg DBConfig, args []string) int {
+	logger := echolog.New("migrate")
+	db, err := connectDB(cfg)
+	if err != nil {
+		logger.Errorf("failed to connect db: %v", err)
+		return 1
+	}
+	defer db.Close()
+
+	if err := runMigrateCommand(context.Background(), db, args, os.Stdout); err != nil {
+		logger.Errorf("failed to migrate: %v", err)
+		return 1
+	}
+	return 0
+}
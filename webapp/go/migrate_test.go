@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 埋め込んだマイグレーションは連番で、upとdownのどちらにも実行する文がある
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationFS, "migrations")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotEmpty(t, migrations)
+	assert.Equal(t, int64(len(migrations)), expectedSchemaVersion())
+	for _, m := range migrations {
+		assert.NotEmpty(t, splitSQLStatements(m.Up), "%d_%s up", m.Version, m.Name)
+		assert.NotEmpty(t, splitSQLStatements(m.Down), "%d_%s down", m.Version, m.Name)
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	file := func(s string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(s)} }
+
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		want    []migration
+		wantErr string
+	}{
+		{
+			name: "sorted by version",
+			fsys: fstest.MapFS{
+				"m/0002_b.up.sql":   file("up b"),
+				"m/0002_b.down.sql": file("down b"),
+				"m/0001_a.up.sql":   file("up a"),
+				"m/0001_a.down.sql": file("down a"),
+			},
+			want: []migration{
+				{Version: 1, Name: "a", Up: "up a", Down: "down a"},
+				{Version: 2, Name: "b", Up: "up b", Down: "down b"},
+			},
+		},
+		{
+			name: "missing down script",
+			fsys: fstest.MapFS{
+				"m/0001_a.up.sql": file("up a"),
+			},
+			wantErr: "migration 1_a must have both up and down scripts",
+		},
+		{
+			name: "gap in versions",
+			fsys: fstest.MapFS{
+				"m/0001_a.up.sql":   file("up a"),
+				"m/0001_a.down.sql": file("down a"),
+				"m/0003_c.up.sql":   file("up c"),
+				"m/0003_c.down.sql": file("down c"),
+			},
+			wantErr: "migration version 2 is missing",
+		},
+		{
+			name: "mismatched names",
+			fsys: fstest.MapFS{
+				"m/0001_a.up.sql":   file("up a"),
+				"m/0001_b.down.sql": file("down b"),
+			},
+			wantErr: `migration version 1 has different names "a" and "b"`,
+		},
+		{
+			name: "invalid file name",
+			fsys: fstest.MapFS{
+				"m/add_index.sql": file("up"),
+			},
+			wantErr: `invalid migration file name "add_index.sql"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadMigrations(tt.fsys, "m")
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+var createTablePattern = regexp.MustCompile("(?i)CREATE TABLE `(\\w+)`")
+
+func createdTables(src string) []string {
+	tables := []string{}
+	for _, m := range createTablePattern.FindAllStringSubmatch(src, -1) {
+		tables = append(tables, m[1])
+	}
+	return tables
+}
+
+// 10_schema.sqlは元のスキーマのままで、追加したテーブルはすべてマイグレーションで作る
+// 既存のデータベースにも同じテーブルができるよう、両方で同じテーブルを作ってはいけない
+func TestMigrationsStartFromSchemaFile(t *testing.T) {
+	src, err := os.ReadFile(schemaFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+	tables := map[string]string{}
+	for _, table := range createdTables(string(src)) {
+		tables[table] = "schema"
+	}
+
+	for _, m := range embeddedMigrations() {
+		name := fmt.Sprintf("%d_%s", m.Version, m.Name)
+		for _, table := range createdTables(m.Up) {
+			if by, ok := tables[table]; ok {
+				t.Errorf("%s creates table %s already created by %s", name, table, by)
+			}
+			tables[table] = name
+			assert.Contains(t, m.Down, "DROP TABLE `"+table+"`", "%s down must drop %s", name, table)
+		}
+	}
+
+	// 初期化で空にするテーブルは、マイグレーションをすべて適用すれば存在する
+	initSQL, err := os.ReadFile(filepath.Join(initialDataDir, "init.sql"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	for _, m := range regexp.MustCompile(`TRUNCATE TABLE (\w+)`).FindAllStringSubmatch(string(initSQL), -1) {
+		assert.Contains(t, tables, m[1], "init.sql truncates unknown table")
+	}
+}
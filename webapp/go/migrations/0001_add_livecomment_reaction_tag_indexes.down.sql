@@ -0,0 +1,11 @@
+ALTER TABLE `livestream_tags`
+  DROP INDEX `tag_id_livestream_id_idx`,
+  DROP INDEX `livestream_id_idx`;
+
+ALTER TABLE `reactions`
+  DROP INDEX `user_id_idx`,
+  DROP INDEX `livestream_id_created_at_idx`;
+
+ALTER TABLE `livecomments`
+  DROP INDEX `user_id_idx`,
+  DROP INDEX `livestream_id_created_at_idx`;
@@ -0,0 +1,14 @@
+-- 配信ごとのライブコメント・リアクションの一覧はcreated_at, idの降順でページングする
+-- InnoDBのセカンダリインデックスは主キーを含むので、idは指定しなくてよい
+ALTER TABLE `livecomments`
+  ADD INDEX `livestream_id_created_at_idx` (`livestream_id`, `created_at`),
+  ADD INDEX `user_id_idx` (`user_id`);
+
+ALTER TABLE `reactions`
+  ADD INDEX `livestream_id_created_at_idx` (`livestream_id`, `created_at`),
+  ADD INDEX `user_id_idx` (`user_id`);
+
+-- 配信のタグ取得とタグによる配信検索
+ALTER TABLE `livestream_tags`
+  ADD INDEX `livestream_id_idx` (`livestream_id`),
+  ADD INDEX `tag_id_livestream_id_idx` (`tag_id`, `livestream_id`);
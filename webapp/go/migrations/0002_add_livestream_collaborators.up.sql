@@ -0,0 +1,10 @@
+-- ライブ配信のコラボレーター
+CREATE TABLE `livestream_collaborators` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `livestream_id` BIGINT NOT NULL,
+  `user_id` BIGINT NOT NULL,
+  UNIQUE `uniq_livestream_collaborator` (`livestream_id`, `user_id`),
+  INDEX `idx_user_id` (`user_id`)
+) ENGINE = InnoDB CHARACTER
+SET
+  utf8mb4 COLLATE utf8mb4_bin;
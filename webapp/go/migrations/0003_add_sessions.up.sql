@@ -0,0 +1,10 @@
+-- ログインセッション
+CREATE TABLE `sessions` (
+  `id` VARCHAR(255) NOT NULL PRIMARY KEY,
+  `user_id` BIGINT NOT NULL,
+  `expires_at` BIGINT NOT NULL,
+  `created_at` BIGINT NOT NULL,
+  INDEX `idx_user_id` (`user_id`)
+) ENGINE = InnoDB CHARACTER
+SET
+  utf8mb4 COLLATE utf8mb4_bin;
@@ -0,0 +1,2 @@
+ALTER TABLE `ng_words`
+  DROP COLUMN `match_mode`;
@@ -0,0 +1,4 @@
+-- livestream_idが0のNGワードは配信者の全ての配信に適用する
+-- match_modeはsubstring, normalized, regexのいずれか
+ALTER TABLE `ng_words`
+  ADD COLUMN `match_mode` VARCHAR(16) NOT NULL DEFAULT 'substring' AFTER `word`;
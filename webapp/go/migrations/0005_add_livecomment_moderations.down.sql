@@ -0,0 +1,7 @@
+DROP TABLE `livecomment_moderations`;
+
+-- 論理削除していたライブコメントは、以前のバージョンと同じく物理削除する
+DELETE FROM `livecomments` WHERE `deleted_at` <> 0;
+
+ALTER TABLE `livecomments`
+  DROP COLUMN `deleted_at`;
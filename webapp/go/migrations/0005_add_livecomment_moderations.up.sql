@@ -0,0 +1,21 @@
+-- モデレーションにより論理削除した日時. 0の場合は削除されていない
+ALTER TABLE `livecomments`
+  ADD COLUMN `deleted_at` BIGINT NOT NULL DEFAULT 0;
+
+-- ライブコメントのモデレーション履歴
+CREATE TABLE `livecomment_moderations` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `livecomment_id` BIGINT NOT NULL,
+  `livestream_id` BIGINT NOT NULL,
+  -- ng_word, report, manual
+  `reason` VARCHAR(16) NOT NULL,
+  -- reasonがng_wordの場合にヒットしたNGワード. それ以外は0
+  `ng_word_id` BIGINT NOT NULL DEFAULT 0,
+  -- モデレーションを行ったユーザ
+  `user_id` BIGINT NOT NULL,
+  `created_at` BIGINT NOT NULL,
+  UNIQUE `uniq_livecomment_id` (`livecomment_id`),
+  INDEX `livestream_id_idx` (`livestream_id`)
+) ENGINE = InnoDB CHARACTER
+SET
+  utf8mb4 COLLATE utf8mb4_bin;
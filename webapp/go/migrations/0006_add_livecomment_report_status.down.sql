@@ -0,0 +1,3 @@
+ALTER TABLE `livecomment_reports`
+  DROP INDEX `livestream_id_livecomment_id_idx`,
+  DROP COLUMN `status`;
@@ -0,0 +1,4 @@
+-- open, dismissed, actioned
+ALTER TABLE `livecomment_reports`
+  ADD COLUMN `status` VARCHAR(16) NOT NULL DEFAULT 'open' AFTER `livecomment_id`,
+  ADD INDEX `livestream_id_livecomment_id_idx` (`livestream_id`, `livecomment_id`);
@@ -0,0 +1,9 @@
+-- レートリミットのトークンバケット (ISUCON13_RATE_LIMIT_STORE=mysql の場合のみ使う)
+CREATE TABLE `rate_limit_buckets` (
+  `key` VARCHAR(255) NOT NULL PRIMARY KEY,
+  `tokens` DOUBLE NOT NULL,
+  -- 最後にトークンを補充した時刻(UnixMilli)
+  `updated_at` BIGINT NOT NULL
+) ENGINE = InnoDB CHARACTER
+SET
+  utf8mb4 COLLATE utf8mb4_bin;
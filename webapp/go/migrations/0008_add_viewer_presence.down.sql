@@ -0,0 +1,5 @@
+ALTER TABLE `livestream_viewers_history`
+  DROP INDEX `user_id_livestream_id_idx`,
+  DROP INDEX `livestream_id_left_at_idx`,
+  DROP COLUMN `left_at`,
+  DROP COLUMN `last_seen_at`;
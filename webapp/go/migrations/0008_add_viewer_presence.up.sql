@@ -0,0 +1,9 @@
+-- last_seen_atは最後にハートビートを受け取った日時、left_atは退出した日時. left_atが0の場合は視聴中
+-- 既存の履歴は退出日時が分からないので、視聴開始と同時に退出したものとして扱う
+ALTER TABLE `livestream_viewers_history`
+  ADD COLUMN `last_seen_at` BIGINT NOT NULL DEFAULT 0,
+  ADD COLUMN `left_at` BIGINT NOT NULL DEFAULT 0,
+  ADD INDEX `livestream_id_left_at_idx` (`livestream_id`, `left_at`),
+  ADD INDEX `user_id_livestream_id_idx` (`user_id`, `livestream_id`);
+
+UPDATE `livestream_viewers_history` SET `last_seen_at` = `created_at`, `left_at` = `created_at`;
@@ -0,0 +1,11 @@
+-- ユーザ間のフォロー関係
+CREATE TABLE `follows` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `follower_id` BIGINT NOT NULL,
+  `followee_id` BIGINT NOT NULL,
+  `created_at` BIGINT NOT NULL,
+  UNIQUE `uniq_follower_id_followee_id` (`follower_id`, `followee_id`),
+  INDEX `followee_id_idx` (`followee_id`)
+) ENGINE = InnoDB CHARACTER
+SET
+  utf8mb4 COLLATE utf8mb4_bin;
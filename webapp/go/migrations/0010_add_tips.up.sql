@@ -0,0 +1,26 @@
+-- 投げ銭の台帳. チップ付きライブコメントと同じトランザクションで記録する
+-- 配信・ユーザを削除しても台帳は残し、削除したライブコメント・ユーザへの参照だけをNULLにする
+-- UNIQUEインデックスはNULLの重複を許すので、livecomment_idのUNIQUEはそのままでよい
+CREATE TABLE `tips` (
+  `id` BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  `livecomment_id` BIGINT NULL UNIQUE,
+  `livestream_id` BIGINT NOT NULL,
+  -- 投げ銭を受け取った配信者
+  `streamer_id` BIGINT NOT NULL,
+  -- 投げ銭をしたユーザ
+  `user_id` BIGINT NULL,
+  `amount` BIGINT NOT NULL,
+  `created_at` BIGINT NOT NULL,
+  INDEX `streamer_id_created_at_idx` (`streamer_id`, `created_at`),
+  INDEX `livestream_id_idx` (`livestream_id`)
+) ENGINE = InnoDB CHARACTER
+SET
+  utf8mb4 COLLATE utf8mb4_bin;
+
+-- 既存のチップ付きライブコメントを台帳に移す
+INSERT INTO `tips` (`livecomment_id`, `livestream_id`, `streamer_id`, `user_id`, `amount`, `created_at`)
+SELECT l.`id`, l.`livestream_id`, s.`user_id`, l.`user_id`, l.`tip`, l.`created_at`
+FROM `livecomments` l
+INNER JOIN `livestreams` s ON s.`id` = l.`livestream_id`
+WHERE l.`tip` > 0
+ORDER BY l.`id`;
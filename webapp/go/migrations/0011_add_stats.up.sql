@@ -0,0 +1,63 @@
+-- 配信ごとの統計情報. 書き込み時に同じトランザクションで更新する
+CREATE TABLE `livestream_stats` (
+  `livestream_id` BIGINT NOT NULL PRIMARY KEY,
+  -- 配信者
+  `user_id` BIGINT NOT NULL,
+  `reactions` BIGINT NOT NULL DEFAULT 0,
+  `livecomments` BIGINT NOT NULL DEFAULT 0,
+  `total_tip` BIGINT NOT NULL DEFAULT 0,
+  `max_tip` BIGINT NOT NULL DEFAULT 0,
+  `reports` BIGINT NOT NULL DEFAULT 0,
+  `score` BIGINT GENERATED ALWAYS AS (`reactions` + `total_tip`) STORED NOT NULL,
+  INDEX `score_livestream_id_idx` (`score`, `livestream_id`),
+  INDEX `user_id_idx` (`user_id`)
+) ENGINE = InnoDB CHARACTER
+SET
+  utf8mb4 COLLATE utf8mb4_bin;
+
+-- 配信者ごとの統計情報. 配信者の全配信の統計情報の合計
+CREATE TABLE `user_stats` (
+  `user_id` BIGINT NOT NULL PRIMARY KEY,
+  -- ランキングで同点の場合の順序に使う
+  `name` VARCHAR(255) NOT NULL,
+  `reactions` BIGINT NOT NULL DEFAULT 0,
+  `livecomments` BIGINT NOT NULL DEFAULT 0,
+  `total_tip` BIGINT NOT NULL DEFAULT 0,
+  `score` BIGINT GENERATED ALWAYS AS (`reactions` + `total_tip`) STORED NOT NULL,
+  INDEX `score_name_idx` (`score`, `name`)
+) ENGINE = InnoDB CHARACTER
+SET
+  utf8mb4 COLLATE utf8mb4_bin;
+
+-- 既存のデータから統計情報のカウンタを集計する (sql/init_stats.sqlと同じ)
+INSERT INTO livestream_stats (livestream_id, user_id)
+SELECT id, user_id FROM livestreams;
+
+INSERT INTO user_stats (user_id, name)
+SELECT id, name FROM users;
+
+UPDATE livestream_stats s
+LEFT JOIN (
+  SELECT livestream_id, COUNT(*) AS count FROM reactions GROUP BY livestream_id
+) r ON r.livestream_id = s.livestream_id
+LEFT JOIN (
+  SELECT livestream_id, COUNT(*) AS count, SUM(tip) AS total_tip, MAX(tip) AS max_tip FROM livecomments GROUP BY livestream_id
+) l ON l.livestream_id = s.livestream_id
+LEFT JOIN (
+  SELECT livestream_id, COUNT(*) AS count FROM livecomment_reports GROUP BY livestream_id
+) rp ON rp.livestream_id = s.livestream_id
+SET
+  s.reactions = IFNULL(r.count, 0),
+  s.livecomments = IFNULL(l.count, 0),
+  s.total_tip = IFNULL(l.total_tip, 0),
+  s.max_tip = IFNULL(l.max_tip, 0),
+  s.reports = IFNULL(rp.count, 0);
+
+UPDATE user_stats u
+INNER JOIN (
+  SELECT user_id, SUM(reactions) AS reactions, SUM(livecomments) AS livecomments, SUM(total_tip) AS total_tip FROM livestream_stats GROUP BY user_id
+) s ON s.user_id = u.user_id
+SET
+  u.reactions = s.reactions,
+  u.livecomments = s.livecomments,
+  u.total_tip = s.total_tip;
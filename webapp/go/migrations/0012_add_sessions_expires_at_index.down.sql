@@ -0,0 +1,2 @@
+ALTER TABLE `sessions`
+  DROP INDEX `expires_at_idx`;
@@ -0,0 +1,3 @@
+-- 期限切れのセッションを全ユーザ分まとめて掃除する
+ALTER TABLE `sessions`
+  ADD INDEX `expires_at_idx` (`expires_at`);
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"os"
+
+	"github.com/isucon/isucon13/webapp/go/cache"
+	"github.com/jmoiron/sqlx"
+)
+
+// ほとんど変更されない行をリクエストをまたいでキャッシュする
+// 書き込むハンドラは、書き込んだ直後にdeferで無効化を登録し、コミットした後に無効化する
+// キャッシュにはコミット済みの値だけを格納する. そのためキャッシュにない行はトランザクションの外で読み込む
+// (トランザクションのスナップショットは、開始後にコミットされて無効化済みの変更を含まないことがある)
+var (
+	// users.id -> users
+	userCache = cache.New[int64, UserModel]()
+	// users.id -> themes
+	themeCache = cache.New[int64, ThemeModel]()
+	// users.id -> アイコンのハッシュ. アイコン未登録の場合はフォールバック画像のハッシュ
+	iconHashCache = cache.New[int64, string]()
+	// tags.id -> tags
+	tagCache = cache.New[int64, TagModel]()
+	// livestreams.id -> livestreams
+	livestreamCache = cache.New[int64, LivestreamModel]()
+	// livestreams.id -> 配信に付けられたタグのID
+	livestreamTagIDsCache = cache.New[int64, []int64]()
+)
+
+func resetCaches() {
+	userCache.Reset()
+	themeCache.Reset()
+	iconHashCache.Reset()
+	tagCache.Reset()
+	livestreamCache.Reset()
+	livestreamTagIDsCache.Reset()
+}
+
+// invalidateUserCaches はユーザ・テーマ・アイコンのキャッシュを無効化する
+func invalidateUserCaches(userIDs ...int64) {
+	userCache.Delete(userIDs...)
+	themeCache.Delete(userIDs...)
+	iconHashCache.Delete(userIDs...)
+}
+
+// invalidateLivestreamCaches は配信とそのタグのキャッシュを無効化する
+func invalidateLivestreamCaches(livestreamIDs ...int64) {
+	livestreamCache.Delete(livestreamIDs...)
+	livestreamTagIDsCache.Delete(livestreamIDs...)
+}
+
+// modelReader はキャッシュ対象の行を読む. トランザクションごとに1つ作る
+type modelReader struct {
+	tx sqlx.QueryerContext
+	// キャッシュにない行を読み込む先. コミット済みの最新の値を読めるよう、トランザクションの外のコネクションを使う
+	db sqlx.QueryerContext
+	// キャッシュ対象のテーブルに書き込んだ後はtrueにする
+	// コミット前の値を読む必要があるので、以降はキャッシュを使わずにトランザクションで読み、キャッシュにも格納しない
+	wrote bool
+}
+
+func newModelReader(tx *sqlx.Tx) *modelReader {
+	return &modelReader{tx: tx, db: dbConn}
+}
+
+// markWritten はトランザクションでキャッシュ対象のテーブルに書き込んだことを記録する
+func (m *modelReader) markWritten() {
+	m.wrote = true
+}
+
+// loadThroughCache はキャッシュを通してkeysの行を読む. loadはqで行を読み込む
+func loadThroughCache[V any](m *modelReader, c *cache.Cache[int64, V], keys []int64, load func(q sqlx.QueryerContext, missing []int64) (map[int64]V, error)) (map[int64]V, error) {
+	if m.wrote {
+		if len(keys) == 0 {
+			return map[int64]V{}, nil
+		}
+		return load(m.tx, keys)
+	}
+	return c.GetMultiOrLoad(keys, func(missing []int64) (map[int64]V, error) {
+		return load(m.db, missing)
+	})
+}
+
+// userModel はユーザを取得する. 存在しない場合はsql.ErrNoRowsを返す
+func (m *modelReader) userModel(ctx context.Context, userID int64) (UserModel, error) {
+	users, err := m.userModels(ctx, []int64{userID})
+	if err != nil {
+		return UserModel{}, err
+	}
+	userModel, ok := users[userID]
+	if !ok {
+		return UserModel{}, sql.ErrNoRows
+	}
+	return userModel, nil
+}
+
+// userModels はユーザを取得する. 存在しないユーザはマップに含まれない
+func (m *modelReader) userModels(ctx context.Context, userIDs []int64) (map[int64]UserModel, error) {
+	return loadThroughCache(m, userCache, userIDs, func(q sqlx.QueryerContext, missing []int64) (map[int64]UserModel, error) {
+		var userModels []UserModel
+		query, params, err := sqlx.In("SELECT * FROM users WHERE id IN (?)", missing)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create users query: %w", err)
+		}
+		if err := sqlx.SelectContext(ctx, q, &userModels, query, params...); err != nil {
+			return nil, fmt.Errorf("failed to query users: %w", err)
+		}
+		users := make(map[int64]UserModel, len(userModels))
+		for _, u := range userModels {
+			users[u.ID] = u
+		}
+		return users, nil
+	})
+}
+
+// themeModels はユーザIDをキーにしてテーマを取得する
+func (m *modelReader) themeModels(ctx context.Context, userIDs []int64) (map[int64]ThemeModel, error) {
+	return loadThroughCache(m, themeCache, userIDs, func(q sqlx.QueryerContext, missing []int64) (map[int64]ThemeModel, error) {
+		var themeModels []ThemeModel
+		query, params, err := sqlx.In("SELECT * FROM themes WHERE user_id IN (?)", missing)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create themes query: %w", err)
+		}
+		if err := sqlx.SelectContext(ctx, q, &themeModels, query, params...); err != nil {
+			return nil, fmt.Errorf("failed to get themes: %w", err)
+		}
+		themes := make(map[int64]ThemeModel, len(themeModels))
+		for _, t := range themeModels {
+			themes[t.UserID] = t
+		}
+		return themes, nil
+	})
+}
+
+// iconHashes はユーザIDをキーにしてアイコンのハッシュを取得する
+// アイコンを登録していないユーザはフォールバック画像のハッシュになる
+func (m *modelReader) iconHashes(ctx context.Context, userIDs []int64) (map[int64]string, error) {
+	return loadThroughCache(m, iconHashCache, userIDs, func(q sqlx.QueryerContext, missing []int64) (map[int64]string, error) {
+		var icons []struct {
+			UserID int64  `db:"user_id"`
+			Hash   string `db:"hash"`
+		}
+		query, params, err := sqlx.In("SELECT user_id, hash FROM icons WHERE user_id IN (?)", missing)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create icons query: %w", err)
+		}
+		if err := sqlx.SelectContext(ctx, q, &icons, query, params...); err != nil {
+			return nil, fmt.Errorf("failed to get icons: %w", err)
+		}
+		hashes := make(map[int64]string, len(missing))
+		for _, icon := range icons {
+			hashes[icon.UserID] = icon.Hash
+		}
+		for _, userID := range missing {
+			if _, ok := hashes[userID]; !ok {
+				hashes[userID] = fallbackImageHash
+			}
+		}
+		return hashes, nil
+	})
+}
+
+// loadFallbackImageHash はフォールバック画像を読んでfallbackImageHashを設定する
+// 画像はリクエストごとに変わらないので、起動時に1度だけ計算する
+func loadFallbackImageHash() error {
+	image, err := os.ReadFile(fallbackImage)
+	if err != nil {
+		return fmt.Errorf("failed to read fallback image: %w", err)
+	}
+	fallbackImageHash = fmt.Sprintf("%x", sha256.Sum256(image))
+	return nil
+}
+
+// livestreamModel は配信を取得する. 存在しない場合はsql.ErrNoRowsを返す
+func (m *modelReader) livestreamModel(ctx context.Context, livestreamID int64) (LivestreamModel, error) {
+	livestreams, err := m.livestreamModels(ctx, []int64{livestreamID})
+	if err != nil {
+		return LivestreamModel{}, err
+	}
+	livestreamModel, ok := livestreams[livestreamID]
+	if !ok {
+		return LivestreamModel{}, sql.ErrNoRows
+	}
+	return livestreamModel, nil
+}
+
+// livestreamModels は配信を取得する. 存在しない配信はマップに含まれない
+func (m *modelReader) livestreamModels(ctx context.Context, livestreamIDs []int64) (map[int64]LivestreamModel, error) {
+	return loadThroughCache(m, livestreamCache, livestreamIDs, func(q sqlx.QueryerContext, missing []int64) (map[int64]LivestreamModel, error) {
+		var livestreamModels []LivestreamModel
+		query, params, err := sqlx.In("SELECT * FROM livestreams WHERE id IN (?)", missing)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create livestreams query: %w", err)
+		}
+		if err := sqlx.SelectContext(ctx, q, &livestreamModels, query, params...); err != nil {
+			return nil, fmt.Errorf("failed to query livestreams: %w", err)
+		}
+		livestreams := make(map[int64]LivestreamModel, len(livestreamModels))
+		for _, l := range livestreamModels {
+			livestreams[l.ID] = l
+		}
+		return livestreams, nil
+	})
+}
+
+// livestreamTags は配信IDをキーにして配信のタグを取得する. タグのない配信は空のスライスになる
+func (m *modelReader) livestreamTags(ctx context.Context, livestreamIDs []int64) (map[int64][]Tag, error) {
+	tagIDs, err := loadThroughCache(m, livestreamTagIDsCache, livestreamIDs, func(q sqlx.QueryerContext, missing []int64) (map[int64][]int64, error) {
+		var livestreamTagModels []LivestreamTagModel
+		query, params, err := sqlx.In("SELECT * FROM livestream_tags WHERE livestream_id IN (?) ORDER BY id", missing)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create livestream_tags query: %w", err)
+		}
+		if err := sqlx.SelectContext(ctx, q, &livestreamTagModels, query, params...); err != nil {
+			return nil, fmt.Errorf("failed to query livestream_tags: %w", err)
+		}
+		tagIDs := make(map[int64][]int64, len(missing))
+		for _, livestreamID := range missing {
+			tagIDs[livestreamID] = []int64{}
+		}
+		for _, lt := range livestreamTagModels {
+			tagIDs[lt.LivestreamID] = append(tagIDs[lt.LivestreamID], lt.TagID)
+		}
+		return tagIDs, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var allTagIDs []int64
+	for _, ids := range tagIDs {
+		allTagIDs = append(allTagIDs, ids...)
+	}
+	tagModels := map[int64]TagModel{}
+	if len(allTagIDs) > 0 {
+		tagModels, err = loadThroughCache(m, tagCache, allTagIDs, func(q sqlx.QueryerContext, missing []int64) (map[int64]TagModel, error) {
+			var models []TagModel
+			query, params, err := sqlx.In("SELECT * FROM tags WHERE id IN (?)", missing)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create tags query: %w", err)
+			}
+			if err := sqlx.SelectContext(ctx, q, &models, query, params...); err != nil {
+				return nil, fmt.Errorf("failed to query tags: %w", err)
+			}
+			tags := make(map[int64]TagModel, len(models))
+			for _, t := range models {
+				tags[t.ID] = t
+			}
+			return tags, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	livestreamTags := make(map[int64][]Tag, len(livestreamIDs))
+	for livestreamID, ids := range tagIDs {
+		tags := make([]Tag, 0, len(ids))
+		for _, id := range ids {
+			tagModel, ok := tagModels[id]
+			if !ok {
+				continue
+			}
+			tags = append(tags, Tag{
+				ID:   tagModel.ID,
+				Name: tagModel.Name,
+			})
+		}
+		livestreamTags[livestreamID] = tags
+	}
+	return livestreamTags, nil
+}
+
+func userModelsOf(m map[int64]UserModel) []UserModel {
+	userModels := make([]UserModel, 0, len(m))
+	for _, u := range m {
+		userModels = append(userModels, u)
+	}
+	return userModels
+}
+
+func livestreamModelsOf(m map[int64]LivestreamModel) []*LivestreamModel {
+	livestreamModels := make([]*LivestreamModel, 0, len(m))
+	for _, l := range m {
+		l := l
+		livestreamModels = append(livestreamModels, &l)
+	}
+	return livestreamModels
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/isucon/isucon13/webapp/go/cache"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadThroughCache(t *testing.T) {
+	// どちらのコネクションで読み込んだかだけを見るので、中身は使わない
+	tx, db := &sqlx.Tx{}, &sqlx.DB{}
+	loadFrom := func(values map[int64]string, used *[]sqlx.QueryerContext) func(sqlx.QueryerContext, []int64) (map[int64]string, error) {
+		return func(q sqlx.QueryerContext, missing []int64) (map[int64]string, error) {
+			*used = append(*used, q)
+			loaded := map[int64]string{}
+			for _, key := range missing {
+				if v, ok := values[key]; ok {
+					loaded[key] = v
+				}
+			}
+			return loaded, nil
+		}
+	}
+
+	t.Run("read only transaction loads committed rows outside the transaction", func(t *testing.T) {
+		c := cache.New[int64, string]()
+		m := &modelReader{tx: tx, db: db}
+		var used []sqlx.QueryerContext
+
+		got, err := loadThroughCache(m, c, []int64{1, 2}, loadFrom(map[int64]string{1: "committed"}, &used))
+		assert.NoError(t, err)
+		assert.Equal(t, map[int64]string{1: "committed"}, got)
+		assert.Equal(t, []sqlx.QueryerContext{db}, used)
+
+		// 2回目はキャッシュから返し、存在しなかったキーだけを読み込む
+		got, err = loadThroughCache(m, c, []int64{1, 2}, loadFrom(map[int64]string{}, &used))
+		assert.NoError(t, err)
+		assert.Equal(t, map[int64]string{1: "committed"}, got)
+		assert.Equal(t, []sqlx.QueryerContext{db, db}, used)
+	})
+
+	t.Run("written transaction bypasses the cache", func(t *testing.T) {
+		c := cache.New[int64, string]()
+		c.Set(1, "committed")
+		m := &modelReader{tx: tx, db: db}
+		m.markWritten()
+		var used []sqlx.QueryerContext
+
+		got, err := loadThroughCache(m, c, []int64{1, 2}, loadFrom(map[int64]string{1: "uncommitted", 2: "inserted"}, &used))
+		assert.NoError(t, err)
+		assert.Equal(t, map[int64]string{1: "uncommitted", 2: "inserted"}, got)
+		assert.Equal(t, []sqlx.QueryerContext{tx}, used)
+
+		// コミット前の値はキャッシュに格納しない
+		v, _ := c.Get(1)
+		assert.Equal(t, "committed", v)
+		_, ok := c.Get(2)
+		assert.False(t, ok)
+
+		got, err = loadThroughCache(m, c, nil, loadFrom(nil, &used))
+		assert.NoError(t, err)
+		assert.Empty(t, got)
+		assert.Len(t, used, 1)
+	})
+}
+
+func TestLoadFallbackImageHash(t *testing.T) {
+	prevFallbackImage, prevFallbackImageHash := fallbackImage, fallbackImageHash
+	t.Cleanup(func() { fallbackImage, fallbackImageHash = prevFallbackImage, prevFallbackImageHash })
+
+	fallbackImage = filepath.Join(t.TempDir(), "NoImage.jpg")
+	assert.Error(t, loadFallbackImageHash())
+
+	assert.NoError(t, os.WriteFile(fallbackImage, []byte("fallback image"), 0644))
+	assert.NoError(t, loadFallbackImageHash())
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte("fallback image"))), fallbackImageHash)
+}
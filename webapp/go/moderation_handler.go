@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	moderationReasonNGWord = "ng_word"
+	moderationReasonReport = "report"
+	moderationReasonManual = "manual"
+)
+
+type LivecommentModerationModel struct {
+	ID            int64  `db:"id"`
+	LivecommentID int64  `db:"livecomment_id"`
+	LivestreamID  int64  `db:"livestream_id"`
+	Reason        string `db:"reason"`
+	NGWordID      int64  `db:"ng_word_id"`
+	UserID        int64  `db:"user_id"`
+	CreatedAt     int64  `db:"created_at"`
+}
+
+type LivecommentModeration struct {
+	ID          int64       `json:"id"`
+	Livecomment Livecomment `json:"livecomment"`
+	Reason      string      `json:"reason"`
+	// reasonがng_wordの場合のみ
+	NGWordID  int64 `json:"ng_word_id,omitempty"`
+	Moderator User  `json:"moderator"`
+	CreatedAt int64 `json:"created_at"`
+}
+
+// softDeleteLivecomments はライブコメントを論理削除し、モデレーション履歴を残す
+// 削除済みのライブコメントは無視する. 呼び出し側で行ロックを取っておくこと
+func softDeleteLivecomments(ctx context.Context, tx *sqlx.Tx, livecommentModels []LivecommentModel, reason string, ngWordID int64, moderatorID int64) error {
+	if len(livecommentModels) == 0 {
+		return nil
+	}
+
+	now := time.Now().Unix()
+	ids := make([]int64, len(livecommentModels))
+	moderations := make([]LivecommentModerationModel, len(livecommentModels))
+	for i, l := range livecommentModels {
+		ids[i] = l.ID
+		moderations[i] = LivecommentModerationModel{
+			LivecommentID: l.ID,
+			LivestreamID:  l.LivestreamID,
+			Reason:        reason,
+			NGWordID:      ngWordID,
+			UserID:        moderatorID,
+			CreatedAt:     now,
+		}
+	}
+
+	query, params, err := sqlx.In("UPDATE livecomments SET deleted_at = ? WHERE id IN (?) AND deleted_at = 0", now, ids)
+	if err != nil {
+		return fmt.Errorf("failed to construct IN query: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, query, params...); err != nil {
+		return fmt.Errorf("failed to soft-delete livecomments: %w", err)
+	}
+	if _, err := tx.NamedExecContext(ctx, "INSERT IGNORE INTO livecomment_moderations (livecomment_id, livestream_id, reason, ng_word_id, user_id, created_at) VALUES (:livecomment_id, :livestream_id, :reason, :ng_word_id, :user_id, :created_at)", moderations); err != nil {
+		return fmt.Errorf("failed to insert livecomment_moderations: %w", err)
+	}
+	return nil
+}
+
+// ライブコメントの手動削除API
+// DELETE /api/livestream/:livestream_id/livecomment/:livecomment_id
+func deleteLivecommentHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+	livecommentID, err := strconv.Atoi(c.Param("livecomment_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livecomment_id in path must be integer")
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	var livecommentModel LivecommentModel
+	err = repositoryStore.Tx(ctx, func(r Repository) error {
+		if _, err := getModeratedLivestream(ctx, r, int64(livestreamID), userID); err != nil {
+			return err
+		}
+
+		var err error
+		livecommentModel, err = r.Livecomments().FindInLivestreamForUpdate(ctx, int64(livestreamID), int64(livecommentID))
+		if errors.Is(err, ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "livecomment not found")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment: "+err.Error())
+		}
+
+		if err := r.Moderations().SoftDelete(ctx, []LivecommentModel{livecommentModel}, moderationReasonManual, 0, userID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete livecomment: "+err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := eventBroker.Publish(livecommentModel.LivestreamID, livestreamEventLivecommentDeleted, LivecommentDeletedEvent{LivecommentID: livecommentModel.ID}); err != nil {
+		c.Logger().Warnf("failed to publish livecomment_deleted event: %+v", err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// モデレーション履歴の取得API
+// GET /api/livestream/:livestream_id/moderations
+func getLivecommentModerationsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	livestreamID, err := strconv.Atoi(c.Param("livestream_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
+	}
+
+	page, err := parsePageRequest(c)
+	if err != nil {
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	var (
+		moderations []LivecommentModeration
+		nextCursor  string
+	)
+	err = repositoryStore.Tx(ctx, func(r Repository) error {
+		if _, err := getModeratedLivestream(ctx, r, int64(livestreamID), userID); err != nil {
+			return err
+		}
+
+		moderationModels, err := r.Moderations().List(ctx, int64(livestreamID), page)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livecomment_moderations: "+err.Error())
+		}
+
+		moderationModels, nextCursor = paginate(page, moderationModels, func(m LivecommentModerationModel) (int64, int64) {
+			return 0, m.ID
+		})
+
+		moderations, err = fillLivecommentModerationsResponse(ctx, r, moderationModels)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill livecomment_moderations: "+err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return writePage(c, page, moderations, nextCursor)
+}
+
+// getModeratedLivestream は配信者かコラボレーターがモデレーションできる配信を返す
+// 返すエラーはecho.NewHTTPErrorなのでそのまま出力できる
+func getModeratedLivestream(ctx context.Context, r Repository, livestreamID int64, userID int64) (*LivestreamModel, error) {
+	livestreamModel, err := r.Livestreams().FindByID(ctx, livestreamID)
+	if errors.Is(err, ErrRecordNotFound) {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "livestream not found")
+	}
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestream: "+err.Error())
+	}
+	isModerator, err := r.Livestreams().IsModerator(ctx, livestreamModel, userID)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to check livestream collaborators: "+err.Error())
+	}
+	if !isModerator {
+		return nil, echo.NewHTTPError(http.StatusForbidden, "A streamer can't moderate livestreams that other streamers own")
+	}
+	return &livestreamModel, nil
+}
+
+func fillLivecommentModerationsResponse(ctx context.Context, r Repository, moderationModels []LivecommentModerationModel) ([]LivecommentModeration, error) {
+	moderations := make([]LivecommentModeration, 0, len(moderationModels))
+	if len(moderationModels) == 0 {
+		return moderations, nil
+	}
+
+	livecommentIDs := make([]int64, 0, len(moderationModels))
+	moderatorIDs := make([]int64, 0, len(moderationModels))
+	for _, m := range moderationModels {
+		livecommentIDs = append(livecommentIDs, m.LivecommentID)
+		moderatorIDs = append(moderatorIDs, m.UserID)
+	}
+
+	livecommentMap, err := fillLivecommentsResponseByID(ctx, r, livecommentIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	moderators, err := fillUsersResponseByID(ctx, r, moderatorIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fill users response: %w", err)
+	}
+
+	for _, m := range moderationModels {
+		livecomment, ok := livecommentMap[m.LivecommentID]
+		if !ok {
+			return nil, fmt.Errorf("livecomment %d is not found", m.LivecommentID)
+		}
+		moderator, ok := moderators[m.UserID]
+		if !ok {
+			return nil, fmt.Errorf("moderator %d is not found", m.UserID)
+		}
+		moderations = append(moderations, LivecommentModeration{
+			ID:          m.ID,
+			Livecomment: livecomment,
+			Reason:      m.Reason,
+			NGWordID:    m.NGWordID,
+			Moderator:   *moderator,
+			CreatedAt:   m.CreatedAt,
+		})
+	}
+	return moderations, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeleteLivecommentHandler(t *testing.T) {
+	tests := []struct {
+		name          string
+		livecommentID string
+		userID        int64
+		wantStatus    int
+	}{
+		{
+			name:          "streamer",
+			livecommentID: "1",
+			userID:        testStreamerID,
+			wantStatus:    http.StatusNoContent,
+		},
+		{
+			name:          "collaborator",
+			livecommentID: "1",
+			userID:        testCollaboratorID,
+			wantStatus:    http.StatusNoContent,
+		},
+		{
+			name:          "viewer",
+			livecommentID: "1",
+			userID:        testViewerID,
+			wantStatus:    http.StatusForbidden,
+		},
+		{
+			name:          "livecomment of other livestream",
+			livecommentID: "5",
+			userID:        testStreamerID,
+			wantStatus:    http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			rec := serveTestRequest(t, http.MethodDelete, "/api/livestream/:livestream_id/livecomment/:livecomment_id", "/api/livestream/1/livecomment/"+tt.livecommentID, tt.userID, deleteLivecommentHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusNoContent {
+				assert.Empty(t, s.data.moderations)
+				return
+			}
+			assert.NotZero(t, s.data.livecomments[1].DeletedAt)
+			if assert.Len(t, s.data.moderations, 1) {
+				m := s.data.moderations[1]
+				assert.Equal(t, int64(1), m.LivecommentID)
+				assert.Equal(t, moderationReasonManual, m.Reason)
+				assert.Equal(t, tt.userID, m.UserID)
+			}
+		})
+	}
+}
+
+func TestGetLivecommentModerationsHandler(t *testing.T) {
+	s := newTestRepositoryStore(t)
+	s.data.moderations[1] = LivecommentModerationModel{ID: 1, LivecommentID: 3, LivestreamID: testLivestreamID, Reason: moderationReasonNGWord, NGWordID: 1, UserID: testStreamerID, CreatedAt: 350}
+	s.data.moderations[2] = LivecommentModerationModel{ID: 2, LivecommentID: 5, LivestreamID: testOtherLivestreamID, Reason: moderationReasonManual, UserID: testStreamerID, CreatedAt: 550}
+
+	rec := serveTestRequest(t, http.MethodGet, "/api/livestream/:livestream_id/moderations", "/api/livestream/1/moderations", testCollaboratorID, getLivecommentModerationsHandler)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	moderations := decodeResponse[[]LivecommentModeration](t, rec)
+	if assert.Len(t, moderations, 1) {
+		// 論理削除したライブコメントも履歴には含める
+		assert.Equal(t, int64(3), moderations[0].Livecomment.ID)
+		assert.Equal(t, int64(1), moderations[0].NGWordID)
+		assert.Equal(t, "streamer", moderations[0].Moderator.Name)
+	}
+
+	rec = serveTestRequest(t, http.MethodGet, "/api/livestream/:livestream_id/moderations", "/api/livestream/1/moderations", testViewerID, getLivecommentModerationsHandler)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// 小文字にした部分文字列で照合する. 既定のモード
+	ngWordMatchSubstring = "substring"
+	// 全角半角・ひらがなカタカナ・大文字小文字の違いを無視して照合する
+	ngWordMatchNormalized = "normalized"
+	// 正規表現で照合する
+	ngWordMatchRegex = "regex"
+
+	ngWordScopeLivestream = "livestream"
+	// 配信者の全ての配信に適用する. livestream_id = 0 として保存する
+	ngWordScopeChannel = "channel"
+
+	channelNGWordLivestreamID = 0
+)
+
+// ngWordMatcher は登録済みのNGワードをコメントと照合する
+type ngWordMatcher struct {
+	word string
+	mode string
+	re   *regexp.Regexp
+}
+
+func newNGWordMatcher(word string, mode string) (*ngWordMatcher, error) {
+	m := &ngWordMatcher{mode: mode}
+	switch mode {
+	case "", ngWordMatchSubstring:
+		m.mode = ngWordMatchSubstring
+		m.word = strings.ToLower(word)
+	case ngWordMatchNormalized:
+		m.word = normalizeNGWordText(word)
+	case ngWordMatchRegex:
+		re, err := regexp.Compile(word)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %w", err)
+		}
+		m.re = re
+	default:
+		return nil, fmt.Errorf("unknown match mode %q", mode)
+	}
+	return m, nil
+}
+
+// Match はコメントがNGワードにヒットするかを返す
+func (m *ngWordMatcher) Match(comment string) bool {
+	switch m.mode {
+	case ngWordMatchNormalized:
+		return m.word != "" && strings.Contains(normalizeNGWordText(comment), m.word)
+	case ngWordMatchRegex:
+		return m.re.MatchString(comment)
+	default:
+		return strings.Contains(strings.ToLower(comment), m.word)
+	}
+}
+
+// normalizeNGWordText は表記揺れを吸収するため、
+// NFKCで全角英数字と半角カナを揃え、小文字化し、カタカナをひらがなに寄せる
+func normalizeNGWordText(s string) string {
+	s = strings.ToLower(norm.NFKC.String(s))
+	return strings.Map(func(r rune) rune {
+		// ァ(U+30A1)からヶ(U+30F6)までは、0x60引くと対応するひらがなになる
+		if r >= 'ァ' && r <= 'ヶ' {
+			return r - 0x60
+		}
+		return r
+	}, s)
+}
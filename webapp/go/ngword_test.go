@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNGWordMatcher(t *testing.T) {
+	tests := []struct {
+		name    string
+		word    string
+		mode    string
+		comment string
+		want    bool
+	}{
+		{name: "substring ignores case", word: "Spam", mode: "", comment: "this is SPAM", want: true},
+		{name: "substring does not normalize width", word: "spam", mode: ngWordMatchSubstring, comment: "ＳＰＡＭ", want: false},
+		{name: "normalized full-width alphabet", word: "spam", mode: ngWordMatchNormalized, comment: "ＳＰＡＭです", want: true},
+		{name: "normalized half-width kana", word: "スパム", mode: ngWordMatchNormalized, comment: "ｽﾊﾟﾑ", want: true},
+		{name: "normalized hiragana and katakana", word: "すぱむ", mode: ngWordMatchNormalized, comment: "スパムだよ", want: true},
+		{name: "normalized no hit", word: "spam", mode: ngWordMatchNormalized, comment: "ham", want: false},
+		{name: "regex", word: `s+p+a+m+`, mode: ngWordMatchRegex, comment: "ssspaaam", want: true},
+		{name: "regex no hit", word: `^spam$`, mode: ngWordMatchRegex, comment: "spam!", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newNGWordMatcher(tt.word, tt.mode)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, m.Match(tt.comment))
+		})
+	}
+}
+
+func TestNewNGWordMatcherError(t *testing.T) {
+	_, err := newNGWordMatcher("(", ngWordMatchRegex)
+	assert.Error(t, err)
+
+	_, err = newNGWordMatcher("spam", "fuzzy")
+	assert.Error(t, err)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// 次ページのカーソルを返すレスポンスヘッダ
+// ページングしないリクエストへのレスポンスボディは配列のままなので、ヘッダでも返す
+const nextCursorHeader = "X-Next-Cursor"
+
+// pageCursor はキーセットページングの位置を表す
+// SortKeyはcreated_atなどの並び替えキーで、IDのみで並べる一覧では使わない
+type pageCursor struct {
+	// Afterがtrueの場合はより新しい方向へ、falseの場合はより古い方向へ辿る
+	After   bool  `json:"a,omitempty"`
+	SortKey int64 `json:"k,omitempty"`
+	ID      int64 `json:"i"`
+}
+
+func (p *pageCursor) Encode() string {
+	b, _ := json.Marshal(p)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodePageCursor(s string) (*pageCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var p pageCursor
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	if p.ID <= 0 {
+		return nil, fmt.Errorf("invalid cursor id: %d", p.ID)
+	}
+	return &p, nil
+}
+
+// pageRequest はlimit, before_id, after_id, cursorクエリパラメータを表す
+type pageRequest struct {
+	// 0の場合は件数を制限しない
+	Limit  int
+	Cursor *pageCursor
+	// before_id/after_idで指定された場合、SortKeyは呼び出し側で解決する必要がある
+	NeedsSortKey bool
+	// before_id, after_id, cursorのいずれかを指定した場合はtrueで、レスポンスをpageResponseで包む
+	// 最初のページはcursorを空文字列で指定する
+	Paged bool
+}
+
+// pageResponse はページングしたリクエストへのレスポンス
+// 次ページがない場合、NextCursorは空文字列になる
+type pageResponse[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor"`
+}
+
+func parsePageRequest(c echo.Context) (*pageRequest, error) {
+	r := &pageRequest{}
+
+	if v := c.QueryParam("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "limit query parameter must be non-negative integer")
+		}
+		r.Limit = limit
+	}
+
+	var (
+		beforeID = c.QueryParam("before_id")
+		afterID  = c.QueryParam("after_id")
+		cursor   = c.QueryParam("cursor")
+	)
+	_, hasCursor := c.QueryParams()["cursor"]
+	specified := 0
+	for _, v := range []bool{beforeID != "", afterID != "", hasCursor} {
+		if v {
+			specified++
+		}
+	}
+	if specified > 1 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "only one of before_id, after_id and cursor can be specified")
+	}
+	r.Paged = specified > 0
+
+	switch {
+	case cursor != "":
+		p, err := decodePageCursor(cursor)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "cursor query parameter is invalid")
+		}
+		r.Cursor = p
+	case beforeID != "":
+		id, err := strconv.ParseInt(beforeID, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "before_id query parameter must be positive integer")
+		}
+		r.Cursor = &pageCursor{ID: id}
+		r.NeedsSortKey = true
+	case afterID != "":
+		id, err := strconv.ParseInt(afterID, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "after_id query parameter must be positive integer")
+		}
+		r.Cursor = &pageCursor{After: true, ID: id}
+		r.NeedsSortKey = true
+	}
+
+	return r, nil
+}
+
+// keysetClause はWHERE句に追加する条件とORDER BY句、LIMIT句を返す
+// sortColumnが空の場合はidのみで並べる
+func (r *pageRequest) keysetClause(sortColumn string) (string, []interface{}, string) {
+	var (
+		cond  string
+		args  []interface{}
+		order string
+	)
+
+	after := r.Cursor != nil && r.Cursor.After
+	if sortColumn == "" {
+		order = " ORDER BY id DESC"
+		if after {
+			order = " ORDER BY id ASC"
+		}
+	} else {
+		order = fmt.Sprintf(" ORDER BY %s DESC, id DESC", sortColumn)
+		if after {
+			order = fmt.Sprintf(" ORDER BY %s ASC, id ASC", sortColumn)
+		}
+	}
+
+	if r.Cursor != nil {
+		op := "<"
+		if after {
+			op = ">"
+		}
+		if sortColumn == "" {
+			cond = fmt.Sprintf(" AND id %s ?", op)
+			args = append(args, r.Cursor.ID)
+		} else {
+			cond = fmt.Sprintf(" AND (%s %s ? OR (%s = ? AND id %s ?))", sortColumn, op, sortColumn, op)
+			args = append(args, r.Cursor.SortKey, r.Cursor.SortKey, r.Cursor.ID)
+		}
+	}
+
+	// 次ページの有無を判定するため1件多く取得する
+	if r.Limit > 0 {
+		order += " LIMIT ?"
+		args = append(args, r.Limit+1)
+	}
+
+	return cond, args, order
+}
+
+// paginate はkeysetClauseで取得した行を新しい順に並べ直し、次ページのカーソルを返す
+// 次ページがない場合、カーソルは空文字列になる
+func paginate[T any](r *pageRequest, rows []T, key func(T) (sortKey int64, id int64)) ([]T, string) {
+	after := r.Cursor != nil && r.Cursor.After
+
+	hasMore := r.Limit > 0 && len(rows) > r.Limit
+	if hasMore {
+		rows = rows[:r.Limit]
+	}
+
+	if after {
+		// 古い順に取得しているので、新しい順に並べ直す
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	if !hasMore || len(rows) == 0 {
+		return rows, ""
+	}
+
+	var edge T
+	if after {
+		edge = rows[0]
+	} else {
+		edge = rows[len(rows)-1]
+	}
+	sortKey, id := key(edge)
+	next := &pageCursor{
+		After:   after,
+		SortKey: sortKey,
+		ID:      id,
+	}
+	return rows, next.Encode()
+}
+
+// writePage はrowsをレスポンスとして返す
+// ページングしたリクエストにはpageResponseで次ページのカーソルと合わせて返し、
+// そうでなければ従来どおり配列で返す. どちらの場合も次ページのカーソルはヘッダでも返す
+func writePage[T any](c echo.Context, r *pageRequest, rows []T, nextCursor string) error {
+	if nextCursor != "" {
+		c.Response().Header().Set(nextCursorHeader, nextCursor)
+	}
+	if r.Paged {
+		return c.JSON(http.StatusOK, pageResponse[T]{
+			Items:      rows,
+			NextCursor: nextCursor,
+		})
+	}
+	return c.JSON(http.StatusOK, rows)
+}
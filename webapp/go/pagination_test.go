@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// decodeListResponse はページングしたレスポンスならpageResponseを、そうでなければ配列を読み込み、
+// 次ページのカーソルとあわせて返す
+func decodeListResponse[T any](t *testing.T, rec *httptest.ResponseRecorder, paged bool) ([]T, string) {
+	t.Helper()
+
+	if !paged {
+		return decodeResponse[[]T](t, rec), rec.Header().Get(nextCursorHeader)
+	}
+	page := decodeResponse[pageResponse[T]](t, rec)
+	assert.NotNil(t, page.Items)
+	assert.Equal(t, rec.Header().Get(nextCursorHeader), page.NextCursor)
+	return page.Items, page.NextCursor
+}
+
+func TestParsePageRequest(t *testing.T) {
+	cursor := (&pageCursor{After: true, SortKey: 10, ID: 3}).Encode()
+	tests := []struct {
+		name       string
+		query      string
+		want       *pageRequest
+		wantStatus int
+	}{
+		{name: "no paging", query: "", want: &pageRequest{}},
+		{name: "limit only", query: "limit=10", want: &pageRequest{Limit: 10}},
+		{name: "first page", query: "limit=10&cursor=", want: &pageRequest{Limit: 10, Paged: true}},
+		{name: "cursor", query: "cursor=" + cursor, want: &pageRequest{Cursor: &pageCursor{After: true, SortKey: 10, ID: 3}, Paged: true}},
+		{name: "before_id", query: "before_id=5", want: &pageRequest{Cursor: &pageCursor{ID: 5}, NeedsSortKey: true, Paged: true}},
+		{name: "after_id", query: "after_id=5", want: &pageRequest{Cursor: &pageCursor{After: true, ID: 5}, NeedsSortKey: true, Paged: true}},
+		{name: "invalid limit", query: "limit=abc", wantStatus: http.StatusBadRequest},
+		{name: "invalid cursor", query: "cursor=!!!", wantStatus: http.StatusBadRequest},
+		{name: "invalid before_id", query: "before_id=0", wantStatus: http.StatusBadRequest},
+		{name: "before_id and after_id", query: "before_id=5&after_id=3", wantStatus: http.StatusBadRequest},
+		{name: "empty cursor and before_id", query: "cursor=&before_id=5", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil)
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+
+			got, err := parsePageRequest(c)
+			if tt.wantStatus != 0 {
+				var he *echo.HTTPError
+				if assert.ErrorAs(t, err, &he) {
+					assert.Equal(t, tt.wantStatus, he.Code)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestWritePage(t *testing.T) {
+	tests := []struct {
+		name       string
+		paged      bool
+		rows       []int64
+		nextCursor string
+		wantBody   string
+		wantHeader string
+	}{
+		{name: "array", rows: []int64{3, 2}, nextCursor: "next", wantBody: `[3,2]`, wantHeader: "next"},
+		{name: "array without next page", rows: []int64{3, 2}, wantBody: `[3,2]`},
+		{name: "page", paged: true, rows: []int64{3, 2}, nextCursor: "next", wantBody: `{"items":[3,2],"next_cursor":"next"}`, wantHeader: "next"},
+		{name: "last page", paged: true, rows: []int64{1}, wantBody: `{"items":[1],"next_cursor":""}`},
+		{name: "empty page", paged: true, rows: []int64{}, wantBody: `{"items":[],"next_cursor":""}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+
+			assert.NoError(t, writePage(c, &pageRequest{Paged: tt.paged}, tt.rows, tt.nextCursor))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.JSONEq(t, tt.wantBody, rec.Body.String())
+			assert.Equal(t, tt.wantHeader, rec.Header().Get(nextCursorHeader))
+		})
+	}
+}
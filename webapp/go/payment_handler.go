@@ -13,19 +13,19 @@ type PaymentResult struct {
 func GetPaymentResult(c echo.Context) error {
 	ctx := c.Request().Context()
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
-	}
-	defer tx.Rollback()
-
+	// 投げ銭は払い戻さないため、モデレーションや削除で消えたライブコメントのチップも計上する
+	// 台帳の行はどちらでも削除しないので、台帳の合計をそのまま返せばよい
 	var totalTip int64
-	if err := tx.GetContext(ctx, &totalTip, "SELECT IFNULL(SUM(tip), 0) FROM livecomments"); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to count total tip: "+err.Error())
-	}
-
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	err := repositoryStore.Tx(ctx, func(r Repository) error {
+		total, err := r.Tips().Total(ctx)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to count total tip: "+err.Error())
+		}
+		totalTip = total
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return c.JSON(http.StatusOK, &PaymentResult{
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	rateLimitStoreEnvKey = "ISUCON13_RATE_LIMIT_STORE"
+	// ルートグループごとの制限は ISUCON13_RATE_LIMIT_<グループ名の大文字> で指定する
+	rateLimitRuleEnvKeyPrefix = "ISUCON13_RATE_LIMIT_"
+
+	rateLimitGroupLivecomment = "livecomment"
+	rateLimitGroupReaction    = "reaction"
+	rateLimitGroupReport      = "report"
+
+	// メモリ上のバケットを掃除する間隔(Takeの呼び出し回数)
+	memoryRateLimitSweepInterval = 1024
+)
+
+var rateLimitGroups = []string{
+	rateLimitGroupLivecomment,
+	rateLimitGroupReaction,
+	rateLimitGroupReport,
+}
+
+// rateLimitRule はトークンバケットの設定
+// Rateは1秒あたりに補充するトークン数、Burstはバケットの容量
+type rateLimitRule struct {
+	Rate  float64
+	Burst float64
+}
+
+func (r rateLimitRule) enabled() bool {
+	return r.Rate > 0 && r.Burst >= 1
+}
+
+var (
+	rateLimitStore RateLimitStore = newMemoryRateLimitStore()
+	// 設定がないグループは制限しない
+	rateLimitRules = map[string]rateLimitRule{}
+)
+
+// parseRateLimitRule は "<回数>/<s|m|h>,<バースト>" 形式の設定を読み取る
+// バーストを省略した場合は回数と同じにする. 例: "30/m,10" は1分に30回、最大10回連続
+func parseRateLimitRule(s string) (rateLimitRule, error) {
+	ratePart, burstPart, hasBurst := strings.Cut(strings.TrimSpace(s), ",")
+	countPart, unitPart, ok := strings.Cut(ratePart, "/")
+	if !ok {
+		return rateLimitRule{}, fmt.Errorf("rate limit %q must be <count>/<s|m|h>[,<burst>]", s)
+	}
+
+	count, err := strconv.ParseFloat(strings.TrimSpace(countPart), 64)
+	if err != nil || count <= 0 {
+		return rateLimitRule{}, fmt.Errorf("rate limit count %q must be positive number", countPart)
+	}
+	var period time.Duration
+	switch strings.TrimSpace(unitPart) {
+	case "s":
+		period = time.Second
+	case "m":
+		period = time.Minute
+	case "h":
+		period = time.Hour
+	default:
+		return rateLimitRule{}, fmt.Errorf("rate limit unit %q must be s, m or h", unitPart)
+	}
+
+	burst := math.Max(1, math.Floor(count))
+	if hasBurst {
+		b, err := strconv.Atoi(strings.TrimSpace(burstPart))
+		if err != nil || b < 1 {
+			return rateLimitRule{}, fmt.Errorf("rate limit burst %q must be positive integer", burstPart)
+		}
+		burst = float64(b)
+	}
+
+	return rateLimitRule{
+		Rate:  count / period.Seconds(),
+		Burst: burst,
+	}, nil
+}
+
+// rateLimitBucket はトークンバケットの状態
+type rateLimitBucket struct {
+	Tokens float64 `db:"tokens"`
+	// 最後にトークンを補充した時刻(UnixMilli)
+	UpdatedAt int64 `db:"updated_at"`
+}
+
+// take は経過時間分のトークンを補充してから1つ消費する
+// 足りない場合は状態を補充のみ進め、次の1トークンが貯まるまでの時間を返す
+func (b rateLimitBucket) take(rule rateLimitRule, now time.Time) (rateLimitBucket, bool, time.Duration) {
+	nowMilli := now.UnixMilli()
+	tokens := rule.Burst
+	if b.UpdatedAt > 0 {
+		elapsed := float64(nowMilli-b.UpdatedAt) / 1000
+		tokens = math.Min(rule.Burst, b.Tokens+math.Max(0, elapsed)*rule.Rate)
+	}
+
+	if tokens < 1 {
+		wait := time.Duration((1 - tokens) / rule.Rate * float64(time.Second))
+		return rateLimitBucket{Tokens: tokens, UpdatedAt: nowMilli}, false, wait
+	}
+	return rateLimitBucket{Tokens: tokens - 1, UpdatedAt: nowMilli}, true, 0
+}
+
+// RateLimitStore はキーごとのトークンバケットを保持する
+type RateLimitStore interface {
+	// Take はkeyのバケットからトークンを1つ消費する. 拒否した場合は再試行までの待ち時間を返す
+	Take(ctx context.Context, key string, rule rateLimitRule, now time.Time) (bool, time.Duration, error)
+	Reset(ctx context.Context) error
+}
+
+func newRateLimitStore(kind string, db *sqlx.DB) (RateLimitStore, error) {
+	switch kind {
+	case "", "memory":
+		return newMemoryRateLimitStore(), nil
+	case "mysql":
+		return &mysqlRateLimitStore{db: db}, nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", kind)
+	}
+}
+
+// memoryRateLimitStore はプロセス内でバケットを保持する
+// 複数台構成ではサーバごとに制限されるため、共有したい場合はmysqlRateLimitStoreを使う
+type memoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]memoryRateLimitEntry
+	takes   int
+}
+
+type memoryRateLimitEntry struct {
+	bucket rateLimitBucket
+	// バケットが満タンまで補充される時刻
+	fullAt time.Time
+}
+
+func newMemoryRateLimitStore() *memoryRateLimitStore {
+	return &memoryRateLimitStore{
+		buckets: make(map[string]memoryRateLimitEntry),
+	}
+}
+
+func (s *memoryRateLimitStore) Take(_ context.Context, key string, rule rateLimitRule, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.takes++
+	if s.takes%memoryRateLimitSweepInterval == 0 {
+		s.sweep(now)
+	}
+
+	bucket, allowed, wait := s.buckets[key].bucket.take(rule, now)
+	s.buckets[key] = memoryRateLimitEntry{
+		bucket: bucket,
+		fullAt: now.Add(time.Duration((rule.Burst - bucket.Tokens) / rule.Rate * float64(time.Second))),
+	}
+	return allowed, wait, nil
+}
+
+// sweep は満タンまで補充されているはずのバケットを捨てる
+// 捨てても次回のTakeで満タンとして扱われるので結果は変わらない
+func (s *memoryRateLimitStore) sweep(now time.Time) {
+	for key, entry := range s.buckets {
+		if now.After(entry.fullAt) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func (s *memoryRateLimitStore) Reset(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.buckets = make(map[string]memoryRateLimitEntry)
+	return nil
+}
+
+// mysqlRateLimitStore はrate_limit_bucketsテーブルでバケットを共有する
+type mysqlRateLimitStore struct {
+	db *sqlx.DB
+}
+
+func (s *mysqlRateLimitStore) Take(ctx context.Context, key string, rule rateLimitRule, now time.Time) (bool, time.Duration, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, 0, err
+	}
+	defer tx.Rollback()
+
+	var bucket rateLimitBucket
+	err = tx.GetContext(ctx, &bucket, "SELECT tokens, updated_at FROM rate_limit_buckets WHERE `key` = ? FOR UPDATE", key)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, 0, err
+	}
+
+	bucket, allowed, wait := bucket.take(rule, now)
+	if _, err := tx.ExecContext(ctx, "INSERT INTO rate_limit_buckets (`key`, tokens, updated_at) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE tokens = VALUES(tokens), updated_at = VALUES(updated_at)", key, bucket.Tokens, bucket.UpdatedAt); err != nil {
+		return false, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, 0, err
+	}
+	return allowed, wait, nil
+}
+
+func (s *mysqlRateLimitStore) Reset(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, "TRUNCATE TABLE rate_limit_buckets")
+	return err
+}
+
+// rateLimit はグループに設定されたトークンバケットでリクエストを制限するミドルウェア
+// ログイン中はユーザID、未ログインの場合はクライアントIPごとに制限する
+func rateLimit(group string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			rule, ok := rateLimitRules[group]
+			if !ok || !rule.enabled() {
+				return next(c)
+			}
+
+			key := group + ":" + rateLimitKey(c)
+			allowed, wait, err := rateLimitStore.Take(c.Request().Context(), key, rule, time.Now())
+			if err != nil {
+				// ストアの障害でサービスを止めないよう、制限せずに通す
+				c.Logger().Warnf("failed to take rate limit token for %s: %+v", key, err)
+				return next(c)
+			}
+			if !allowed {
+				retryAfter := int64(math.Ceil(wait.Seconds()))
+				if retryAfter < 1 {
+					retryAfter = 1
+				}
+				c.Response().Header().Set(echo.HeaderRetryAfter, strconv.FormatInt(retryAfter, 10))
+				return echo.NewHTTPError(http.StatusTooManyRequests, "too many requests")
+			}
+			return next(c)
+		}
+	}
+}
+
+func rateLimitKey(c echo.Context) string {
+	if sess, err := session.Get(defaultSessionIDKey, c); err == nil {
+		if userID, ok := sess.Values[defaultUserIDKey].(int64); ok {
+			return "user:" + strconv.FormatInt(userID, 10)
+		}
+	}
+	return "ip:" + c.RealIP()
+}
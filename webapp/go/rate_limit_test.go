@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRateLimitRule(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    rateLimitRule
+		wantErr bool
+	}{
+		{in: "10/s", want: rateLimitRule{Rate: 10, Burst: 10}},
+		{in: "30/m,5", want: rateLimitRule{Rate: 0.5, Burst: 5}},
+		{in: " 3600/h , 1 ", want: rateLimitRule{Rate: 1, Burst: 1}},
+		{in: "10", wantErr: true},
+		{in: "0/s", wantErr: true},
+		{in: "10/d", wantErr: true},
+		{in: "10/s,0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseRateLimitRule(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryRateLimitStore()
+	rule := rateLimitRule{Rate: 1, Burst: 2}
+	now := time.Unix(1700000000, 0)
+
+	// バースト分は連続で通る
+	for i := 0; i < 2; i++ {
+		allowed, _, err := store.Take(ctx, "user:1", rule, now)
+		assert.NoError(t, err)
+		assert.True(t, allowed)
+	}
+
+	allowed, wait, err := store.Take(ctx, "user:1", rule, now)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, time.Second, wait)
+
+	// 別のキーは影響を受けない
+	allowed, _, err = store.Take(ctx, "user:2", rule, now)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	// 1秒で1トークン補充される
+	allowed, _, err = store.Take(ctx, "user:1", rule, now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	assert.NoError(t, store.Reset(ctx))
+	allowed, _, err = store.Take(ctx, "user:1", rule, now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+}
+
+// setTestRateLimit はrateLimitミドルウェアのストアと設定をテスト用に差し替える
+func setTestRateLimit(t *testing.T, store RateLimitStore, rules map[string]rateLimitRule) {
+	t.Helper()
+
+	prevStore, prevRules := rateLimitStore, rateLimitRules
+	t.Cleanup(func() { rateLimitStore, rateLimitRules = prevStore, prevRules })
+	rateLimitStore, rateLimitRules = store, rules
+}
+
+// serveRateLimitedRequest はuserIDでログインした状態で(0の場合は未ログインでremoteAddrから)、
+// groupで制限したハンドラにリクエストを送る
+func serveRateLimitedRequest(t *testing.T, group string, userID int64, remoteAddr string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/limited", nil)
+	req.RemoteAddr = remoteAddr
+	handler := rateLimit(group)(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	return serveTestHTTPRequest(t, "/limited", req, userID, handler)
+}
+
+func TestRateLimit(t *testing.T) {
+	setTestRateLimit(t, newMemoryRateLimitStore(), map[string]rateLimitRule{
+		// 1000秒に1回、最大2回連続
+		rateLimitGroupLivecomment: {Rate: 0.001, Burst: 2},
+		rateLimitGroupReaction:    {Rate: 0.001, Burst: 2},
+	})
+
+	const (
+		ipA = "192.0.2.1:1234"
+		ipB = "192.0.2.2:1234"
+	)
+	steps := []struct {
+		name           string
+		group          string
+		userID         int64
+		remoteAddr     string
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{name: "user burst 1", group: rateLimitGroupLivecomment, userID: testViewerID, remoteAddr: ipA, wantStatus: http.StatusOK},
+		{name: "user burst 2", group: rateLimitGroupLivecomment, userID: testViewerID, remoteAddr: ipA, wantStatus: http.StatusOK},
+		{name: "user limited", group: rateLimitGroupLivecomment, userID: testViewerID, remoteAddr: ipB, wantStatus: http.StatusTooManyRequests, wantRetryAfter: "1000"},
+		// ログイン中はユーザごとに制限するので、同じIPでも他のユーザは通る
+		{name: "other user from same ip", group: rateLimitGroupLivecomment, userID: testStreamerID, remoteAddr: ipA, wantStatus: http.StatusOK},
+		// グループごとに制限する
+		{name: "user in other group", group: rateLimitGroupReaction, userID: testViewerID, remoteAddr: ipA, wantStatus: http.StatusOK},
+		// 未ログインの場合はIPごとに制限し、ユーザの制限とは独立している
+		{name: "ip burst 1", group: rateLimitGroupLivecomment, remoteAddr: ipA, wantStatus: http.StatusOK},
+		{name: "ip burst 2", group: rateLimitGroupLivecomment, remoteAddr: ipA, wantStatus: http.StatusOK},
+		{name: "ip limited", group: rateLimitGroupLivecomment, remoteAddr: ipA, wantStatus: http.StatusTooManyRequests, wantRetryAfter: "1000"},
+		{name: "other ip", group: rateLimitGroupLivecomment, remoteAddr: ipB, wantStatus: http.StatusOK},
+		// 設定がないグループは制限しない
+		{name: "unlimited group 1", group: rateLimitGroupReport, userID: testViewerID, remoteAddr: ipA, wantStatus: http.StatusOK},
+		{name: "unlimited group 2", group: rateLimitGroupReport, userID: testViewerID, remoteAddr: ipA, wantStatus: http.StatusOK},
+		{name: "unlimited group 3", group: rateLimitGroupReport, userID: testViewerID, remoteAddr: ipA, wantStatus: http.StatusOK},
+	}
+	for _, step := range steps {
+		rec := serveRateLimitedRequest(t, step.group, step.userID, step.remoteAddr)
+		assert.Equal(t, step.wantStatus, rec.Code, step.name)
+		assert.Equal(t, step.wantRetryAfter, rec.Header().Get(echo.HeaderRetryAfter), step.name)
+	}
+}
+
+func TestRateLimitRetryAfterIsAtLeastOneSecond(t *testing.T) {
+	// 0.1秒で1トークン補充されるが、Retry-Afterは秒単位なので切り上げる
+	setTestRateLimit(t, newMemoryRateLimitStore(), map[string]rateLimitRule{
+		rateLimitGroupLivecomment: {Rate: 10, Burst: 1},
+	})
+
+	assert.Equal(t, http.StatusOK, serveRateLimitedRequest(t, rateLimitGroupLivecomment, testViewerID, "192.0.2.1:1234").Code)
+	rec := serveRateLimitedRequest(t, rateLimitGroupLivecomment, testViewerID, "192.0.2.1:1234")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get(echo.HeaderRetryAfter))
+}
+
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Take(context.Context, string, rateLimitRule, time.Time) (bool, time.Duration, error) {
+	return false, 0, errors.New("connection refused")
+}
+
+func (failingRateLimitStore) Reset(context.Context) error {
+	return errors.New("connection refused")
+}
+
+func TestRateLimitFailsOpen(t *testing.T) {
+	setTestRateLimit(t, failingRateLimitStore{}, map[string]rateLimitRule{
+		rateLimitGroupLivecomment: {Rate: 0.001, Burst: 1},
+	})
+
+	// ストアの障害時は制限せずに通す
+	for i := 0; i < 3; i++ {
+		rec := serveRateLimitedRequest(t, rateLimitGroupLivecomment, testViewerID, "192.0.2.1:1234")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Header().Get(echo.HeaderRetryAfter))
+	}
+}
+
+// fakeRateLimitBucketsTable はrate_limit_bucketsテーブルへのクエリに応答する
+type fakeRateLimitBucketsTable struct {
+	rows map[string]rateLimitBucket
+	// エラーを返すクエリの接頭辞
+	failOn string
+}
+
+func (tbl *fakeRateLimitBucketsTable) handle(query string, args []driver.Value) (fakeSQLResult, error) {
+	if tbl.failOn != "" && strings.HasPrefix(query, tbl.failOn) {
+		return fakeSQLResult{}, errors.New("connection refused")
+	}
+	switch {
+	case query == "BEGIN" || query == "COMMIT" || query == "ROLLBACK":
+		return fakeSQLResult{}, nil
+	case strings.HasPrefix(query, "SELECT tokens, updated_at FROM rate_limit_buckets WHERE `key` = ? FOR UPDATE"):
+		r := fakeSQLResult{Columns: []string{"tokens", "updated_at"}}
+		if b, ok := tbl.rows[args[0].(string)]; ok {
+			r.Rows = append(r.Rows, []driver.Value{b.Tokens, b.UpdatedAt})
+		}
+		return r, nil
+	case strings.HasPrefix(query, "INSERT INTO rate_limit_buckets"):
+		tbl.rows[args[0].(string)] = rateLimitBucket{Tokens: args[1].(float64), UpdatedAt: args[2].(int64)}
+		return fakeSQLResult{RowsAffected: 1}, nil
+	case query == "TRUNCATE TABLE rate_limit_buckets":
+		tbl.rows = make(map[string]rateLimitBucket)
+		return fakeSQLResult{}, nil
+	}
+	return fakeSQLResult{}, fmt.Errorf("unexpected query: %s", query)
+}
+
+func TestMySQLRateLimitStore(t *testing.T) {
+	ctx := context.Background()
+	tbl := &fakeRateLimitBucketsTable{rows: make(map[string]rateLimitBucket)}
+	db, f := newFakeSQLDB(t, tbl.handle)
+	store := &mysqlRateLimitStore{db: db}
+	rule := rateLimitRule{Rate: 1, Burst: 2}
+	now := time.Unix(1700000000, 0)
+
+	// バースト分は連続で通る
+	for i := 0; i < 2; i++ {
+		allowed, _, err := store.Take(ctx, "user:1", rule, now)
+		assert.NoError(t, err)
+		assert.True(t, allowed)
+	}
+	assert.Equal(t, rateLimitBucket{Tokens: 0, UpdatedAt: now.UnixMilli()}, tbl.rows["user:1"])
+
+	allowed, wait, err := store.Take(ctx, "user:1", rule, now)
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, time.Second, wait)
+
+	// 別のキーは影響を受けない
+	allowed, _, err = store.Take(ctx, "user:2", rule, now)
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	// 1秒で1トークン補充される
+	allowed, _, err = store.Take(ctx, "user:1", rule, now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	// 他のサーバと取り合わないよう、行をロックしてから更新する
+	queries := f.Queries()
+	assert.Equal(t, "BEGIN", queries[0])
+	assert.True(t, strings.HasSuffix(queries[1], "FOR UPDATE"))
+	assert.True(t, strings.HasPrefix(queries[2], "INSERT INTO rate_limit_buckets"))
+	assert.Equal(t, "COMMIT", queries[3])
+
+	assert.NoError(t, store.Reset(ctx))
+	assert.Empty(t, tbl.rows)
+}
+
+func TestMySQLRateLimitStoreError(t *testing.T) {
+	for _, failOn := range []string{"BEGIN", "SELECT", "INSERT", "COMMIT"} {
+		t.Run(failOn, func(t *testing.T) {
+			tbl := &fakeRateLimitBucketsTable{
+				rows:   map[string]rateLimitBucket{"user:1": {Tokens: 1, UpdatedAt: 1}},
+				failOn: failOn,
+			}
+			db, f := newFakeSQLDB(t, tbl.handle)
+			store := &mysqlRateLimitStore{db: db}
+
+			_, _, err := store.Take(context.Background(), "user:1", rateLimitRule{Rate: 1, Burst: 2}, time.Unix(1700000000, 0))
+			assert.Error(t, err)
+			if failOn == "SELECT" || failOn == "INSERT" {
+				// 途中で失敗した場合はロールバックする
+				queries := f.Queries()
+				assert.Equal(t, "ROLLBACK", queries[len(queries)-1])
+			}
+		})
+	}
+}
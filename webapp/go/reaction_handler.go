@@ -3,12 +3,12 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
-	"github.com/jmoiron/sqlx"
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
 )
@@ -46,40 +46,51 @@ func getReactionsHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "livestream_id in path must be integer")
 	}
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
+	page, err := parsePageRequest(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+		return err
 	}
-	defer tx.Rollback()
 
-	query := "SELECT * FROM reactions WHERE livestream_id = ? ORDER BY created_at DESC"
-	if c.QueryParam("limit") != "" {
-		limit, err := strconv.Atoi(c.QueryParam("limit"))
-		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, "limit query parameter must be integer")
+	var (
+		reactions  []Reaction
+		nextCursor string
+	)
+	err = repositoryStore.Tx(ctx, func(r Repository) error {
+		if page.NeedsSortKey {
+			cursor, err := r.Reactions().FindInLivestream(ctx, int64(livestreamID), page.Cursor.ID)
+			if errors.Is(err, ErrRecordNotFound) {
+				return echo.NewHTTPError(http.StatusBadRequest, "not found reaction that has the given cursor id")
+			}
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to get cursor reaction: "+err.Error())
+			}
+			page.Cursor.SortKey = cursor.CreatedAt
 		}
-		query += fmt.Sprintf(" LIMIT %d", limit)
-	}
 
-	reactionModels := []ReactionModel{}
-	if err := tx.SelectContext(ctx, &reactionModels, query, livestreamID); err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, "failed to get reactions")
-	}
-
-	reactions := make([]Reaction, 0, len(reactionModels))
-	if len(reactionModels) > 0 {
-		r, err := fillReactionsResponse(ctx, tx, reactionModels)
+		reactionModels, err := r.Reactions().List(ctx, int64(livestreamID), page)
 		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill reactions: "+err.Error())
+			return echo.NewHTTPError(http.StatusNotFound, "failed to get reactions")
 		}
-		reactions = r
-	}
 
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+		reactionModels, nextCursor = paginate(page, reactionModels, func(r ReactionModel) (int64, int64) {
+			return r.CreatedAt, r.ID
+		})
+
+		reactions = make([]Reaction, 0, len(reactionModels))
+		if len(reactionModels) > 0 {
+			filled, err := fillReactionsResponse(ctx, r, reactionModels)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill reactions: "+err.Error())
+			}
+			reactions = filled
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	return c.JSON(http.StatusOK, reactions)
+	return writePage(c, page, reactions, nextCursor)
 }
 
 func postReactionHandler(c echo.Context) error {
@@ -104,12 +115,6 @@ func postReactionHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
 	}
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
-	}
-	defer tx.Rollback()
-
 	reactionModel := ReactionModel{
 		UserID:       int64(userID),
 		LivestreamID: int64(livestreamID),
@@ -117,44 +122,52 @@ func postReactionHandler(c echo.Context) error {
 		CreatedAt:    time.Now().Unix(),
 	}
 
-	result, err := tx.NamedExecContext(ctx, "INSERT INTO reactions (user_id, livestream_id, emoji_name, created_at) VALUES (:user_id, :livestream_id, :emoji_name, :created_at)", reactionModel)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert reaction: "+err.Error())
-	}
+	var reaction Reaction
+	err = repositoryStore.Tx(ctx, func(r Repository) error {
+		if err := verifyLivestreamIsLive(ctx, r, int64(livestreamID)); err != nil {
+			return err
+		}
 
-	reactionID, err := result.LastInsertId()
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get last inserted reaction id: "+err.Error())
-	}
-	reactionModel.ID = reactionID
+		if err := r.Reactions().Create(ctx, &reactionModel); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert reaction: "+err.Error())
+		}
+
+		if err := r.Stats().CountReaction(ctx, reactionModel.LivestreamID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
 
-	reaction, err := fillReactionResponse(ctx, tx, reactionModel)
+		reaction, err = fillReactionResponse(ctx, r, reactionModel)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill reaction: "+err.Error())
+		}
+		return nil
+	})
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill reaction: "+err.Error())
+		return err
 	}
 
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	if err := eventBroker.Publish(reactionModel.LivestreamID, livestreamEventReaction, reaction); err != nil {
+		c.Logger().Warnf("failed to publish reaction event: %+v", err)
 	}
 
 	return c.JSON(http.StatusCreated, reaction)
 }
 
-func fillReactionResponse(ctx context.Context, tx *sqlx.Tx, reactionModel ReactionModel) (Reaction, error) {
-	userModel := UserModel{}
-	if err := tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE id = ?", reactionModel.UserID); err != nil {
+func fillReactionResponse(ctx context.Context, r Repository, reactionModel ReactionModel) (Reaction, error) {
+	userModel, err := r.Users().FindByID(ctx, reactionModel.UserID)
+	if err != nil {
 		return Reaction{}, err
 	}
-	user, err := fillUserResponse(ctx, tx, userModel)
+	user, err := fillUserResponse(ctx, r, userModel)
 	if err != nil {
 		return Reaction{}, err
 	}
 
-	livestreamModel := LivestreamModel{}
-	if err := tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ?", reactionModel.LivestreamID); err != nil {
+	livestreamModel, err := r.Livestreams().FindByID(ctx, reactionModel.LivestreamID)
+	if err != nil {
 		return Reaction{}, err
 	}
-	livestream, err := fillLivestreamResponse(ctx, tx, livestreamModel)
+	livestream, err := fillLivestreamResponse(ctx, r, livestreamModel)
 	if err != nil {
 		return Reaction{}, err
 	}
@@ -170,53 +183,45 @@ func fillReactionResponse(ctx context.Context, tx *sqlx.Tx, reactionModel Reacti
 	return reaction, nil
 }
 
-func fillReactionsResponse(ctx context.Context, tx *sqlx.Tx, reactionModels []ReactionModel) ([]Reaction, error) {
+func fillReactionsResponse(ctx context.Context, r Repository, reactionModels []ReactionModel) ([]Reaction, error) {
 	userIDs := make([]int64, 0, len(reactionModels))
 	livestreammIDs := make([]int64, 0, len(reactionModels))
-	for _, r := range reactionModels {
-		userIDs = append(userIDs, r.UserID)
-		livestreammIDs = append(livestreammIDs, r.LivestreamID)
+	for _, reaction := range reactionModels {
+		userIDs = append(userIDs, reaction.UserID)
+		livestreammIDs = append(livestreammIDs, reaction.LivestreamID)
 	}
 
-	userModels := make([]UserModel, 0, len(userIDs))
-	query, params, err := sqlx.In("SELECT * FROM users WHERE id IN (?)", userIDs)
+	userModels, err := r.Users().FindByIDs(ctx, userIDs)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create users query: %w", err)
-	}
-	if err := tx.SelectContext(ctx, &userModels, query, params...); err != nil {
-		return nil, fmt.Errorf("failed to query users: %w", err)
+		return nil, err
 	}
-	users, err := fillUsersResponse(ctx, tx, userModels)
+	users, err := fillUsersResponse(ctx, r, userModelsOf(userModels))
 	if err != nil {
 		return nil, fmt.Errorf("failed to fill users response: %w", err)
 	}
 
-	livestreamModels := make([]*LivestreamModel, 0, len(livestreammIDs))
-	query, params, err = sqlx.In("SELECT * FROM livestreams WHERE id IN (?)", livestreammIDs)
+	livestreamModels, err := r.Livestreams().FindByIDs(ctx, livestreammIDs)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create livestreams query: %w", err)
-	}
-	if err := tx.SelectContext(ctx, &livestreamModels, query, params...); err != nil {
-		return nil, fmt.Errorf("failed to create livestreams query: %w", err)
+		return nil, err
 	}
-	livestreams, err := fillLivestreamsResponse(ctx, tx, livestreamModels)
+	livestreams, err := fillLivestreamsResponse(ctx, r, livestreamModelsOf(livestreamModels))
 	if err != nil {
 		return nil, fmt.Errorf("failed to fill livestreams response: %w", err)
 	}
 
-	livestreamMap := make(map[int64]*Livestream)
-	for _, l := range livestreams {
-		livestreamMap[l.ID] = &l
+	livestreamMap := make(map[int64]*Livestream, len(livestreams))
+	for i := range livestreams {
+		livestreamMap[livestreams[i].ID] = &livestreams[i]
 	}
 
 	reactions := make([]Reaction, 0, len(reactionModels))
-	for _, r := range reactionModels {
+	for _, reactionModel := range reactionModels {
 		reaction := Reaction{
-			ID:         r.ID,
-			EmojiName:  r.EmojiName,
-			User:       *(users[r.UserID]),
-			Livestream: *(livestreamMap[r.LivestreamID]),
-			CreatedAt:  r.CreatedAt,
+			ID:         reactionModel.ID,
+			EmojiName:  reactionModel.EmojiName,
+			User:       *(users[reactionModel.UserID]),
+			Livestream: *(livestreamMap[reactionModel.LivestreamID]),
+			CreatedAt:  reactionModel.CreatedAt,
 		}
 		reactions = append(reactions, reaction)
 	}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetReactionsHandler(t *testing.T) {
+	tests := []struct {
+		name           string
+		livestreamID   string
+		query          string
+		wantStatus     int
+		wantEmojis     []string
+		wantNextCursor bool
+		paged          bool
+	}{
+		{
+			name:         "same created_at ordered by id",
+			livestreamID: "1",
+			wantStatus:   http.StatusOK,
+			wantEmojis:   []string{"clap", "heart", "smile"},
+		},
+		{
+			name:           "limit",
+			livestreamID:   "1",
+			query:          "?limit=1",
+			wantStatus:     http.StatusOK,
+			wantEmojis:     []string{"clap"},
+			wantNextCursor: true,
+		},
+		{
+			name:         "before_id",
+			livestreamID: "1",
+			query:        "?before_id=3",
+			wantStatus:   http.StatusOK,
+			wantEmojis:   []string{"heart", "smile"},
+			paged:        true,
+		},
+		{
+			name:         "no reactions",
+			livestreamID: "2",
+			wantStatus:   http.StatusOK,
+			wantEmojis:   []string{},
+		},
+		{
+			name:         "unknown cursor id",
+			livestreamID: "1",
+			query:        "?after_id=100",
+			wantStatus:   http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestRepositoryStore(t)
+			rec := serveTestRequest(t, http.MethodGet, "/api/livestream/:livestream_id/reaction", "/api/livestream/"+tt.livestreamID+"/reaction"+tt.query, testViewerID, getReactionsHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			reactions, nextCursor := decodeListResponse[Reaction](t, rec, tt.paged)
+			emojis := []string{}
+			for _, r := range reactions {
+				emojis = append(emojis, r.EmojiName)
+			}
+			assert.Equal(t, tt.wantEmojis, emojis)
+			assert.Equal(t, tt.wantNextCursor, nextCursor != "")
+		})
+	}
+}
+
+func TestPostReactionHandler(t *testing.T) {
+	s := newTestRepositoryStore(t)
+
+	rec := serveTestJSONRequest(t, http.MethodPost, "/api/livestream/:livestream_id/reaction", "/api/livestream/1/reaction", `{"emoji_name": "tada"}`, testCollaboratorID, postReactionHandler)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	reaction := decodeResponse[Reaction](t, rec)
+	assert.Equal(t, int64(4), reaction.ID)
+	assert.Equal(t, "tada", reaction.EmojiName)
+	assert.Equal(t, "collaborator", reaction.User.Name)
+	assert.Equal(t, testLivestreamID, reaction.Livestream.ID)
+
+	assert.Equal(t, "tada", s.data.reactions[4].EmojiName)
+	assert.Equal(t, int64(4), s.data.livestreamStats[testLivestreamID].Reactions)
+	assert.Equal(t, int64(504), s.data.userStats[testStreamerID].Score)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// リポジトリ層
+// ハンドラからSQLを切り離し、データベースなしでハンドラをテストできるようにする
+// MySQLの実装(repository_mysql.go)とテスト用のインメモリの実装(repository_memory.go)がある
+// ハンドラはrepositoryStore.Txを通してデータベースにアクセスする
+// dbConnを直接使うのは、データベース全体を操作する初期化(initializer.go)・マイグレーション(migrate.go)と
+// ハンドラから独立したセッション・レート制限などのストアだけ
+
+// ErrRecordNotFound は対象の行が存在しない場合にリポジトリが返す
+var ErrRecordNotFound = errors.New("record not found")
+
+// RepositoryStore はトランザクションを開始し、その中で使うリポジトリを渡す
+type RepositoryStore interface {
+	// Tx はfnが返したエラーをそのまま返し、その場合はロールバックする
+	// fnがecho.HTTPErrorを返せば、ハンドラはTxのエラーをそのまま返してよい
+	Tx(ctx context.Context, fn func(r Repository) error) error
+}
+
+var repositoryStore RepositoryStore
+
+// Repository は1つのトランザクション内で使うリポジトリの集まり
+type Repository interface {
+	Users() UserRepository
+	Livestreams() LivestreamRepository
+	Tags() TagRepository
+	Livecomments() LivecommentRepository
+	Reactions() ReactionRepository
+	Reports() ReportRepository
+	NGWords() NGWordRepository
+	ReservationSlots() ReservationSlotRepository
+	Tips() TipRepository
+	Follows() FollowRepository
+	Moderations() ModerationRepository
+	Stats() StatsRepository
+	Viewers() ViewerRepository
+}
+
+type UserRepository interface {
+	// FindByID は存在しない場合ErrRecordNotFoundを返す
+	FindByID(ctx context.Context, id int64) (UserModel, error)
+	// FindByIDForUpdate は行ロックを取って取得する. 存在しない場合ErrRecordNotFoundを返す
+	FindByIDForUpdate(ctx context.Context, id int64) (UserModel, error)
+	// FindByName は存在しない場合ErrRecordNotFoundを返す
+	FindByName(ctx context.Context, name string) (UserModel, error)
+	// FindByIDs は存在しないユーザをマップに含めない
+	FindByIDs(ctx context.Context, ids []int64) (map[int64]UserModel, error)
+	// FindByNames は存在しないユーザを結果に含めない
+	FindByNames(ctx context.Context, names []string) ([]UserModel, error)
+	// Themes はユーザIDをキーにしてテーマを返す
+	Themes(ctx context.Context, userIDs []int64) (map[int64]ThemeModel, error)
+	// IconHashes はユーザIDをキーにしてアイコンのハッシュを返す
+	// アイコンを登録していないユーザはフォールバック画像のハッシュになる
+	IconHashes(ctx context.Context, userIDs []int64) (map[int64]string, error)
+	// SetIcon はユーザのアイコンをhashのアイコンで置き換え、登録したアイコンのIDを返す
+	SetIcon(ctx context.Context, userID int64, hash string) (int64, error)
+	// FollowerCounts はユーザごとのフォロワー数を返す. フォロワーがいないユーザはマップに含めない
+	FollowerCounts(ctx context.Context, userIDs []int64) (map[int64]int64, error)
+	// Create はユーザを登録し、userModel.IDを設定する
+	Create(ctx context.Context, userModel *UserModel) error
+	// Update は表示名・説明・パスワードを更新する
+	Update(ctx context.Context, userModel UserModel) error
+	// CreateTheme はユーザのテーマを登録する
+	CreateTheme(ctx context.Context, theme ThemeModel) error
+	// UpdateDarkMode はユーザのテーマのダークモード設定を更新する
+	UpdateDarkMode(ctx context.Context, userID int64, darkMode bool) error
+	// Delete はユーザと、ユーザが所有する全てのデータを削除する. 配信予定の配信の予約枠は返却する
+	// 他の配信に投稿したライブコメントはdeletedUserIDに付け替えて残す
+	Delete(ctx context.Context, id int64) error
+}
+
+type LivestreamRepository interface {
+	// FindByID は存在しない場合ErrRecordNotFoundを返す
+	FindByID(ctx context.Context, id int64) (LivestreamModel, error)
+	// FindByIDForUpdate は行ロックを取って取得する. 存在しない場合ErrRecordNotFoundを返す
+	FindByIDForUpdate(ctx context.Context, id int64) (LivestreamModel, error)
+	// FindByIDs は存在しない配信をマップに含めない
+	FindByIDs(ctx context.Context, ids []int64) (map[int64]LivestreamModel, error)
+	ListByUserID(ctx context.Context, userID int64) ([]*LivestreamModel, error)
+	// Tags は配信IDをキーにしてタグを返す. タグのない配信は空のスライスになる
+	Tags(ctx context.Context, livestreamIDs []int64) (map[int64][]Tag, error)
+	// Collaborators は配信のコラボレーターを登録順に返す
+	Collaborators(ctx context.Context, livestreamIDs []int64) ([]*LivestreamCollaboratorModel, error)
+	// IsModerator は配信者かコラボレーターであればtrueを返す
+	IsModerator(ctx context.Context, livestream LivestreamModel, userID int64) (bool, error)
+	// Create は配信を登録し、livestreamModel.IDを設定する
+	Create(ctx context.Context, livestreamModel *LivestreamModel) error
+	// Update はタイトル・説明・URL・配信時間を更新する
+	Update(ctx context.Context, livestream LivestreamModel) error
+	// SetTags は配信のタグをtagIDsで置き換える
+	SetTags(ctx context.Context, livestreamID int64, tagIDs []int64) error
+	// SetCollaborators は配信のコラボレーターをuserIDsで置き換える
+	SetCollaborators(ctx context.Context, livestreamID int64, userIDs []int64) error
+	// Search は検索条件に一致する配信を、pageのkeysetClauseと同じ順序・件数で返す
+	// 結果はpaginateで並べ直すこと
+	Search(ctx context.Context, q *LivestreamSearchQuery, page *pageRequest) ([]*LivestreamModel, error)
+	// Delete は配信と、それに紐づくタグ・ライブコメント・リアクション等を削除する
+	Delete(ctx context.Context, id int64) error
+}
+
+type TagRepository interface {
+	// List は全てのタグをID順に返す
+	List(ctx context.Context) ([]*TagModel, error)
+}
+
+type LivecommentRepository interface {
+	// FindByID は論理削除したライブコメントも返す. 存在しない場合ErrRecordNotFoundを返す
+	FindByID(ctx context.Context, id int64) (LivecommentModel, error)
+	// FindByIDs は論理削除したライブコメントも返す
+	FindByIDs(ctx context.Context, ids []int64) ([]LivecommentModel, error)
+	// FindInLivestream は配信のライブコメントを取得する. 存在しない場合ErrRecordNotFoundを返す
+	FindInLivestream(ctx context.Context, livestreamID int64, id int64) (LivecommentModel, error)
+	// FindInLivestreamForUpdate は配信の論理削除していないライブコメントを行ロックを取って取得する
+	// 存在しない場合ErrRecordNotFoundを返す
+	FindInLivestreamForUpdate(ctx context.Context, livestreamID int64, id int64) (LivecommentModel, error)
+	// ListVisible は配信の論理削除していないライブコメントを、pageのkeysetClauseと同じ順序・件数で返す
+	// 結果はpaginateで並べ直すこと
+	ListVisible(ctx context.Context, livestreamID int64, page *pageRequest) ([]LivecommentModel, error)
+	// ListHitNGWord は論理削除していないライブコメントのうち、NGワードにヒットするものを行ロックを取って返す
+	// ngWordLivestreamIDがchannelNGWordLivestreamIDの場合は配信者の全ての配信が対象になる
+	ListHitNGWord(ctx context.Context, livestream LivestreamModel, ngWordLivestreamID int64, ngWord string, matcher *ngWordMatcher) ([]LivecommentModel, error)
+	// Create はライブコメントを投稿し、livecommentModel.IDを設定する
+	Create(ctx context.Context, livecommentModel *LivecommentModel) error
+}
+
+type ReactionRepository interface {
+	// FindInLivestream は配信のリアクションを取得する. 存在しない場合ErrRecordNotFoundを返す
+	FindInLivestream(ctx context.Context, livestreamID int64, id int64) (ReactionModel, error)
+	// List は配信のリアクションを、pageのkeysetClauseと同じ順序・件数で返す
+	// 結果はpaginateで並べ直すこと
+	List(ctx context.Context, livestreamID int64, page *pageRequest) ([]ReactionModel, error)
+	// Create はリアクションを投稿し、reactionModel.IDを設定する
+	Create(ctx context.Context, reactionModel *ReactionModel) error
+}
+
+type ReportRepository interface {
+	ListByLivestreamID(ctx context.Context, livestreamID int64) ([]LivecommentReportModel, error)
+	// Create はスパム報告を登録し、reportModel.IDを設定する
+	Create(ctx context.Context, reportModel *LivecommentReportModel) error
+	// CountOpenReporters はライブコメントを報告した未対応の報告のユーザ数を返す
+	CountOpenReporters(ctx context.Context, livestreamID int64, livecommentID int64) (int64, error)
+	// ListReported はスパム報告をライブコメントごとに集計し、最後に報告された順に返す
+	// livecommentIDが0でない場合はそのライブコメントのみ、statusが空でない場合はそのstatusのもののみを返す
+	ListReported(ctx context.Context, livestreamID int64, livecommentID int64, status string) ([]reportedLivecommentModel, error)
+	// Resolve はライブコメントへの未対応の報告をstatusにする
+	Resolve(ctx context.Context, livestreamID int64, livecommentID int64, status string) error
+}
+
+type NGWordRepository interface {
+	// List はユーザが登録したNGワードのうち、livestreamIDsのいずれかに適用されるものを新しい順に返す
+	List(ctx context.Context, userID int64, livestreamIDs []int64) ([]*NGWord, error)
+	// Create はNGワードを登録し、ngWord.IDを設定する
+	Create(ctx context.Context, ngWord *NGWord) error
+	// Delete はユーザが登録したNGワードのうち、livestreamIDsのいずれかに適用されるものを削除する
+	// 該当するNGワードがない場合ErrRecordNotFoundを返す
+	Delete(ctx context.Context, id int64, userID int64, livestreamIDs []int64) error
+}
+
+type ReservationSlotRepository interface {
+	// List は[from, to]に収まる予約枠をstart_at順に返す
+	List(ctx context.Context, from int64, to int64) ([]*ReservationSlotModel, error)
+	// Lock は予約区間が消費する予約枠をstart_at順に行ロックを取って取得する
+	// 複数の区間をまとめてロックすることで、ロック順序を揃えてデッドロックを避ける
+	Lock(ctx context.Context, ranges ...reservationRange) ([]*ReservationSlotModel, error)
+	// Consume は予約区間が消費する予約枠を1つずつ減らす
+	Consume(ctx context.Context, r reservationRange) error
+	// Release は予約区間が消費していた予約枠を1つずつ戻す
+	Release(ctx context.Context, r reservationRange) error
+}
+
+type TipRepository interface {
+	// Total は台帳の合計額を返す
+	Total(ctx context.Context) (int64, error)
+	// Record はチップ付きライブコメントを台帳に記録する. チップがない場合は何もしない
+	Record(ctx context.Context, livecommentModel LivecommentModel, streamerID int64) error
+	// DailyEarnings は配信者が[from, to)に受け取ったチップをJSTの日ごとに集計し、日付順に返す
+	// toが0の場合は上限なし
+	DailyEarnings(ctx context.Context, streamerID int64, from int64, to int64) ([]dailyEarnings, error)
+}
+
+type FollowRepository interface {
+	// Follow はフォローを登録する. 既にフォローしている場合は何もしない
+	Follow(ctx context.Context, follow FollowModel) error
+	// Unfollow はフォローを解除する. フォローしていない場合は何もしない
+	Unfollow(ctx context.Context, followerID int64, followeeID int64) error
+	// ListFollowees はフォロー中のユーザを、フォローした新しい順に返す
+	ListFollowees(ctx context.Context, followerID int64) ([]UserModel, error)
+	// Feed はフォロー中のユーザのnow時点で終了していない配信を、開始が早い順にlimit件まで返す
+	Feed(ctx context.Context, followerID int64, now int64, limit int) ([]*LivestreamModel, error)
+}
+
+type ModerationRepository interface {
+	// SoftDelete はライブコメントを論理削除し、モデレーション履歴を残す
+	// 削除済みのライブコメントは無視する. 呼び出し側で行ロックを取っておくこと
+	SoftDelete(ctx context.Context, livecommentModels []LivecommentModel, reason string, ngWordID int64, moderatorID int64) error
+	// List は配信のモデレーション履歴を、pageのkeysetClauseと同じ順序・件数で返す
+	// 結果はpaginateで並べ直すこと
+	List(ctx context.Context, livestreamID int64, page *pageRequest) ([]LivecommentModerationModel, error)
+}
+
+// StatsRepository はstats_counter.goの統計情報のカウンタを読み書きする
+type StatsRepository interface {
+	CreateUser(ctx context.Context, userID int64, name string) error
+	CreateLivestream(ctx context.Context, livestreamID int64, userID int64) error
+	CountReaction(ctx context.Context, livestreamID int64) error
+	CountLivecomment(ctx context.Context, livestreamID int64, tip int64) error
+	CountReport(ctx context.Context, livestreamID int64) error
+	// Livestream は存在しない場合ErrRecordNotFoundを返す
+	Livestream(ctx context.Context, livestreamID int64) (LivestreamStatsModel, error)
+	// User は存在しない場合ErrRecordNotFoundを返す
+	User(ctx context.Context, userID int64) (UserStatsModel, error)
+	LivestreamRank(ctx context.Context, stats LivestreamStatsModel) (int64, error)
+	UserRank(ctx context.Context, stats UserStatsModel) (int64, error)
+	// FavoriteEmoji は配信者の配信で最も多く使われた絵文字を返す. 同数の場合は名前の降順で先のもの
+	// リアクションがない場合は空文字列を返す
+	FavoriteEmoji(ctx context.Context, userID int64) (string, error)
+}
+
+// ViewerRepository は配信ごとの視聴履歴(viewer_presence.go)を読み書きする
+type ViewerRepository interface {
+	// Enter は入室を記録する
+	Enter(ctx context.Context, viewer LivestreamViewerModel) error
+	// Exit は退出していない視聴に退出日時を記録する
+	Exit(ctx context.Context, userID int64, livestreamID int64, now int64) error
+	// Heartbeat は退出していない視聴の最終確認日時を更新する
+	// 退出していない視聴がない場合ErrRecordNotFoundを返す
+	Heartbeat(ctx context.Context, userID int64, livestreamID int64, now int64) error
+	// Count は配信の視聴者数を数える. livestreamIDsが空の場合は全て0を返す
+	Count(ctx context.Context, livestreamIDs []int64, now time.Time) (ViewerCounts, error)
+}
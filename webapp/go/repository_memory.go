@@ -0,0 +1,1381 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryRepositoryStore はハンドラのテストで使うインメモリのリポジトリ
+// トランザクションは直列に実行し、fnがエラーを返した場合は開始前の状態に戻す
+type memoryRepositoryStore struct {
+	mu   sync.Mutex
+	data *memoryData
+}
+
+// memoryData はテーブルごとの行. テストから直接行を用意してよい
+type memoryData struct {
+	users       map[int64]UserModel
+	themes      map[int64]ThemeModel // users.id -> themes
+	icons       map[int64]IconModel  // users.id -> icons
+	follows     []FollowModel
+	livestreams map[int64]LivestreamModel
+	tags        map[int64]TagModel
+	// 登録順
+	livestreamTags []LivestreamTagModel
+	// 登録順
+	collaborators    []LivestreamCollaboratorModel
+	livecomments     map[int64]LivecommentModel
+	reactions        map[int64]ReactionModel
+	reports          map[int64]LivecommentReportModel
+	ngWords          map[int64]NGWord
+	reservationSlots []ReservationSlotModel
+	tips             map[int64]TipModel
+	moderations      map[int64]LivecommentModerationModel
+	viewersHistory   []LivestreamViewerModel
+	livestreamStats  map[int64]LivestreamStatsModel
+	userStats        map[int64]UserStatsModel
+}
+
+func newMemoryRepositoryStore() *memoryRepositoryStore {
+	return &memoryRepositoryStore{
+		data: &memoryData{
+			users:           make(map[int64]UserModel),
+			themes:          make(map[int64]ThemeModel),
+			icons:           make(map[int64]IconModel),
+			livestreams:     make(map[int64]LivestreamModel),
+			tags:            make(map[int64]TagModel),
+			livecomments:    make(map[int64]LivecommentModel),
+			reactions:       make(map[int64]ReactionModel),
+			reports:         make(map[int64]LivecommentReportModel),
+			ngWords:         make(map[int64]NGWord),
+			tips:            make(map[int64]TipModel),
+			moderations:     make(map[int64]LivecommentModerationModel),
+			livestreamStats: make(map[int64]LivestreamStatsModel),
+			userStats:       make(map[int64]UserStatsModel),
+		},
+	}
+}
+
+func (s *memoryRepositoryStore) Tx(_ context.Context, fn func(r Repository) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d := s.data.clone()
+	if err := fn(&memoryRepository{d: d}); err != nil {
+		return err
+	}
+	s.data = d
+	return nil
+}
+
+func (d *memoryData) clone() *memoryData {
+	return &memoryData{
+		users:            cloneMap(d.users),
+		themes:           cloneMap(d.themes),
+		icons:            cloneMap(d.icons),
+		follows:          append([]FollowModel(nil), d.follows...),
+		livestreams:      cloneMap(d.livestreams),
+		tags:             cloneMap(d.tags),
+		livestreamTags:   append([]LivestreamTagModel(nil), d.livestreamTags...),
+		collaborators:    append([]LivestreamCollaboratorModel(nil), d.collaborators...),
+		livecomments:     cloneMap(d.livecomments),
+		reactions:        cloneMap(d.reactions),
+		reports:          cloneMap(d.reports),
+		ngWords:          cloneMap(d.ngWords),
+		reservationSlots: append([]ReservationSlotModel(nil), d.reservationSlots...),
+		tips:             cloneMap(d.tips),
+		moderations:      cloneMap(d.moderations),
+		viewersHistory:   append([]LivestreamViewerModel(nil), d.viewersHistory...),
+		livestreamStats:  cloneMap(d.livestreamStats),
+		userStats:        cloneMap(d.userStats),
+	}
+}
+
+func cloneMap[K comparable, V any](m map[K]V) map[K]V {
+	cloned := make(map[K]V, len(m))
+	for k, v := range m {
+		cloned[k] = v
+	}
+	return cloned
+}
+
+// findByIDs はidsのうちmに存在するものを返す
+func findByIDs[V any](m map[int64]V, ids []int64) map[int64]V {
+	found := make(map[int64]V, len(ids))
+	for _, id := range ids {
+		if v, ok := m[id]; ok {
+			found[id] = v
+		}
+	}
+	return found
+}
+
+// nextID はAUTO_INCREMENTの代わりに、rowsのIDの最大値+1を返す
+func nextID[T any](rows []T, id func(T) int64) int64 {
+	var max int64
+	for _, row := range rows {
+		if v := id(row); v > max {
+			max = v
+		}
+	}
+	return max + 1
+}
+
+// nextMapID はIDをキーにしたテーブルについて、nextIDと同じく次のIDを返す
+func nextMapID[V any](m map[int64]V) int64 {
+	var max int64
+	for id := range m {
+		if id > max {
+			max = id
+		}
+	}
+	return max + 1
+}
+
+// keysetPage はpageRequest.keysetClauseと同じ条件・順序・件数で行を絞り込む
+func keysetPage[T any](page *pageRequest, rows []T, key func(T) (sortKey int64, id int64)) []T {
+	after := page.Cursor != nil && page.Cursor.After
+
+	selected := make([]T, 0, len(rows))
+	for _, row := range rows {
+		if page.Cursor != nil {
+			sortKey, id := key(row)
+			cursorKey, cursorID := page.Cursor.SortKey, page.Cursor.ID
+			if after && !(sortKey > cursorKey || (sortKey == cursorKey && id > cursorID)) {
+				continue
+			}
+			if !after && !(sortKey < cursorKey || (sortKey == cursorKey && id < cursorID)) {
+				continue
+			}
+		}
+		selected = append(selected, row)
+	}
+
+	less := func(a, b T) bool {
+		ka, ia := key(a)
+		kb, ib := key(b)
+		return ka < kb || (ka == kb && ia < ib)
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		if after {
+			return less(selected[i], selected[j])
+		}
+		return less(selected[j], selected[i])
+	})
+
+	// 次ページの有無を判定するため1件多く返す
+	if page.Limit > 0 && len(selected) > page.Limit+1 {
+		selected = selected[:page.Limit+1]
+	}
+	return selected
+}
+
+type memoryRepository struct {
+	d *memoryData
+}
+
+func (r *memoryRepository) Users() UserRepository {
+	return &memoryUserRepository{d: r.d}
+}
+
+func (r *memoryRepository) Livestreams() LivestreamRepository {
+	return &memoryLivestreamRepository{d: r.d}
+}
+
+func (r *memoryRepository) Tags() TagRepository {
+	return &memoryTagRepository{d: r.d}
+}
+
+func (r *memoryRepository) Livecomments() LivecommentRepository {
+	return &memoryLivecommentRepository{d: r.d}
+}
+
+func (r *memoryRepository) Reactions() ReactionRepository {
+	return &memoryReactionRepository{d: r.d}
+}
+
+func (r *memoryRepository) Reports() ReportRepository {
+	return &memoryReportRepository{d: r.d}
+}
+
+func (r *memoryRepository) NGWords() NGWordRepository {
+	return &memoryNGWordRepository{d: r.d}
+}
+
+func (r *memoryRepository) ReservationSlots() ReservationSlotRepository {
+	return &memoryReservationSlotRepository{d: r.d}
+}
+
+func (r *memoryRepository) Tips() TipRepository {
+	return &memoryTipRepository{d: r.d}
+}
+
+func (r *memoryRepository) Follows() FollowRepository {
+	return &memoryFollowRepository{d: r.d}
+}
+
+func (r *memoryRepository) Moderations() ModerationRepository {
+	return &memoryModerationRepository{d: r.d}
+}
+
+func (r *memoryRepository) Stats() StatsRepository {
+	return &memoryStatsRepository{d: r.d}
+}
+
+func (r *memoryRepository) Viewers() ViewerRepository {
+	return &memoryViewerRepository{d: r.d}
+}
+
+type memoryUserRepository struct {
+	d *memoryData
+}
+
+func (r *memoryUserRepository) FindByID(_ context.Context, id int64) (UserModel, error) {
+	userModel, ok := r.d.users[id]
+	if !ok {
+		return UserModel{}, ErrRecordNotFound
+	}
+	return userModel, nil
+}
+
+func (r *memoryUserRepository) FindByIDForUpdate(ctx context.Context, id int64) (UserModel, error) {
+	// トランザクションは直列に実行するので、ロックは不要
+	return r.FindByID(ctx, id)
+}
+
+func (r *memoryUserRepository) FindByName(_ context.Context, name string) (UserModel, error) {
+	for _, u := range r.d.users {
+		if u.Name == name {
+			return u, nil
+		}
+	}
+	return UserModel{}, ErrRecordNotFound
+}
+
+func (r *memoryUserRepository) FindByIDs(_ context.Context, ids []int64) (map[int64]UserModel, error) {
+	return findByIDs(r.d.users, ids), nil
+}
+
+func (r *memoryUserRepository) FindByNames(_ context.Context, names []string) ([]UserModel, error) {
+	userModels := []UserModel{}
+	for _, u := range r.d.users {
+		if slices.Contains(names, u.Name) {
+			userModels = append(userModels, u)
+		}
+	}
+	sort.Slice(userModels, func(i, j int) bool {
+		return userModels[i].ID < userModels[j].ID
+	})
+	return userModels, nil
+}
+
+func (r *memoryUserRepository) Themes(_ context.Context, userIDs []int64) (map[int64]ThemeModel, error) {
+	return findByIDs(r.d.themes, userIDs), nil
+}
+
+func (r *memoryUserRepository) IconHashes(_ context.Context, userIDs []int64) (map[int64]string, error) {
+	hashes := make(map[int64]string, len(userIDs))
+	for _, userID := range userIDs {
+		hashes[userID] = fallbackImageHash
+		if icon, ok := r.d.icons[userID]; ok {
+			hashes[userID] = icon.Hash
+		}
+	}
+	return hashes, nil
+}
+
+func (r *memoryUserRepository) SetIcon(_ context.Context, userID int64, hash string) (int64, error) {
+	var iconID int64
+	for _, icon := range r.d.icons {
+		iconID = max(iconID, icon.ID)
+	}
+	iconID++
+	r.d.icons[userID] = IconModel{ID: iconID, UserID: userID, Hash: hash}
+	return iconID, nil
+}
+
+func (r *memoryUserRepository) FollowerCounts(_ context.Context, userIDs []int64) (map[int64]int64, error) {
+	counts := make(map[int64]int64, len(userIDs))
+	for _, f := range r.d.follows {
+		for _, userID := range userIDs {
+			if f.FolloweeID == userID {
+				counts[userID]++
+				break
+			}
+		}
+	}
+	return counts, nil
+}
+
+func (r *memoryUserRepository) Create(_ context.Context, userModel *UserModel) error {
+	for _, u := range r.d.users {
+		if u.Name == userModel.Name {
+			return fmt.Errorf("duplicate user name %q", userModel.Name)
+		}
+	}
+	userModel.ID = nextMapID(r.d.users)
+	r.d.users[userModel.ID] = *userModel
+	return nil
+}
+
+func (r *memoryUserRepository) Update(_ context.Context, userModel UserModel) error {
+	if u, ok := r.d.users[userModel.ID]; ok {
+		u.DisplayName = userModel.DisplayName
+		u.Description = userModel.Description
+		u.HashedPassword = userModel.HashedPassword
+		r.d.users[userModel.ID] = u
+	}
+	return nil
+}
+
+func (r *memoryUserRepository) CreateTheme(_ context.Context, theme ThemeModel) error {
+	if _, ok := r.d.themes[theme.UserID]; ok {
+		return fmt.Errorf("duplicate theme of user %d", theme.UserID)
+	}
+	var maxID int64
+	for _, t := range r.d.themes {
+		maxID = max(maxID, t.ID)
+	}
+	theme.ID = maxID + 1
+	r.d.themes[theme.UserID] = theme
+	return nil
+}
+
+func (r *memoryUserRepository) UpdateDarkMode(_ context.Context, userID int64, darkMode bool) error {
+	if t, ok := r.d.themes[userID]; ok {
+		t.DarkMode = darkMode
+		r.d.themes[userID] = t
+	}
+	return nil
+}
+
+// Delete はuser_handler.goのdeleteUserと同じく、ユーザの持つ行を削除し、他の配信に投稿したライブコメントを匿名化する
+func (r *memoryUserRepository) Delete(ctx context.Context, id int64) error {
+	livestreams := &memoryLivestreamRepository{d: r.d}
+	slots := &memoryReservationSlotRepository{d: r.d}
+	now := time.Now().Unix()
+	for _, l := range r.d.livestreams {
+		if l.UserID != id {
+			continue
+		}
+		if l.StartAt > now {
+			if err := slots.Release(ctx, reservationRange{StartAt: l.StartAt, EndAt: l.EndAt}); err != nil {
+				return err
+			}
+		}
+		if err := livestreams.Delete(ctx, l.ID); err != nil {
+			return err
+		}
+	}
+
+	// 削除したリアクション・スパム報告があった配信の統計情報を集計し直す
+	var affectedLivestreamIDs []int64
+	for _, v := range r.d.reactions {
+		if v.UserID == id && !slices.Contains(affectedLivestreamIDs, v.LivestreamID) {
+			affectedLivestreamIDs = append(affectedLivestreamIDs, v.LivestreamID)
+		}
+	}
+	for _, v := range r.d.reports {
+		if v.UserID == id && !slices.Contains(affectedLivestreamIDs, v.LivestreamID) {
+			affectedLivestreamIDs = append(affectedLivestreamIDs, v.LivestreamID)
+		}
+	}
+
+	r.d.follows = slices.DeleteFunc(r.d.follows, func(f FollowModel) bool {
+		return f.FollowerID == id || f.FolloweeID == id
+	})
+	for k, v := range r.d.livecomments {
+		if v.UserID == id {
+			v.UserID = deletedUserID
+			r.d.livecomments[k] = v
+		}
+	}
+	for k, v := range r.d.moderations {
+		if v.UserID == id {
+			v.UserID = deletedUserID
+			r.d.moderations[k] = v
+		}
+	}
+	for k, v := range r.d.tips {
+		if v.UserID.Valid && v.UserID.Int64 == id {
+			v.UserID = sql.NullInt64{}
+			r.d.tips[k] = v
+		}
+	}
+	for k, v := range r.d.reports {
+		if v.UserID == id {
+			delete(r.d.reports, k)
+		}
+	}
+	for k, v := range r.d.reactions {
+		if v.UserID == id {
+			delete(r.d.reactions, k)
+		}
+	}
+	r.d.viewersHistory = slices.DeleteFunc(r.d.viewersHistory, func(v LivestreamViewerModel) bool {
+		return v.UserID == id
+	})
+	r.d.collaborators = slices.DeleteFunc(r.d.collaborators, func(lc LivestreamCollaboratorModel) bool {
+		return lc.UserID == id
+	})
+	for k, v := range r.d.ngWords {
+		if v.UserID == id {
+			delete(r.d.ngWords, k)
+		}
+	}
+	delete(r.d.icons, id)
+	delete(r.d.themes, id)
+
+	r.d.recalculateStats(affectedLivestreamIDs)
+	delete(r.d.userStats, id)
+	delete(r.d.users, id)
+	return nil
+}
+
+type memoryLivestreamRepository struct {
+	d *memoryData
+}
+
+func (r *memoryLivestreamRepository) FindByID(_ context.Context, id int64) (LivestreamModel, error) {
+	livestreamModel, ok := r.d.livestreams[id]
+	if !ok {
+		return LivestreamModel{}, ErrRecordNotFound
+	}
+	return livestreamModel, nil
+}
+
+func (r *memoryLivestreamRepository) FindByIDForUpdate(ctx context.Context, id int64) (LivestreamModel, error) {
+	// トランザクションは直列に実行するので、ロックは不要
+	return r.FindByID(ctx, id)
+}
+
+func (r *memoryLivestreamRepository) FindByIDs(_ context.Context, ids []int64) (map[int64]LivestreamModel, error) {
+	return findByIDs(r.d.livestreams, ids), nil
+}
+
+func (r *memoryLivestreamRepository) ListByUserID(_ context.Context, userID int64) ([]*LivestreamModel, error) {
+	livestreamModels := []*LivestreamModel{}
+	for _, l := range r.d.livestreams {
+		if l.UserID == userID {
+			l := l
+			livestreamModels = append(livestreamModels, &l)
+		}
+	}
+	sort.Slice(livestreamModels, func(i, j int) bool {
+		return livestreamModels[i].ID < livestreamModels[j].ID
+	})
+	return livestreamModels, nil
+}
+
+func (r *memoryLivestreamRepository) Tags(_ context.Context, livestreamIDs []int64) (map[int64][]Tag, error) {
+	livestreamTags := make(map[int64][]Tag, len(livestreamIDs))
+	for _, livestreamID := range livestreamIDs {
+		livestreamTags[livestreamID] = []Tag{}
+	}
+	for _, lt := range r.d.livestreamTags {
+		tags, ok := livestreamTags[lt.LivestreamID]
+		if !ok {
+			continue
+		}
+		tagModel, ok := r.d.tags[lt.TagID]
+		if !ok {
+			continue
+		}
+		livestreamTags[lt.LivestreamID] = append(tags, Tag{
+			ID:   tagModel.ID,
+			Name: tagModel.Name,
+		})
+	}
+	return livestreamTags, nil
+}
+
+func (r *memoryLivestreamRepository) Collaborators(_ context.Context, livestreamIDs []int64) ([]*LivestreamCollaboratorModel, error) {
+	collaboratorModels := []*LivestreamCollaboratorModel{}
+	for _, lc := range r.d.collaborators {
+		for _, livestreamID := range livestreamIDs {
+			if lc.LivestreamID == livestreamID {
+				lc := lc
+				collaboratorModels = append(collaboratorModels, &lc)
+				break
+			}
+		}
+	}
+	return collaboratorModels, nil
+}
+
+func (r *memoryLivestreamRepository) IsModerator(_ context.Context, livestream LivestreamModel, userID int64) (bool, error) {
+	if livestream.UserID == userID {
+		return true, nil
+	}
+	for _, lc := range r.d.collaborators {
+		if lc.LivestreamID == livestream.ID && lc.UserID == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memoryLivestreamRepository) Create(_ context.Context, livestreamModel *LivestreamModel) error {
+	livestreamModel.ID = nextMapID(r.d.livestreams)
+	r.d.livestreams[livestreamModel.ID] = *livestreamModel
+	return nil
+}
+
+func (r *memoryLivestreamRepository) Update(_ context.Context, livestream LivestreamModel) error {
+	if _, ok := r.d.livestreams[livestream.ID]; ok {
+		r.d.livestreams[livestream.ID] = livestream
+	}
+	return nil
+}
+
+func (r *memoryLivestreamRepository) SetTags(_ context.Context, livestreamID int64, tagIDs []int64) error {
+	r.d.livestreamTags = slices.DeleteFunc(r.d.livestreamTags, func(lt LivestreamTagModel) bool {
+		return lt.LivestreamID == livestreamID
+	})
+	for _, tagID := range tagIDs {
+		r.d.livestreamTags = append(r.d.livestreamTags, LivestreamTagModel{
+			ID:           nextID(r.d.livestreamTags, func(lt LivestreamTagModel) int64 { return lt.ID }),
+			LivestreamID: livestreamID,
+			TagID:        tagID,
+		})
+	}
+	return nil
+}
+
+func (r *memoryLivestreamRepository) SetCollaborators(_ context.Context, livestreamID int64, userIDs []int64) error {
+	r.d.collaborators = slices.DeleteFunc(r.d.collaborators, func(lc LivestreamCollaboratorModel) bool {
+		return lc.LivestreamID == livestreamID
+	})
+	for _, userID := range userIDs {
+		r.d.collaborators = append(r.d.collaborators, LivestreamCollaboratorModel{
+			ID:           nextID(r.d.collaborators, func(lc LivestreamCollaboratorModel) int64 { return lc.ID }),
+			LivestreamID: livestreamID,
+			UserID:       userID,
+		})
+	}
+	return nil
+}
+
+// Search はLivestreamSearchQuery.whereClauseと同じ条件で配信を絞り込む
+func (r *memoryLivestreamRepository) Search(_ context.Context, q *LivestreamSearchQuery, page *pageRequest) ([]*LivestreamModel, error) {
+	tags := uniqueStrings(q.Tags)
+	statuses := uniqueStrings(q.Statuses)
+
+	var livestreamModels []*LivestreamModel
+	for _, l := range r.d.livestreams {
+		if len(tags) > 0 {
+			hits := map[int64]struct{}{}
+			for _, lt := range r.d.livestreamTags {
+				if lt.LivestreamID == l.ID && slices.Contains(tags, r.d.tags[lt.TagID].Name) {
+					hits[lt.TagID] = struct{}{}
+				}
+			}
+			if len(hits) == 0 || (q.TagMode == searchTagModeAnd && len(hits) != len(tags)) {
+				continue
+			}
+		}
+		if q.Keyword != "" && !strings.Contains(l.Title, q.Keyword) && !strings.Contains(l.Description, q.Keyword) {
+			continue
+		}
+		if q.Owner != "" && r.d.users[l.UserID].Name != q.Owner {
+			continue
+		}
+		if q.StartAt != 0 && l.EndAt <= q.StartAt {
+			continue
+		}
+		if q.EndAt != 0 && l.StartAt >= q.EndAt {
+			continue
+		}
+		if len(statuses) > 0 && !slices.Contains(statuses, livestreamStatus(l.StartAt, l.EndAt, q.Now)) {
+			continue
+		}
+		l := l
+		livestreamModels = append(livestreamModels, &l)
+	}
+	return keysetPage(page, livestreamModels, func(l *LivestreamModel) (int64, int64) {
+		return 0, l.ID
+	}), nil
+}
+
+func (r *memoryLivestreamRepository) Delete(_ context.Context, id int64) error {
+	r.d.removeLivestreamStats(id)
+	delete(r.d.livestreams, id)
+
+	livestreamTags := r.d.livestreamTags[:0]
+	for _, lt := range r.d.livestreamTags {
+		if lt.LivestreamID != id {
+			livestreamTags = append(livestreamTags, lt)
+		}
+	}
+	r.d.livestreamTags = livestreamTags
+
+	collaborators := r.d.collaborators[:0]
+	for _, lc := range r.d.collaborators {
+		if lc.LivestreamID != id {
+			collaborators = append(collaborators, lc)
+		}
+	}
+	r.d.collaborators = collaborators
+
+	for k, v := range r.d.livecomments {
+		if v.LivestreamID == id {
+			delete(r.d.livecomments, k)
+		}
+	}
+	for k, v := range r.d.reactions {
+		if v.LivestreamID == id {
+			delete(r.d.reactions, k)
+		}
+	}
+	for k, v := range r.d.reports {
+		if v.LivestreamID == id {
+			delete(r.d.reports, k)
+		}
+	}
+	for k, v := range r.d.ngWords {
+		if v.LivestreamID == id {
+			delete(r.d.ngWords, k)
+		}
+	}
+	for k, v := range r.d.moderations {
+		if v.LivestreamID == id {
+			delete(r.d.moderations, k)
+		}
+	}
+	r.d.viewersHistory = slices.DeleteFunc(r.d.viewersHistory, func(v LivestreamViewerModel) bool {
+		return v.LivestreamID == id
+	})
+	for k, v := range r.d.tips {
+		if v.LivestreamID == id {
+			v.LivecommentID = sql.NullInt64{}
+			r.d.tips[k] = v
+		}
+	}
+	return nil
+}
+
+type memoryTagRepository struct {
+	d *memoryData
+}
+
+func (r *memoryTagRepository) List(_ context.Context) ([]*TagModel, error) {
+	tagModels := make([]*TagModel, 0, len(r.d.tags))
+	for _, t := range r.d.tags {
+		t := t
+		tagModels = append(tagModels, &t)
+	}
+	sort.Slice(tagModels, func(i, j int) bool {
+		return tagModels[i].ID < tagModels[j].ID
+	})
+	return tagModels, nil
+}
+
+type memoryLivecommentRepository struct {
+	d *memoryData
+}
+
+func (r *memoryLivecommentRepository) FindByID(_ context.Context, id int64) (LivecommentModel, error) {
+	livecommentModel, ok := r.d.livecomments[id]
+	if !ok {
+		return LivecommentModel{}, ErrRecordNotFound
+	}
+	return livecommentModel, nil
+}
+
+func (r *memoryLivecommentRepository) FindByIDs(_ context.Context, ids []int64) ([]LivecommentModel, error) {
+	livecommentModels := []LivecommentModel{}
+	for _, l := range findByIDs(r.d.livecomments, ids) {
+		livecommentModels = append(livecommentModels, l)
+	}
+	return livecommentModels, nil
+}
+
+func (r *memoryLivecommentRepository) FindInLivestream(_ context.Context, livestreamID int64, id int64) (LivecommentModel, error) {
+	livecommentModel, ok := r.d.livecomments[id]
+	if !ok || livecommentModel.LivestreamID != livestreamID {
+		return LivecommentModel{}, ErrRecordNotFound
+	}
+	return livecommentModel, nil
+}
+
+func (r *memoryLivecommentRepository) ListVisible(_ context.Context, livestreamID int64, page *pageRequest) ([]LivecommentModel, error) {
+	var livecommentModels []LivecommentModel
+	for _, l := range r.d.livecomments {
+		if l.LivestreamID == livestreamID && l.DeletedAt == 0 {
+			livecommentModels = append(livecommentModels, l)
+		}
+	}
+	return keysetPage(page, livecommentModels, func(l LivecommentModel) (int64, int64) {
+		return l.CreatedAt, l.ID
+	}), nil
+}
+
+func (r *memoryLivecommentRepository) FindInLivestreamForUpdate(ctx context.Context, livestreamID int64, id int64) (LivecommentModel, error) {
+	// トランザクションは直列に実行するので、ロックは不要
+	livecommentModel, err := r.FindInLivestream(ctx, livestreamID, id)
+	if err != nil {
+		return LivecommentModel{}, err
+	}
+	if livecommentModel.DeletedAt != 0 {
+		return LivecommentModel{}, ErrRecordNotFound
+	}
+	return livecommentModel, nil
+}
+
+func (r *memoryLivecommentRepository) ListHitNGWord(_ context.Context, livestream LivestreamModel, ngWordLivestreamID int64, _ string, matcher *ngWordMatcher) ([]LivecommentModel, error) {
+	hits := []LivecommentModel{}
+	for _, l := range r.d.livecomments {
+		if l.DeletedAt != 0 {
+			continue
+		}
+		if ngWordLivestreamID == channelNGWordLivestreamID {
+			if r.d.livestreams[l.LivestreamID].UserID != livestream.UserID {
+				continue
+			}
+		} else if l.LivestreamID != livestream.ID {
+			continue
+		}
+		if matcher.Match(l.Comment) {
+			hits = append(hits, l)
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		return hits[i].ID < hits[j].ID
+	})
+	return hits, nil
+}
+
+func (r *memoryLivecommentRepository) Create(_ context.Context, livecommentModel *LivecommentModel) error {
+	livecommentModel.ID = nextMapID(r.d.livecomments)
+	r.d.livecomments[livecommentModel.ID] = *livecommentModel
+	return nil
+}
+
+type memoryReactionRepository struct {
+	d *memoryData
+}
+
+func (r *memoryReactionRepository) FindInLivestream(_ context.Context, livestreamID int64, id int64) (ReactionModel, error) {
+	reactionModel, ok := r.d.reactions[id]
+	if !ok || reactionModel.LivestreamID != livestreamID {
+		return ReactionModel{}, ErrRecordNotFound
+	}
+	return reactionModel, nil
+}
+
+func (r *memoryReactionRepository) List(_ context.Context, livestreamID int64, page *pageRequest) ([]ReactionModel, error) {
+	var reactionModels []ReactionModel
+	for _, reaction := range r.d.reactions {
+		if reaction.LivestreamID == livestreamID {
+			reactionModels = append(reactionModels, reaction)
+		}
+	}
+	return keysetPage(page, reactionModels, func(r ReactionModel) (int64, int64) {
+		return r.CreatedAt, r.ID
+	}), nil
+}
+
+func (r *memoryReactionRepository) Create(_ context.Context, reactionModel *ReactionModel) error {
+	reactionModel.ID = nextMapID(r.d.reactions)
+	r.d.reactions[reactionModel.ID] = *reactionModel
+	return nil
+}
+
+type memoryReportRepository struct {
+	d *memoryData
+}
+
+func (r *memoryReportRepository) ListByLivestreamID(_ context.Context, livestreamID int64) ([]LivecommentReportModel, error) {
+	reportModels := []LivecommentReportModel{}
+	for _, report := range r.d.reports {
+		if report.LivestreamID == livestreamID {
+			reportModels = append(reportModels, report)
+		}
+	}
+	sort.Slice(reportModels, func(i, j int) bool {
+		return reportModels[i].ID < reportModels[j].ID
+	})
+	return reportModels, nil
+}
+
+func (r *memoryReportRepository) Create(_ context.Context, reportModel *LivecommentReportModel) error {
+	reportModel.ID = nextMapID(r.d.reports)
+	r.d.reports[reportModel.ID] = *reportModel
+	return nil
+}
+
+func (r *memoryReportRepository) CountOpenReporters(_ context.Context, livestreamID int64, livecommentID int64) (int64, error) {
+	reporters := map[int64]struct{}{}
+	for _, report := range r.d.reports {
+		if report.LivestreamID == livestreamID && report.LivecommentID == livecommentID && report.Status == reportStatusOpen {
+			reporters[report.UserID] = struct{}{}
+		}
+	}
+	return int64(len(reporters)), nil
+}
+
+func (r *memoryReportRepository) ListReported(_ context.Context, livestreamID int64, livecommentID int64, status string) ([]reportedLivecommentModel, error) {
+	if _, ok := reportStatusHavingClauses[status]; status != "" && !ok {
+		return nil, fmt.Errorf("unknown report status %q", status)
+	}
+
+	byLivecomment := map[int64]*reportedLivecommentModel{}
+	for _, report := range r.d.reports {
+		if report.LivestreamID != livestreamID || (livecommentID != 0 && report.LivecommentID != livecommentID) {
+			continue
+		}
+		m, ok := byLivecomment[report.LivecommentID]
+		if !ok {
+			m = &reportedLivecommentModel{LivecommentID: report.LivecommentID, FirstReportedAt: report.CreatedAt, LastReportedAt: report.CreatedAt}
+			byLivecomment[report.LivecommentID] = m
+		}
+		m.ReportCount++
+		switch report.Status {
+		case reportStatusOpen:
+			m.OpenCount++
+		case reportStatusActioned:
+			m.ActionedCount++
+		}
+		m.FirstReportedAt = min(m.FirstReportedAt, report.CreatedAt)
+		m.LastReportedAt = max(m.LastReportedAt, report.CreatedAt)
+	}
+
+	models := []reportedLivecommentModel{}
+	for _, m := range byLivecomment {
+		if status == "" || m.status() == status {
+			models = append(models, *m)
+		}
+	}
+	sort.Slice(models, func(i, j int) bool {
+		if models[i].LastReportedAt != models[j].LastReportedAt {
+			return models[i].LastReportedAt > models[j].LastReportedAt
+		}
+		return models[i].LivecommentID > models[j].LivecommentID
+	})
+	return models, nil
+}
+
+func (r *memoryReportRepository) Resolve(_ context.Context, livestreamID int64, livecommentID int64, status string) error {
+	for k, report := range r.d.reports {
+		if report.LivestreamID == livestreamID && report.LivecommentID == livecommentID && report.Status == reportStatusOpen {
+			report.Status = status
+			r.d.reports[k] = report
+		}
+	}
+	return nil
+}
+
+type memoryNGWordRepository struct {
+	d *memoryData
+}
+
+func (r *memoryNGWordRepository) List(_ context.Context, userID int64, livestreamIDs []int64) ([]*NGWord, error) {
+	var ngWords []*NGWord
+	for _, w := range r.d.ngWords {
+		if w.UserID != userID {
+			continue
+		}
+		for _, livestreamID := range livestreamIDs {
+			if w.LivestreamID == livestreamID {
+				w := w
+				ngWords = append(ngWords, &w)
+				break
+			}
+		}
+	}
+	sort.Slice(ngWords, func(i, j int) bool {
+		if ngWords[i].CreatedAt != ngWords[j].CreatedAt {
+			return ngWords[i].CreatedAt > ngWords[j].CreatedAt
+		}
+		return ngWords[i].ID < ngWords[j].ID
+	})
+	return ngWords, nil
+}
+
+func (r *memoryNGWordRepository) Create(_ context.Context, ngWord *NGWord) error {
+	ngWord.ID = nextMapID(r.d.ngWords)
+	r.d.ngWords[ngWord.ID] = *ngWord
+	return nil
+}
+
+func (r *memoryNGWordRepository) Delete(_ context.Context, id int64, userID int64, livestreamIDs []int64) error {
+	w, ok := r.d.ngWords[id]
+	if !ok || w.UserID != userID || !slices.Contains(livestreamIDs, w.LivestreamID) {
+		return ErrRecordNotFound
+	}
+	delete(r.d.ngWords, id)
+	return nil
+}
+
+type memoryReservationSlotRepository struct {
+	d *memoryData
+}
+
+func (r *memoryReservationSlotRepository) List(_ context.Context, from int64, to int64) ([]*ReservationSlotModel, error) {
+	slotModels := []*ReservationSlotModel{}
+	for _, s := range r.d.reservationSlots {
+		if s.StartAt >= from && s.EndAt <= to {
+			s := s
+			slotModels = append(slotModels, &s)
+		}
+	}
+	sort.Slice(slotModels, func(i, j int) bool {
+		return slotModels[i].StartAt < slotModels[j].StartAt
+	})
+	return slotModels, nil
+}
+
+func (r *memoryReservationSlotRepository) Lock(_ context.Context, ranges ...reservationRange) ([]*ReservationSlotModel, error) {
+	var slots []*ReservationSlotModel
+	for _, s := range r.d.reservationSlots {
+		for _, rr := range ranges {
+			if rr.contains(s) {
+				s := s
+				slots = append(slots, &s)
+				break
+			}
+		}
+	}
+	sort.Slice(slots, func(i, j int) bool {
+		return slots[i].StartAt < slots[j].StartAt
+	})
+	return slots, nil
+}
+
+func (r *memoryReservationSlotRepository) Consume(_ context.Context, rr reservationRange) error {
+	for i := range r.d.reservationSlots {
+		if rr.contains(r.d.reservationSlots[i]) {
+			r.d.reservationSlots[i].Slot--
+		}
+	}
+	return nil
+}
+
+func (r *memoryReservationSlotRepository) Release(_ context.Context, rr reservationRange) error {
+	for i := range r.d.reservationSlots {
+		if rr.contains(r.d.reservationSlots[i]) {
+			r.d.reservationSlots[i].Slot++
+		}
+	}
+	return nil
+}
+
+type memoryTipRepository struct {
+	d *memoryData
+}
+
+func (r *memoryTipRepository) Total(_ context.Context) (int64, error) {
+	var total int64
+	for _, t := range r.d.tips {
+		total += t.Amount
+	}
+	return total, nil
+}
+
+func (r *memoryTipRepository) Record(_ context.Context, livecommentModel LivecommentModel, streamerID int64) error {
+	if livecommentModel.Tip <= 0 {
+		return nil
+	}
+	id := nextMapID(r.d.tips)
+	r.d.tips[id] = TipModel{
+		ID:            id,
+		LivecommentID: sql.NullInt64{Int64: livecommentModel.ID, Valid: true},
+		LivestreamID:  livecommentModel.LivestreamID,
+		StreamerID:    streamerID,
+		UserID:        sql.NullInt64{Int64: livecommentModel.UserID, Valid: true},
+		Amount:        livecommentModel.Tip,
+		CreatedAt:     livecommentModel.CreatedAt,
+	}
+	return nil
+}
+
+func (r *memoryTipRepository) DailyEarnings(_ context.Context, streamerID int64, from int64, to int64) ([]dailyEarnings, error) {
+	byDay := map[int64]*dailyEarnings{}
+	for _, t := range r.d.tips {
+		if t.StreamerID != streamerID || t.CreatedAt < from || (to > 0 && t.CreatedAt >= to) {
+			continue
+		}
+		day := (t.CreatedAt + earningsUTCOffset) / 86400
+		d, ok := byDay[day]
+		if !ok {
+			d = &dailyEarnings{Day: day}
+			byDay[day] = d
+		}
+		d.TipsCount++
+		d.TotalTip += t.Amount
+	}
+
+	daily := make([]dailyEarnings, 0, len(byDay))
+	for _, d := range byDay {
+		daily = append(daily, *d)
+	}
+	sort.Slice(daily, func(i, j int) bool {
+		return daily[i].Day < daily[j].Day
+	})
+	return daily, nil
+}
+
+type memoryFollowRepository struct {
+	d *memoryData
+}
+
+func (r *memoryFollowRepository) Follow(_ context.Context, follow FollowModel) error {
+	for _, f := range r.d.follows {
+		if f.FollowerID == follow.FollowerID && f.FolloweeID == follow.FolloweeID {
+			return nil
+		}
+	}
+	follow.ID = nextID(r.d.follows, func(f FollowModel) int64 { return f.ID })
+	r.d.follows = append(r.d.follows, follow)
+	return nil
+}
+
+func (r *memoryFollowRepository) Unfollow(_ context.Context, followerID int64, followeeID int64) error {
+	r.d.follows = slices.DeleteFunc(r.d.follows, func(f FollowModel) bool {
+		return f.FollowerID == followerID && f.FolloweeID == followeeID
+	})
+	return nil
+}
+
+func (r *memoryFollowRepository) ListFollowees(_ context.Context, followerID int64) ([]UserModel, error) {
+	follows := []FollowModel{}
+	for _, f := range r.d.follows {
+		if f.FollowerID == followerID {
+			follows = append(follows, f)
+		}
+	}
+	sort.Slice(follows, func(i, j int) bool {
+		return follows[i].ID > follows[j].ID
+	})
+
+	followeeModels := []UserModel{}
+	for _, f := range follows {
+		if u, ok := r.d.users[f.FolloweeID]; ok {
+			followeeModels = append(followeeModels, u)
+		}
+	}
+	return followeeModels, nil
+}
+
+func (r *memoryFollowRepository) Feed(_ context.Context, followerID int64, now int64, limit int) ([]*LivestreamModel, error) {
+	livestreamModels := []*LivestreamModel{}
+	for _, f := range r.d.follows {
+		if f.FollowerID != followerID {
+			continue
+		}
+		for _, l := range r.d.livestreams {
+			if l.UserID == f.FolloweeID && l.EndAt > now {
+				l := l
+				livestreamModels = append(livestreamModels, &l)
+			}
+		}
+	}
+	sort.Slice(livestreamModels, func(i, j int) bool {
+		if livestreamModels[i].StartAt != livestreamModels[j].StartAt {
+			return livestreamModels[i].StartAt < livestreamModels[j].StartAt
+		}
+		return livestreamModels[i].ID < livestreamModels[j].ID
+	})
+	if len(livestreamModels) > limit {
+		livestreamModels = livestreamModels[:limit]
+	}
+	return livestreamModels, nil
+}
+
+type memoryModerationRepository struct {
+	d *memoryData
+}
+
+func (r *memoryModerationRepository) SoftDelete(_ context.Context, livecommentModels []LivecommentModel, reason string, ngWordID int64, moderatorID int64) error {
+	now := time.Now().Unix()
+	for _, l := range livecommentModels {
+		if v, ok := r.d.livecomments[l.ID]; ok && v.DeletedAt == 0 {
+			v.DeletedAt = now
+			r.d.livecomments[l.ID] = v
+		}
+		// livecomment_idのユニーク制約と同じく、既に履歴があれば残さない
+		moderated := false
+		for _, m := range r.d.moderations {
+			if m.LivecommentID == l.ID {
+				moderated = true
+				break
+			}
+		}
+		if moderated {
+			continue
+		}
+		id := nextMapID(r.d.moderations)
+		r.d.moderations[id] = LivecommentModerationModel{
+			ID:            id,
+			LivecommentID: l.ID,
+			LivestreamID:  l.LivestreamID,
+			Reason:        reason,
+			NGWordID:      ngWordID,
+			UserID:        moderatorID,
+			CreatedAt:     now,
+		}
+	}
+	return nil
+}
+
+func (r *memoryModerationRepository) List(_ context.Context, livestreamID int64, page *pageRequest) ([]LivecommentModerationModel, error) {
+	var moderationModels []LivecommentModerationModel
+	for _, m := range r.d.moderations {
+		if m.LivestreamID == livestreamID {
+			moderationModels = append(moderationModels, m)
+		}
+	}
+	return keysetPage(page, moderationModels, func(m LivecommentModerationModel) (int64, int64) {
+		return 0, m.ID
+	}), nil
+}
+
+// memoryStatsRepository はstats_counter.goのカウンタと同じ更新をする
+// 配信者の統計情報の行がない配信は、INNER JOINで更新されないのと同じく更新しない
+type memoryStatsRepository struct {
+	d *memoryData
+}
+
+func (r *memoryStatsRepository) CreateUser(_ context.Context, userID int64, name string) error {
+	if _, ok := r.d.userStats[userID]; ok {
+		return fmt.Errorf("duplicate user_stats of user %d", userID)
+	}
+	r.d.userStats[userID] = UserStatsModel{UserID: userID, Name: name}
+	return nil
+}
+
+func (r *memoryStatsRepository) CreateLivestream(_ context.Context, livestreamID int64, userID int64) error {
+	if _, ok := r.d.livestreamStats[livestreamID]; ok {
+		return fmt.Errorf("duplicate livestream_stats of livestream %d", livestreamID)
+	}
+	r.d.livestreamStats[livestreamID] = LivestreamStatsModel{LivestreamID: livestreamID, UserID: userID}
+	return nil
+}
+
+// update は配信と配信者の統計情報をfnで更新し、スコアを計算し直す
+func (r *memoryStatsRepository) update(livestreamID int64, fn func(s *LivestreamStatsModel, u *UserStatsModel)) {
+	s, ok := r.d.livestreamStats[livestreamID]
+	if !ok {
+		return
+	}
+	u, ok := r.d.userStats[s.UserID]
+	if !ok {
+		return
+	}
+	fn(&s, &u)
+	s.Score = s.Reactions + s.TotalTip
+	u.Score = u.Reactions + u.TotalTip
+	r.d.livestreamStats[livestreamID] = s
+	r.d.userStats[u.UserID] = u
+}
+
+func (r *memoryStatsRepository) CountReaction(_ context.Context, livestreamID int64) error {
+	r.update(livestreamID, func(s *LivestreamStatsModel, u *UserStatsModel) {
+		s.Reactions++
+		u.Reactions++
+	})
+	return nil
+}
+
+func (r *memoryStatsRepository) CountLivecomment(_ context.Context, livestreamID int64, tip int64) error {
+	r.update(livestreamID, func(s *LivestreamStatsModel, u *UserStatsModel) {
+		s.Livecomments++
+		s.TotalTip += tip
+		s.MaxTip = max(s.MaxTip, tip)
+		u.Livecomments++
+		u.TotalTip += tip
+	})
+	return nil
+}
+
+func (r *memoryStatsRepository) CountReport(_ context.Context, livestreamID int64) error {
+	if s, ok := r.d.livestreamStats[livestreamID]; ok {
+		s.Reports++
+		r.d.livestreamStats[livestreamID] = s
+	}
+	return nil
+}
+
+func (r *memoryStatsRepository) Livestream(_ context.Context, livestreamID int64) (LivestreamStatsModel, error) {
+	s, ok := r.d.livestreamStats[livestreamID]
+	if !ok {
+		return LivestreamStatsModel{}, ErrRecordNotFound
+	}
+	return s, nil
+}
+
+func (r *memoryStatsRepository) User(_ context.Context, userID int64) (UserStatsModel, error) {
+	u, ok := r.d.userStats[userID]
+	if !ok {
+		return UserStatsModel{}, ErrRecordNotFound
+	}
+	return u, nil
+}
+
+func (r *memoryStatsRepository) LivestreamRank(_ context.Context, stats LivestreamStatsModel) (int64, error) {
+	var higher int64
+	for _, s := range r.d.livestreamStats {
+		if s.Score > stats.Score || (s.Score == stats.Score && s.LivestreamID > stats.LivestreamID) {
+			higher++
+		}
+	}
+	return higher + 1, nil
+}
+
+func (r *memoryStatsRepository) UserRank(_ context.Context, stats UserStatsModel) (int64, error) {
+	var higher int64
+	for _, u := range r.d.userStats {
+		if u.Score > stats.Score || (u.Score == stats.Score && u.Name > stats.Name) {
+			higher++
+		}
+	}
+	return higher + 1, nil
+}
+
+func (r *memoryStatsRepository) FavoriteEmoji(_ context.Context, userID int64) (string, error) {
+	counts := map[string]int64{}
+	for _, reaction := range r.d.reactions {
+		if l, ok := r.d.livestreams[reaction.LivestreamID]; ok && l.UserID == userID {
+			counts[reaction.EmojiName]++
+		}
+	}
+
+	var (
+		favoriteEmoji string
+		maxCount      int64
+	)
+	for emoji, count := range counts {
+		if count > maxCount || (count == maxCount && emoji > favoriteEmoji) {
+			favoriteEmoji, maxCount = emoji, count
+		}
+	}
+	return favoriteEmoji, nil
+}
+
+type memoryViewerRepository struct {
+	d *memoryData
+}
+
+func (r *memoryViewerRepository) Enter(_ context.Context, viewer LivestreamViewerModel) error {
+	r.d.viewersHistory = append(r.d.viewersHistory, viewer)
+	return nil
+}
+
+func (r *memoryViewerRepository) Exit(_ context.Context, userID int64, livestreamID int64, now int64) error {
+	for i, v := range r.d.viewersHistory {
+		if v.UserID == userID && v.LivestreamID == livestreamID && v.LeftAt == 0 {
+			r.d.viewersHistory[i].LeftAt = now
+		}
+	}
+	return nil
+}
+
+func (r *memoryViewerRepository) Heartbeat(_ context.Context, userID int64, livestreamID int64, now int64) error {
+	found := false
+	for i, v := range r.d.viewersHistory {
+		if v.UserID == userID && v.LivestreamID == livestreamID && v.LeftAt == 0 {
+			r.d.viewersHistory[i].LastSeenAt = now
+			found = true
+		}
+	}
+	if !found {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *memoryViewerRepository) Count(_ context.Context, livestreamIDs []int64, now time.Time) (ViewerCounts, error) {
+	var counts ViewerCounts
+	staleBefore := now.Add(-viewerHeartbeatTimeout).Unix()
+	concurrent := map[int64]struct{}{}
+	unique := map[int64]struct{}{}
+	for _, v := range r.d.viewersHistory {
+		if !slices.Contains(livestreamIDs, v.LivestreamID) {
+			continue
+		}
+		if v.LeftAt == 0 {
+			counts.Present++
+			if v.LastSeenAt >= staleBefore {
+				concurrent[v.UserID] = struct{}{}
+			}
+		}
+		unique[v.UserID] = struct{}{}
+	}
+	counts.Concurrent = int64(len(concurrent))
+	counts.Unique = int64(len(unique))
+	return counts, nil
+}
+
+// removeLivestreamStats はstats_counter.goのremoveLivestreamStatsと同じく、配信の統計情報を配信者から差し引いて削除する
+func (d *memoryData) removeLivestreamStats(livestreamID int64) {
+	s, ok := d.livestreamStats[livestreamID]
+	if !ok {
+		return
+	}
+	if u, ok := d.userStats[s.UserID]; ok {
+		u.Reactions -= s.Reactions
+		u.Livecomments -= s.Livecomments
+		u.TotalTip -= s.TotalTip
+		u.Score = u.Reactions + u.TotalTip
+		d.userStats[u.UserID] = u
+	}
+	delete(d.livestreamStats, livestreamID)
+}
+
+// recalculateStats はstats_counter.goのrecalculateStatsと同じく、配信と配信者の統計情報を元の行から集計し直す
+func (d *memoryData) recalculateStats(livestreamIDs []int64) {
+	var userIDs []int64
+	for _, livestreamID := range livestreamIDs {
+		s, ok := d.livestreamStats[livestreamID]
+		if !ok {
+			continue
+		}
+		s.Reactions, s.Livecomments, s.TotalTip, s.MaxTip, s.Reports = 0, 0, 0, 0, 0
+		for _, reaction := range d.reactions {
+			if reaction.LivestreamID == livestreamID {
+				s.Reactions++
+			}
+		}
+		// 論理削除したライブコメントも数える
+		for _, l := range d.livecomments {
+			if l.LivestreamID == livestreamID {
+				s.Livecomments++
+				s.TotalTip += l.Tip
+				s.MaxTip = max(s.MaxTip, l.Tip)
+			}
+		}
+		for _, report := range d.reports {
+			if report.LivestreamID == livestreamID {
+				s.Reports++
+			}
+		}
+		s.Score = s.Reactions + s.TotalTip
+		d.livestreamStats[livestreamID] = s
+		if !slices.Contains(userIDs, s.UserID) {
+			userIDs = append(userIDs, s.UserID)
+		}
+	}
+
+	for _, userID := range userIDs {
+		u, ok := d.userStats[userID]
+		if !ok {
+			continue
+		}
+		u.Reactions, u.Livecomments, u.TotalTip = 0, 0, 0
+		for _, s := range d.livestreamStats {
+			if s.UserID == userID {
+				u.Reactions += s.Reactions
+				u.Livecomments += s.Livecomments
+				u.TotalTip += s.TotalTip
+			}
+		}
+		u.Score = u.Reactions + u.TotalTip
+		d.userStats[userID] = u
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// テスト用のフィクスチャ
+// streamerが配信1, 2を持ち、配信1にはcollaboratorがコラボレーターとして参加している
+const (
+	testStreamerID     int64 = 1
+	testViewerID       int64 = 2
+	testCollaboratorID int64 = 3
+
+	testLivestreamID      int64 = 1
+	testOtherLivestreamID int64 = 2
+)
+
+var testLivestreamStartAt = time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestRepositoryStore はフィクスチャを入れたインメモリのリポジトリをrepositoryStoreに設定して返す
+func newTestRepositoryStore(t *testing.T) *memoryRepositoryStore {
+	t.Helper()
+
+	s := newMemoryRepositoryStore()
+	d := s.data
+	prevFallbackImageHash := fallbackImageHash
+	t.Cleanup(func() { fallbackImageHash = prevFallbackImageHash })
+	fallbackImageHash = "fallback"
+	for _, u := range []UserModel{
+		{ID: testStreamerID, Name: "streamer", DisplayName: "配信者"},
+		{ID: testViewerID, Name: "viewer", DisplayName: "視聴者"},
+		{ID: testCollaboratorID, Name: "collaborator", DisplayName: "コラボレーター"},
+	} {
+		d.users[u.ID] = u
+		d.themes[u.ID] = ThemeModel{ID: u.ID, UserID: u.ID, DarkMode: u.ID == testStreamerID}
+		d.userStats[u.ID] = UserStatsModel{UserID: u.ID, Name: u.Name}
+	}
+	d.icons[testStreamerID] = IconModel{ID: 1, UserID: testStreamerID, Hash: "streamer-icon"}
+	d.follows = []FollowModel{{ID: 1, FollowerID: testViewerID, FolloweeID: testStreamerID}}
+
+	startAt, endAt := testLivestreamStartAt.Unix(), testLivestreamStartAt.Add(time.Hour).Unix()
+	d.livestreams[testLivestreamID] = LivestreamModel{ID: testLivestreamID, UserID: testStreamerID, Title: "配信1", StartAt: startAt, EndAt: endAt}
+	d.livestreams[testOtherLivestreamID] = LivestreamModel{ID: testOtherLivestreamID, UserID: testStreamerID, Title: "配信2", StartAt: endAt, EndAt: endAt + 3600}
+	d.tags[1] = TagModel{ID: 1, Name: "ライブ配信"}
+	d.tags[2] = TagModel{ID: 2, Name: "ゲーム実況"}
+	d.livestreamTags = []LivestreamTagModel{
+		{ID: 1, LivestreamID: testLivestreamID, TagID: 2},
+		{ID: 2, LivestreamID: testLivestreamID, TagID: 1},
+	}
+	d.collaborators = []LivestreamCollaboratorModel{{ID: 1, LivestreamID: testLivestreamID, UserID: testCollaboratorID}}
+
+	for _, l := range []LivecommentModel{
+		{ID: 1, UserID: testViewerID, LivestreamID: testLivestreamID, Comment: "こんにちは", CreatedAt: 100},
+		{ID: 2, UserID: testViewerID, LivestreamID: testLivestreamID, Comment: "投げ銭です", Tip: 500, CreatedAt: 200},
+		{ID: 3, UserID: testViewerID, LivestreamID: testLivestreamID, Comment: "スパム", CreatedAt: 300, DeletedAt: 350},
+		{ID: 4, UserID: testCollaboratorID, LivestreamID: testLivestreamID, Comment: "よろしく", CreatedAt: 400},
+		{ID: 5, UserID: testViewerID, LivestreamID: testOtherLivestreamID, Comment: "別の配信", CreatedAt: 500},
+	} {
+		d.livecomments[l.ID] = l
+	}
+	for _, r := range []ReactionModel{
+		{ID: 1, UserID: testViewerID, LivestreamID: testLivestreamID, EmojiName: "smile", CreatedAt: 100},
+		{ID: 2, UserID: testCollaboratorID, LivestreamID: testLivestreamID, EmojiName: "heart", CreatedAt: 200},
+		{ID: 3, UserID: testViewerID, LivestreamID: testLivestreamID, EmojiName: "clap", CreatedAt: 200},
+	} {
+		d.reactions[r.ID] = r
+	}
+	d.reports[1] = LivecommentReportModel{ID: 1, UserID: testViewerID, LivestreamID: testLivestreamID, LivecommentID: 3, Status: reportStatusOpen, CreatedAt: 320}
+	for _, w := range []NGWord{
+		{ID: 1, UserID: testStreamerID, LivestreamID: testLivestreamID, Word: "foo", MatchMode: "substring", CreatedAt: 100},
+		{ID: 2, UserID: testStreamerID, LivestreamID: channelNGWordLivestreamID, Word: "bar", MatchMode: "substring", CreatedAt: 200},
+		{ID: 3, UserID: testStreamerID, LivestreamID: testOtherLivestreamID, Word: "baz", MatchMode: "substring", CreatedAt: 300},
+	} {
+		d.ngWords[w.ID] = w
+	}
+	d.reservationSlots = []ReservationSlotModel{
+		{ID: 1, Slot: 4, StartAt: startAt, EndAt: endAt},
+		{ID: 2, Slot: 4, StartAt: endAt, EndAt: endAt + 3600},
+	}
+
+	// 統計情報は上の行から集計する
+	for _, l := range d.livestreams {
+		d.livestreamStats[l.ID] = LivestreamStatsModel{LivestreamID: l.ID, UserID: l.UserID}
+	}
+	d.recalculateStats([]int64{testLivestreamID, testOtherLivestreamID})
+
+	repositoryStore = s
+	return s
+}
+
+// serveTestRequest はルーティングとセッションを本番と同じように設定して、ハンドラにリクエストを送る
+// userIDが0でなければ、ログイン済みのセッションを用意する
+func serveTestRequest(t *testing.T, method, path, target string, userID int64, handler echo.HandlerFunc) *httptest.ResponseRecorder {
+	t.Helper()
+	return serveTestJSONRequest(t, method, path, target, "", userID, handler)
+}
+
+// serveTestJSONRequest はbodyをJSONのリクエストボディとして送る
+func serveTestJSONRequest(t *testing.T, method, path, target, body string, userID int64, handler echo.HandlerFunc) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	return serveTestHTTPRequest(t, path, req, userID, handler)
+}
+
+// serveTestHTTPRequest はヘッダなどを設定済みのreqを送る
+func serveTestHTTPRequest(t *testing.T, path string, req *http.Request, userID int64, handler echo.HandlerFunc) *httptest.ResponseRecorder {
+	t.Helper()
+
+	e := echo.New()
+	e.Logger.SetOutput(io.Discard)
+	e.HTTPErrorHandler = errorResponseHandler
+	e.Use(session.Middleware(sessions.NewCookieStore([]byte("test"))))
+
+	sessionStore = newMemorySessionStore()
+	e.Add(req.Method, path, handler, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if userID == 0 {
+				return next(c)
+			}
+			// session.Getはリクエストごとにセッションを保持するので、ここで設定した値をハンドラから読める
+			sess, err := session.Get(defaultSessionIDKey, c)
+			if err != nil {
+				return err
+			}
+			sessionID := "test-session"
+			expiresAt := time.Now().Add(time.Hour).Unix()
+			sess.Values[defaultSessionIDKey] = sessionID
+			sess.Values[defaultUserIDKey] = userID
+			sess.Values[defaultSessionExpiresKey] = expiresAt
+			if err := sessionStore.Create(c.Request().Context(), &SessionModel{ID: sessionID, UserID: userID, ExpiresAt: expiresAt}); err != nil {
+				return err
+			}
+			return next(c)
+		}
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+// moveTestLivestream は配信と、配信が消費している予約枠を、現在時刻から見てstatusになる時間帯に移す
+func moveTestLivestream(s *memoryRepositoryStore, livestreamID int64, status string) {
+	startAt := time.Now().Truncate(time.Hour)
+	switch status {
+	case livestreamStatusUpcoming:
+		startAt = startAt.Add(24 * time.Hour)
+	case livestreamStatusEnded:
+		startAt = startAt.Add(-24 * time.Hour)
+	}
+
+	l := s.data.livestreams[livestreamID]
+	for i, slot := range s.data.reservationSlots {
+		if slot.StartAt == l.StartAt && slot.EndAt == l.EndAt {
+			s.data.reservationSlots[i].StartAt = startAt.Unix()
+			s.data.reservationSlots[i].EndAt = startAt.Add(time.Hour).Unix()
+		}
+	}
+	l.StartAt, l.EndAt = startAt.Unix(), startAt.Add(time.Hour).Unix()
+	s.data.livestreams[livestreamID] = l
+}
+
+func decodeResponse[T any](t *testing.T, rec *httptest.ResponseRecorder) T {
+	t.Helper()
+
+	var v T
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &v))
+	return v
+}
+
+func TestMemoryRepositoryStoreRollback(t *testing.T) {
+	s := newTestRepositoryStore(t)
+	ctx := context.Background()
+
+	err := s.Tx(ctx, func(r Repository) error {
+		if err := r.Livestreams().Delete(ctx, testLivestreamID); err != nil {
+			return err
+		}
+		if err := r.ReservationSlots().Release(ctx, reservationRange{StartAt: testLivestreamStartAt.Unix(), EndAt: testLivestreamStartAt.Add(time.Hour).Unix()}); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	})
+	assert.EqualError(t, err, "rollback")
+
+	// エラーを返したトランザクションの変更は残らない
+	assert.NoError(t, s.Tx(ctx, func(r Repository) error {
+		_, err := r.Livestreams().FindByID(ctx, testLivestreamID)
+		assert.NoError(t, err)
+		slots, err := r.ReservationSlots().Lock(ctx, reservationRange{StartAt: testLivestreamStartAt.Unix(), EndAt: testLivestreamStartAt.Add(time.Hour).Unix()})
+		assert.NoError(t, err)
+		if assert.Len(t, slots, 1) {
+			assert.Equal(t, int64(4), slots[0].Slot)
+		}
+		tags, err := r.Livestreams().Tags(ctx, []int64{testLivestreamID})
+		assert.NoError(t, err)
+		assert.Len(t, tags[testLivestreamID], 2)
+		return nil
+	}))
+}
+
+func TestKeysetPage(t *testing.T) {
+	type row struct{ sortKey, id int64 }
+	rows := []row{{100, 1}, {200, 3}, {200, 2}, {300, 4}, {400, 5}}
+	key := func(r row) (int64, int64) { return r.sortKey, r.id }
+
+	tests := []struct {
+		name string
+		page *pageRequest
+		want []int64
+	}{
+		{
+			name: "newest first",
+			page: &pageRequest{},
+			want: []int64{5, 4, 3, 2, 1},
+		},
+		{
+			name: "limit fetches one more row",
+			page: &pageRequest{Limit: 2},
+			want: []int64{5, 4, 3},
+		},
+		{
+			name: "before cursor",
+			page: &pageRequest{Limit: 2, Cursor: &pageCursor{SortKey: 200, ID: 3}},
+			want: []int64{2, 1},
+		},
+		{
+			name: "after cursor in ascending order",
+			page: &pageRequest{Limit: 1, Cursor: &pageCursor{After: true, SortKey: 200, ID: 2}},
+			want: []int64{3, 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int64
+			for _, r := range keysetPage(tt.page, rows, key) {
+				got = append(got, r.id)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// 未ログインのリクエストはどのハンドラでもリポジトリに触れずに弾く
+func TestHandlersRequireSession(t *testing.T) {
+	newTestRepositoryStore(t)
+
+	tests := []struct {
+		method  string
+		path    string
+		target  string
+		handler echo.HandlerFunc
+	}{
+		{http.MethodGet, "/api/user/me", "/api/user/me", getMeHandler},
+		{http.MethodDelete, "/api/user/me", "/api/user/me", deleteMeHandler},
+		{http.MethodPost, "/api/logout", "/api/logout", logoutHandler},
+		{http.MethodPost, "/api/logout/all", "/api/logout/all", logoutAllHandler},
+		{http.MethodGet, "/api/user/:username", "/api/user/streamer", getUserHandler},
+		{http.MethodGet, "/api/user/:username/theme", "/api/user/streamer/theme", getStreamerThemeHandler},
+		{http.MethodPost, "/api/livestream/reservation", "/api/livestream/reservation", reserveLivestreamHandler},
+		{http.MethodGet, "/api/reservation_slots", "/api/reservation_slots", getReservationSlotsHandler},
+		{http.MethodGet, "/api/reservation_slots/next", "/api/reservation_slots/next", getNextReservationWindowHandler},
+		{http.MethodGet, "/api/livestream", "/api/livestream", getMyLivestreamsHandler},
+		{http.MethodGet, "/api/user/:username/livestream", "/api/user/streamer/livestream", getUserLivestreamsHandler},
+		{http.MethodGet, "/api/livestream/:livestream_id", "/api/livestream/1", getLivestreamHandler},
+		{http.MethodPatch, "/api/livestream/:livestream_id", "/api/livestream/1", updateLivestreamHandler},
+		{http.MethodDelete, "/api/livestream/:livestream_id", "/api/livestream/1", cancelLivestreamHandler},
+		{http.MethodGet, "/api/livestream/:livestream_id/report", "/api/livestream/1/report", getLivecommentReportsHandler},
+		{http.MethodGet, "/api/livestream/:livestream_id/livecomment", "/api/livestream/1/livecomment", getLivecommentsHandler},
+		{http.MethodGet, "/api/livestream/:livestream_id/ngwords", "/api/livestream/1/ngwords", getNgwords},
+		{http.MethodGet, "/api/livestream/:livestream_id/reaction", "/api/livestream/1/reaction", getReactionsHandler},
+		{http.MethodGet, "/api/livestream/:livestream_id/events", "/api/livestream/1/events", getLivestreamEventsHandler},
+		{http.MethodPost, "/api/livestream/:livestream_id/livecomment", "/api/livestream/1/livecomment", postLivecommentHandler},
+		{http.MethodPost, "/api/livestream/:livestream_id/reaction", "/api/livestream/1/reaction", postReactionHandler},
+		{http.MethodPost, "/api/livestream/:livestream_id/livecomment/:livecomment_id/report", "/api/livestream/1/livecomment/1/report", reportLivecommentHandler},
+		{http.MethodPost, "/api/livestream/:livestream_id/moderate", "/api/livestream/1/moderate", moderateHandler},
+		{http.MethodDelete, "/api/livestream/:livestream_id/ngwords/:ngword_id", "/api/livestream/1/ngwords/1", deleteNgwordHandler},
+		{http.MethodDelete, "/api/livestream/:livestream_id/livecomment/:livecomment_id", "/api/livestream/1/livecomment/1", deleteLivecommentHandler},
+		{http.MethodGet, "/api/livestream/:livestream_id/moderations", "/api/livestream/1/moderations", getLivecommentModerationsHandler},
+		{http.MethodGet, "/api/livestream/:livestream_id/report/livecomments", "/api/livestream/1/report/livecomments", getReportedLivecommentsHandler},
+		{http.MethodPost, "/api/livestream/:livestream_id/livecomment/:livecomment_id/report/dismiss", "/api/livestream/1/livecomment/3/report/dismiss", dismissLivecommentReportsHandler},
+		{http.MethodPost, "/api/livestream/:livestream_id/livecomment/:livecomment_id/report/delete", "/api/livestream/1/livecomment/3/report/delete", deleteReportedLivecommentHandler},
+		{http.MethodPost, "/api/livestream/:livestream_id/enter", "/api/livestream/1/enter", enterLivestreamHandler},
+		{http.MethodDelete, "/api/livestream/:livestream_id/exit", "/api/livestream/1/exit", exitLivestreamHandler},
+		{http.MethodPost, "/api/livestream/:livestream_id/heartbeat", "/api/livestream/1/heartbeat", heartbeatLivestreamHandler},
+		{http.MethodGet, "/api/livestream/:livestream_id/statistics", "/api/livestream/1/statistics", getLivestreamStatisticsHandler},
+		{http.MethodPatch, "/api/user/me", "/api/user/me", updateMeHandler},
+		{http.MethodPut, "/api/user/me/password", "/api/user/me/password", updatePasswordHandler},
+		{http.MethodGet, "/api/user/me/following", "/api/user/me/following", getFollowingHandler},
+		{http.MethodGet, "/api/user/me/earnings", "/api/user/me/earnings", getMyEarningsHandler},
+		{http.MethodPost, "/api/user/:username/follow", "/api/user/streamer/follow", followHandler},
+		{http.MethodDelete, "/api/user/:username/follow", "/api/user/streamer/follow", unfollowHandler},
+		{http.MethodGet, "/api/feed", "/api/feed", getFeedHandler},
+		{http.MethodGet, "/api/user/:username/statistics", "/api/user/streamer/statistics", getUserStatisticsHandler},
+		{http.MethodPost, "/api/icon", "/api/icon", postIconHandler},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := serveTestRequest(t, tt.method, tt.path, tt.target, 0, tt.handler)
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,799 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type mysqlRepositoryStore struct {
+	db *sqlx.DB
+}
+
+func newMySQLRepositoryStore(db *sqlx.DB) *mysqlRepositoryStore {
+	return &mysqlRepositoryStore{db: db}
+}
+
+func (s *mysqlRepositoryStore) Tx(ctx context.Context, fn func(r Repository) error) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(newMySQLRepository(tx)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit: %w", err)
+	}
+	return nil
+}
+
+// mysqlRepository はトランザクションに紐づくリポジトリ
+// ユーザや配信など、ほとんど変更されない行はmodel_cache.goのキャッシュを通して読む
+type mysqlRepository struct {
+	tx     *sqlx.Tx
+	models *modelReader
+}
+
+func newMySQLRepository(tx *sqlx.Tx) *mysqlRepository {
+	return &mysqlRepository{tx: tx, models: newModelReader(tx)}
+}
+
+func (r *mysqlRepository) Users() UserRepository {
+	return &mysqlUserRepository{tx: r.tx, models: r.models}
+}
+
+func (r *mysqlRepository) Livestreams() LivestreamRepository {
+	return &mysqlLivestreamRepository{tx: r.tx, models: r.models}
+}
+
+func (r *mysqlRepository) Tags() TagRepository {
+	return &mysqlTagRepository{tx: r.tx, models: r.models}
+}
+
+func (r *mysqlRepository) Livecomments() LivecommentRepository {
+	return &mysqlLivecommentRepository{tx: r.tx, models: r.models}
+}
+
+func (r *mysqlRepository) Reactions() ReactionRepository {
+	return &mysqlReactionRepository{tx: r.tx, models: r.models}
+}
+
+func (r *mysqlRepository) Reports() ReportRepository {
+	return &mysqlReportRepository{tx: r.tx, models: r.models}
+}
+
+func (r *mysqlRepository) NGWords() NGWordRepository {
+	return &mysqlNGWordRepository{tx: r.tx, models: r.models}
+}
+
+func (r *mysqlRepository) ReservationSlots() ReservationSlotRepository {
+	return &mysqlReservationSlotRepository{tx: r.tx, models: r.models}
+}
+
+func (r *mysqlRepository) Tips() TipRepository {
+	return &mysqlTipRepository{tx: r.tx, models: r.models}
+}
+
+func (r *mysqlRepository) Follows() FollowRepository {
+	return &mysqlFollowRepository{tx: r.tx, models: r.models}
+}
+
+func (r *mysqlRepository) Moderations() ModerationRepository {
+	return &mysqlModerationRepository{tx: r.tx, models: r.models}
+}
+
+func (r *mysqlRepository) Stats() StatsRepository {
+	return &mysqlStatsRepository{tx: r.tx, models: r.models}
+}
+
+func (r *mysqlRepository) Viewers() ViewerRepository {
+	return &mysqlViewerRepository{tx: r.tx, models: r.models}
+}
+
+// recordNotFound はsql.ErrNoRowsをErrRecordNotFoundに置き換える
+func recordNotFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRecordNotFound
+	}
+	return err
+}
+
+type mysqlUserRepository struct {
+	tx     *sqlx.Tx
+	models *modelReader
+}
+
+func (r *mysqlUserRepository) FindByID(ctx context.Context, id int64) (UserModel, error) {
+	userModel, err := r.models.userModel(ctx, id)
+	return userModel, recordNotFound(err)
+}
+
+func (r *mysqlUserRepository) FindByIDForUpdate(ctx context.Context, id int64) (UserModel, error) {
+	var userModel UserModel
+	if err := r.tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE id = ? FOR UPDATE", id); err != nil {
+		return UserModel{}, recordNotFound(err)
+	}
+	return userModel, nil
+}
+
+func (r *mysqlUserRepository) FindByName(ctx context.Context, name string) (UserModel, error) {
+	var userModel UserModel
+	if err := r.tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE name = ?", name); err != nil {
+		return UserModel{}, recordNotFound(err)
+	}
+	return userModel, nil
+}
+
+func (r *mysqlUserRepository) FindByIDs(ctx context.Context, ids []int64) (map[int64]UserModel, error) {
+	return r.models.userModels(ctx, ids)
+}
+
+func (r *mysqlUserRepository) FindByNames(ctx context.Context, names []string) ([]UserModel, error) {
+	userModels := []UserModel{}
+	if len(names) == 0 {
+		return userModels, nil
+	}
+	query, params, err := sqlx.In("SELECT * FROM users WHERE name IN (?)", names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create users query: %w", err)
+	}
+	if err := r.tx.SelectContext(ctx, &userModels, query, params...); err != nil {
+		return nil, err
+	}
+	return userModels, nil
+}
+
+func (r *mysqlUserRepository) Themes(ctx context.Context, userIDs []int64) (map[int64]ThemeModel, error) {
+	return r.models.themeModels(ctx, userIDs)
+}
+
+func (r *mysqlUserRepository) IconHashes(ctx context.Context, userIDs []int64) (map[int64]string, error) {
+	return r.models.iconHashes(ctx, userIDs)
+}
+
+func (r *mysqlUserRepository) SetIcon(ctx context.Context, userID int64, hash string) (int64, error) {
+	r.models.markWritten()
+	if _, err := r.tx.ExecContext(ctx, "DELETE FROM icons WHERE user_id = ?", userID); err != nil {
+		return 0, fmt.Errorf("failed to delete old user icon: %w", err)
+	}
+	rs, err := r.tx.ExecContext(ctx, "INSERT INTO icons (user_id, hash) VALUES (?, ?)", userID, hash)
+	if err != nil {
+		return 0, err
+	}
+	iconID, err := rs.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last inserted icon id: %w", err)
+	}
+	return iconID, nil
+}
+
+func (r *mysqlUserRepository) FollowerCounts(ctx context.Context, userIDs []int64) (map[int64]int64, error) {
+	return countFollowers(ctx, r.tx, userIDs)
+}
+
+func (r *mysqlUserRepository) Create(ctx context.Context, userModel *UserModel) error {
+	r.models.markWritten()
+	rs, err := r.tx.NamedExecContext(ctx, "INSERT INTO users (name, display_name, description, password) VALUES(:name, :display_name, :description, :password)", userModel)
+	if err != nil {
+		return err
+	}
+	userID, err := rs.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last inserted user id: %w", err)
+	}
+	userModel.ID = userID
+	return nil
+}
+
+func (r *mysqlUserRepository) Update(ctx context.Context, userModel UserModel) error {
+	r.models.markWritten()
+	_, err := r.tx.NamedExecContext(ctx, "UPDATE users SET display_name = :display_name, description = :description, password = :password WHERE id = :id", userModel)
+	return err
+}
+
+func (r *mysqlUserRepository) CreateTheme(ctx context.Context, theme ThemeModel) error {
+	r.models.markWritten()
+	_, err := r.tx.NamedExecContext(ctx, "INSERT INTO themes (user_id, dark_mode) VALUES(:user_id, :dark_mode)", theme)
+	return err
+}
+
+func (r *mysqlUserRepository) UpdateDarkMode(ctx context.Context, userID int64, darkMode bool) error {
+	r.models.markWritten()
+	_, err := r.tx.ExecContext(ctx, "UPDATE themes SET dark_mode = ? WHERE user_id = ?", darkMode, userID)
+	return err
+}
+
+func (r *mysqlUserRepository) Delete(ctx context.Context, id int64) error {
+	r.models.markWritten()
+	return deleteUser(ctx, r.tx, id)
+}
+
+type mysqlLivestreamRepository struct {
+	tx     *sqlx.Tx
+	models *modelReader
+}
+
+func (r *mysqlLivestreamRepository) FindByID(ctx context.Context, id int64) (LivestreamModel, error) {
+	livestreamModel, err := r.models.livestreamModel(ctx, id)
+	return livestreamModel, recordNotFound(err)
+}
+
+func (r *mysqlLivestreamRepository) FindByIDForUpdate(ctx context.Context, id int64) (LivestreamModel, error) {
+	var livestreamModel LivestreamModel
+	if err := r.tx.GetContext(ctx, &livestreamModel, "SELECT * FROM livestreams WHERE id = ? FOR UPDATE", id); err != nil {
+		return LivestreamModel{}, recordNotFound(err)
+	}
+	return livestreamModel, nil
+}
+
+func (r *mysqlLivestreamRepository) FindByIDs(ctx context.Context, ids []int64) (map[int64]LivestreamModel, error) {
+	return r.models.livestreamModels(ctx, ids)
+}
+
+func (r *mysqlLivestreamRepository) ListByUserID(ctx context.Context, userID int64) ([]*LivestreamModel, error) {
+	livestreamModels := []*LivestreamModel{}
+	if err := r.tx.SelectContext(ctx, &livestreamModels, "SELECT * FROM livestreams WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	return livestreamModels, nil
+}
+
+func (r *mysqlLivestreamRepository) Tags(ctx context.Context, livestreamIDs []int64) (map[int64][]Tag, error) {
+	return r.models.livestreamTags(ctx, livestreamIDs)
+}
+
+func (r *mysqlLivestreamRepository) Collaborators(ctx context.Context, livestreamIDs []int64) ([]*LivestreamCollaboratorModel, error) {
+	collaboratorModels := []*LivestreamCollaboratorModel{}
+	if len(livestreamIDs) == 0 {
+		return collaboratorModels, nil
+	}
+	query, params, err := sqlx.In("SELECT * FROM livestream_collaborators WHERE livestream_id IN (?) ORDER BY id", livestreamIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create livestream_collaborators query: %w", err)
+	}
+	if err := r.tx.SelectContext(ctx, &collaboratorModels, query, params...); err != nil {
+		return nil, fmt.Errorf("failed to query livestream_collaborators: %w", err)
+	}
+	return collaboratorModels, nil
+}
+
+func (r *mysqlLivestreamRepository) IsModerator(ctx context.Context, livestream LivestreamModel, userID int64) (bool, error) {
+	return isLivestreamModerator(ctx, r.tx, livestream, userID)
+}
+
+func (r *mysqlLivestreamRepository) Create(ctx context.Context, livestreamModel *LivestreamModel) error {
+	r.models.markWritten()
+	rs, err := r.tx.NamedExecContext(ctx, "INSERT INTO livestreams (user_id, title, description, playlist_url, thumbnail_url, start_at, end_at) VALUES(:user_id, :title, :description, :playlist_url, :thumbnail_url, :start_at, :end_at)", livestreamModel)
+	if err != nil {
+		return err
+	}
+	livestreamID, err := rs.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last inserted livestream id: %w", err)
+	}
+	livestreamModel.ID = livestreamID
+	return nil
+}
+
+func (r *mysqlLivestreamRepository) Update(ctx context.Context, livestream LivestreamModel) error {
+	r.models.markWritten()
+	_, err := r.tx.NamedExecContext(ctx, "UPDATE livestreams SET title = :title, description = :description, playlist_url = :playlist_url, thumbnail_url = :thumbnail_url, start_at = :start_at, end_at = :end_at WHERE id = :id", livestream)
+	return err
+}
+
+func (r *mysqlLivestreamRepository) SetTags(ctx context.Context, livestreamID int64, tagIDs []int64) error {
+	r.models.markWritten()
+	if _, err := r.tx.ExecContext(ctx, "DELETE FROM livestream_tags WHERE livestream_id = ?", livestreamID); err != nil {
+		return err
+	}
+	return insertLivestreamTags(ctx, r.tx, livestreamID, tagIDs)
+}
+
+func (r *mysqlLivestreamRepository) SetCollaborators(ctx context.Context, livestreamID int64, userIDs []int64) error {
+	if _, err := r.tx.ExecContext(ctx, "DELETE FROM livestream_collaborators WHERE livestream_id = ?", livestreamID); err != nil {
+		return err
+	}
+	if len(userIDs) == 0 {
+		return nil
+	}
+	collaborators := make([]*LivestreamCollaboratorModel, 0, len(userIDs))
+	for _, userID := range userIDs {
+		collaborators = append(collaborators, &LivestreamCollaboratorModel{
+			LivestreamID: livestreamID,
+			UserID:       userID,
+		})
+	}
+	_, err := r.tx.NamedExecContext(ctx, "INSERT INTO livestream_collaborators (livestream_id, user_id) VALUES (:livestream_id, :user_id)", collaborators)
+	return err
+}
+
+func (r *mysqlLivestreamRepository) Search(ctx context.Context, q *LivestreamSearchQuery, page *pageRequest) ([]*LivestreamModel, error) {
+	where, whereArgs, err := q.whereClause()
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct search query: %w", err)
+	}
+	cond, args, order := page.keysetClause("")
+	query := "SELECT * FROM livestreams WHERE " + where + cond + order
+
+	livestreamModels := []*LivestreamModel{}
+	if err := r.tx.SelectContext(ctx, &livestreamModels, query, append(whereArgs, args...)...); err != nil {
+		return nil, err
+	}
+	return livestreamModels, nil
+}
+
+func (r *mysqlLivestreamRepository) Delete(ctx context.Context, id int64) error {
+	r.models.markWritten()
+	return deleteLivestream(ctx, r.tx, id)
+}
+
+type mysqlTagRepository struct {
+	tx     *sqlx.Tx
+	models *modelReader
+}
+
+func (r *mysqlTagRepository) List(ctx context.Context) ([]*TagModel, error) {
+	tagModels := []*TagModel{}
+	if err := r.tx.SelectContext(ctx, &tagModels, "SELECT * FROM tags ORDER BY id"); err != nil {
+		return nil, err
+	}
+	return tagModels, nil
+}
+
+type mysqlLivecommentRepository struct {
+	tx     *sqlx.Tx
+	models *modelReader
+}
+
+func (r *mysqlLivecommentRepository) FindByID(ctx context.Context, id int64) (LivecommentModel, error) {
+	var livecommentModel LivecommentModel
+	if err := r.tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ?", id); err != nil {
+		return LivecommentModel{}, recordNotFound(err)
+	}
+	return livecommentModel, nil
+}
+
+func (r *mysqlLivecommentRepository) FindByIDs(ctx context.Context, ids []int64) ([]LivecommentModel, error) {
+	livecommentModels := []LivecommentModel{}
+	if len(ids) == 0 {
+		return livecommentModels, nil
+	}
+	query, params, err := sqlx.In("SELECT * FROM livecomments WHERE id IN (?)", ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create livecomments query: %w", err)
+	}
+	if err := r.tx.SelectContext(ctx, &livecommentModels, query, params...); err != nil {
+		return nil, fmt.Errorf("failed to query livecomments: %w", err)
+	}
+	return livecommentModels, nil
+}
+
+func (r *mysqlLivecommentRepository) FindInLivestream(ctx context.Context, livestreamID int64, id int64) (LivecommentModel, error) {
+	var livecommentModel LivecommentModel
+	if err := r.tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ? AND livestream_id = ?", id, livestreamID); err != nil {
+		return LivecommentModel{}, recordNotFound(err)
+	}
+	return livecommentModel, nil
+}
+
+func (r *mysqlLivecommentRepository) FindInLivestreamForUpdate(ctx context.Context, livestreamID int64, id int64) (LivecommentModel, error) {
+	var livecommentModel LivecommentModel
+	if err := r.tx.GetContext(ctx, &livecommentModel, "SELECT * FROM livecomments WHERE id = ? AND livestream_id = ? AND deleted_at = 0 FOR UPDATE", id, livestreamID); err != nil {
+		return LivecommentModel{}, recordNotFound(err)
+	}
+	return livecommentModel, nil
+}
+
+func (r *mysqlLivecommentRepository) ListVisible(ctx context.Context, livestreamID int64, page *pageRequest) ([]LivecommentModel, error) {
+	cond, args, order := page.keysetClause("created_at")
+	query := "SELECT * FROM livecomments WHERE livestream_id = ? AND deleted_at = 0" + cond + order
+
+	livecommentModels := []LivecommentModel{}
+	if err := r.tx.SelectContext(ctx, &livecommentModels, query, append([]interface{}{livestreamID}, args...)...); err != nil {
+		return nil, err
+	}
+	return livecommentModels, nil
+}
+
+func (r *mysqlLivecommentRepository) ListHitNGWord(ctx context.Context, livestream LivestreamModel, ngWordLivestreamID int64, ngWord string, matcher *ngWordMatcher) ([]LivecommentModel, error) {
+	return findLivecommentsHitNGWord(ctx, r.tx, livestream, ngWordLivestreamID, ngWord, matcher)
+}
+
+func (r *mysqlLivecommentRepository) Create(ctx context.Context, livecommentModel *LivecommentModel) error {
+	rs, err := r.tx.NamedExecContext(ctx, "INSERT INTO livecomments (user_id, livestream_id, comment, tip, created_at) VALUES (:user_id, :livestream_id, :comment, :tip, :created_at)", livecommentModel)
+	if err != nil {
+		return err
+	}
+	livecommentID, err := rs.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last inserted livecomment id: %w", err)
+	}
+	livecommentModel.ID = livecommentID
+	return nil
+}
+
+type mysqlReactionRepository struct {
+	tx     *sqlx.Tx
+	models *modelReader
+}
+
+func (r *mysqlReactionRepository) FindInLivestream(ctx context.Context, livestreamID int64, id int64) (ReactionModel, error) {
+	var reactionModel ReactionModel
+	if err := r.tx.GetContext(ctx, &reactionModel, "SELECT * FROM reactions WHERE id = ? AND livestream_id = ?", id, livestreamID); err != nil {
+		return ReactionModel{}, recordNotFound(err)
+	}
+	return reactionModel, nil
+}
+
+func (r *mysqlReactionRepository) List(ctx context.Context, livestreamID int64, page *pageRequest) ([]ReactionModel, error) {
+	cond, args, order := page.keysetClause("created_at")
+	query := "SELECT * FROM reactions WHERE livestream_id = ?" + cond + order
+
+	reactionModels := []ReactionModel{}
+	if err := r.tx.SelectContext(ctx, &reactionModels, query, append([]interface{}{livestreamID}, args...)...); err != nil {
+		return nil, err
+	}
+	return reactionModels, nil
+}
+
+func (r *mysqlReactionRepository) Create(ctx context.Context, reactionModel *ReactionModel) error {
+	rs, err := r.tx.NamedExecContext(ctx, "INSERT INTO reactions (user_id, livestream_id, emoji_name, created_at) VALUES (:user_id, :livestream_id, :emoji_name, :created_at)", reactionModel)
+	if err != nil {
+		return err
+	}
+	reactionID, err := rs.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last inserted reaction id: %w", err)
+	}
+	reactionModel.ID = reactionID
+	return nil
+}
+
+type mysqlReportRepository struct {
+	tx     *sqlx.Tx
+	models *modelReader
+}
+
+func (r *mysqlReportRepository) ListByLivestreamID(ctx context.Context, livestreamID int64) ([]LivecommentReportModel, error) {
+	reportModels := []LivecommentReportModel{}
+	if err := r.tx.SelectContext(ctx, &reportModels, "SELECT * FROM livecomment_reports WHERE livestream_id = ?", livestreamID); err != nil {
+		return nil, err
+	}
+	return reportModels, nil
+}
+
+func (r *mysqlReportRepository) Create(ctx context.Context, reportModel *LivecommentReportModel) error {
+	rs, err := r.tx.NamedExecContext(ctx, "INSERT INTO livecomment_reports(user_id, livestream_id, livecomment_id, status, created_at) VALUES (:user_id, :livestream_id, :livecomment_id, :status, :created_at)", reportModel)
+	if err != nil {
+		return err
+	}
+	reportID, err := rs.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last inserted livecomment report id: %w", err)
+	}
+	reportModel.ID = reportID
+	return nil
+}
+
+func (r *mysqlReportRepository) CountOpenReporters(ctx context.Context, livestreamID int64, livecommentID int64) (int64, error) {
+	var reporters int64
+	if err := r.tx.GetContext(ctx, &reporters, "SELECT COUNT(DISTINCT user_id) FROM livecomment_reports WHERE livestream_id = ? AND livecomment_id = ? AND status = ?", livestreamID, livecommentID, reportStatusOpen); err != nil {
+		return 0, err
+	}
+	return reporters, nil
+}
+
+func (r *mysqlReportRepository) ListReported(ctx context.Context, livestreamID int64, livecommentID int64, status string) ([]reportedLivecommentModel, error) {
+	query := "SELECT livecomment_id, COUNT(*) AS report_count, SUM(status = ?) AS open_count, SUM(status = ?) AS actioned_count, MIN(created_at) AS first_reported_at, MAX(created_at) AS last_reported_at FROM livecomment_reports WHERE livestream_id = ?"
+	args := []interface{}{reportStatusOpen, reportStatusActioned, livestreamID}
+	if livecommentID != 0 {
+		query += " AND livecomment_id = ?"
+		args = append(args, livecommentID)
+	}
+	having := ""
+	if status != "" {
+		h, ok := reportStatusHavingClauses[status]
+		if !ok {
+			return nil, fmt.Errorf("unknown report status %q", status)
+		}
+		having = h
+	}
+	query += " GROUP BY livecomment_id" + having + " ORDER BY last_reported_at DESC, livecomment_id DESC"
+
+	models := []reportedLivecommentModel{}
+	if err := r.tx.SelectContext(ctx, &models, query, args...); err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+func (r *mysqlReportRepository) Resolve(ctx context.Context, livestreamID int64, livecommentID int64, status string) error {
+	_, err := r.tx.ExecContext(ctx, "UPDATE livecomment_reports SET status = ? WHERE livestream_id = ? AND livecomment_id = ? AND status = ?", status, livestreamID, livecommentID, reportStatusOpen)
+	return err
+}
+
+type mysqlNGWordRepository struct {
+	tx     *sqlx.Tx
+	models *modelReader
+}
+
+func (r *mysqlNGWordRepository) List(ctx context.Context, userID int64, livestreamIDs []int64) ([]*NGWord, error) {
+	var ngWords []*NGWord
+	if len(livestreamIDs) == 0 {
+		return ngWords, nil
+	}
+	query, params, err := sqlx.In("SELECT * FROM ng_words WHERE user_id = ? AND livestream_id IN (?) ORDER BY created_at DESC", userID, livestreamIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ng_words query: %w", err)
+	}
+	if err := r.tx.SelectContext(ctx, &ngWords, query, params...); err != nil {
+		return nil, err
+	}
+	return ngWords, nil
+}
+
+func (r *mysqlNGWordRepository) Create(ctx context.Context, ngWord *NGWord) error {
+	rs, err := r.tx.NamedExecContext(ctx, "INSERT INTO ng_words(user_id, livestream_id, word, match_mode, created_at) VALUES (:user_id, :livestream_id, :word, :match_mode, :created_at)", ngWord)
+	if err != nil {
+		return err
+	}
+	wordID, err := rs.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last inserted NG word id: %w", err)
+	}
+	ngWord.ID = wordID
+	return nil
+}
+
+func (r *mysqlNGWordRepository) Delete(ctx context.Context, id int64, userID int64, livestreamIDs []int64) error {
+	if len(livestreamIDs) == 0 {
+		return ErrRecordNotFound
+	}
+	query, params, err := sqlx.In("DELETE FROM ng_words WHERE id = ? AND user_id = ? AND livestream_id IN (?)", id, userID, livestreamIDs)
+	if err != nil {
+		return fmt.Errorf("failed to create ng_words query: %w", err)
+	}
+	rs, err := r.tx.ExecContext(ctx, query, params...)
+	if err != nil {
+		return err
+	}
+	n, err := rs.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if n == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+type mysqlReservationSlotRepository struct {
+	tx     *sqlx.Tx
+	models *modelReader
+}
+
+func (r *mysqlReservationSlotRepository) List(ctx context.Context, from int64, to int64) ([]*ReservationSlotModel, error) {
+	slotModels := []*ReservationSlotModel{}
+	if err := r.tx.SelectContext(ctx, &slotModels, "SELECT * FROM reservation_slots WHERE start_at >= ? AND end_at <= ? ORDER BY start_at", from, to); err != nil {
+		return nil, err
+	}
+	return slotModels, nil
+}
+
+func (r *mysqlReservationSlotRepository) Lock(ctx context.Context, ranges ...reservationRange) ([]*ReservationSlotModel, error) {
+	return lockReservationSlots(ctx, r.tx, ranges...)
+}
+
+func (r *mysqlReservationSlotRepository) Consume(ctx context.Context, rr reservationRange) error {
+	return consumeReservationSlots(ctx, r.tx, rr)
+}
+
+func (r *mysqlReservationSlotRepository) Release(ctx context.Context, rr reservationRange) error {
+	return releaseReservationSlots(ctx, r.tx, rr)
+}
+
+type mysqlTipRepository struct {
+	tx     *sqlx.Tx
+	models *modelReader
+}
+
+func (r *mysqlTipRepository) Total(ctx context.Context) (int64, error) {
+	var total int64
+	if err := r.tx.GetContext(ctx, &total, "SELECT IFNULL(SUM(amount), 0) FROM tips"); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+func (r *mysqlTipRepository) Record(ctx context.Context, livecommentModel LivecommentModel, streamerID int64) error {
+	return recordTip(ctx, r.tx, livecommentModel, streamerID)
+}
+
+func (r *mysqlTipRepository) DailyEarnings(ctx context.Context, streamerID int64, from int64, to int64) ([]dailyEarnings, error) {
+	query := "SELECT (created_at + ?) DIV 86400 AS day, COUNT(*) AS tips_count, SUM(amount) AS total_tip FROM tips WHERE streamer_id = ? AND created_at >= ?"
+	params := []interface{}{earningsUTCOffset, streamerID, from}
+	if to > 0 {
+		query += " AND created_at < ?"
+		params = append(params, to)
+	}
+	query += " GROUP BY day ORDER BY day"
+
+	daily := []dailyEarnings{}
+	if err := r.tx.SelectContext(ctx, &daily, query, params...); err != nil {
+		return nil, err
+	}
+	return daily, nil
+}
+
+type mysqlFollowRepository struct {
+	tx     *sqlx.Tx
+	models *modelReader
+}
+
+func (r *mysqlFollowRepository) Follow(ctx context.Context, follow FollowModel) error {
+	_, err := r.tx.NamedExecContext(ctx, "INSERT IGNORE INTO follows (follower_id, followee_id, created_at) VALUES (:follower_id, :followee_id, :created_at)", follow)
+	return err
+}
+
+func (r *mysqlFollowRepository) Unfollow(ctx context.Context, followerID int64, followeeID int64) error {
+	_, err := r.tx.ExecContext(ctx, "DELETE FROM follows WHERE follower_id = ? AND followee_id = ?", followerID, followeeID)
+	return err
+}
+
+func (r *mysqlFollowRepository) ListFollowees(ctx context.Context, followerID int64) ([]UserModel, error) {
+	followeeModels := []UserModel{}
+	if err := r.tx.SelectContext(ctx, &followeeModels, "SELECT u.* FROM follows f INNER JOIN users u ON u.id = f.followee_id WHERE f.follower_id = ? ORDER BY f.id DESC", followerID); err != nil {
+		return nil, err
+	}
+	return followeeModels, nil
+}
+
+func (r *mysqlFollowRepository) Feed(ctx context.Context, followerID int64, now int64, limit int) ([]*LivestreamModel, error) {
+	livestreamModels := []*LivestreamModel{}
+	query := `
+		SELECT l.* FROM livestreams l
+		INNER JOIN follows f ON f.followee_id = l.user_id
+		WHERE f.follower_id = ? AND l.end_at > ?
+		ORDER BY l.start_at ASC, l.id ASC
+		LIMIT ?`
+	if err := r.tx.SelectContext(ctx, &livestreamModels, query, followerID, now, limit); err != nil {
+		return nil, err
+	}
+	return livestreamModels, nil
+}
+
+type mysqlModerationRepository struct {
+	tx     *sqlx.Tx
+	models *modelReader
+}
+
+func (r *mysqlModerationRepository) SoftDelete(ctx context.Context, livecommentModels []LivecommentModel, reason string, ngWordID int64, moderatorID int64) error {
+	return softDeleteLivecomments(ctx, r.tx, livecommentModels, reason, ngWordID, moderatorID)
+}
+
+func (r *mysqlModerationRepository) List(ctx context.Context, livestreamID int64, page *pageRequest) ([]LivecommentModerationModel, error) {
+	cond, args, order := page.keysetClause("")
+	query := "SELECT * FROM livecomment_moderations WHERE livestream_id = ?" + cond + order
+
+	moderationModels := []LivecommentModerationModel{}
+	if err := r.tx.SelectContext(ctx, &moderationModels, query, append([]interface{}{livestreamID}, args...)...); err != nil {
+		return nil, err
+	}
+	return moderationModels, nil
+}
+
+type mysqlStatsRepository struct {
+	tx     *sqlx.Tx
+	models *modelReader
+}
+
+func (r *mysqlStatsRepository) CreateUser(ctx context.Context, userID int64, name string) error {
+	return createUserStats(ctx, r.tx, userID, name)
+}
+
+func (r *mysqlStatsRepository) CreateLivestream(ctx context.Context, livestreamID int64, userID int64) error {
+	return createLivestreamStats(ctx, r.tx, livestreamID, userID)
+}
+
+func (r *mysqlStatsRepository) CountReaction(ctx context.Context, livestreamID int64) error {
+	return countReaction(ctx, r.tx, livestreamID)
+}
+
+func (r *mysqlStatsRepository) CountLivecomment(ctx context.Context, livestreamID int64, tip int64) error {
+	return countLivecomment(ctx, r.tx, livestreamID, tip)
+}
+
+func (r *mysqlStatsRepository) CountReport(ctx context.Context, livestreamID int64) error {
+	return countReport(ctx, r.tx, livestreamID)
+}
+
+func (r *mysqlStatsRepository) Livestream(ctx context.Context, livestreamID int64) (LivestreamStatsModel, error) {
+	var stats LivestreamStatsModel
+	if err := r.tx.GetContext(ctx, &stats, "SELECT * FROM livestream_stats WHERE livestream_id = ?", livestreamID); err != nil {
+		return LivestreamStatsModel{}, recordNotFound(err)
+	}
+	return stats, nil
+}
+
+func (r *mysqlStatsRepository) User(ctx context.Context, userID int64) (UserStatsModel, error) {
+	var stats UserStatsModel
+	if err := r.tx.GetContext(ctx, &stats, "SELECT * FROM user_stats WHERE user_id = ?", userID); err != nil {
+		return UserStatsModel{}, recordNotFound(err)
+	}
+	return stats, nil
+}
+
+func (r *mysqlStatsRepository) LivestreamRank(ctx context.Context, stats LivestreamStatsModel) (int64, error) {
+	return livestreamRank(ctx, r.tx, stats)
+}
+
+func (r *mysqlStatsRepository) UserRank(ctx context.Context, stats UserStatsModel) (int64, error) {
+	return userRank(ctx, r.tx, stats)
+}
+
+func (r *mysqlStatsRepository) FavoriteEmoji(ctx context.Context, userID int64) (string, error) {
+	var favoriteEmoji string
+	query := `
+		SELECT r.emoji_name
+		FROM livestreams l
+		INNER JOIN reactions r ON r.livestream_id = l.id
+		WHERE l.user_id = ?
+		GROUP BY r.emoji_name
+		ORDER BY COUNT(*) DESC, r.emoji_name DESC
+		LIMIT 1`
+	if err := r.tx.GetContext(ctx, &favoriteEmoji, query, userID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	return favoriteEmoji, nil
+}
+
+type mysqlViewerRepository struct {
+	tx     *sqlx.Tx
+	models *modelReader
+}
+
+func (r *mysqlViewerRepository) Enter(ctx context.Context, viewer LivestreamViewerModel) error {
+	_, err := r.tx.NamedExecContext(ctx, "INSERT INTO livestream_viewers_history (user_id, livestream_id, created_at, last_seen_at) VALUES(:user_id, :livestream_id, :created_at, :last_seen_at)", viewer)
+	return err
+}
+
+func (r *mysqlViewerRepository) Exit(ctx context.Context, userID int64, livestreamID int64, now int64) error {
+	_, err := r.tx.ExecContext(ctx, "UPDATE livestream_viewers_history SET left_at = ? WHERE user_id = ? AND livestream_id = ? AND left_at = 0", now, userID, livestreamID)
+	return err
+}
+
+func (r *mysqlViewerRepository) Heartbeat(ctx context.Context, userID int64, livestreamID int64, now int64) error {
+	rs, err := r.tx.ExecContext(ctx, "UPDATE livestream_viewers_history SET last_seen_at = ? WHERE user_id = ? AND livestream_id = ? AND left_at = 0", now, userID, livestreamID)
+	if err != nil {
+		return err
+	}
+	n, err := rs.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if n > 0 {
+		return nil
+	}
+
+	// 入室と同じ秒のハートビートなど、last_seen_atが変わらない場合も更新行数は0になるので、視聴があるか確かめる
+	var exists bool
+	if err := r.tx.GetContext(ctx, &exists, "SELECT EXISTS(SELECT 1 FROM livestream_viewers_history WHERE user_id = ? AND livestream_id = ? AND left_at = 0)", userID, livestreamID); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *mysqlViewerRepository) Count(ctx context.Context, livestreamIDs []int64, now time.Time) (ViewerCounts, error) {
+	return countViewers(ctx, r.tx, livestreamIDs, now)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// 予約枠の一覧を一度に取得できる最大の期間
+const maxReservationSlotsQueryRange = 31 * 24 * 60 * 60
+
+type ReservationSlot struct {
+	StartAt int64 `json:"start_at"`
+	EndAt   int64 `json:"end_at"`
+	// Slot は予約枠の残数
+	Slot int64 `json:"slot"`
+}
+
+type ReservationWindow struct {
+	StartAt int64 `json:"start_at"`
+	EndAt   int64 `json:"end_at"`
+}
+
+// 予約枠の残数取得API
+// GET /api/reservation_slots?from=&to=
+func getReservationSlotsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	from, to, err := parseReservationSlotsRange(c)
+	if err != nil {
+		return err
+	}
+	if to-from > maxReservationSlotsQueryRange {
+		return echo.NewHTTPError(http.StatusBadRequest, "range between from and to is too long")
+	}
+
+	slotModels, err := listReservationSlots(ctx, from, to)
+	if err != nil {
+		return err
+	}
+
+	slots := make([]ReservationSlot, len(slotModels))
+	for i := range slotModels {
+		slots[i] = ReservationSlot{
+			StartAt: slotModels[i].StartAt,
+			EndAt:   slotModels[i].EndAt,
+			Slot:    slotModels[i].Slot,
+		}
+	}
+
+	return c.JSON(http.StatusOK, slots)
+}
+
+// 予約可能な直近の時間帯の取得API
+// GET /api/reservation_slots/next?hours=&from=&to=
+func getNextReservationWindowHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	hours := 1
+	if v := c.QueryParam("hours"); v != "" {
+		h, err := strconv.Atoi(v)
+		if err != nil || h < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, "hours query parameter must be positive integer")
+		}
+		hours = h
+	}
+
+	from, to, err := parseReservationSlotsRange(c)
+	if err != nil {
+		return err
+	}
+
+	slotModels, err := listReservationSlots(ctx, from, to)
+	if err != nil {
+		return err
+	}
+
+	window, ok := findReservationWindow(slotModels, hours)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "not found available reservation window")
+	}
+
+	return c.JSON(http.StatusOK, window)
+}
+
+// listReservationSlots は[from, to]に収まる予約枠をstart_at順に返す
+// 返すエラーはecho.HTTPErrorなので、そのままハンドラから返してよい
+func listReservationSlots(ctx context.Context, from int64, to int64) ([]*ReservationSlotModel, error) {
+	var slotModels []*ReservationSlotModel
+	err := repositoryStore.Tx(ctx, func(r Repository) error {
+		var err error
+		slotModels, err = r.ReservationSlots().List(ctx, from, to)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get reservation_slots: "+err.Error())
+		}
+		return nil
+	})
+	return slotModels, err
+}
+
+// parseReservationSlotsRange はfrom, toクエリパラメータを読み取る
+// 指定がない場合は予約可能期間全体とする
+func parseReservationSlotsRange(c echo.Context) (int64, int64, error) {
+	from := reservationTermStartAt.Unix()
+	to := reservationTermEndAt.Unix()
+
+	if v := c.QueryParam("from"); v != "" {
+		f, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "from query parameter must be integer")
+		}
+		from = f
+	}
+	if v := c.QueryParam("to"); v != "" {
+		t, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "to query parameter must be integer")
+		}
+		to = t
+	}
+	if from >= to {
+		return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "from must be before to")
+	}
+
+	return from, to, nil
+}
+
+// findReservationWindow はstart_at順に並んだ予約枠から、
+// 全ての時間に残数がある連続したhours時間の区間のうち最も早いものを返す
+func findReservationWindow(slots []*ReservationSlotModel, hours int) (ReservationWindow, bool) {
+	var (
+		run     int
+		startAt int64
+	)
+	for i, slot := range slots {
+		// 予約枠が途切れている場合は数え直す
+		if slot.Slot < 1 || (i > 0 && slots[i-1].EndAt != slot.StartAt) {
+			run = 0
+		}
+		if slot.Slot < 1 {
+			continue
+		}
+		if run == 0 {
+			startAt = slot.StartAt
+		}
+		run++
+		if run >= hours {
+			return ReservationWindow{
+				StartAt: startAt,
+				EndAt:   slot.EndAt,
+			}, true
+		}
+	}
+	return ReservationWindow{}, false
+}
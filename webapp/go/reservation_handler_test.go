@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestReservationSlots(baseAt time.Time, remains ...int64) []*ReservationSlotModel {
+	slots := make([]*ReservationSlotModel, len(remains))
+	for i, remain := range remains {
+		slots[i] = &ReservationSlotModel{
+			ID:      int64(i + 1),
+			Slot:    remain,
+			StartAt: baseAt.Add(time.Duration(i) * time.Hour).Unix(),
+			EndAt:   baseAt.Add(time.Duration(i+1) * time.Hour).Unix(),
+		}
+	}
+	return slots
+}
+
+func TestFindReservationWindow(t *testing.T) {
+	baseAt := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	hour := func(h int) int64 {
+		return baseAt.Add(time.Duration(h) * time.Hour).Unix()
+	}
+
+	tests := []struct {
+		name    string
+		slots   []*ReservationSlotModel
+		hours   int
+		want    ReservationWindow
+		wantErr bool
+	}{
+		{
+			name:  "first slot",
+			slots: newTestReservationSlots(baseAt, 2, 2, 2),
+			hours: 1,
+			want:  ReservationWindow{StartAt: hour(0), EndAt: hour(1)},
+		},
+		{
+			name:  "whole range",
+			slots: newTestReservationSlots(baseAt, 1, 1, 1),
+			hours: 3,
+			want:  ReservationWindow{StartAt: hour(0), EndAt: hour(3)},
+		},
+		{
+			name:  "skip full slots",
+			slots: newTestReservationSlots(baseAt, 0, 1, 0, 1, 1, 1),
+			hours: 2,
+			want:  ReservationWindow{StartAt: hour(3), EndAt: hour(5)},
+		},
+		{
+			name:    "no window long enough",
+			slots:   newTestReservationSlots(baseAt, 1, 0, 1, 1, 0),
+			hours:   3,
+			wantErr: true,
+		},
+		{
+			name:    "all slots are full",
+			slots:   newTestReservationSlots(baseAt, 0, 0, 0),
+			hours:   1,
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			slots:   nil,
+			hours:   1,
+			wantErr: true,
+		},
+		{
+			name: "non-contiguous slots",
+			slots: append(
+				newTestReservationSlots(baseAt, 1, 1),
+				newTestReservationSlots(baseAt.Add(3*time.Hour), 1, 1, 1)...,
+			),
+			hours: 3,
+			want:  ReservationWindow{StartAt: hour(3), EndAt: hour(6)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := findReservationWindow(tt.slots, tt.hours)
+			if tt.wantErr {
+				assert.False(t, ok)
+				return
+			}
+			assert.True(t, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGetReservationSlotsHandlerListsSlotsInRange(t *testing.T) {
+	s := newTestRepositoryStore(t)
+	// 範囲をまたぐ予約枠は含めない
+	startAt := testLivestreamStartAt.Unix()
+	s.data.reservationSlots = append(s.data.reservationSlots, ReservationSlotModel{ID: 3, Slot: 1, StartAt: startAt + 1800, EndAt: startAt + 3*3600})
+
+	target := fmt.Sprintf("/api/reservation_slots?from=%d&to=%d", startAt, startAt+2*3600)
+	rec := serveTestRequest(t, http.MethodGet, "/api/reservation_slots", target, testStreamerID, getReservationSlotsHandler)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []ReservationSlot{
+		{StartAt: startAt, EndAt: startAt + 3600, Slot: 4},
+		{StartAt: startAt + 3600, EndAt: startAt + 2*3600, Slot: 4},
+	}, decodeResponse[[]ReservationSlot](t, rec))
+}
+
+func TestReservationSlotsHandlersValidation(t *testing.T) {
+	startAt := testLivestreamStartAt.Unix()
+	tests := []struct {
+		name       string
+		handler    echo.HandlerFunc
+		query      string
+		wantStatus int
+	}{
+		{name: "slots in reservation term", handler: getReservationSlotsHandler, query: "", wantStatus: http.StatusBadRequest},
+		{name: "slots in range", handler: getReservationSlotsHandler, query: fmt.Sprintf("from=%d&to=%d", startAt, startAt+3600), wantStatus: http.StatusOK},
+		{name: "slots in longest range", handler: getReservationSlotsHandler, query: fmt.Sprintf("from=%d&to=%d", startAt, startAt+maxReservationSlotsQueryRange), wantStatus: http.StatusOK},
+		{name: "slots in too long range", handler: getReservationSlotsHandler, query: fmt.Sprintf("from=%d&to=%d", startAt, startAt+maxReservationSlotsQueryRange+1), wantStatus: http.StatusBadRequest},
+		{name: "slots with from not integer", handler: getReservationSlotsHandler, query: fmt.Sprintf("from=abc&to=%d", startAt), wantStatus: http.StatusBadRequest},
+		{name: "slots with to not integer", handler: getReservationSlotsHandler, query: fmt.Sprintf("from=%d&to=abc", startAt), wantStatus: http.StatusBadRequest},
+		{name: "slots with from equal to to", handler: getReservationSlotsHandler, query: fmt.Sprintf("from=%d&to=%d", startAt, startAt), wantStatus: http.StatusBadRequest},
+		{name: "slots with from after to", handler: getReservationSlotsHandler, query: fmt.Sprintf("from=%d&to=%d", startAt+3600, startAt), wantStatus: http.StatusBadRequest},
+		// 次の予約可能な時間帯は期間の制限なしに予約可能期間全体から探す
+		{name: "next in reservation term", handler: getNextReservationWindowHandler, query: "", wantStatus: http.StatusOK},
+		{name: "next with hours", handler: getNextReservationWindowHandler, query: "hours=2", wantStatus: http.StatusOK},
+		{name: "next with zero hours", handler: getNextReservationWindowHandler, query: "hours=0", wantStatus: http.StatusBadRequest},
+		{name: "next with negative hours", handler: getNextReservationWindowHandler, query: "hours=-1", wantStatus: http.StatusBadRequest},
+		{name: "next with hours not integer", handler: getNextReservationWindowHandler, query: "hours=abc", wantStatus: http.StatusBadRequest},
+		{name: "next with from after to", handler: getNextReservationWindowHandler, query: fmt.Sprintf("from=%d&to=%d", startAt+3600, startAt), wantStatus: http.StatusBadRequest},
+		{name: "next longer than slots", handler: getNextReservationWindowHandler, query: "hours=3", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestRepositoryStore(t)
+			rec := serveTestRequest(t, http.MethodGet, "/api/reservation_slots", "/api/reservation_slots?"+tt.query, testStreamerID, tt.handler)
+			assert.Equal(t, tt.wantStatus, rec.Code)
+		})
+	}
+}
+
+// reservationOracle はbench/internal/scheduler/interval_temperature.goのIntervalTemperaturesと同じ規則で、
+// 予約が成功した区間の温度(1時間ごとの予約数)を数える
+// ベンチマーカーのinternalパッケージは別モジュールから読み込めないので、数え方をここに写している
+// ベンチマーカーは温度が予約枠数-1未満(cold)の区間への予約は成功すると期待し、そうした区間を選んで予約する
+type reservationOracle struct {
+	baseAt       int64
+	numSlots     int64
+	temperatures []int64
+}
+
+func newReservationOracle(baseAt int64, numSlots int64, hours int) *reservationOracle {
+	return &reservationOracle{
+		baseAt:       baseAt,
+		numSlots:     numSlots,
+		temperatures: make([]int64, hours),
+	}
+}
+
+// addInterval はIntervalTemperatures.addIntervalと同じく、終了時刻を含む1時間ごとの温度を上げる
+// そのため温度はwebappが実際に消費した予約枠数以上になる
+func (o *reservationOracle) addInterval(startAt int64, endAt int64) {
+	offset := int((startAt - o.baseAt) / 3600)
+	length := int((endAt - startAt) / 3600)
+	for i := offset; i <= offset+length && i < len(o.temperatures); i++ {
+		o.temperatures[i]++
+	}
+}
+
+func (o *reservationOracle) cold(i int) bool {
+	return o.temperatures[i] < o.numSlots-1
+}
+
+// isCold は[startAt, endAt)の全ての時間がcoldであればtrueを返す
+func (o *reservationOracle) isCold(startAt int64, endAt int64) bool {
+	for i := int((startAt - o.baseAt) / 3600); i < int((endAt-o.baseAt)/3600); i++ {
+		if !o.cold(i) {
+			return false
+		}
+	}
+	return true
+}
+
+// coldWindow は全ての時間がcoldな連続したhours時間の区間のうち最も早いものを返す
+func (o *reservationOracle) coldWindow(hours int) (ReservationWindow, bool) {
+	run := 0
+	for i := range o.temperatures {
+		if !o.cold(i) {
+			run = 0
+			continue
+		}
+		run++
+		if run >= hours {
+			startAt := o.baseAt + int64(i+1-hours)*3600
+			return ReservationWindow{StartAt: startAt, EndAt: startAt + int64(hours)*3600}, true
+		}
+	}
+	return ReservationWindow{}, false
+}
+
+// 予約を再生しながら、予約枠の残数と次の予約可能な時間帯がベンチマーカーの期待と矛盾しないか確かめる
+func TestReservationSlotsFollowBenchScheduler(t *testing.T) {
+	const (
+		// bench/internal/config/reservation.goのNumSlots
+		numSlots = 5
+		hours    = 12
+	)
+	baseAt := testLivestreamStartAt.Add(24 * time.Hour).Unix()
+	hourAt := func(h int) int64 { return baseAt + int64(h)*3600 }
+
+	s := newTestRepositoryStore(t)
+	s.data.reservationSlots = nil
+	for h := 0; h < hours; h++ {
+		s.data.reservationSlots = append(s.data.reservationSlots, ReservationSlotModel{ID: int64(h + 1), Slot: numSlots, StartAt: hourAt(h), EndAt: hourAt(h + 1)})
+	}
+	o := newReservationOracle(baseAt, numSlots, hours)
+	// webappは予約区間の開始時刻の予約枠だけを消費する
+	started := make([]int64, hours)
+
+	reserve := func(startAt int64, endAt int64) int {
+		body := fmt.Sprintf(`{"title": "予約", "description": "", "playlist_url": "", "thumbnail_url": "", "start_at": %d, "end_at": %d, "tags": [], "collaborators": []}`, startAt, endAt)
+		rec := serveTestJSONRequest(t, http.MethodPost, "/api/livestream/reservation", "/api/livestream/reservation", body, testStreamerID, reserveLivestreamHandler)
+		if rec.Code == http.StatusCreated {
+			o.addInterval(startAt, endAt)
+			started[(startAt-baseAt)/3600]++
+		}
+		return rec.Code
+	}
+	assertSlots := func(t *testing.T) {
+		t.Helper()
+		target := fmt.Sprintf("/api/reservation_slots?from=%d&to=%d", hourAt(0), hourAt(hours))
+		rec := serveTestRequest(t, http.MethodGet, "/api/reservation_slots", target, testStreamerID, getReservationSlotsHandler)
+		if !assert.Equal(t, http.StatusOK, rec.Code) {
+			return
+		}
+		slots := decodeResponse[[]ReservationSlot](t, rec)
+		if !assert.Len(t, slots, hours) {
+			return
+		}
+		for h, slot := range slots {
+			assert.Equal(t, ReservationSlot{StartAt: hourAt(h), EndAt: hourAt(h + 1), Slot: numSlots - started[h]}, slot, "hour %d", h)
+			// ベンチマーカーが想定するより残数が少なくなることはない
+			assert.GreaterOrEqual(t, slot.Slot, numSlots-o.temperatures[h], "hour %d", h)
+		}
+	}
+
+	// 開始時刻と時間数. 0時と6時の予約枠は使い切る
+	for _, r := range []struct{ start, length int }{
+		{0, 2}, {0, 1}, {1, 3}, {0, 1}, {0, 1}, {0, 1}, {0, 1},
+		{3, 2}, {6, 1}, {6, 1}, {6, 3}, {6, 1}, {6, 1}, {6, 1}, {8, 4},
+	} {
+		startAt, endAt := hourAt(r.start), hourAt(r.start+r.length)
+		full := started[r.start] >= numSlots
+		cold := o.isCold(startAt, endAt)
+
+		code := reserve(startAt, endAt)
+		if full {
+			assert.Equal(t, http.StatusBadRequest, code, "reserve %d+%dh", r.start, r.length)
+		} else {
+			assert.Equal(t, http.StatusCreated, code, "reserve %d+%dh", r.start, r.length)
+		}
+		// ベンチマーカーがcoldとみなす区間の予約は必ず成功する
+		if cold {
+			assert.Equal(t, http.StatusCreated, code, "cold %d+%dh", r.start, r.length)
+		}
+	}
+	assertSlots(t)
+
+	// 次の予約可能な時間帯は、ベンチマーカーがcoldとみなす最も早い時間帯より遅くならず、実際に予約できる
+	for n := 1; n <= 4; n++ {
+		target := fmt.Sprintf("/api/reservation_slots/next?hours=%d&from=%d&to=%d", n, hourAt(0), hourAt(hours))
+		rec := serveTestRequest(t, http.MethodGet, "/api/reservation_slots/next", target, testStreamerID, getNextReservationWindowHandler)
+		if !assert.Equal(t, http.StatusOK, rec.Code, "%d hours", n) {
+			continue
+		}
+		window := decodeResponse[ReservationWindow](t, rec)
+		assert.Equal(t, int64(n)*3600, window.EndAt-window.StartAt, "%d hours", n)
+		if coldWindow, ok := o.coldWindow(n); ok {
+			assert.LessOrEqual(t, window.StartAt, coldWindow.StartAt, "%d hours", n)
+		}
+		assert.Equal(t, http.StatusCreated, reserve(window.StartAt, window.EndAt), "%d hours", n)
+	}
+	assertSlots(t)
+
+	// 全ての予約枠を使い切ると次の予約可能な時間帯はない
+	for h := 0; h < hours; h++ {
+		for started[h] < numSlots {
+			assert.Equal(t, http.StatusCreated, reserve(hourAt(h), hourAt(h+1)))
+		}
+	}
+	target := fmt.Sprintf("/api/reservation_slots/next?from=%d&to=%d", hourAt(0), hourAt(hours))
+	rec := serveTestRequest(t, http.MethodGet, "/api/reservation_slots/next", target, testStreamerID, getNextReservationWindowHandler)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assertSlots(t)
+}
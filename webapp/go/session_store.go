@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	sessionStoreEnvKey = "ISUCON13_SESSION_STORE"
+
+	// 期限切れのセッションを全ユーザ分掃除する間隔(ログインの回数)
+	// それ以外のログインでは、ログインしたユーザの分だけ掃除する
+	sessionSweepInterval = 100
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionModel はサーバ側で管理するログインセッション
+// IDはログイン時に発行し、クッキーのSESSIONIDに保存するUUID
+type SessionModel struct {
+	ID        string `db:"id"`
+	UserID    int64  `db:"user_id"`
+	ExpiresAt int64  `db:"expires_at"`
+	CreatedAt int64  `db:"created_at"`
+}
+
+// SessionStore はログインセッションを保持する
+// クッキーが有効期限内でも、ストアから削除されたセッションは無効になる
+type SessionStore interface {
+	Create(ctx context.Context, s *SessionModel) error
+	// Get は有効期限内のセッションを返す. 存在しない場合はErrSessionNotFoundを返す
+	Get(ctx context.Context, id string) (*SessionModel, error)
+	Delete(ctx context.Context, id string) error
+	// DeleteByUserID はユーザの全セッションを削除する. exceptIDのセッションは残す
+	DeleteByUserID(ctx context.Context, userID int64, exceptID string) error
+	Reset(ctx context.Context) error
+}
+
+var sessionStore SessionStore
+
+func newSessionStore(kind string, db *sqlx.DB) (SessionStore, error) {
+	switch kind {
+	case "", "mysql":
+		return &mysqlSessionStore{db: db}, nil
+	case "memory":
+		return newMemorySessionStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown session store %q", kind)
+	}
+}
+
+type mysqlSessionStore struct {
+	db      *sqlx.DB
+	creates atomic.Int64
+}
+
+func (s *mysqlSessionStore) Create(ctx context.Context, session *SessionModel) error {
+	// 期限切れのセッションはログインのついでに掃除する
+	now := time.Now().Unix()
+	if s.creates.Add(1)%sessionSweepInterval == 0 {
+		if _, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE expires_at < ?", now); err != nil {
+			return err
+		}
+	} else {
+		if _, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = ? AND expires_at < ?", session.UserID, now); err != nil {
+			return err
+		}
+	}
+	_, err := s.db.NamedExecContext(ctx, "INSERT INTO sessions (id, user_id, expires_at, created_at) VALUES (:id, :user_id, :expires_at, :created_at)", session)
+	return err
+}
+
+func (s *mysqlSessionStore) Get(ctx context.Context, id string) (*SessionModel, error) {
+	var session SessionModel
+	if err := s.db.GetContext(ctx, &session, "SELECT * FROM sessions WHERE id = ? AND expires_at >= ?", id, time.Now().Unix()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *mysqlSessionStore) Delete(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE id = ?", id)
+	return err
+}
+
+func (s *mysqlSessionStore) DeleteByUserID(ctx context.Context, userID int64, exceptID string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = ? AND id != ?", userID, exceptID)
+	return err
+}
+
+func (s *mysqlSessionStore) Reset(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, "TRUNCATE TABLE sessions")
+	return err
+}
+
+// memorySessionStore はプロセス内でセッションを保持する
+// 複数台構成では共有されないため、1台構成か開発用途でのみ使う
+type memorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]SessionModel
+	creates  int
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{
+		sessions: make(map[string]SessionModel),
+	}
+}
+
+func (s *memorySessionStore) Create(_ context.Context, session *SessionModel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.creates++
+	sweepAll := s.creates%sessionSweepInterval == 0
+	now := time.Now().Unix()
+	for id, v := range s.sessions {
+		if (sweepAll || v.UserID == session.UserID) && v.ExpiresAt < now {
+			delete(s.sessions, id)
+		}
+	}
+	s.sessions[session.ID] = *session
+	return nil
+}
+
+func (s *memorySessionStore) Get(_ context.Context, id string) (*SessionModel, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.ExpiresAt < time.Now().Unix() {
+		return nil, ErrSessionNotFound
+	}
+	return &session, nil
+}
+
+func (s *memorySessionStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *memorySessionStore) DeleteByUserID(_ context.Context, userID int64, exceptID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, v := range s.sessions {
+		if v.UserID == userID && id != exceptID {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (s *memorySessionStore) Reset(_ context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions = make(map[string]SessionModel)
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemorySessionStore(t *testing.T) {
+	ctx := context.Background()
+	store := newMemorySessionStore()
+
+	expiresAt := time.Now().Add(1 * time.Hour).Unix()
+	for _, s := range []*SessionModel{
+		{ID: "a", UserID: 1, ExpiresAt: expiresAt},
+		{ID: "b", UserID: 1, ExpiresAt: expiresAt},
+		{ID: "c", UserID: 2, ExpiresAt: expiresAt},
+		{ID: "expired", UserID: 2, ExpiresAt: time.Now().Add(-1 * time.Hour).Unix()},
+	} {
+		assert.NoError(t, store.Create(ctx, s))
+	}
+
+	got, err := store.Get(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), got.UserID)
+
+	_, err = store.Get(ctx, "expired")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	// ログアウト
+	assert.NoError(t, store.Delete(ctx, "a"))
+	_, err = store.Get(ctx, "a")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	// 他端末のセッションのみ失効
+	assert.NoError(t, store.Create(ctx, &SessionModel{ID: "d", UserID: 1, ExpiresAt: expiresAt}))
+	assert.NoError(t, store.DeleteByUserID(ctx, 1, "d"))
+	_, err = store.Get(ctx, "b")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+	_, err = store.Get(ctx, "d")
+	assert.NoError(t, err)
+	_, err = store.Get(ctx, "c")
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.Reset(ctx))
+	_, err = store.Get(ctx, "c")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+}
+
+func TestMemorySessionStoreSweepsExpiredSessions(t *testing.T) {
+	ctx := context.Background()
+	store := newMemorySessionStore()
+
+	expired := time.Now().Add(-1 * time.Hour).Unix()
+	expiresAt := time.Now().Add(1 * time.Hour).Unix()
+	store.sessions["expired-1"] = SessionModel{ID: "expired-1", UserID: 1, ExpiresAt: expired}
+	store.sessions["expired-2"] = SessionModel{ID: "expired-2", UserID: 2, ExpiresAt: expired}
+
+	// ログインしたユーザの期限切れセッションだけを消す
+	assert.NoError(t, store.Create(ctx, &SessionModel{ID: "a", UserID: 1, ExpiresAt: expiresAt}))
+	assert.NotContains(t, store.sessions, "expired-1")
+	assert.Contains(t, store.sessions, "expired-2")
+
+	// 一定回数ごとに、ログインしていないユーザの期限切れセッションも消す
+	for i := 1; i < sessionSweepInterval-1; i++ {
+		assert.NoError(t, store.Create(ctx, &SessionModel{ID: fmt.Sprintf("s%d", i), UserID: 1, ExpiresAt: expiresAt}))
+	}
+	assert.Contains(t, store.sessions, "expired-2")
+	assert.NoError(t, store.Create(ctx, &SessionModel{ID: "b", UserID: 1, ExpiresAt: expiresAt}))
+	assert.NotContains(t, store.sessions, "expired-2")
+	assert.Len(t, store.sessions, sessionSweepInterval)
+}
+
+// fakeSessionsTable はsessionsテーブルへのクエリに応答する
+type fakeSessionsTable struct {
+	rows map[string]SessionModel
+	// 全ユーザ分の期限切れセッションを消した回数
+	sweeps int
+}
+
+func (tbl *fakeSessionsTable) handle(query string, args []driver.Value) (fakeSQLResult, error) {
+	switch {
+	case strings.HasPrefix(query, "DELETE FROM sessions WHERE user_id = ? AND expires_at < ?"):
+		return tbl.delete(func(s SessionModel) bool { return s.UserID == args[0].(int64) && s.ExpiresAt < args[1].(int64) }), nil
+	case strings.HasPrefix(query, "DELETE FROM sessions WHERE expires_at < ?"):
+		tbl.sweeps++
+		return tbl.delete(func(s SessionModel) bool { return s.ExpiresAt < args[0].(int64) }), nil
+	case strings.HasPrefix(query, "DELETE FROM sessions WHERE user_id = ? AND id != ?"):
+		return tbl.delete(func(s SessionModel) bool { return s.UserID == args[0].(int64) && s.ID != args[1].(string) }), nil
+	case strings.HasPrefix(query, "DELETE FROM sessions WHERE id = ?"):
+		return tbl.delete(func(s SessionModel) bool { return s.ID == args[0].(string) }), nil
+	case strings.HasPrefix(query, "INSERT INTO sessions"):
+		s := SessionModel{ID: args[0].(string), UserID: args[1].(int64), ExpiresAt: args[2].(int64), CreatedAt: args[3].(int64)}
+		tbl.rows[s.ID] = s
+		return fakeSQLResult{RowsAffected: 1}, nil
+	case strings.HasPrefix(query, "SELECT * FROM sessions WHERE id = ? AND expires_at >= ?"):
+		r := fakeSQLResult{Columns: []string{"id", "user_id", "expires_at", "created_at"}}
+		if s, ok := tbl.rows[args[0].(string)]; ok && s.ExpiresAt >= args[1].(int64) {
+			r.Rows = append(r.Rows, []driver.Value{s.ID, s.UserID, s.ExpiresAt, s.CreatedAt})
+		}
+		return r, nil
+	case query == "TRUNCATE TABLE sessions":
+		tbl.rows = make(map[string]SessionModel)
+		return fakeSQLResult{}, nil
+	}
+	return fakeSQLResult{}, fmt.Errorf("unexpected query: %s", query)
+}
+
+func (tbl *fakeSessionsTable) delete(match func(SessionModel) bool) fakeSQLResult {
+	var r fakeSQLResult
+	for id, s := range tbl.rows {
+		if match(s) {
+			delete(tbl.rows, id)
+			r.RowsAffected++
+		}
+	}
+	return r
+}
+
+func TestMySQLSessionStore(t *testing.T) {
+	ctx := context.Background()
+	tbl := &fakeSessionsTable{rows: make(map[string]SessionModel)}
+	db, _ := newFakeSQLDB(t, tbl.handle)
+	store := &mysqlSessionStore{db: db}
+
+	expired := time.Now().Add(-1 * time.Hour).Unix()
+	expiresAt := time.Now().Add(1 * time.Hour).Unix()
+	tbl.rows["expired-1"] = SessionModel{ID: "expired-1", UserID: 1, ExpiresAt: expired}
+	tbl.rows["expired-2"] = SessionModel{ID: "expired-2", UserID: 2, ExpiresAt: expired}
+
+	for _, s := range []*SessionModel{
+		{ID: "a", UserID: 1, ExpiresAt: expiresAt, CreatedAt: 1},
+		{ID: "b", UserID: 1, ExpiresAt: expiresAt, CreatedAt: 2},
+		{ID: "c", UserID: 2, ExpiresAt: expiresAt, CreatedAt: 3},
+	} {
+		assert.NoError(t, store.Create(ctx, s))
+	}
+	// ログインしたユーザの期限切れセッションだけを消す
+	assert.NotContains(t, tbl.rows, "expired-1")
+	assert.NotContains(t, tbl.rows, "expired-2")
+	assert.Equal(t, 0, tbl.sweeps)
+
+	got, err := store.Get(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, &SessionModel{ID: "a", UserID: 1, ExpiresAt: expiresAt, CreatedAt: 1}, got)
+
+	tbl.rows["expired-3"] = SessionModel{ID: "expired-3", UserID: 3, ExpiresAt: expired}
+	_, err = store.Get(ctx, "expired-3")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+	_, err = store.Get(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	// 一定回数ごとに、ログインしていないユーザの期限切れセッションも消す
+	for i := 3; i < sessionSweepInterval; i++ {
+		assert.NoError(t, store.Create(ctx, &SessionModel{ID: fmt.Sprintf("s%d", i), UserID: 1, ExpiresAt: expiresAt}))
+	}
+	assert.Equal(t, 1, tbl.sweeps)
+	assert.NotContains(t, tbl.rows, "expired-3")
+
+	// ログアウト
+	assert.NoError(t, store.Delete(ctx, "a"))
+	_, err = store.Get(ctx, "a")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+
+	// 他端末のセッションのみ失効
+	assert.NoError(t, store.DeleteByUserID(ctx, 1, "b"))
+	assert.Len(t, tbl.rows, 2)
+	assert.Contains(t, tbl.rows, "b")
+	assert.Contains(t, tbl.rows, "c")
+
+	assert.NoError(t, store.Reset(ctx))
+	assert.Empty(t, tbl.rows)
+}
+
+func TestMySQLSessionStoreError(t *testing.T) {
+	ctx := context.Background()
+	errDB := errors.New("connection refused")
+	db, _ := newFakeSQLDB(t, func(string, []driver.Value) (fakeSQLResult, error) {
+		return fakeSQLResult{}, errDB
+	})
+	store := &mysqlSessionStore{db: db}
+
+	// 見つからない場合と区別する
+	_, err := store.Get(ctx, "a")
+	assert.ErrorIs(t, err, errDB)
+	assert.NotErrorIs(t, err, ErrSessionNotFound)
+	assert.ErrorIs(t, store.Create(ctx, &SessionModel{ID: "a", UserID: 1}), errDB)
+	assert.ErrorIs(t, store.Delete(ctx, "a"), errDB)
+	assert.ErrorIs(t, store.DeleteByUserID(ctx, 1, ""), errDB)
+}
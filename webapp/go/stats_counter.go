@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// 統計情報のカウンタ
+// ライブコメント・リアクション・スパム報告の書き込みと同じトランザクションで更新し、
+// 統計情報APIでは全件の集計をせずにカウンタとスコアのインデックスから値とランクを求める
+// モデレーションによる論理削除ではライブコメントは残るため、カウンタは変化しない
+// 視聴者数はハートビートの時刻に依存するため、カウンタにせず配信ごとの視聴履歴から数える(countViewers)
+
+type LivestreamStatsModel struct {
+	LivestreamID int64 `db:"livestream_id"`
+	UserID       int64 `db:"user_id"`
+	Reactions    int64 `db:"reactions"`
+	Livecomments int64 `db:"livecomments"`
+	TotalTip     int64 `db:"total_tip"`
+	MaxTip       int64 `db:"max_tip"`
+	Reports      int64 `db:"reports"`
+	// reactions + total_tip
+	Score int64 `db:"score"`
+}
+
+type UserStatsModel struct {
+	UserID       int64  `db:"user_id"`
+	Name         string `db:"name"`
+	Reactions    int64  `db:"reactions"`
+	Livecomments int64  `db:"livecomments"`
+	TotalTip     int64  `db:"total_tip"`
+	// reactions + total_tip
+	Score int64 `db:"score"`
+}
+
+func createUserStats(ctx context.Context, tx *sqlx.Tx, userID int64, name string) error {
+	if _, err := tx.ExecContext(ctx, "INSERT INTO user_stats (user_id, name) VALUES (?, ?)", userID, name); err != nil {
+		return fmt.Errorf("failed to insert user_stats: %w", err)
+	}
+	return nil
+}
+
+func createLivestreamStats(ctx context.Context, tx *sqlx.Tx, livestreamID, userID int64) error {
+	if _, err := tx.ExecContext(ctx, "INSERT INTO livestream_stats (livestream_id, user_id) VALUES (?, ?)", livestreamID, userID); err != nil {
+		return fmt.Errorf("failed to insert livestream_stats: %w", err)
+	}
+	return nil
+}
+
+// countReaction は配信と配信者のリアクション数を1つ増やす
+func countReaction(ctx context.Context, tx *sqlx.Tx, livestreamID int64) error {
+	query := `
+		UPDATE livestream_stats s
+		INNER JOIN user_stats u ON u.user_id = s.user_id
+		SET s.reactions = s.reactions + 1, u.reactions = u.reactions + 1
+		WHERE s.livestream_id = ?`
+	if _, err := tx.ExecContext(ctx, query, livestreamID); err != nil {
+		return fmt.Errorf("failed to count reaction: %w", err)
+	}
+	return nil
+}
+
+// countLivecomment は配信と配信者のライブコメント数とチップを加算する
+func countLivecomment(ctx context.Context, tx *sqlx.Tx, livestreamID, tip int64) error {
+	query := `
+		UPDATE livestream_stats s
+		INNER JOIN user_stats u ON u.user_id = s.user_id
+		SET
+			s.livecomments = s.livecomments + 1,
+			s.total_tip = s.total_tip + ?,
+			s.max_tip = GREATEST(s.max_tip, ?),
+			u.livecomments = u.livecomments + 1,
+			u.total_tip = u.total_tip + ?
+		WHERE s.livestream_id = ?`
+	if _, err := tx.ExecContext(ctx, query, tip, tip, tip, livestreamID); err != nil {
+		return fmt.Errorf("failed to count livecomment: %w", err)
+	}
+	return nil
+}
+
+// countReport は配信のスパム報告数を1つ増やす
+func countReport(ctx context.Context, tx *sqlx.Tx, livestreamID int64) error {
+	if _, err := tx.ExecContext(ctx, "UPDATE livestream_stats SET reports = reports + 1 WHERE livestream_id = ?", livestreamID); err != nil {
+		return fmt.Errorf("failed to count report: %w", err)
+	}
+	return nil
+}
+
+// removeLivestreamStats は配信の統計情報を削除し、配信者の統計情報から差し引く
+func removeLivestreamStats(ctx context.Context, tx *sqlx.Tx, livestreamID int64) error {
+	query := `
+		UPDATE user_stats u
+		INNER JOIN livestream_stats s ON s.user_id = u.user_id
+		SET
+			u.reactions = u.reactions - s.reactions,
+			u.livecomments = u.livecomments - s.livecomments,
+			u.total_tip = u.total_tip - s.total_tip
+		WHERE s.livestream_id = ?`
+	if _, err := tx.ExecContext(ctx, query, livestreamID); err != nil {
+		return fmt.Errorf("failed to subtract livestream_stats from user_stats: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM livestream_stats WHERE livestream_id = ?", livestreamID); err != nil {
+		return fmt.Errorf("failed to delete livestream_stats: %w", err)
+	}
+	return nil
+}
+
+// recalculateStats は配信と、その配信者の統計情報を元のテーブルから集計し直す
+// ユーザの削除などで、ライブコメント等が配信をまたいで削除された場合に使う
+func recalculateStats(ctx context.Context, tx *sqlx.Tx, livestreamIDs []int64) error {
+	if len(livestreamIDs) == 0 {
+		return nil
+	}
+
+	query, params, err := sqlx.In(`
+		UPDATE livestream_stats s
+		LEFT JOIN (
+			SELECT livestream_id, COUNT(*) AS count FROM reactions WHERE livestream_id IN (?) GROUP BY livestream_id
+		) r ON r.livestream_id = s.livestream_id
+		LEFT JOIN (
+			SELECT livestream_id, COUNT(*) AS count, SUM(tip) AS total_tip, MAX(tip) AS max_tip FROM livecomments WHERE livestream_id IN (?) GROUP BY livestream_id
+		) l ON l.livestream_id = s.livestream_id
+		LEFT JOIN (
+			SELECT livestream_id, COUNT(*) AS count FROM livecomment_reports WHERE livestream_id IN (?) GROUP BY livestream_id
+		) rp ON rp.livestream_id = s.livestream_id
+		SET
+			s.reactions = IFNULL(r.count, 0),
+			s.livecomments = IFNULL(l.count, 0),
+			s.total_tip = IFNULL(l.total_tip, 0),
+			s.max_tip = IFNULL(l.max_tip, 0),
+			s.reports = IFNULL(rp.count, 0)
+		WHERE s.livestream_id IN (?)`, livestreamIDs, livestreamIDs, livestreamIDs, livestreamIDs)
+	if err != nil {
+		return fmt.Errorf("failed to create livestream_stats query: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, query, params...); err != nil {
+		return fmt.Errorf("failed to recalculate livestream_stats: %w", err)
+	}
+
+	var userIDs []int64
+	query, params, err = sqlx.In("SELECT DISTINCT user_id FROM livestream_stats WHERE livestream_id IN (?)", livestreamIDs)
+	if err != nil {
+		return fmt.Errorf("failed to create livestream_stats query: %w", err)
+	}
+	if err := tx.SelectContext(ctx, &userIDs, query, params...); err != nil {
+		return fmt.Errorf("failed to get streamers: %w", err)
+	}
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	query, params, err = sqlx.In(`
+		UPDATE user_stats u
+		LEFT JOIN (
+			SELECT user_id, SUM(reactions) AS reactions, SUM(livecomments) AS livecomments, SUM(total_tip) AS total_tip
+			FROM livestream_stats WHERE user_id IN (?) GROUP BY user_id
+		) s ON s.user_id = u.user_id
+		SET
+			u.reactions = IFNULL(s.reactions, 0),
+			u.livecomments = IFNULL(s.livecomments, 0),
+			u.total_tip = IFNULL(s.total_tip, 0)
+		WHERE u.user_id IN (?)`, userIDs, userIDs)
+	if err != nil {
+		return fmt.Errorf("failed to create user_stats query: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, query, params...); err != nil {
+		return fmt.Errorf("failed to recalculate user_stats: %w", err)
+	}
+	return nil
+}
+
+// livestreamRank は配信のランクを返す
+// スコアの降順、同点の場合は配信IDの降順で並べた順位
+func livestreamRank(ctx context.Context, tx *sqlx.Tx, stats LivestreamStatsModel) (int64, error) {
+	var higher int64
+	if err := tx.GetContext(ctx, &higher, "SELECT COUNT(*) FROM livestream_stats WHERE (score, livestream_id) > (?, ?)", stats.Score, stats.LivestreamID); err != nil {
+		return 0, fmt.Errorf("failed to count higher ranked livestreams: %w", err)
+	}
+	return higher + 1, nil
+}
+
+// userRank は配信者のランクを返す
+// スコアの降順、同点の場合はユーザ名の降順で並べた順位
+func userRank(ctx context.Context, tx *sqlx.Tx, stats UserStatsModel) (int64, error) {
+	var higher int64
+	if err := tx.GetContext(ctx, &higher, "SELECT COUNT(*) FROM user_stats WHERE (score, name) > (?, ?)", stats.Score, stats.Name); err != nil {
+		return 0, fmt.Errorf("failed to count higher ranked users: %w", err)
+	}
+	return higher + 1, nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// statsOracle はbench/internal/scheduler/stats_scheduler.goのStatsSchedulerと同じ規則で集計し、順位をつける
+// ベンチマーカーのinternalパッケージは別モジュールから読み込めないので、集計と順位付けをここに写している
+type statsOracle struct {
+	users       map[string]*oracleUserStats
+	livestreams map[int64]*oracleLivestreamStats
+	streamers   map[int64]string
+}
+
+type oracleUserStats struct {
+	username          string
+	reactions         map[string]int64
+	totalLivecomments int64
+	totalTips         int64
+}
+
+func (s *oracleUserStats) totalReactions() int64 {
+	var total int64
+	for _, count := range s.reactions {
+		total += count
+	}
+	return total
+}
+
+func (s *oracleUserStats) score() int64 {
+	return s.totalReactions() + s.totalTips
+}
+
+// favoriteEmoji は最も多い絵文字を返す. 同数の場合は名前の大きいもの
+func (s *oracleUserStats) favoriteEmoji() string {
+	var (
+		favoriteEmojis     []string
+		favoriteEmojiCount int64
+	)
+	for emoji, count := range s.reactions {
+		if count > favoriteEmojiCount {
+			favoriteEmojis = []string{emoji}
+			favoriteEmojiCount = count
+		} else if count == favoriteEmojiCount {
+			favoriteEmojis = append(favoriteEmojis, emoji)
+		}
+	}
+	if len(favoriteEmojis) == 0 {
+		return ""
+	}
+	slices.Sort(favoriteEmojis)
+	return favoriteEmojis[len(favoriteEmojis)-1]
+}
+
+type oracleLivestreamStats struct {
+	livestreamID   int64
+	totalReports   int64
+	totalReactions int64
+	totalTips      int64
+	maxTip         int64
+}
+
+func (s *oracleLivestreamStats) score() int64 {
+	return s.totalReactions + s.totalTips
+}
+
+func (o *statsOracle) addReaction(livestreamID int64, emojiName string) {
+	o.users[o.streamers[livestreamID]].reactions[emojiName]++
+	o.livestreams[livestreamID].totalReactions++
+}
+
+func (o *statsOracle) addLivecomment(livestreamID int64, tip int64) {
+	u := o.users[o.streamers[livestreamID]]
+	u.totalLivecomments++
+	u.totalTips += tip
+	l := o.livestreams[livestreamID]
+	l.totalTips += tip
+	l.maxTip = max(l.maxTip, tip)
+}
+
+func (o *statsOracle) addReport(livestreamID int64) {
+	o.livestreams[livestreamID].totalReports++
+}
+
+// userRank はスコアの昇順、同点ならユーザ名の昇順に並べ、後ろから数えた順位を返す
+func (o *statsOracle) userRank(username string) int64 {
+	stats := make([]*oracleUserStats, 0, len(o.users))
+	for _, s := range o.users {
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].score() == stats[j].score() {
+			return stats[i].username < stats[j].username
+		}
+		return stats[i].score() < stats[j].score()
+	})
+	for i := len(stats) - 1; i >= 0; i-- {
+		if stats[i].username == username {
+			return int64(len(stats) - i)
+		}
+	}
+	return 0
+}
+
+// livestreamRank はスコアの昇順、同点なら配信IDの昇順に並べ、後ろから数えた順位を返す
+func (o *statsOracle) livestreamRank(livestreamID int64) int64 {
+	stats := make([]*oracleLivestreamStats, 0, len(o.livestreams))
+	for _, s := range o.livestreams {
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].score() == stats[j].score() {
+			return stats[i].livestreamID < stats[j].livestreamID
+		}
+		return stats[i].score() < stats[j].score()
+	})
+	for i := len(stats) - 1; i >= 0; i-- {
+		if stats[i].livestreamID == livestreamID {
+			return int64(len(stats) - i)
+		}
+	}
+	return 0
+}
+
+const (
+	statsWriteReaction    = "reaction"
+	statsWriteLivecomment = "livecomment"
+	statsWriteReport      = "report"
+)
+
+// statsWrite は統計情報に関わる書き込み. userIDは書き込んだユーザ
+type statsWrite struct {
+	kind         string
+	userID       int64
+	livestreamID int64
+	emojiName    string
+	tip          int64
+}
+
+// replayStatsOracle は残っているユーザと配信について、書き込みをオラクルに流し直す
+// ベンチマーカーは配信のキャンセルや退会を扱わないので、その後は消えた行への書き込みを除いて集計し直す
+// 退会したユーザのリアクションとスパム報告は消えるが、他の配信へのライブコメントは匿名化して残る
+func replayStatsOracle(s *memoryRepositoryStore, writes []statsWrite) *statsOracle {
+	o := &statsOracle{
+		users:       map[string]*oracleUserStats{},
+		livestreams: map[int64]*oracleLivestreamStats{},
+		streamers:   map[int64]string{},
+	}
+	for _, u := range s.data.users {
+		o.users[u.Name] = &oracleUserStats{username: u.Name, reactions: map[string]int64{}}
+	}
+	for _, l := range s.data.livestreams {
+		o.livestreams[l.ID] = &oracleLivestreamStats{livestreamID: l.ID}
+		o.streamers[l.ID] = s.data.users[l.UserID].Name
+	}
+
+	for _, w := range writes {
+		if _, ok := o.livestreams[w.livestreamID]; !ok {
+			continue
+		}
+		_, authorExists := s.data.users[w.userID]
+		switch w.kind {
+		case statsWriteReaction:
+			if authorExists {
+				o.addReaction(w.livestreamID, w.emojiName)
+			}
+		case statsWriteLivecomment:
+			o.addLivecomment(w.livestreamID, w.tip)
+		case statsWriteReport:
+			if authorExists {
+				o.addReport(w.livestreamID)
+			}
+		}
+	}
+	return o
+}
+
+// assertStatsMatchOracle は統計情報APIの値がオラクルと一致することを確かめる
+func assertStatsMatchOracle(t *testing.T, o *statsOracle) {
+	t.Helper()
+
+	for name, want := range o.users {
+		rec := serveTestRequest(t, http.MethodGet, "/api/user/:username/statistics", "/api/user/"+name+"/statistics", testCollaboratorID, getUserStatisticsHandler)
+		if !assert.Equal(t, http.StatusOK, rec.Code, name) {
+			continue
+		}
+		got := decodeResponse[UserStatistics](t, rec)
+		assert.Equal(t, o.userRank(name), got.Rank, "rank of %s", name)
+		assert.Equal(t, want.totalReactions(), got.TotalReactions, "reactions of %s", name)
+		assert.Equal(t, want.totalLivecomments, got.TotalLivecomments, "livecomments of %s", name)
+		assert.Equal(t, want.totalTips, got.TotalTip, "tip of %s", name)
+		assert.Equal(t, want.favoriteEmoji(), got.FavoriteEmoji, "favorite emoji of %s", name)
+	}
+
+	for id, want := range o.livestreams {
+		rec := serveTestRequest(t, http.MethodGet, "/api/livestream/:livestream_id/statistics", "/api/livestream/"+strconv.FormatInt(id, 10)+"/statistics", testCollaboratorID, getLivestreamStatisticsHandler)
+		if !assert.Equal(t, http.StatusOK, rec.Code, id) {
+			continue
+		}
+		got := decodeResponse[LivestreamStatistics](t, rec)
+		assert.Equal(t, o.livestreamRank(id), got.Rank, "rank of livestream %d", id)
+		assert.Equal(t, want.totalReactions, got.TotalReactions, "reactions of livestream %d", id)
+		assert.Equal(t, want.totalReports, got.TotalReports, "reports of livestream %d", id)
+		assert.Equal(t, want.maxTip, got.MaxTip, "max tip of livestream %d", id)
+	}
+}
+
+// 書き込みで更新する統計情報が、ベンチマーカーのStatsSchedulerで集計した値と一致する
+func TestStatsCountersMatchBenchScheduler(t *testing.T) {
+	const (
+		aliceID           int64 = 4
+		aliceLivestreamID int64 = 3
+	)
+
+	s := newTestRepositoryStore(t)
+	dnsRegistrar = newMemoryDNSRegistrar("u.isucon.dev", defaultPowerDNSZoneFile, "127.0.0.1")
+	d := s.data
+	d.users[aliceID] = UserModel{ID: aliceID, Name: "alice", DisplayName: "アリス"}
+	d.themes[aliceID] = ThemeModel{ID: aliceID, UserID: aliceID}
+	d.userStats[aliceID] = UserStatsModel{UserID: aliceID, Name: "alice"}
+	d.livestreams[aliceLivestreamID] = LivestreamModel{ID: aliceLivestreamID, UserID: aliceID, Title: "配信3"}
+	d.livestreamStats[aliceLivestreamID] = LivestreamStatsModel{LivestreamID: aliceLivestreamID, UserID: aliceID}
+	moveTestLivestream(s, aliceLivestreamID, livestreamStatusLive)
+	// キャンセルできるよう、配信2を配信予定にする
+	moveTestLivestream(s, testOtherLivestreamID, livestreamStatusUpcoming)
+
+	// フィクスチャの行も書き込みとして流す
+	var writes []statsWrite
+	for _, id := range sortedIDs(d.reactions) {
+		r := d.reactions[id]
+		writes = append(writes, statsWrite{kind: statsWriteReaction, userID: r.UserID, livestreamID: r.LivestreamID, emojiName: r.EmojiName})
+	}
+	for _, id := range sortedIDs(d.livecomments) {
+		l := d.livecomments[id]
+		writes = append(writes, statsWrite{kind: statsWriteLivecomment, userID: l.UserID, livestreamID: l.LivestreamID, tip: l.Tip})
+	}
+	for _, id := range sortedIDs(d.reports) {
+		r := d.reports[id]
+		writes = append(writes, statsWrite{kind: statsWriteReport, userID: r.UserID, livestreamID: r.LivestreamID})
+	}
+	assertStatsMatchOracle(t, replayStatsOracle(s, writes))
+
+	react := func(userID, livestreamID int64, emojiName string) {
+		t.Helper()
+		rec := serveTestJSONRequest(t, http.MethodPost, "/api/livestream/:livestream_id/reaction", "/api/livestream/"+strconv.FormatInt(livestreamID, 10)+"/reaction", `{"emoji_name": "`+emojiName+`"}`, userID, postReactionHandler)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		writes = append(writes, statsWrite{kind: statsWriteReaction, userID: userID, livestreamID: livestreamID, emojiName: emojiName})
+	}
+	comment := func(userID, livestreamID, tip int64) int64 {
+		t.Helper()
+		rec := serveTestJSONRequest(t, http.MethodPost, "/api/livestream/:livestream_id/livecomment", "/api/livestream/"+strconv.FormatInt(livestreamID, 10)+"/livecomment", `{"comment": "応援", "tip": `+strconv.FormatInt(tip, 10)+`}`, userID, postLivecommentHandler)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		writes = append(writes, statsWrite{kind: statsWriteLivecomment, userID: userID, livestreamID: livestreamID, tip: tip})
+		return decodeResponse[Livecomment](t, rec).ID
+	}
+	report := func(userID, livestreamID, livecommentID int64) {
+		t.Helper()
+		rec := serveTestRequest(t, http.MethodPost, "/api/livestream/:livestream_id/livecomment/:livecomment_id/report", "/api/livestream/"+strconv.FormatInt(livestreamID, 10)+"/livecomment/"+strconv.FormatInt(livecommentID, 10)+"/report", userID, reportLivecommentHandler)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		writes = append(writes, statsWrite{kind: statsWriteReport, userID: userID, livestreamID: livestreamID})
+	}
+
+	// 配信2と配信3を同点にし、配信IDで順位が決まるようにする
+	react(testViewerID, testOtherLivestreamID, "heart")
+	react(testCollaboratorID, testOtherLivestreamID, "heart")
+	comment(testCollaboratorID, aliceLivestreamID, 100)
+	livecommentID := comment(testViewerID, testOtherLivestreamID, 98)
+	report(testCollaboratorID, testOtherLivestreamID, livecommentID)
+	react(testCollaboratorID, testLivestreamID, "tada")
+	comment(testCollaboratorID, testLivestreamID, 0)
+	// viewerとcollaboratorは配信を持たないので同点になり、ユーザ名で順位が決まる
+	assertStatsMatchOracle(t, replayStatsOracle(s, writes))
+
+	t.Run("cancel livestream", func(t *testing.T) {
+		rec := serveTestRequest(t, http.MethodDelete, "/api/livestream/:livestream_id", "/api/livestream/2", testStreamerID, cancelLivestreamHandler)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assertStatsMatchOracle(t, replayStatsOracle(s, writes))
+	})
+
+	t.Run("delete viewer", func(t *testing.T) {
+		rec := serveTestRequest(t, http.MethodDelete, "/api/user/me", "/api/user/me", testViewerID, deleteMeHandler)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assertStatsMatchOracle(t, replayStatsOracle(s, writes))
+	})
+
+	t.Run("delete streamer", func(t *testing.T) {
+		rec := serveTestRequest(t, http.MethodDelete, "/api/user/me", "/api/user/me", aliceID, deleteMeHandler)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.NotContains(t, s.data.userStats, aliceID)
+		assert.NotContains(t, s.data.livestreamStats, aliceLivestreamID)
+		assertStatsMatchOracle(t, replayStatsOracle(s, writes))
+	})
+}
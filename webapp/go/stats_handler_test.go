@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// addTestViewers は配信1に視聴中と退出済みの視聴者を、配信2にハートビートの途切れた視聴者を入れる
+func addTestViewers(s *memoryRepositoryStore) {
+	now := time.Now().Unix()
+	stale := now - int64(2*viewerHeartbeatTimeout/time.Second)
+	s.data.viewersHistory = []LivestreamViewerModel{
+		{UserID: testViewerID, LivestreamID: testLivestreamID, CreatedAt: now - 10, LastSeenAt: now},
+		{UserID: testCollaboratorID, LivestreamID: testLivestreamID, CreatedAt: now - 20, LastSeenAt: now - 10, LeftAt: now - 5},
+		{UserID: testViewerID, LivestreamID: testOtherLivestreamID, CreatedAt: stale, LastSeenAt: stale},
+	}
+}
+
+func TestGetUserStatisticsHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		username   string
+		wantStatus int
+		want       UserStatistics
+	}{
+		{
+			name:       "streamer",
+			username:   "streamer",
+			wantStatus: http.StatusOK,
+			want: UserStatistics{
+				Rank:              1,
+				ViewersCount:      2,
+				ConcurrentViewers: 1,
+				UniqueViewers:     2,
+				FollowersCount:    1,
+				TotalReactions:    3,
+				TotalLivecomments: 5,
+				TotalTip:          500,
+				// 同数の絵文字は名前の降順で選ぶ
+				FavoriteEmoji: "smile",
+			},
+		},
+		{
+			// スコアが同じユーザは名前の降順で順位をつける
+			name:       "same score as collaborator",
+			username:   "viewer",
+			wantStatus: http.StatusOK,
+			want:       UserStatistics{Rank: 2},
+		},
+		{
+			name:       "user not found",
+			username:   "nobody",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			addTestViewers(s)
+			rec := serveTestRequest(t, http.MethodGet, "/api/user/:username/statistics", "/api/user/"+tt.username+"/statistics", testViewerID, getUserStatisticsHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, tt.want, decodeResponse[UserStatistics](t, rec))
+			}
+		})
+	}
+}
+
+func TestGetLivestreamStatisticsHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		livestreamID string
+		wantStatus   int
+		want         LivestreamStatistics
+	}{
+		{
+			name:         "livestream with viewers",
+			livestreamID: "1",
+			wantStatus:   http.StatusOK,
+			want: LivestreamStatistics{
+				Rank:              1,
+				ViewersCount:      1,
+				ConcurrentViewers: 1,
+				UniqueViewers:     2,
+				TotalReactions:    3,
+				TotalReports:      1,
+				MaxTip:            500,
+			},
+		},
+		{
+			name:         "stale heartbeat",
+			livestreamID: "2",
+			wantStatus:   http.StatusOK,
+			want: LivestreamStatistics{
+				Rank:          2,
+				ViewersCount:  1,
+				UniqueViewers: 1,
+			},
+		},
+		{
+			name:         "livestream not found",
+			livestreamID: "100",
+			wantStatus:   http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			addTestViewers(s)
+			rec := serveTestRequest(t, http.MethodGet, "/api/livestream/:livestream_id/statistics", "/api/livestream/"+tt.livestreamID+"/statistics", testViewerID, getLivestreamStatisticsHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, tt.want, decodeResponse[LivestreamStatistics](t, rec))
+			}
+		})
+	}
+}
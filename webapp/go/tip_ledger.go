@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	earningsPeriodDay   = "day"
+	earningsPeriodMonth = "month"
+
+	earningsFormatJSON = "json"
+	earningsFormatCSV  = "csv"
+)
+
+// 収益の日次・月次集計はJSTの暦で区切る
+const earningsUTCOffset = 9 * 60 * 60
+
+// TipModel は投げ銭の台帳の1行
+// 投げ銭は払い戻さないので、モデレーションや配信・ユーザの削除でも台帳の行は削除しない
+// 削除したライブコメント・ユーザへの参照はNULLにする
+type TipModel struct {
+	ID            int64         `db:"id"`
+	LivecommentID sql.NullInt64 `db:"livecomment_id"`
+	LivestreamID  int64         `db:"livestream_id"`
+	StreamerID    int64         `db:"streamer_id"`
+	UserID        sql.NullInt64 `db:"user_id"`
+	Amount        int64         `db:"amount"`
+	CreatedAt     int64         `db:"created_at"`
+}
+
+type EarningsSummary struct {
+	// 日次の場合は2006-01-02、月次の場合は2006-01形式
+	Period    string `json:"period"`
+	TipsCount int64  `json:"tips_count"`
+	TotalTip  int64  `json:"total_tip"`
+}
+
+type Earnings struct {
+	Period    string            `json:"period"`
+	TipsCount int64             `json:"tips_count"`
+	TotalTip  int64             `json:"total_tip"`
+	Summaries []EarningsSummary `json:"summaries"`
+}
+
+// dailyEarnings はJSTの日ごとの集計. DayはJSTの1970-01-01からの日数
+type dailyEarnings struct {
+	Day       int64 `db:"day"`
+	TipsCount int64 `db:"tips_count"`
+	TotalTip  int64 `db:"total_tip"`
+}
+
+// recordTip はチップ付きライブコメントを台帳に記録する. チップがない場合は何もしない
+func recordTip(ctx context.Context, tx *sqlx.Tx, livecommentModel LivecommentModel, streamerID int64) error {
+	if livecommentModel.Tip <= 0 {
+		return nil
+	}
+	if _, err := tx.NamedExecContext(ctx, "INSERT INTO tips (livecomment_id, livestream_id, streamer_id, user_id, amount, created_at) VALUES (:livecomment_id, :livestream_id, :streamer_id, :user_id, :amount, :created_at)", &TipModel{
+		LivecommentID: sql.NullInt64{Int64: livecommentModel.ID, Valid: true},
+		LivestreamID:  livecommentModel.LivestreamID,
+		StreamerID:    streamerID,
+		UserID:        sql.NullInt64{Int64: livecommentModel.UserID, Valid: true},
+		Amount:        livecommentModel.Tip,
+		CreatedAt:     livecommentModel.CreatedAt,
+	}); err != nil {
+		return fmt.Errorf("failed to insert tip: %w", err)
+	}
+	return nil
+}
+
+// 配信者の収益取得API
+// GET /api/user/me/earnings?period=day|month&from=&to=&format=json|csv
+func getMyEarningsHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	period := c.QueryParam("period")
+	if period == "" {
+		period = earningsPeriodDay
+	}
+	if period != earningsPeriodDay && period != earningsPeriodMonth {
+		return echo.NewHTTPError(http.StatusBadRequest, "period query parameter must be day or month")
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = earningsFormatJSON
+		if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "text/csv") {
+			format = earningsFormatCSV
+		}
+	}
+	if format != earningsFormatJSON && format != earningsFormatCSV {
+		return echo.NewHTTPError(http.StatusBadRequest, "format query parameter must be json or csv")
+	}
+
+	// 期間はUNIX時間で[from, to)
+	var from, to int64
+	if v := c.QueryParam("from"); v != "" {
+		f, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "from query parameter must be integer")
+		}
+		from = f
+	}
+	if v := c.QueryParam("to"); v != "" {
+		t, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "to query parameter must be integer")
+		}
+		to = t
+	}
+	if to > 0 && from >= to {
+		return echo.NewHTTPError(http.StatusBadRequest, "from must be less than to")
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	var daily []dailyEarnings
+	err := repositoryStore.Tx(ctx, func(r Repository) error {
+		var err error
+		daily, err = r.Tips().DailyEarnings(ctx, userID, from, to)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get tips: "+err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	earnings := summarizeEarnings(daily, period)
+
+	if format == earningsFormatCSV {
+		c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=UTF-8")
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"earnings_%s.csv\"", period))
+		c.Response().WriteHeader(http.StatusOK)
+		return writeEarningsCSV(c.Response(), earnings)
+	}
+	return c.JSON(http.StatusOK, earnings)
+}
+
+// summarizeEarnings は日ごとの集計を指定された期間ごとにまとめる. dailyは日付順であること
+func summarizeEarnings(daily []dailyEarnings, period string) Earnings {
+	layout := "2006-01-02"
+	if period == earningsPeriodMonth {
+		layout = "2006-01"
+	}
+
+	earnings := Earnings{
+		Period:    period,
+		Summaries: []EarningsSummary{},
+	}
+	for _, d := range daily {
+		// JSTにずらした日数なので、UTCとして書式化するとJSTの日付になる
+		label := time.Unix(d.Day*24*60*60, 0).UTC().Format(layout)
+		if n := len(earnings.Summaries); n > 0 && earnings.Summaries[n-1].Period == label {
+			earnings.Summaries[n-1].TipsCount += d.TipsCount
+			earnings.Summaries[n-1].TotalTip += d.TotalTip
+		} else {
+			earnings.Summaries = append(earnings.Summaries, EarningsSummary{
+				Period:    label,
+				TipsCount: d.TipsCount,
+				TotalTip:  d.TotalTip,
+			})
+		}
+		earnings.TipsCount += d.TipsCount
+		earnings.TotalTip += d.TotalTip
+	}
+	return earnings
+}
+
+func writeEarningsCSV(w io.Writer, earnings Earnings) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"period", "tips_count", "total_tip"}); err != nil {
+		return err
+	}
+	for _, s := range earnings.Summaries {
+		if err := cw.Write([]string{
+			s.Period,
+			strconv.FormatInt(s.TipsCount, 10),
+			strconv.FormatInt(s.TotalTip, 10),
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummarizeEarnings(t *testing.T) {
+	day := func(s string) int64 {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d.Unix() / (24 * 60 * 60)
+	}
+	daily := []dailyEarnings{
+		{Day: day("2023-11-30"), TipsCount: 1, TotalTip: 100},
+		{Day: day("2023-12-01"), TipsCount: 2, TotalTip: 300},
+		{Day: day("2023-12-31"), TipsCount: 1, TotalTip: 500},
+		{Day: day("2024-01-01"), TipsCount: 3, TotalTip: 1000},
+	}
+
+	tests := []struct {
+		name   string
+		daily  []dailyEarnings
+		period string
+		want   Earnings
+	}{
+		{
+			name:   "empty",
+			daily:  nil,
+			period: earningsPeriodDay,
+			want:   Earnings{Period: earningsPeriodDay, Summaries: []EarningsSummary{}},
+		},
+		{
+			name:   "by day",
+			daily:  daily,
+			period: earningsPeriodDay,
+			want: Earnings{
+				Period:    earningsPeriodDay,
+				TipsCount: 7,
+				TotalTip:  1900,
+				Summaries: []EarningsSummary{
+					{Period: "2023-11-30", TipsCount: 1, TotalTip: 100},
+					{Period: "2023-12-01", TipsCount: 2, TotalTip: 300},
+					{Period: "2023-12-31", TipsCount: 1, TotalTip: 500},
+					{Period: "2024-01-01", TipsCount: 3, TotalTip: 1000},
+				},
+			},
+		},
+		{
+			name:   "by month",
+			daily:  daily,
+			period: earningsPeriodMonth,
+			want: Earnings{
+				Period:    earningsPeriodMonth,
+				TipsCount: 7,
+				TotalTip:  1900,
+				Summaries: []EarningsSummary{
+					{Period: "2023-11", TipsCount: 1, TotalTip: 100},
+					{Period: "2023-12", TipsCount: 3, TotalTip: 800},
+					{Period: "2024-01", TipsCount: 3, TotalTip: 1000},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, summarizeEarnings(tt.daily, tt.period))
+		})
+	}
+}
+
+func TestEarningsDayIsJST(t *testing.T) {
+	// 2023-12-31 15:00 UTC はJSTで2024-01-01 00:00
+	createdAt := time.Date(2023, 12, 31, 15, 0, 0, 0, time.UTC).Unix()
+	earnings := summarizeEarnings([]dailyEarnings{
+		{Day: (createdAt + earningsUTCOffset) / (24 * 60 * 60), TipsCount: 1, TotalTip: 100},
+	}, earningsPeriodDay)
+	assert.Equal(t, "2024-01-01", earnings.Summaries[0].Period)
+}
+
+func TestWriteEarningsCSV(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeEarningsCSV(&buf, Earnings{
+		Period: earningsPeriodMonth,
+		Summaries: []EarningsSummary{
+			{Period: "2023-12", TipsCount: 3, TotalTip: 800},
+			{Period: "2024-01", TipsCount: 3, TotalTip: 1000},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "period,tips_count,total_tip\n2023-12,3,800\n2024-01,3,1000\n", buf.String())
+}
+
+// 投げ銭は払い戻さないので、配信のキャンセルや退会でライブコメントが消えても売上は変わらない
+func TestPaymentTotalSurvivesDeletion(t *testing.T) {
+	s := newTestRepositoryStore(t)
+	dnsRegistrar = newMemoryDNSRegistrar("u.isucon.dev", defaultPowerDNSZoneFile, "127.0.0.1")
+	d := s.data
+	// キャンセルできるよう、配信2を配信予定にする
+	moveTestLivestream(s, testOtherLivestreamID, livestreamStatusUpcoming)
+	d.livecomments[6] = LivecommentModel{ID: 6, UserID: testCollaboratorID, LivestreamID: testOtherLivestreamID, Comment: "予約おめでとう", Tip: 300, CreatedAt: 600}
+	d.tips[1] = TipModel{ID: 1, LivecommentID: sql.NullInt64{Int64: 2, Valid: true}, LivestreamID: testLivestreamID, StreamerID: testStreamerID, UserID: sql.NullInt64{Int64: testViewerID, Valid: true}, Amount: 500, CreatedAt: 200}
+	d.tips[2] = TipModel{ID: 2, LivecommentID: sql.NullInt64{Int64: 6, Valid: true}, LivestreamID: testOtherLivestreamID, StreamerID: testStreamerID, UserID: sql.NullInt64{Int64: testCollaboratorID, Valid: true}, Amount: 300, CreatedAt: 600}
+
+	assertTotalTip := func(want int64) {
+		t.Helper()
+		rec := serveTestRequest(t, http.MethodGet, "/api/payment", "/api/payment", 0, GetPaymentResult)
+		if assert.Equal(t, http.StatusOK, rec.Code) {
+			assert.Equal(t, want, decodeResponse[PaymentResult](t, rec).TotalTip)
+		}
+	}
+	assertTotalTip(800)
+
+	rec := serveTestRequest(t, http.MethodDelete, "/api/livestream/:livestream_id", "/api/livestream/2", testStreamerID, cancelLivestreamHandler)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assertTotalTip(800)
+	assert.Equal(t, TipModel{ID: 2, LivestreamID: testOtherLivestreamID, StreamerID: testStreamerID, UserID: sql.NullInt64{Int64: testCollaboratorID, Valid: true}, Amount: 300, CreatedAt: 600}, s.data.tips[2])
+
+	rec = serveTestRequest(t, http.MethodDelete, "/api/user/me", "/api/user/me", testViewerID, deleteMeHandler)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assertTotalTip(800)
+	// 他の配信に投稿したライブコメントは匿名化して残すので、台帳からはユーザへの参照だけを外す
+	assert.Equal(t, TipModel{ID: 1, LivecommentID: sql.NullInt64{Int64: 2, Valid: true}, LivestreamID: testLivestreamID, StreamerID: testStreamerID, Amount: 500, CreatedAt: 200}, s.data.tips[1])
+}
+
+func TestGetMyEarningsHandler(t *testing.T) {
+	s := newTestRepositoryStore(t)
+	// JSTで2023-12-31, 2024-01-01, 2024-01-01の投げ銭と、他の配信者への投げ銭
+	jst := time.FixedZone("JST", earningsUTCOffset)
+	for i, tip := range []TipModel{
+		{StreamerID: testStreamerID, Amount: 100, CreatedAt: time.Date(2023, 12, 31, 23, 0, 0, 0, jst).Unix()},
+		{StreamerID: testStreamerID, Amount: 200, CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, jst).Unix()},
+		{StreamerID: testStreamerID, Amount: 300, CreatedAt: time.Date(2024, 1, 1, 12, 0, 0, 0, jst).Unix()},
+		{StreamerID: testViewerID, Amount: 1000, CreatedAt: time.Date(2024, 1, 1, 12, 0, 0, 0, jst).Unix()},
+	} {
+		tip.ID = int64(i + 1)
+		s.data.tips[tip.ID] = tip
+	}
+	from := strconv.FormatInt(time.Date(2024, 1, 1, 0, 0, 0, 0, jst).Unix(), 10)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		want       Earnings
+	}{
+		{
+			name:       "by day",
+			wantStatus: http.StatusOK,
+			want: Earnings{
+				Period:    earningsPeriodDay,
+				TipsCount: 3,
+				TotalTip:  600,
+				Summaries: []EarningsSummary{
+					{Period: "2023-12-31", TipsCount: 1, TotalTip: 100},
+					{Period: "2024-01-01", TipsCount: 2, TotalTip: 500},
+				},
+			},
+		},
+		{
+			name:       "by month from",
+			query:      "?period=month&from=" + from,
+			wantStatus: http.StatusOK,
+			want: Earnings{
+				Period:    earningsPeriodMonth,
+				TipsCount: 2,
+				TotalTip:  500,
+				Summaries: []EarningsSummary{
+					{Period: "2024-01", TipsCount: 2, TotalTip: 500},
+				},
+			},
+		},
+		{
+			name:       "invalid period",
+			query:      "?period=year",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "from after to",
+			query:      "?from=200&to=100",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveTestRequest(t, http.MethodGet, "/api/user/me/earnings", "/api/user/me/earnings"+tt.query, testStreamerID, getMyEarningsHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, tt.want, decodeResponse[Earnings](t, rec))
+			}
+		})
+	}
+}
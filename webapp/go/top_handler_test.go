@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTagHandler(t *testing.T) {
+	s := newTestRepositoryStore(t)
+	s.data.tags[3] = TagModel{ID: 3, Name: "雑談"}
+
+	rec := serveTestRequest(t, http.MethodGet, "/api/tag", "/api/tag", 0, getTagHandler)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, TagsResponse{Tags: []*Tag{
+		{ID: 1, Name: "ライブ配信"},
+		{ID: 2, Name: "ゲーム実況"},
+		{ID: 3, Name: "雑談"},
+	}}, decodeResponse[TagsResponse](t, rec))
+}
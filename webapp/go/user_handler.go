@@ -3,13 +3,13 @@ package main
 import (
 	"context"
 	"crypto/sha256"
-	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"os"
-	"os/exec"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -28,7 +28,19 @@ const (
 	bcryptDefaultCost        = bcrypt.MinCost
 )
 
-var fallbackImage = "../img/NoImage.jpg"
+// 以下はconfig.goの設定で上書きする
+var (
+	// baseDomain はユーザごとのサブドメインを払い出すドメイン. セッションのCookieもこのドメインに発行する
+	baseDomain = "u.isucon.dev"
+	// iconPath はアップロードされたアイコンを保存するディレクトリ
+	iconPath      = "/home/isucon/webapp/public/icons"
+	fallbackImage = "../img/NoImage.jpg"
+	// fallbackImageHash はフォールバック画像のハッシュ. 起動時にloadFallbackImageHashで計算する
+	fallbackImageHash string
+
+	sessionTTL          = time.Hour
+	sessionCookieMaxAge = 60000 * time.Second
+)
 
 type UserModel struct {
 	ID             int64  `db:"id"`
@@ -45,6 +57,8 @@ type User struct {
 	Description string `json:"description,omitempty"`
 	Theme       Theme  `json:"theme,omitempty"`
 	IconHash    string `json:"icon_hash,omitempty"`
+	// フォロワー数
+	FollowersCount int64 `json:"followers_count"`
 }
 
 type Theme struct {
@@ -71,12 +85,34 @@ type PostUserRequestTheme struct {
 	DarkMode bool `json:"dark_mode"`
 }
 
+type UpdateUserRequest struct {
+	DisplayName *string                 `json:"display_name"`
+	Description *string                 `json:"description"`
+	Theme       *UpdateUserRequestTheme `json:"theme"`
+}
+
+type UpdateUserRequestTheme struct {
+	DarkMode *bool `json:"dark_mode"`
+}
+
+type UpdatePasswordRequest struct {
+	// CurrentPassword and NewPassword are non-hashed passwords.
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
 type LoginRequest struct {
 	Username string `json:"username"`
 	// Password is non-hashed password.
 	Password string `json:"password"`
 }
 
+type IconModel struct {
+	ID     int64  `db:"id"`
+	UserID int64  `db:"user_id"`
+	Hash   string `db:"hash"`
+}
+
 type PostIconRequest struct {
 	Image []byte `json:"image"`
 }
@@ -85,6 +121,72 @@ type PostIconResponse struct {
 	ID int64 `json:"id"`
 }
 
+// ユーザのアイコン取得API
+// GET /api/user/:username/icon
+func getIconHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	username := c.Param("username")
+
+	var iconHash string
+	err := repositoryStore.Tx(ctx, func(r Repository) error {
+		userModel, err := r.Users().FindByName(ctx, username)
+		if errors.Is(err, ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found user that has the given username")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+		}
+
+		iconHashes, err := r.Users().IconHashes(ctx, []int64{userModel.ID})
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get icon hash: "+err.Error())
+		}
+		iconHash = iconHashes[userModel.ID]
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// アイコン未登録のユーザと、アイコンのファイルが失われているユーザにはフォールバック画像を返す
+	imagePath := fmt.Sprintf("%s/%s.jpg", iconPath, username)
+	if iconHash == fallbackImageHash {
+		imagePath = fallbackImage
+	} else if _, err := os.Stat(imagePath); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to stat icon image: "+err.Error())
+		}
+		c.Logger().Warnf("icon image of %s is missing, serving fallback image", username)
+		imagePath = fallbackImage
+		iconHash = fallbackImageHash
+	}
+
+	c.Response().Header().Set("ETag", `"`+iconHash+`"`)
+	if matchETag(c.Request().Header.Get("If-None-Match"), iconHash) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.File(imagePath)
+}
+
+// matchETag reports whether the If-None-Match header value contains the given hash.
+func matchETag(ifNoneMatch string, hash string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		tag = strings.TrimPrefix(tag, "W/")
+		if strings.Trim(tag, `"`) == hash {
+			return true
+		}
+	}
+	return false
+}
+
 func postIconHandler(c echo.Context) error {
 	ctx := c.Request().Context()
 
@@ -103,31 +205,27 @@ func postIconHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
 	}
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
-	}
-	defer tx.Rollback()
-
-	var username string
-	if err := tx.GetContext(ctx, &username, "SELECT name from users where id = ?", userID); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get username "+err.Error())
-	}
+	// コミットした後に無効化する
+	defer iconHashCache.Delete(userID)
+	var (
+		username string
+		iconID   int64
+	)
+	err := repositoryStore.Tx(ctx, func(r Repository) error {
+		userModel, err := r.Users().FindByID(ctx, userID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get username "+err.Error())
+		}
+		username = userModel.Name
 
-	if _, err := tx.ExecContext(ctx, "DELETE FROM icons WHERE user_id = ?", userID); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete old user icon: "+err.Error())
-	}
-	rs, err := tx.ExecContext(ctx, "INSERT INTO icons (user_id, hash) VALUES (?, ?)", userID, fmt.Sprintf("%x", sha256.Sum256(req.Image)))
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert new user icon: "+err.Error())
-	}
-	iconID, err := rs.LastInsertId()
+		iconID, err = r.Users().SetIcon(ctx, userID, fmt.Sprintf("%x", sha256.Sum256(req.Image)))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert new user icon: "+err.Error())
+		}
+		return nil
+	})
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get last inserted icon id: "+err.Error())
-	}
-
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+		return err
 	}
 
 	if err := os.WriteFile(fmt.Sprintf("%s/%s.jpg", iconPath, username), req.Image, 0644); err != nil {
@@ -152,97 +250,383 @@ func getMeHandler(c echo.Context) error {
 	// existence already checked
 	userID := sess.Values[defaultUserIDKey].(int64)
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
+	var user User
+	err := repositoryStore.Tx(ctx, func(r Repository) error {
+		userModel, err := r.Users().FindByID(ctx, userID)
+		if errors.Is(err, ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found user that has the userid in session")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+		}
+
+		user, err = fillUserResponse(ctx, r, userModel)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill user: "+err.Error())
+		}
+		return nil
+	})
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+		return err
 	}
-	defer tx.Rollback()
 
-	userModel := UserModel{}
-	err = tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE id = ?", userID)
-	if errors.Is(err, sql.ErrNoRows) {
-		return echo.NewHTTPError(http.StatusNotFound, "not found user that has the userid in session")
-	}
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+	return c.JSON(http.StatusOK, user)
+}
+
+// プロフィール・テーマ更新API
+// PATCH /api/user/me
+func updateMeHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
 	}
 
-	user, err := fillUserResponse(ctx, tx, userModel)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill user: "+err.Error())
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	var req *UpdateUserRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
 	}
 
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	// コミットした後に無効化する
+	defer invalidateUserCaches(userID)
+	var user User
+	err := repositoryStore.Tx(ctx, func(r Repository) error {
+		userModel, err := r.Users().FindByIDForUpdate(ctx, userID)
+		if errors.Is(err, ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found user that has the userid in session")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+		}
+
+		if req.DisplayName != nil {
+			userModel.DisplayName = *req.DisplayName
+		}
+		if req.Description != nil {
+			userModel.Description = *req.Description
+		}
+		if err := r.Users().Update(ctx, userModel); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update user: "+err.Error())
+		}
+
+		if req.Theme != nil && req.Theme.DarkMode != nil {
+			if err := r.Users().UpdateDarkMode(ctx, userID, *req.Theme.DarkMode); err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to update user theme: "+err.Error())
+			}
+		}
+
+		user, err = fillUserResponse(ctx, r, userModel)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill user: "+err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	return c.JSON(http.StatusOK, user)
 }
 
-// ユーザ登録API
-// POST /api/register
-func registerHandler(c echo.Context) error {
+// パスワード変更API
+// PUT /api/user/me/password
+func updatePasswordHandler(c echo.Context) error {
 	ctx := c.Request().Context()
 	defer c.Request().Body.Close()
 
-	req := PostUserRequest{}
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+	sessionID := sess.Values[defaultSessionIDKey].(string)
+
+	var req *UpdatePasswordRequest
 	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
 	}
-
-	if req.Name == "pipe" {
-		return echo.NewHTTPError(http.StatusBadRequest, "the username 'pipe' is reserved")
+	if req.NewPassword == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "new_password must not be empty")
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcryptDefaultCost)
+	// コミットした後に無効化する
+	defer userCache.Delete(userID)
+	var user User
+	err := repositoryStore.Tx(ctx, func(r Repository) error {
+		userModel, err := r.Users().FindByIDForUpdate(ctx, userID)
+		if errors.Is(err, ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found user that has the userid in session")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+		}
+
+		err = bcrypt.CompareHashAndPassword([]byte(userModel.HashedPassword), []byte(req.CurrentPassword))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return echo.NewHTTPError(http.StatusUnauthorized, "invalid password")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to compare hash and password: "+err.Error())
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcryptDefaultCost)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate hashed password: "+err.Error())
+		}
+		userModel.HashedPassword = string(hashedPassword)
+
+		if err := r.Users().Update(ctx, userModel); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update password: "+err.Error())
+		}
+
+		user, err = fillUserResponse(ctx, r, userModel)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill user: "+err.Error())
+		}
+		return nil
+	})
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate hashed password: "+err.Error())
+		return err
 	}
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
+	// パスワードを変更した端末以外のセッションは失効させる
+	if err := sessionStore.DeleteByUserID(ctx, userID, sessionID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to revoke sessions: "+err.Error())
 	}
-	defer tx.Rollback()
 
-	userModel := UserModel{
-		Name:           req.Name,
-		DisplayName:    req.DisplayName,
-		Description:    req.Description,
-		HashedPassword: string(hashedPassword),
+	return c.JSON(http.StatusOK, user)
+}
+
+// 退会API
+// DELETE /api/user/me
+func deleteMeHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
 	}
 
-	result, err := tx.NamedExecContext(ctx, "INSERT INTO users (name, display_name, description, password) VALUES(:name, :display_name, :description, :password)", userModel)
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	var (
+		userModel     UserModel
+		livestreamIDs []int64
+	)
+	// コミットした後に無効化する
+	defer func() {
+		invalidateUserCaches(userID)
+		invalidateLivestreamCaches(livestreamIDs...)
+	}()
+	err := repositoryStore.Tx(ctx, func(r Repository) error {
+		var err error
+		userModel, err = r.Users().FindByIDForUpdate(ctx, userID)
+		if errors.Is(err, ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "not found user that has the userid in session")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+		}
+
+		livestreamModels, err := r.Livestreams().ListByUserID(ctx, userID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get livestreams: "+err.Error())
+		}
+		for _, l := range livestreamModels {
+			livestreamIDs = append(livestreamIDs, l.ID)
+		}
+
+		if err := r.Users().Delete(ctx, userID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete user: "+err.Error())
+		}
+		return nil
+	})
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert user: "+err.Error())
+		return err
 	}
 
-	userID, err := result.LastInsertId()
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get last inserted user id: "+err.Error())
+	// 以降はDBの外にあるリソースの後始末. 失敗してもユーザは削除済みなので警告に留める
+	if err := os.Remove(fmt.Sprintf("%s/%s.jpg", iconPath, userModel.Name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		c.Logger().Warnf("failed to remove icon of deleted user %s: %+v", userModel.Name, err)
+	}
+	if err := dnsRegistrar.RemoveRecord(ctx, userModel.Name); err != nil {
+		c.Logger().Warnf("failed to remove DNS record of deleted user %s: %+v", userModel.Name, err)
+	}
+	if err := sessionStore.DeleteByUserID(ctx, userID, ""); err != nil {
+		c.Logger().Warnf("failed to delete sessions of deleted user %s: %+v", userModel.Name, err)
+	}
+
+	if err := expireSessionCookie(c); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// deleteUser はユーザと、ユーザが所有する全てのデータを削除する
+// 配信予定の配信の予約枠は返却する
+// 他の配信に投稿したライブコメントと投げ銭はその配信の記録でもあるので削除せず、ユーザへの参照だけを外して匿名化する
+func deleteUser(ctx context.Context, tx *sqlx.Tx, userID int64) error {
+	var livestreamModels []*LivestreamModel
+	if err := tx.SelectContext(ctx, &livestreamModels, "SELECT * FROM livestreams WHERE user_id = ? FOR UPDATE", userID); err != nil {
+		return fmt.Errorf("failed to get livestreams: %w", err)
+	}
+
+	now := time.Now().Unix()
+	for _, l := range livestreamModels {
+		// 配信中・終了済みの配信の予約枠は既に使われたものなので返却しない
+		if l.StartAt > now {
+			reservation := reservationRange{StartAt: l.StartAt, EndAt: l.EndAt}
+			if _, err := lockReservationSlots(ctx, tx, reservation); err != nil {
+				return fmt.Errorf("failed to lock reservation_slots: %w", err)
+			}
+			if err := releaseReservationSlots(ctx, tx, reservation); err != nil {
+				return fmt.Errorf("failed to release reservation_slots: %w", err)
+			}
+		}
+		if err := deleteLivestream(ctx, tx, l.ID); err != nil {
+			return err
+		}
 	}
 
-	userModel.ID = userID
+	// 他の配信でのユーザの活動
+	// 削除後に、削除したリアクション・スパム報告があった配信の統計情報を集計し直す
+	var affectedLivestreamIDs []int64
+	query := `
+		SELECT livestream_id FROM reactions WHERE user_id = ?
+		UNION SELECT livestream_id FROM livecomment_reports WHERE user_id = ?`
+	if err := tx.SelectContext(ctx, &affectedLivestreamIDs, query, userID, userID); err != nil {
+		return fmt.Errorf("failed to get livestreams the user acted on: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM follows WHERE follower_id = ? OR followee_id = ?", userID, userID); err != nil {
+		return fmt.Errorf("failed to delete follows: %w", err)
+	}
+	// ライブコメントとモデレーション履歴は配信の記録として残し、退会したユーザに付け替える
+	for _, table := range []string{
+		"livecomments",
+		"livecomment_moderations",
+	} {
+		if _, err := tx.ExecContext(ctx, "UPDATE "+table+" SET user_id = ? WHERE user_id = ?", deletedUserID, userID); err != nil {
+			return fmt.Errorf("failed to anonymize %s: %w", table, err)
+		}
+	}
+	// 投げ銭は売上として台帳に残し、ユーザへの参照だけを外す
+	if _, err := tx.ExecContext(ctx, "UPDATE tips SET user_id = NULL WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("failed to detach tips: %w", err)
+	}
+	for _, table := range []string{
+		"livecomment_reports",
+		"reactions",
+		"livestream_viewers_history",
+		"livestream_collaborators",
+		"ng_words",
+		"icons",
+		"themes",
+	} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE user_id = ?", userID); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", table, err)
+		}
+	}
+
+	if err := recalculateStats(ctx, tx, affectedLivestreamIDs); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_stats WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("failed to delete user_stats: %w", err)
+	}
 
-	themeModel := ThemeModel{
-		UserID:   userID,
-		DarkMode: req.Theme.DarkMode,
+	if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = ?", userID); err != nil {
+		return fmt.Errorf("failed to delete users: %w", err)
 	}
-	if _, err := tx.NamedExecContext(ctx, "INSERT INTO themes (user_id, dark_mode) VALUES(:user_id, :dark_mode)", themeModel); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert user theme: "+err.Error())
+	return nil
+}
+
+// ユーザ登録API
+// POST /api/register
+func registerHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	defer c.Request().Body.Close()
+
+	req := PostUserRequest{}
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
 	}
 
-	if out, err := exec.Command("pdnsutil", "add-record", "u.isucon.dev", req.Name, "A", "3600", powerDNSSubdomainAddress).CombinedOutput(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, string(out)+": "+err.Error())
+	if req.Name == "pipe" {
+		return echo.NewHTTPError(http.StatusBadRequest, "the username 'pipe' is reserved")
 	}
 
-	user, err := fillUserResponse(ctx, tx, userModel)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcryptDefaultCost)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill user: "+err.Error())
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate hashed password: "+err.Error())
 	}
 
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+	var (
+		userModel = UserModel{
+			Name:           req.Name,
+			DisplayName:    req.DisplayName,
+			Description:    req.Description,
+			HashedPassword: string(hashedPassword),
+		}
+		user        User
+		recordAdded bool
+	)
+	// コミットした後に無効化する
+	defer func() {
+		if userModel.ID != 0 {
+			invalidateUserCaches(userModel.ID)
+		}
+	}()
+	err = repositoryStore.Tx(ctx, func(r Repository) error {
+		if err := r.Users().Create(ctx, &userModel); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert user: "+err.Error())
+		}
+
+		if err := r.Stats().CreateUser(ctx, userModel.ID, userModel.Name); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+
+		if err := r.Users().CreateTheme(ctx, ThemeModel{
+			UserID:   userModel.ID,
+			DarkMode: req.Theme.DarkMode,
+		}); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to insert user theme: "+err.Error())
+		}
+
+		if err := dnsRegistrar.AddRecord(ctx, req.Name); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to add DNS record: "+err.Error())
+		}
+		recordAdded = true
+
+		var err error
+		user, err = fillUserResponse(ctx, r, userModel)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill user: "+err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		// DNSレコードはトランザクションの外にあるので、ロールバックしたユーザの分を消しておく
+		if recordAdded {
+			if err := dnsRegistrar.RemoveRecord(ctx, req.Name); err != nil {
+				c.Logger().Warnf("failed to remove DNS record of unregistered user %s: %+v", req.Name, err)
+			}
+		}
+		return err
 	}
 
 	return c.JSON(http.StatusCreated, user)
@@ -259,24 +643,21 @@ func loginHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "failed to decode the request body as json")
 	}
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
-	}
-	defer tx.Rollback()
-
-	userModel := UserModel{}
-	// usernameはUNIQUEなので、whereで一意に特定できる
-	err = tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE name = ?", req.Username)
-	if errors.Is(err, sql.ErrNoRows) {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid username or password")
-	}
+	var userModel UserModel
+	err := repositoryStore.Tx(ctx, func(r Repository) error {
+		var err error
+		// usernameはUNIQUEなので、一意に特定できる
+		userModel, err = r.Users().FindByName(ctx, req.Username)
+		if errors.Is(err, ErrRecordNotFound) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "invalid username or password")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+		}
+		return nil
+	})
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
-	}
-
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+		return err
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(userModel.HashedPassword), []byte(req.Password))
@@ -287,7 +668,7 @@ func loginHandler(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to compare hash and password: "+err.Error())
 	}
 
-	sessionEndAt := time.Now().Add(1 * time.Hour)
+	sessionEndAt := time.Now().Add(sessionTTL)
 
 	sessionID := uuid.NewString()
 
@@ -297,8 +678,8 @@ func loginHandler(c echo.Context) error {
 	}
 
 	sess.Options = &sessions.Options{
-		Domain: "u.isucon.dev",
-		MaxAge: int(60000),
+		Domain: baseDomain,
+		MaxAge: int(sessionCookieMaxAge.Seconds()),
 		Path:   "/",
 	}
 	sess.Values[defaultSessionIDKey] = sessionID
@@ -306,6 +687,15 @@ func loginHandler(c echo.Context) error {
 	sess.Values[defaultUsernameKey] = userModel.Name
 	sess.Values[defaultSessionExpiresKey] = sessionEndAt.Unix()
 
+	if err := sessionStore.Create(ctx, &SessionModel{
+		ID:        sessionID,
+		UserID:    userModel.ID,
+		ExpiresAt: sessionEndAt.Unix(),
+		CreatedAt: time.Now().Unix(),
+	}); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create session: "+err.Error())
+	}
+
 	if err := sess.Save(c.Request(), c.Response()); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save session: "+err.Error())
 	}
@@ -313,6 +703,75 @@ func loginHandler(c echo.Context) error {
 	return c.NoContent(http.StatusOK)
 }
 
+// ユーザログアウトAPI
+// POST /api/logout
+func logoutHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	sessionID := sess.Values[defaultSessionIDKey].(string)
+
+	if err := sessionStore.Delete(ctx, sessionID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete session: "+err.Error())
+	}
+
+	if err := expireSessionCookie(c); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// 全端末からのログアウトAPI
+// POST /api/logout/all
+func logoutAllHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	if err := verifyUserSession(c); err != nil {
+		// echo.NewHTTPErrorが返っているのでそのまま出力
+		return err
+	}
+
+	// error already checked
+	sess, _ := session.Get(defaultSessionIDKey, c)
+	// existence already checked
+	userID := sess.Values[defaultUserIDKey].(int64)
+
+	if err := sessionStore.DeleteByUserID(ctx, userID, ""); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete sessions: "+err.Error())
+	}
+
+	if err := expireSessionCookie(c); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+func expireSessionCookie(c echo.Context) error {
+	sess, err := session.Get(defaultSessionIDKey, c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "failed to get session")
+	}
+
+	sess.Options = &sessions.Options{
+		Domain: baseDomain,
+		MaxAge: -1,
+		Path:   "/",
+	}
+	if err := sess.Save(c.Request(), c.Response()); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save session: "+err.Error())
+	}
+	return nil
+}
+
 // ユーザ詳細API
 // GET /api/user/:username
 func getUserHandler(c echo.Context) error {
@@ -324,27 +783,24 @@ func getUserHandler(c echo.Context) error {
 
 	username := c.Param("username")
 
-	tx, err := dbConn.BeginTxx(ctx, nil)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to begin transaction: "+err.Error())
-	}
-	defer tx.Rollback()
-
-	userModel := UserModel{}
-	if err := tx.GetContext(ctx, &userModel, "SELECT * FROM users WHERE name = ?", username); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+	var user User
+	err := repositoryStore.Tx(ctx, func(r Repository) error {
+		userModel, err := r.Users().FindByName(ctx, username)
+		if errors.Is(err, ErrRecordNotFound) {
 			return echo.NewHTTPError(http.StatusNotFound, "not found user that has the given username")
 		}
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
-	}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to get user: "+err.Error())
+		}
 
-	user, err := fillUserResponse(ctx, tx, userModel)
+		user, err = fillUserResponse(ctx, r, userModel)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill user: "+err.Error())
+		}
+		return nil
+	})
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fill user: "+err.Error())
-	}
-
-	if err := tx.Commit(); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to commit: "+err.Error())
+		return err
 	}
 
 	return c.JSON(http.StatusOK, user)
@@ -361,7 +817,7 @@ func verifyUserSession(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusForbidden, "failed to get EXPIRES value from session")
 	}
 
-	_, ok = sess.Values[defaultUserIDKey].(int64)
+	userID, ok := sess.Values[defaultUserIDKey].(int64)
 	if !ok {
 		return echo.NewHTTPError(http.StatusUnauthorized, "failed to get USERID value from session")
 	}
@@ -371,91 +827,97 @@ func verifyUserSession(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "session has expired")
 	}
 
+	// ログアウトや失効されたセッションを弾くため、サーバ側のセッションも確認する
+	sessionID, ok := sess.Values[defaultSessionIDKey].(string)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "failed to get SESSIONID value from session")
+	}
+	storedSession, err := sessionStore.Get(c.Request().Context(), sessionID)
+	if errors.Is(err, ErrSessionNotFound) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "session has been revoked")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to get session: "+err.Error())
+	}
+	if storedSession.UserID != userID {
+		return echo.NewHTTPError(http.StatusUnauthorized, "session has been revoked")
+	}
+
 	return nil
 }
 
-func fillUserResponse(ctx context.Context, tx *sqlx.Tx, userModel UserModel) (User, error) {
-	themeModel := ThemeModel{}
-	if err := tx.GetContext(ctx, &themeModel, "SELECT * FROM themes WHERE user_id = ?", userModel.ID); err != nil {
+// deletedUserID は退会したユーザが他の配信に投稿したライブコメント・モデレーション履歴のuser_id
+// AUTO_INCREMENTは1から始まるので、実在するユーザと重ならない
+const deletedUserID int64 = 0
+
+// deletedUserResponse は退会したユーザの代わりに返すユーザ. アイコンはフォールバック画像になる
+func deletedUserResponse(ctx context.Context, r Repository) (User, error) {
+	iconHashes, err := r.Users().IconHashes(ctx, []int64{deletedUserID})
+	if err != nil {
 		return User{}, err
 	}
+	return User{
+		ID:          deletedUserID,
+		DisplayName: "退会したユーザ",
+		IconHash:    iconHashes[deletedUserID],
+	}, nil
+}
 
-	var iconHash string
-	if err := tx.GetContext(ctx, &iconHash, "SELECT hash FROM icons WHERE user_id = ?", userModel.ID); err != nil {
-		if !errors.Is(err, sql.ErrNoRows) {
-			return User{}, err
-		}
-
-		image, err := os.ReadFile(fallbackImage)
+// fillUsersResponseByID はユーザIDをキーにしてユーザを返す
+// deletedUserIDには退会したユーザを入れ、存在しないユーザはマップに含めない
+func fillUsersResponseByID(ctx context.Context, r Repository, userIDs []int64) (map[int64]*User, error) {
+	userModels, err := r.Users().FindByIDs(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	users, err := fillUsersResponse(ctx, r, userModelsOf(userModels))
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(userIDs, deletedUserID) {
+		deletedUser, err := deletedUserResponse(ctx, r)
 		if err != nil {
-			return User{}, err
+			return nil, err
 		}
-		iconHash = fmt.Sprintf("%x", sha256.Sum256(image))
+		users[deletedUserID] = &deletedUser
 	}
+	return users, nil
+}
 
-	user := User{
-		ID:          userModel.ID,
-		Name:        userModel.Name,
-		DisplayName: userModel.DisplayName,
-		Description: userModel.Description,
-		Theme: Theme{
-			ID:       themeModel.ID,
-			DarkMode: themeModel.DarkMode,
-		},
-		IconHash: iconHash,
+func fillUserResponse(ctx context.Context, r Repository, userModel UserModel) (User, error) {
+	users, err := fillUsersResponse(ctx, r, []UserModel{userModel})
+	if err != nil {
+		return User{}, err
 	}
-
-	return user, nil
+	return *users[userModel.ID], nil
 }
 
-func fillUsersResponse(ctx context.Context, tx *sqlx.Tx, userModels []UserModel) (map[int64]*User, error) {
+func fillUsersResponse(ctx context.Context, r Repository, userModels []UserModel) (map[int64]*User, error) {
 	userIds := make([]int64, 0, len(userModels))
 	for _, user := range userModels {
 		userIds = append(userIds, user.ID)
 	}
 
-	themeModels := make([]ThemeModel, 0, len(userIds))
-	query, params, err := sqlx.In("SELECT user_id, id, dark_mode FROM themes WHERE user_id IN (?)", userIds)
+	themeMap, err := r.Users().Themes(ctx, userIds)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create themes query: %w", err)
-	}
-	if err := tx.SelectContext(ctx, &themeModels, query, params...); err != nil {
-		return nil, fmt.Errorf("failed to get themes: %w", err)
-	}
-	themeMap := make(map[int64]*ThemeModel, len(userIds))
-	for _, theme := range themeModels {
-		themeMap[theme.UserID] = &theme
+		return nil, err
 	}
 
-	fallbackImageBytes, err := os.ReadFile(fallbackImage)
+	iconHashMap, err := r.Users().IconHashes(ctx, userIds)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read fallback image")
+		return nil, err
 	}
-	fallbackImageHash := fmt.Sprintf("%x", sha256.Sum256(fallbackImageBytes))
 
-	images := make([]struct {
-		UserId   int64  `db:"user_id"`
-		IconHash string `db:"icon_hash"`
-	}, 0, len(userIds))
-	query, params, err = sqlx.In("SELECT user_id, hash as icon_hash FROM icons WHERE user_id IN (?)", userIds)
+	followers, err := r.Users().FollowerCounts(ctx, userIds)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create icons query: %w", err)
-	}
-	if err := tx.SelectContext(ctx, &images, query, params...); err != nil {
-		if !errors.Is(err, sql.ErrNoRows) {
-			return nil, err
-		}
-	}
-	imageMap := make(map[int64]string, len(images))
-	for _, image := range images {
-		imageMap[image.UserId] = image.IconHash
+		return nil, err
 	}
 
 	users := make(map[int64]*User, len(userIds))
 	for _, user := range userModels {
-		iconHash := fallbackImageHash
-		if v, ok := imageMap[user.ID]; ok {
-			iconHash = v
+		theme, ok := themeMap[user.ID]
+		if !ok {
+			return nil, fmt.Errorf("theme of user %d is not found", user.ID)
 		}
 		users[user.ID] = &User{
 			ID:          user.ID,
@@ -463,10 +925,11 @@ func fillUsersResponse(ctx context.Context, tx *sqlx.Tx, userModels []UserModel)
 			DisplayName: user.DisplayName,
 			Description: user.Description,
 			Theme: Theme{
-				ID:       themeMap[user.ID].ID,
-				DarkMode: themeMap[user.ID].DarkMode,
+				ID:       theme.ID,
+				DarkMode: theme.DarkMode,
 			},
-			IconHash: iconHash,
+			IconHash:       iconHashMap[user.ID],
+			FollowersCount: followers[user.ID],
 		}
 	}
 
@@ -0,0 +1,629 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestGetUserHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		username   string
+		wantStatus int
+		want       User
+	}{
+		{
+			name:       "user with icon and followers",
+			username:   "streamer",
+			wantStatus: http.StatusOK,
+			want: User{
+				ID:             testStreamerID,
+				Name:           "streamer",
+				DisplayName:    "配信者",
+				Theme:          Theme{ID: testStreamerID, DarkMode: true},
+				IconHash:       "streamer-icon",
+				FollowersCount: 1,
+			},
+		},
+		{
+			name:       "user without icon",
+			username:   "viewer",
+			wantStatus: http.StatusOK,
+			want: User{
+				ID:          testViewerID,
+				Name:        "viewer",
+				DisplayName: "視聴者",
+				Theme:       Theme{ID: testViewerID},
+				IconHash:    "fallback",
+			},
+		},
+		{
+			name:       "not found",
+			username:   "nobody",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestRepositoryStore(t)
+			rec := serveTestRequest(t, http.MethodGet, "/api/user/:username", "/api/user/"+tt.username, testViewerID, getUserHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, tt.want, decodeResponse[User](t, rec))
+			}
+		})
+	}
+}
+
+func TestGetMeHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		userID     int64
+		wantStatus int
+		wantName   string
+	}{
+		{
+			name:       "logged in user",
+			userID:     testCollaboratorID,
+			wantStatus: http.StatusOK,
+			wantName:   "collaborator",
+		},
+		{
+			name:       "deleted user",
+			userID:     100,
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestRepositoryStore(t)
+			rec := serveTestRequest(t, http.MethodGet, "/api/user/me", "/api/user/me", tt.userID, getMeHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus == http.StatusOK {
+				user := decodeResponse[User](t, rec)
+				assert.Equal(t, tt.userID, user.ID)
+				assert.Equal(t, tt.wantName, user.Name)
+			}
+		})
+	}
+}
+
+func TestUpdateMeHandler(t *testing.T) {
+	tests := []struct {
+		name            string
+		body            string
+		wantStatus      int
+		wantDisplayName string
+		wantDescription string
+		wantDarkMode    bool
+	}{
+		{
+			name:            "all fields",
+			body:            `{"display_name": "新しい名前", "description": "自己紹介", "theme": {"dark_mode": false}}`,
+			wantStatus:      http.StatusOK,
+			wantDisplayName: "新しい名前",
+			wantDescription: "自己紹介",
+			wantDarkMode:    false,
+		},
+		{
+			// 指定しなかった項目は変更しない
+			name:            "display name only",
+			body:            `{"display_name": "新しい名前"}`,
+			wantStatus:      http.StatusOK,
+			wantDisplayName: "新しい名前",
+			wantDarkMode:    true,
+		},
+		{
+			name:            "theme without dark mode",
+			body:            `{"theme": {}}`,
+			wantStatus:      http.StatusOK,
+			wantDisplayName: "配信者",
+			wantDarkMode:    true,
+		},
+		{
+			name:       "invalid json",
+			body:       `{"display_name": 1}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			rec := serveTestJSONRequest(t, http.MethodPatch, "/api/user/me", "/api/user/me", tt.body, testStreamerID, updateMeHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusOK {
+				assert.Equal(t, "配信者", s.data.users[testStreamerID].DisplayName)
+				return
+			}
+			user := decodeResponse[User](t, rec)
+			assert.Equal(t, testStreamerID, user.ID)
+			assert.Equal(t, "streamer", user.Name)
+			assert.Equal(t, tt.wantDisplayName, user.DisplayName)
+			assert.Equal(t, tt.wantDescription, user.Description)
+			assert.Equal(t, tt.wantDarkMode, user.Theme.DarkMode)
+			assert.Equal(t, "streamer-icon", user.IconHash)
+
+			assert.Equal(t, tt.wantDisplayName, s.data.users[testStreamerID].DisplayName)
+			assert.Equal(t, tt.wantDarkMode, s.data.themes[testStreamerID].DarkMode)
+		})
+	}
+
+	t.Run("deleted user", func(t *testing.T) {
+		newTestRepositoryStore(t)
+		rec := serveTestJSONRequest(t, http.MethodPatch, "/api/user/me", "/api/user/me", `{"display_name": "幽霊"}`, 100, updateMeHandler)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestUpdatePasswordHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		wantStatus   int
+		wantPassword string
+	}{
+		{
+			name:         "valid current password",
+			body:         `{"current_password": "password", "new_password": "new-password"}`,
+			wantStatus:   http.StatusOK,
+			wantPassword: "new-password",
+		},
+		{
+			name:         "wrong current password",
+			body:         `{"current_password": "wrong", "new_password": "new-password"}`,
+			wantStatus:   http.StatusUnauthorized,
+			wantPassword: "password",
+		},
+		{
+			name:         "empty new password",
+			body:         `{"current_password": "password", "new_password": ""}`,
+			wantStatus:   http.StatusBadRequest,
+			wantPassword: "password",
+		},
+		{
+			name:         "invalid json",
+			body:         `{"current_password": "password"`,
+			wantStatus:   http.StatusBadRequest,
+			wantPassword: "password",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			hashedPassword, err := bcrypt.GenerateFromPassword([]byte("password"), bcryptDefaultCost)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u := s.data.users[testStreamerID]
+			u.HashedPassword = string(hashedPassword)
+			s.data.users[testStreamerID] = u
+
+			// 別の端末でもログインしている状態にする
+			handler := func(c echo.Context) error {
+				other := &SessionModel{ID: "other-session", UserID: testStreamerID, ExpiresAt: time.Now().Add(time.Hour).Unix()}
+				if err := sessionStore.Create(c.Request().Context(), other); err != nil {
+					return err
+				}
+				return updatePasswordHandler(c)
+			}
+			rec := serveTestJSONRequest(t, http.MethodPut, "/api/user/me/password", "/api/user/me/password", tt.body, testStreamerID, handler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(s.data.users[testStreamerID].HashedPassword), []byte(tt.wantPassword)))
+
+			// 変更した端末のセッションは残り、他の端末のセッションは変更した場合のみ失効する
+			ctx := context.Background()
+			_, err = sessionStore.Get(ctx, "test-session")
+			assert.NoError(t, err)
+			_, err = sessionStore.Get(ctx, "other-session")
+			assert.Equal(t, tt.wantStatus == http.StatusOK, err != nil)
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, "streamer", decodeResponse[User](t, rec).Name)
+			}
+
+			// 他の端末のクッキーは有効期限内でも、失効していればverifyUserSessionで弾かれる
+			store := sessionStore
+			fromOtherDevice := func(c echo.Context) error {
+				sessionStore = store
+				sess, err := session.Get(defaultSessionIDKey, c)
+				if err != nil {
+					return err
+				}
+				sess.Values[defaultSessionIDKey] = "other-session"
+				return getMeHandler(c)
+			}
+			rec = serveTestRequest(t, http.MethodGet, "/api/user/me", "/api/user/me", testStreamerID, fromOtherDevice)
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, http.StatusUnauthorized, rec.Code)
+			} else {
+				assert.Equal(t, http.StatusOK, rec.Code)
+			}
+		})
+	}
+}
+
+func TestDeleteMeHandler(t *testing.T) {
+	t.Run("streamer", func(t *testing.T) {
+		s := newTestRepositoryStore(t)
+		registrar := newMemoryDNSRegistrar("u.isucon.dev", defaultPowerDNSZoneFile, "127.0.0.1")
+		assert.NoError(t, registrar.AddRecord(context.Background(), "streamer"))
+		dnsRegistrar = registrar
+		moveTestLivestream(s, testLivestreamID, livestreamStatusEnded)
+		moveTestLivestream(s, testOtherLivestreamID, livestreamStatusUpcoming)
+
+		rec := serveTestRequest(t, http.MethodDelete, "/api/user/me", "/api/user/me", testStreamerID, deleteMeHandler)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		d := s.data
+		assert.NotContains(t, d.users, testStreamerID)
+		assert.Empty(t, d.livestreams)
+		assert.Empty(t, d.livecomments)
+		assert.Empty(t, d.collaborators)
+		assert.Empty(t, d.ngWords)
+		assert.Empty(t, d.follows)
+		// 配信予定の配信の予約枠だけを返却する
+		assert.Equal(t, int64(4), d.reservationSlots[0].Slot)
+		assert.Equal(t, int64(5), d.reservationSlots[1].Slot)
+		// サブドメインのレコードも削除する
+		_, ok := registrar.Lookup("streamer")
+		assert.False(t, ok)
+	})
+
+	t.Run("viewer", func(t *testing.T) {
+		s := newTestRepositoryStore(t)
+		dnsRegistrar = newMemoryDNSRegistrar("u.isucon.dev", defaultPowerDNSZoneFile, "127.0.0.1")
+
+		rec := serveTestRequest(t, http.MethodDelete, "/api/user/me", "/api/user/me", testViewerID, deleteMeHandler)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		d := s.data
+		assert.NotContains(t, d.users, testViewerID)
+		assert.Empty(t, d.follows)
+		assert.Empty(t, d.reports)
+		assert.Equal(t, []int64{2}, sortedIDs(d.reactions))
+		// 他の配信に投稿したライブコメントは残して匿名化する
+		assert.Len(t, d.livecomments, 5)
+		for _, id := range []int64{1, 2, 3, 5} {
+			assert.Equal(t, deletedUserID, d.livecomments[id].UserID)
+		}
+		assert.Equal(t, testCollaboratorID, d.livecomments[4].UserID)
+		assert.Len(t, d.livestreams, 2)
+
+		rec = serveTestRequest(t, http.MethodGet, "/api/livestream/:livestream_id/livecomment", "/api/livestream/1/livecomment", testStreamerID, getLivecommentsHandler)
+		if assert.Equal(t, http.StatusOK, rec.Code) {
+			livecomments := decodeResponse[[]Livecomment](t, rec)
+			if assert.Len(t, livecomments, 3) {
+				assert.Equal(t, "よろしく", livecomments[0].Comment)
+				assert.Equal(t, User{ID: deletedUserID, DisplayName: "退会したユーザ", IconHash: "fallback"}, livecomments[1].User)
+			}
+		}
+	})
+
+	t.Run("collaborator", func(t *testing.T) {
+		s := newTestRepositoryStore(t)
+		dnsRegistrar = newMemoryDNSRegistrar("u.isucon.dev", defaultPowerDNSZoneFile, "127.0.0.1")
+
+		rec := serveTestRequest(t, http.MethodDelete, "/api/user/me", "/api/user/me", testCollaboratorID, deleteMeHandler)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+
+		d := s.data
+		assert.Empty(t, d.collaborators)
+		assert.Equal(t, deletedUserID, d.livecomments[4].UserID)
+		assert.Equal(t, []int64{1, 3}, sortedIDs(d.reactions))
+		assert.Equal(t, []int64{1}, sortedIDs(d.reports))
+	})
+}
+
+func TestRegisterHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{
+			name:       "new user",
+			body:       `{"name": "newuser", "display_name": "新規", "password": "password", "theme": {"dark_mode": true}}`,
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "reserved name",
+			body:       `{"name": "pipe", "password": "password"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "duplicate name",
+			body:       `{"name": "viewer", "password": "password"}`,
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			registrar := newMemoryDNSRegistrar("u.isucon.dev", defaultPowerDNSZoneFile, "127.0.0.1")
+			dnsRegistrar = registrar
+
+			rec := serveTestJSONRequest(t, http.MethodPost, "/api/register", "/api/register", tt.body, 0, registerHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus != http.StatusCreated {
+				assert.Len(t, s.data.users, 3)
+				assert.Len(t, s.data.userStats, 3)
+				return
+			}
+			user := decodeResponse[User](t, rec)
+			assert.Equal(t, User{ID: 4, Name: "newuser", DisplayName: "新規", Theme: Theme{ID: 4, DarkMode: true}, IconHash: "fallback"}, user)
+			assert.Equal(t, UserStatsModel{UserID: 4, Name: "newuser"}, s.data.userStats[4])
+			_, ok := registrar.Lookup("newuser")
+			assert.True(t, ok)
+		})
+	}
+}
+
+// failingCommitRepositoryStore はfnが成功してもコミットに失敗したものとしてロールバックする
+type failingCommitRepositoryStore struct {
+	RepositoryStore
+}
+
+func (s failingCommitRepositoryStore) Tx(ctx context.Context, fn func(r Repository) error) error {
+	return s.RepositoryStore.Tx(ctx, func(r Repository) error {
+		if err := fn(r); err != nil {
+			return err
+		}
+		return errors.New("failed to commit")
+	})
+}
+
+// failingDNSRegistrar はレコードの登録に失敗する
+type failingDNSRegistrar struct {
+	*memoryDNSRegistrar
+}
+
+func (failingDNSRegistrar) AddRecord(context.Context, string) error {
+	return errors.New("connection refused")
+}
+
+// DNSレコードはトランザクションの外にあるので、ユーザ登録をロールバックした場合に残らないようにする
+func TestRegisterHandlerRollback(t *testing.T) {
+	tests := []struct {
+		name      string
+		failing   func(s *memoryRepositoryStore, registrar *memoryDNSRegistrar)
+		wantError string
+	}{
+		{
+			name: "commit fails after adding DNS record",
+			failing: func(s *memoryRepositoryStore, registrar *memoryDNSRegistrar) {
+				repositoryStore = failingCommitRepositoryStore{s}
+				dnsRegistrar = registrar
+			},
+			wantError: "failed to commit",
+		},
+		{
+			name: "DNS registration fails",
+			failing: func(s *memoryRepositoryStore, registrar *memoryDNSRegistrar) {
+				dnsRegistrar = failingDNSRegistrar{registrar}
+			},
+			wantError: "failed to add DNS record",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			registrar := newMemoryDNSRegistrar("u.isucon.dev", defaultPowerDNSZoneFile, "127.0.0.1")
+			tt.failing(s, registrar)
+
+			body := `{"name": "newuser", "display_name": "新規", "password": "password", "theme": {"dark_mode": true}}`
+			rec := serveTestJSONRequest(t, http.MethodPost, "/api/register", "/api/register", body, 0, registerHandler)
+
+			assert.Equal(t, http.StatusInternalServerError, rec.Code)
+			assert.Contains(t, rec.Body.String(), tt.wantError)
+			assert.Len(t, s.data.users, 3)
+			assert.Len(t, s.data.userStats, 3)
+			_, ok := registrar.Lookup("newuser")
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestLoginHandler(t *testing.T) {
+	newTestRepositoryStore(t)
+	dnsRegistrar = newMemoryDNSRegistrar("u.isucon.dev", defaultPowerDNSZoneFile, "127.0.0.1")
+	rec := serveTestJSONRequest(t, http.MethodPost, "/api/register", "/api/register", `{"name": "newuser", "password": "password"}`, 0, registerHandler)
+	if !assert.Equal(t, http.StatusCreated, rec.Code) {
+		return
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{
+			name:       "valid password",
+			body:       `{"username": "newuser", "password": "password"}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "wrong password",
+			body:       `{"username": "newuser", "password": "wrong"}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "user not found",
+			body:       `{"username": "nobody", "password": "password"}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveTestJSONRequest(t, http.MethodPost, "/api/login", "/api/login", tt.body, 0, loginHandler)
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantStatus == http.StatusOK, rec.Header().Get("Set-Cookie") != "")
+		})
+	}
+}
+
+func TestLogoutHandlers(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler echo.HandlerFunc
+		// ログアウトした後も残るセッション
+		wantSessions []string
+	}{
+		{name: "logout", handler: logoutHandler, wantSessions: []string{"other-device", "other-user"}},
+		{name: "logout from all devices", handler: logoutAllHandler, wantSessions: []string{"other-user"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestRepositoryStore(t)
+
+			// 同じユーザの別の端末と、別のユーザのセッションを用意する
+			expiresAt := time.Now().Add(time.Hour).Unix()
+			handler := func(c echo.Context) error {
+				ctx := c.Request().Context()
+				for _, s := range []*SessionModel{
+					{ID: "other-device", UserID: testViewerID, ExpiresAt: expiresAt},
+					{ID: "other-user", UserID: testStreamerID, ExpiresAt: expiresAt},
+				} {
+					if err := sessionStore.Create(ctx, s); err != nil {
+						return err
+					}
+				}
+				return tt.handler(c)
+			}
+			rec := serveTestRequest(t, http.MethodPost, "/api/logout", "/api/logout", testViewerID, handler)
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			// クッキーも削除する
+			cookies := rec.Result().Cookies()
+			if assert.Len(t, cookies, 1) {
+				assert.Equal(t, defaultSessionIDKey, cookies[0].Name)
+				assert.Less(t, cookies[0].MaxAge, 0)
+			}
+
+			ctx := context.Background()
+			var remaining []string
+			for _, id := range []string{"test-session", "other-device", "other-user"} {
+				if _, err := sessionStore.Get(ctx, id); err == nil {
+					remaining = append(remaining, id)
+				}
+			}
+			assert.Equal(t, tt.wantSessions, remaining)
+
+			// クッキーが残っていても、ログアウトしたセッションでは操作できない
+			store := sessionStore
+			afterLogout := func(c echo.Context) error {
+				sessionStore = store
+				return getMeHandler(c)
+			}
+			rec = serveTestRequest(t, http.MethodGet, "/api/user/me", "/api/user/me", testViewerID, afterLogout)
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		})
+	}
+}
+
+func sortedIDs[V any](m map[int64]V) []int64 {
+	ids := make([]int64, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// setTestIconFiles はアイコンのディレクトリとフォールバック画像を一時ディレクトリに用意する
+// streamerのアイコンのファイルだけを置く
+func setTestIconFiles(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	prevIconPath, prevFallbackImage := iconPath, fallbackImage
+	t.Cleanup(func() { iconPath, fallbackImage = prevIconPath, prevFallbackImage })
+	iconPath = filepath.Join(dir, "icons")
+	fallbackImage = filepath.Join(dir, "NoImage.jpg")
+
+	assert.NoError(t, os.Mkdir(iconPath, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(iconPath, "streamer.jpg"), []byte("streamer image"), 0644))
+	assert.NoError(t, os.WriteFile(fallbackImage, []byte("fallback image"), 0644))
+}
+
+func TestGetIconHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		username    string
+		ifNoneMatch string
+		wantStatus  int
+		wantETag    string
+		wantBody    string
+	}{
+		{name: "registered icon", username: "streamer", wantStatus: http.StatusOK, wantETag: `"streamer-icon"`, wantBody: "streamer image"},
+		{name: "matching tag", username: "streamer", ifNoneMatch: `"streamer-icon"`, wantStatus: http.StatusNotModified, wantETag: `"streamer-icon"`},
+		{name: "weak matching tag", username: "streamer", ifNoneMatch: `W/"streamer-icon"`, wantStatus: http.StatusNotModified, wantETag: `"streamer-icon"`},
+		{name: "matching tag in list", username: "streamer", ifNoneMatch: `"other", "streamer-icon"`, wantStatus: http.StatusNotModified, wantETag: `"streamer-icon"`},
+		{name: "wildcard", username: "streamer", ifNoneMatch: `*`, wantStatus: http.StatusNotModified, wantETag: `"streamer-icon"`},
+		{name: "non-matching tag", username: "streamer", ifNoneMatch: `"other"`, wantStatus: http.StatusOK, wantETag: `"streamer-icon"`, wantBody: "streamer image"},
+		{name: "fallback for user without icon", username: "viewer", wantStatus: http.StatusOK, wantETag: `"fallback"`, wantBody: "fallback image"},
+		{name: "fallback matching tag", username: "viewer", ifNoneMatch: `"fallback"`, wantStatus: http.StatusNotModified, wantETag: `"fallback"`},
+		// collaboratorのアイコンは登録済みだがファイルがない
+		{name: "fallback for missing icon file", username: "collaborator", wantStatus: http.StatusOK, wantETag: `"fallback"`, wantBody: "fallback image"},
+		{name: "unknown user", username: "unknown", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			s.data.icons[testCollaboratorID] = IconModel{ID: 2, UserID: testCollaboratorID, Hash: "collaborator-icon"}
+			setTestIconFiles(t)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/user/"+tt.username+"/icon", nil)
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			rec := serveTestHTTPRequest(t, "/api/user/:username/icon", req, 0, getIconHandler)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantStatus == http.StatusNotFound {
+				return
+			}
+			assert.Equal(t, tt.wantETag, rec.Header().Get("ETag"))
+			assert.Equal(t, tt.wantBody, rec.Body.String())
+		})
+	}
+}
+
+func TestPostIconHandler(t *testing.T) {
+	s := newTestRepositoryStore(t)
+	setTestIconFiles(t)
+
+	body := `{"image": "` + base64.StdEncoding.EncodeToString([]byte("new image")) + `"}`
+	rec := serveTestJSONRequest(t, http.MethodPost, "/api/icon", "/api/icon", body, testStreamerID, postIconHandler)
+
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, PostIconResponse{ID: 2}, decodeResponse[PostIconResponse](t, rec))
+	assert.Equal(t, IconModel{ID: 2, UserID: testStreamerID, Hash: fmt.Sprintf("%x", sha256.Sum256([]byte("new image")))}, s.data.icons[testStreamerID])
+	image, err := os.ReadFile(filepath.Join(iconPath, "streamer.jpg"))
+	assert.NoError(t, err)
+	assert.Equal(t, "new image", string(image))
+
+	// 新しいハッシュがETagになる
+	rec = serveTestRequest(t, http.MethodGet, "/api/user/:username/icon", "/api/user/streamer/icon", 0, getIconHandler)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"`+s.data.icons[testStreamerID].Hash+`"`, rec.Header().Get("ETag"))
+	assert.Equal(t, "new image", rec.Body.String())
+}
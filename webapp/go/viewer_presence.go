@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	viewerHeartbeatTimeoutEnvKey = "ISUCON13_VIEWER_HEARTBEAT_TIMEOUT"
+)
+
+// 最後のハートビートからこの時間が経過した視聴者は、退出していなくても同時視聴者に数えない
+var viewerHeartbeatTimeout = 60 * time.Second
+
+// ViewerCounts は配信(または配信者の全配信)の視聴者数
+type ViewerCounts struct {
+	// 入室して退出していない視聴の数. 同じユーザが複数回入室した場合はそれぞれ数える
+	// 統計情報のviewers_countとして従来から返しているもの
+	Present int64 `db:"present"`
+	// ハートビートが途切れていない視聴者のユーザ数
+	Concurrent int64 `db:"concurrent"`
+	// これまでに一度でも入室したユーザ数
+	Unique int64 `db:"unique_viewers"`
+}
+
+// countViewers は配信の視聴者数を数える
+// livestreamIDsが空の場合は全て0を返す
+func countViewers(ctx context.Context, tx *sqlx.Tx, livestreamIDs []int64, now time.Time) (ViewerCounts, error) {
+	var counts ViewerCounts
+	if len(livestreamIDs) == 0 {
+		return counts, nil
+	}
+
+	staleBefore := now.Add(-viewerHeartbeatTimeout).Unix()
+	query, params, err := sqlx.In(`
+		SELECT
+			IFNULL(SUM(left_at = 0), 0) AS present,
+			COUNT(DISTINCT CASE WHEN left_at = 0 AND last_seen_at >= ? THEN user_id END) AS concurrent,
+			COUNT(DISTINCT user_id) AS unique_viewers
+		FROM livestream_viewers_history
+		WHERE livestream_id IN (?)`, staleBefore, livestreamIDs)
+	if err != nil {
+		return counts, fmt.Errorf("failed to create livestream_viewers_history query: %w", err)
+	}
+	if err := tx.GetContext(ctx, &counts, query, params...); err != nil {
+		return counts, fmt.Errorf("failed to count viewers: %w", err)
+	}
+	return counts, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViewerPresence(t *testing.T) {
+	type step struct {
+		action     string
+		userID     int64
+		wantStatus int
+	}
+	tests := []struct {
+		name  string
+		steps []step
+		want  ViewerCounts
+	}{
+		{
+			name: "enter and heartbeat",
+			steps: []step{
+				{"enter", testViewerID, http.StatusOK},
+				// 入室と同じ秒のハートビートも受け付ける
+				{"heartbeat", testViewerID, http.StatusNoContent},
+				{"enter", testCollaboratorID, http.StatusOK},
+			},
+			want: ViewerCounts{Present: 2, Concurrent: 2, Unique: 2},
+		},
+		{
+			// 退出しても視聴履歴は残り、ユニーク視聴者数に数える
+			name: "exit",
+			steps: []step{
+				{"enter", testViewerID, http.StatusOK},
+				{"enter", testCollaboratorID, http.StatusOK},
+				{"exit", testCollaboratorID, http.StatusOK},
+			},
+			want: ViewerCounts{Present: 1, Concurrent: 1, Unique: 2},
+		},
+		{
+			// 複数の端末から入室した場合、在室数はそれぞれ数えるが同時視聴者数は1人
+			name: "enter twice",
+			steps: []step{
+				{"enter", testViewerID, http.StatusOK},
+				{"enter", testViewerID, http.StatusOK},
+			},
+			want: ViewerCounts{Present: 2, Concurrent: 1, Unique: 1},
+		},
+		{
+			name: "exit leaves all entries",
+			steps: []step{
+				{"enter", testViewerID, http.StatusOK},
+				{"enter", testViewerID, http.StatusOK},
+				{"exit", testViewerID, http.StatusOK},
+				{"heartbeat", testViewerID, http.StatusNotFound},
+			},
+			want: ViewerCounts{Present: 0, Concurrent: 0, Unique: 1},
+		},
+		{
+			name: "heartbeat without enter",
+			steps: []step{
+				{"heartbeat", testViewerID, http.StatusNotFound},
+				// 入室していなくても退出はエラーにしない
+				{"exit", testViewerID, http.StatusOK},
+			},
+			want: ViewerCounts{},
+		},
+	}
+	handlers := map[string]struct {
+		method  string
+		path    string
+		handler echo.HandlerFunc
+	}{
+		"enter":     {http.MethodPost, "/api/livestream/:livestream_id/enter", enterLivestreamHandler},
+		"exit":      {http.MethodDelete, "/api/livestream/:livestream_id/exit", exitLivestreamHandler},
+		"heartbeat": {http.MethodPost, "/api/livestream/:livestream_id/heartbeat", heartbeatLivestreamHandler},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			for i, step := range tt.steps {
+				h := handlers[step.action]
+				rec := serveTestRequest(t, h.method, h.path, "/api/livestream/1/"+step.action, step.userID, h.handler)
+				assert.Equal(t, step.wantStatus, rec.Code, "step %d %s", i+1, step.action)
+			}
+
+			var counts ViewerCounts
+			err := s.Tx(context.Background(), func(r Repository) error {
+				var err error
+				counts, err = r.Viewers().Count(context.Background(), []int64{testLivestreamID}, time.Now())
+				return err
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, counts)
+		})
+	}
+}
+
+func TestEnterLivestreamHandlerLiveWindow(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       string
+		livestreamID string
+		wantStatus   int
+	}{
+		{name: "live", status: livestreamStatusLive, livestreamID: "1", wantStatus: http.StatusOK},
+		{name: "upcoming", status: livestreamStatusUpcoming, livestreamID: "1", wantStatus: http.StatusBadRequest},
+		{name: "ended", status: livestreamStatusEnded, livestreamID: "1", wantStatus: http.StatusBadRequest},
+		{name: "livestream not found", status: livestreamStatusLive, livestreamID: "100", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			moveTestLivestream(s, testLivestreamID, tt.status)
+			defer func(v bool) { enforceLiveWindow = v }(enforceLiveWindow)
+			enforceLiveWindow = true
+
+			rec := serveTestRequest(t, http.MethodPost, "/api/livestream/:livestream_id/enter", "/api/livestream/"+tt.livestreamID+"/enter", testViewerID, enterLivestreamHandler)
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantStatus == http.StatusOK, len(s.data.viewersHistory) == 1)
+		})
+	}
+}
+
+func TestMemoryViewerRepositoryCount(t *testing.T) {
+	now := time.Now()
+	fresh := now.Unix()
+	stale := now.Add(-2 * viewerHeartbeatTimeout).Unix()
+
+	tests := []struct {
+		name          string
+		history       []LivestreamViewerModel
+		livestreamIDs []int64
+		want          ViewerCounts
+	}{
+		{
+			name: "stale viewers are present but not concurrent",
+			history: []LivestreamViewerModel{
+				{UserID: testViewerID, LivestreamID: testLivestreamID, CreatedAt: fresh, LastSeenAt: fresh},
+				{UserID: testCollaboratorID, LivestreamID: testLivestreamID, CreatedAt: stale, LastSeenAt: stale},
+			},
+			livestreamIDs: []int64{testLivestreamID},
+			want:          ViewerCounts{Present: 2, Concurrent: 1, Unique: 2},
+		},
+		{
+			// 配信者の全配信を数える場合、複数の配信を視聴したユーザは1人として数える
+			name: "multiple livestreams",
+			history: []LivestreamViewerModel{
+				{UserID: testViewerID, LivestreamID: testLivestreamID, CreatedAt: fresh, LastSeenAt: fresh},
+				{UserID: testViewerID, LivestreamID: testOtherLivestreamID, CreatedAt: fresh, LastSeenAt: fresh},
+				{UserID: testCollaboratorID, LivestreamID: testOtherLivestreamID, CreatedAt: stale, LastSeenAt: stale, LeftAt: stale},
+			},
+			livestreamIDs: []int64{testLivestreamID, testOtherLivestreamID},
+			want:          ViewerCounts{Present: 2, Concurrent: 1, Unique: 2},
+		},
+		{
+			name: "other livestreams are not counted",
+			history: []LivestreamViewerModel{
+				{UserID: testViewerID, LivestreamID: testOtherLivestreamID, CreatedAt: fresh, LastSeenAt: fresh},
+			},
+			livestreamIDs: []int64{testLivestreamID},
+			want:          ViewerCounts{},
+		},
+		{
+			name: "no livestreams",
+			history: []LivestreamViewerModel{
+				{UserID: testViewerID, LivestreamID: testLivestreamID, CreatedAt: fresh, LastSeenAt: fresh},
+			},
+			livestreamIDs: []int64{},
+			want:          ViewerCounts{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestRepositoryStore(t)
+			s.data.viewersHistory = tt.history
+
+			var counts ViewerCounts
+			err := s.Tx(context.Background(), func(r Repository) error {
+				var err error
+				counts, err = r.Viewers().Count(context.Background(), tt.livestreamIDs, now)
+				return err
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, counts)
+		})
+	}
+}
@@ -1,3 +1,5 @@
+TRUNCATE TABLE sessions;
+TRUNCATE TABLE rate_limit_buckets;
 TRUNCATE TABLE themes;
 TRUNCATE TABLE icons;
 TRUNCATE TABLE reservation_slots;
@@ -7,6 +9,12 @@ TRUNCATE TABLE ng_words;
 TRUNCATE TABLE reactions;
 TRUNCATE TABLE tags;
 TRUNCATE TABLE livestream_tags;
+TRUNCATE TABLE livestream_collaborators;
+TRUNCATE TABLE livecomment_moderations;
+TRUNCATE TABLE follows;
+TRUNCATE TABLE tips;
+TRUNCATE TABLE livestream_stats;
+TRUNCATE TABLE user_stats;
 TRUNCATE TABLE livecomments;
 TRUNCATE TABLE livestreams;
 TRUNCATE TABLE users;
@@ -15,6 +23,10 @@ ALTER TABLE `themes` auto_increment = 1;
 ALTER TABLE `icons` auto_increment = 1;
 ALTER TABLE `reservation_slots` auto_increment = 1;
 ALTER TABLE `livestream_tags` auto_increment = 1;
+ALTER TABLE `livestream_collaborators` auto_increment = 1;
+ALTER TABLE `livecomment_moderations` auto_increment = 1;
+ALTER TABLE `follows` auto_increment = 1;
+ALTER TABLE `tips` auto_increment = 1;
 ALTER TABLE `livestream_viewers_history` auto_increment = 1;
 ALTER TABLE `livecomment_reports` auto_increment = 1;
 ALTER TABLE `ng_words` auto_increment = 1;
@@ -0,0 +1,32 @@
+-- 初期データを投入した後に、統計情報のカウンタを集計する
+INSERT INTO livestream_stats (livestream_id, user_id)
+SELECT id, user_id FROM livestreams;
+
+INSERT INTO user_stats (user_id, name)
+SELECT id, name FROM users;
+
+UPDATE livestream_stats s
+LEFT JOIN (
+  SELECT livestream_id, COUNT(*) AS count FROM reactions GROUP BY livestream_id
+) r ON r.livestream_id = s.livestream_id
+LEFT JOIN (
+  SELECT livestream_id, COUNT(*) AS count, SUM(tip) AS total_tip, MAX(tip) AS max_tip FROM livecomments GROUP BY livestream_id
+) l ON l.livestream_id = s.livestream_id
+LEFT JOIN (
+  SELECT livestream_id, COUNT(*) AS count FROM livecomment_reports GROUP BY livestream_id
+) rp ON rp.livestream_id = s.livestream_id
+SET
+  s.reactions = IFNULL(r.count, 0),
+  s.livecomments = IFNULL(l.count, 0),
+  s.total_tip = IFNULL(l.total_tip, 0),
+  s.max_tip = IFNULL(l.max_tip, 0),
+  s.reports = IFNULL(rp.count, 0);
+
+UPDATE user_stats u
+INNER JOIN (
+  SELECT user_id, SUM(reactions) AS reactions, SUM(livecomments) AS livecomments, SUM(total_tip) AS total_tip FROM livestream_stats GROUP BY user_id
+) s ON s.user_id = u.user_id
+SET
+  u.reactions = s.reactions,
+  u.livecomments = s.livecomments,
+  u.total_tip = s.total_tip;